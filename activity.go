@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// maxActivitySessions caps the sessions listed by activity.
+	maxActivitySessions = 100
+	activityTimeout     = 30 * time.Second
+)
+
+// activityQuery lists user sessions that are running a request or hold an
+// open transaction (all user sessions with @p1 = 1), busiest first.
+const activityQuery = `SELECT TOP (@p2) s.session_id,
+	ISNULL(r.blocking_session_id, 0) AS blocking_session_id,
+	ISNULL(r.status, s.status) AS status,
+	ISNULL(r.command, '') AS command,
+	ISNULL(r.wait_type, '') AS wait_type,
+	CAST(ISNULL(r.wait_time, 0) AS bigint) AS wait_ms,
+	ISNULL(s.login_name, '') AS login_name,
+	ISNULL(s.host_name, '') AS host_name,
+	ISNULL(s.program_name, '') AS program_name,
+	ISNULL(DB_NAME(COALESCE(r.database_id, s.database_id)), '') AS database_name,
+	s.open_transaction_count,
+	CAST(ISNULL(r.cpu_time, s.cpu_time) AS bigint) AS cpu_ms,
+	CAST(ISNULL(r.total_elapsed_time, 0) AS bigint) AS elapsed_ms,
+	CAST(ISNULL(r.logical_reads, s.logical_reads) AS bigint) AS logical_reads,
+	CASE WHEN r.sql_handle IS NULL THEN t.text
+		ELSE SUBSTRING(t.text, r.statement_start_offset / 2 + 1,
+			(CASE r.statement_end_offset WHEN -1 THEN DATALENGTH(t.text) ELSE r.statement_end_offset END - r.statement_start_offset) / 2 + 1)
+	END AS statement_text
+FROM sys.dm_exec_sessions s
+LEFT JOIN sys.dm_exec_requests r ON r.session_id = s.session_id
+LEFT JOIN sys.dm_exec_connections c ON c.session_id = s.session_id AND c.parent_connection_id IS NULL
+OUTER APPLY sys.dm_exec_sql_text(COALESCE(r.sql_handle, c.most_recent_sql_handle)) t
+WHERE s.is_user_process = 1
+	AND s.session_id <> @@SPID
+	AND (@p1 = 1 OR r.session_id IS NOT NULL OR s.open_transaction_count > 0
+		OR s.session_id IN (SELECT blocking_session_id FROM sys.dm_exec_requests WHERE blocking_session_id <> 0))
+ORDER BY CASE WHEN r.blocking_session_id <> 0 THEN 0 ELSE 1 END, ISNULL(r.total_elapsed_time, 0) DESC`
+
+// killTargetQuery checks that the session to end exists and is a user
+// session other than the server's own.
+const killTargetQuery = `SELECT s.is_user_process, ISNULL(s.login_name, ''), ISNULL(s.host_name, ''), ISNULL(s.program_name, '')
+FROM sys.dm_exec_sessions s
+WHERE s.session_id = @p1 AND s.session_id <> @@SPID`
+
+// activitySession is one session of the activity list.
+type activitySession struct {
+	blockingSession
+	CPUMs        int64 `json:"cpu_ms"`
+	ElapsedMs    int64 `json:"elapsed_ms,omitempty"`
+	LogicalReads int64 `json:"logical_reads"`
+}
+
+// activityReport is the result of activity's list action.
+type activityReport struct {
+	Summary  string            `json:"summary"`
+	Notes    []string          `json:"notes,omitempty"`
+	Sessions []activitySession `json:"sessions"`
+	Blocking []*blockingNode   `json:"blocking,omitempty"`
+}
+
+// renderBlockingTree draws blocking chains as an indented text tree, one
+// line per session.
+func renderBlockingTree(tree []*blockingNode) string {
+	var sb strings.Builder
+	var walk func(n *blockingNode, prefix string, last, root bool)
+	walk = func(n *blockingNode, prefix string, last, root bool) {
+		branch, next := "", ""
+		if !root {
+			branch, next = "├─ ", "│  "
+			if last {
+				branch, next = "└─ ", "   "
+			}
+		}
+		sb.WriteString(prefix + branch + describeBlockingSession(n.blockingSession) + "\n")
+		for i, c := range n.Blocking {
+			walk(c, prefix+next, i == len(n.Blocking)-1, false)
+		}
+	}
+	for _, n := range tree {
+		walk(n, "", true, true)
+	}
+	return sb.String()
+}
+
+// describeBlockingSession is the one-line label of a session in the tree.
+func describeBlockingSession(b blockingSession) string {
+	parts := []string{fmt.Sprintf("session %d", b.SessionID)}
+	if b.WaitType != "" {
+		parts = append(parts, fmt.Sprintf("waiting %s %dms", b.WaitType, b.WaitMs))
+	} else if b.Status != "" {
+		parts = append(parts, b.Status)
+	}
+	if b.OpenTransactions > 0 {
+		parts = append(parts, fmt.Sprintf("%d open tran", b.OpenTransactions))
+	}
+	if who := strings.Trim(b.Login+"@"+b.Host, "@"); who != "" {
+		parts = append(parts, who)
+	}
+	line := strings.Join(parts, ", ")
+	if b.Statement != "" {
+		stmt := b.Statement
+		if r := []rune(stmt); len(r) > 80 {
+			stmt = string(r[:80]) + "…"
+		}
+		line += ": " + stmt
+	}
+	return line
+}
+
+// killAllowedAnywhere reports whether any dynamic alias allows
+// kill_session, which changes how the activity tool is advertised.
+func (s *MCPMSSQLServer) killAllowedAnywhere() bool {
+	s.dynamicMu.RLock()
+	defer s.dynamicMu.RUnlock()
+	for _, a := range s.dynamicAliases {
+		if a.AllowKill {
+			return true
+		}
+	}
+	return false
+}
+
+// activityTool is the activity tool definition. kill_session is only
+// described, and the tool only annotated as destructive, when some alias
+// allows it.
+func (s *MCPMSSQLServer) activityTool(killAllowed bool) Tool {
+	tool := Tool{
+		Name:        "activity",
+		Title:       "Live Activity",
+		Description: "Show who is doing what right now: active user sessions and requests with their wait type, blocking session, open transaction count, CPU, elapsed time, reads and current statement, plus the blocking tree (lead blockers first). Sessions that are idle but hold an open transaction are included. Without VIEW SERVER STATE only the login's own sessions are visible.",
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]Property{
+				"action": {
+					Type:        "string",
+					Description: "'list' (default)",
+				},
+				"include_idle": {
+					Type:        "boolean",
+					Description: "Also list idle user sessions without an open transaction (default false)",
+				},
+			},
+			Required: []string{},
+		},
+		Annotations: &ToolAnnotations{
+			ReadOnlyHint:    boolPtr(true),
+			DestructiveHint: boolPtr(false),
+			IdempotentHint:  boolPtr(false),
+			OpenWorldHint:   boolPtr(false),
+		},
+	}
+	if killAllowed {
+		tool.Description += " action=kill_session ends session_id on aliases configured with MSSQL_DYNAMIC_<ALIAS>_ALLOW_KILL=true, after confirm_operation (e.g. 'KILL session 57')."
+		tool.InputSchema.Properties["action"] = Property{
+			Type:        "string",
+			Description: "'list' (default) or 'kill_session'",
+		}
+		tool.InputSchema.Properties["session_id"] = Property{
+			Type:        "integer",
+			Description: "Session to end with action=kill_session",
+		}
+		tool.Annotations.ReadOnlyHint = boolPtr(false)
+		tool.Annotations.DestructiveHint = boolPtr(true)
+	}
+	return tool
+}
+
+// activityList reads the current sessions and their blocking chains.
+func (s *MCPMSSQLServer) activityList(ctx context.Context, target *queryTarget, includeIdle bool) (*activityReport, error) {
+	report := &activityReport{Sessions: []activitySession{}}
+	caps, err := s.perfCapabilitiesOn(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	if !caps.dmv {
+		report.Notes = append(report.Notes, viewServerStateNote("Seeing other sessions")+". Only this login's own sessions are listed.")
+	}
+
+	idle := 0
+	if includeIdle {
+		idle = 1
+	}
+	var involved []blockingSession
+	blockers := map[int]bool{}
+	err = s.scanQuery(ctx, target, activityQuery, []interface{}{idle, maxActivitySessions + 1}, func(rows *sql.Rows) error {
+		var a activitySession
+		var stmt sql.NullString
+		if err := rows.Scan(&a.SessionID, &a.BlockedBy, &a.Status, &a.Command, &a.WaitType, &a.WaitMs,
+			&a.Login, &a.Host, &a.Program, &a.Database, &a.OpenTransactions,
+			&a.CPUMs, &a.ElapsedMs, &a.LogicalReads, &stmt); err != nil {
+			return err
+		}
+		a.Statement = truncateText(stmt.String)
+		report.Sessions = append(report.Sessions, a)
+		if a.BlockedBy != 0 {
+			blockers[a.BlockedBy] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(report.Sessions) > maxActivitySessions {
+		report.Sessions = report.Sessions[:maxActivitySessions]
+		report.Notes = append(report.Notes, fmt.Sprintf("Only the first %d sessions are listed (blocked sessions first).", maxActivitySessions))
+	}
+
+	blocked := 0
+	for _, a := range report.Sessions {
+		if a.BlockedBy != 0 || blockers[a.SessionID] {
+			involved = append(involved, a.blockingSession)
+		}
+		if a.BlockedBy != 0 {
+			blocked++
+		}
+	}
+	report.Blocking = blockingTree(involved)
+	report.Summary = fmt.Sprintf("%d session(s), no blocking", len(report.Sessions))
+	if blocked > 0 {
+		report.Summary = fmt.Sprintf("%d session(s), %d blocked behind %d lead blocker(s)", len(report.Sessions), blocked, len(report.Blocking))
+	}
+	return report, nil
+}
+
+// activityKill ends a session after the kill policy allowed it.
+func (s *MCPMSSQLServer) activityKill(ctx context.Context, target *queryTarget, session int) (string, error) {
+	if session <= 0 {
+		return "", fmt.Errorf("'session_id' is required for kill_session")
+	}
+	killStmt := fmt.Sprintf("KILL %d", session)
+	if err := s.enforcePolicy(policyRequest{target: target, query: killStmt, mode: policyKill, session: session}); err != nil {
+		return "", err
+	}
+
+	var userProcess bool
+	var login, host, program string
+	found := false
+	err := s.scanQuery(ctx, target, killTargetQuery, []interface{}{session}, func(rows *sql.Rows) error {
+		found = true
+		return rows.Scan(&userProcess, &login, &host, &program)
+	})
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", fmt.Errorf("session %d not found (or it is this server's own session)", session)
+	}
+	if !userProcess {
+		return "", fmt.Errorf("session %d is a system session and cannot be killed", session)
+	}
+
+	if _, err := target.db.ExecContext(ctx, killStmt); err != nil {
+		if s.devMode {
+			return "", fmt.Errorf("KILL failed: %v", err)
+		}
+		return "", fmt.Errorf("KILL failed: check that the login has ALTER ANY CONNECTION")
+	}
+	s.secLogger.Printf("activity: killed session %d (login=%s host=%s program=%s) on alias '%s'", session, login, host, program, target.alias)
+	return fmt.Sprintf("Session %d (%s@%s, %s) was killed. Its open transaction, if any, is being rolled back.", session, login, host, program), nil
+}
+
+// handleActivity implements the activity tool.
+func (s *MCPMSSQLServer) handleActivity(id interface{}, args map[string]interface{}) *MCPResponse {
+	errorResponse := func(msg string) *MCPResponse {
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result: CallToolResult{
+				Content: []ContentItem{{Type: "text", Text: msg}},
+				IsError: true,
+			},
+		}
+	}
+	textResponse := func(text string) *MCPResponse {
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result:  CallToolResult{Content: []ContentItem{{Type: "text", Text: text}}},
+		}
+	}
+
+	target, err := s.resolveTarget(args)
+	if err != nil {
+		return errorResponse(fmt.Sprintf("Error: %v", err))
+	}
+	if !target.config.performanceInsights {
+		return errorResponse("Error: the activity tool is disabled. Set MSSQL_PERFORMANCE_INSIGHTS=true to enable it.")
+	}
+	target.tool = "activity"
+
+	ctx, cancel := context.WithTimeout(context.Background(), activityTimeout)
+	defer cancel()
+
+	action, _ := args["action"].(string)
+	switch strings.ToLower(strings.TrimSpace(action)) {
+	case "", "list":
+		includeIdle, _ := args["include_idle"].(bool)
+		report, err := s.activityList(ctx, target, includeIdle)
+		if err != nil {
+			return errorResponse(fmt.Sprintf("Activity Error: %v", err))
+		}
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return errorResponse(fmt.Sprintf("Error formatting results: %v", err))
+		}
+		text := "Activity: " + report.Summary + "\n"
+		if len(report.Blocking) > 0 {
+			text += "\nBlocking tree:\n" + renderBlockingTree(report.Blocking)
+		}
+		return textResponse(text + "\n" + string(out))
+	case "kill_session":
+		session := 0
+		if v, ok := args["session_id"].(float64); ok && v == float64(int(v)) {
+			session = int(v)
+		}
+		msg, err := s.activityKill(ctx, target, session)
+		if err != nil {
+			return errorResponse(fmt.Sprintf("Error: %v", err))
+		}
+		return textResponse(msg)
+	default:
+		return errorResponse(fmt.Sprintf("Error: invalid action '%s' (use list or kill_session)", action))
+	}
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCompareSampleSize = 10
+	maxCompareSampleSize     = 100
+	// defaultCompareMaxRows bounds how many rows are read from each side. The
+	// left side is held in memory keyed by the key columns; the right side is
+	// streamed against it.
+	defaultCompareMaxRows = 100000
+	maxCompareMaxRows     = 1000000
+	compareTimeout        = 2 * time.Minute
+)
+
+// compareSource builds the SELECT run on both sides of a comparison from
+// either a 'table' (plus optional 'schema') or a 'query' argument.
+func (s *MCPMSSQLServer) compareSource(args map[string]interface{}) (string, error) {
+	query, _ := args["query"].(string)
+	table, _ := args["table"].(string)
+	query, table = strings.TrimSpace(query), strings.TrimSpace(table)
+
+	switch {
+	case query != "" && table != "":
+		return "", fmt.Errorf("provide either 'table' or 'query', not both")
+	case query != "":
+		return query, nil
+	case table != "":
+		defaultSchema := "dbo"
+		if sch, ok := args["schema"].(string); ok && strings.TrimSpace(sch) != "" {
+			defaultSchema = sch
+		}
+		schema, name, err := splitQualifiedName(table, defaultSchema)
+		if err != nil {
+			return "", err
+		}
+		return "SELECT * FROM " + quoteIdentifier(schema) + "." + quoteIdentifier(name), nil
+	default:
+		return "", fmt.Errorf("missing 'table' or 'query' parameter")
+	}
+}
+
+// comparedRow is one row kept for sampling, with values already converted
+// to their JSON-friendly form.
+type comparedRow struct {
+	values []interface{}
+}
+
+// columnChange describes one differing column of a changed row.
+type columnChange struct {
+	Column string      `json:"column"`
+	Left   interface{} `json:"left"`
+	Right  interface{} `json:"right"`
+}
+
+// changedRowSample is a changed row as reported by the compare tool.
+type changedRowSample struct {
+	Key     map[string]interface{} `json:"key"`
+	Changes []columnChange         `json:"changes"`
+}
+
+// compareResult is the JSON document returned by the compare tool.
+type compareResult struct {
+	LeftAlias          string                   `json:"left_alias"`
+	RightAlias         string                   `json:"right_alias"`
+	KeyColumns         []string                 `json:"key_columns"`
+	ComparedColumns    []string                 `json:"compared_columns"`
+	ColumnsOnlyLeft    []string                 `json:"columns_only_left,omitempty"`
+	ColumnsOnlyRight   []string                 `json:"columns_only_right,omitempty"`
+	LeftRows           int                      `json:"left_rows"`
+	RightRows          int                      `json:"right_rows"`
+	OnlyLeft           int                      `json:"only_left"`
+	OnlyRight          int                      `json:"only_right"`
+	Changed            int                      `json:"changed"`
+	Identical          int                      `json:"identical"`
+	DuplicateKeysLeft  int                      `json:"duplicate_keys_left,omitempty"`
+	DuplicateKeysRight int                      `json:"duplicate_keys_right,omitempty"`
+	Truncated          bool                     `json:"truncated,omitempty"`
+	Warnings           []string                 `json:"warnings,omitempty"`
+	SampleOnlyLeft     []map[string]interface{} `json:"sample_only_left"`
+	SampleOnlyRight    []map[string]interface{} `json:"sample_only_right"`
+	SampleChanged      []changedRowSample       `json:"sample_changed"`
+}
+
+// rowDiffer diffs two row streams by key. The left side is indexed in
+// memory; right rows are matched against it one at a time and matched left
+// rows are released, so of the right side only the keys are retained.
+type rowDiffer struct {
+	keyColumns []string
+	sampleSize int
+
+	leftColumns  []string
+	leftKeyIdx   []int
+	left         map[string]*comparedRow
+	leftOrder    []string
+	rightColumns []string
+	rightKeyIdx  []int
+	// pairs maps a left column index to the right column index it is
+	// compared against (same name, case-insensitive).
+	pairs    [][2]int
+	seen     map[string]bool
+	result   compareResult
+	finished bool
+}
+
+func newRowDiffer(keyColumns []string, sampleSize int) *rowDiffer {
+	return &rowDiffer{
+		keyColumns: keyColumns,
+		sampleSize: sampleSize,
+		left:       make(map[string]*comparedRow),
+		seen:       make(map[string]bool),
+		result: compareResult{
+			KeyColumns:      keyColumns,
+			SampleOnlyLeft:  []map[string]interface{}{},
+			SampleOnlyRight: []map[string]interface{}{},
+			SampleChanged:   []changedRowSample{},
+		},
+	}
+}
+
+// keyIndexes resolves the key columns against a result set's columns.
+func keyIndexes(side string, keyColumns, columns []string) ([]int, error) {
+	idx := make([]int, len(keyColumns))
+	for i, key := range keyColumns {
+		idx[i] = -1
+		for j, col := range columns {
+			if strings.EqualFold(col, key) {
+				idx[i] = j
+				break
+			}
+		}
+		if idx[i] < 0 {
+			return nil, fmt.Errorf("key column '%s' not found on the %s side (columns: %s)", key, side, strings.Join(columns, ", "))
+		}
+	}
+	return idx, nil
+}
+
+func (d *rowDiffer) setLeftColumns(columns []string) error {
+	idx, err := keyIndexes("left", d.keyColumns, columns)
+	if err != nil {
+		return err
+	}
+	d.leftColumns, d.leftKeyIdx = columns, idx
+	return nil
+}
+
+func (d *rowDiffer) setRightColumns(columns []string) error {
+	idx, err := keyIndexes("right", d.keyColumns, columns)
+	if err != nil {
+		return err
+	}
+	d.rightColumns, d.rightKeyIdx = columns, idx
+
+	matchedRight := make(map[int]bool)
+	for li, lc := range d.leftColumns {
+		found := false
+		for ri, rc := range columns {
+			if strings.EqualFold(lc, rc) {
+				d.pairs = append(d.pairs, [2]int{li, ri})
+				d.result.ComparedColumns = append(d.result.ComparedColumns, lc)
+				matchedRight[ri] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			d.result.ColumnsOnlyLeft = append(d.result.ColumnsOnlyLeft, lc)
+		}
+	}
+	for ri, rc := range columns {
+		if !matchedRight[ri] {
+			d.result.ColumnsOnlyRight = append(d.result.ColumnsOnlyRight, rc)
+		}
+	}
+	return nil
+}
+
+// compareValue converts a scanned value to the form used both for equality
+// checks and in the JSON output.
+func compareValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case []byte:
+		return string(t)
+	case time.Time:
+		return t.Format(time.RFC3339Nano)
+	default:
+		return v
+	}
+}
+
+// sameValue reports whether two normalised column values are equal. NULL
+// only equals NULL; everything else is compared by its printed form so that
+// e.g. int32 and int64 columns of the same value match across servers.
+func sameValue(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+// rowKey builds the map key for a row from its key column values.
+func rowKey(values []interface{}, idx []int) string {
+	var sb strings.Builder
+	for i, j := range idx {
+		if i > 0 {
+			sb.WriteByte(0)
+		}
+		if values[j] == nil {
+			sb.WriteString("\x01NULL")
+			continue
+		}
+		fmt.Fprintf(&sb, "%v", values[j])
+	}
+	return sb.String()
+}
+
+func normalizeRow(values []interface{}) []interface{} {
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		out[i] = compareValue(v)
+	}
+	return out
+}
+
+func rowAsMap(columns []string, values []interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(columns))
+	for i, col := range columns {
+		m[col] = values[i]
+	}
+	return m
+}
+
+func (d *rowDiffer) addLeft(values []interface{}) {
+	values = normalizeRow(values)
+	d.result.LeftRows++
+	key := rowKey(values, d.leftKeyIdx)
+	if _, dup := d.left[key]; dup {
+		d.result.DuplicateKeysLeft++
+		return
+	}
+	d.left[key] = &comparedRow{values: values}
+	d.leftOrder = append(d.leftOrder, key)
+}
+
+func (d *rowDiffer) addRight(values []interface{}) {
+	values = normalizeRow(values)
+	d.result.RightRows++
+	key := rowKey(values, d.rightKeyIdx)
+	if d.seen[key] {
+		d.result.DuplicateKeysRight++
+		return
+	}
+	d.seen[key] = true
+
+	left, ok := d.left[key]
+	if !ok {
+		d.result.OnlyRight++
+		if len(d.result.SampleOnlyRight) < d.sampleSize {
+			d.result.SampleOnlyRight = append(d.result.SampleOnlyRight, rowAsMap(d.rightColumns, values))
+		}
+		return
+	}
+	delete(d.left, key)
+
+	var changes []columnChange
+	for _, p := range d.pairs {
+		lv, rv := left.values[p[0]], values[p[1]]
+		if !sameValue(lv, rv) {
+			changes = append(changes, columnChange{Column: d.leftColumns[p[0]], Left: lv, Right: rv})
+		}
+	}
+	if len(changes) == 0 {
+		d.result.Identical++
+		return
+	}
+	d.result.Changed++
+	if len(d.result.SampleChanged) < d.sampleSize {
+		keyMap := make(map[string]interface{}, len(d.keyColumns))
+		for i, j := range d.rightKeyIdx {
+			keyMap[d.keyColumns[i]] = values[j]
+		}
+		d.result.SampleChanged = append(d.result.SampleChanged, changedRowSample{Key: keyMap, Changes: changes})
+	}
+}
+
+// finish counts the unmatched left rows and returns the result.
+func (d *rowDiffer) finish() compareResult {
+	if d.finished {
+		return d.result
+	}
+	d.finished = true
+	for _, key := range d.leftOrder {
+		row, ok := d.left[key]
+		if !ok {
+			continue
+		}
+		d.result.OnlyLeft++
+		if len(d.result.SampleOnlyLeft) < d.sampleSize {
+			d.result.SampleOnlyLeft = append(d.result.SampleOnlyLeft, rowAsMap(d.leftColumns, row.values))
+		}
+	}
+	sort.Strings(d.result.ColumnsOnlyLeft)
+	sort.Strings(d.result.ColumnsOnlyRight)
+	return d.result
+}
+
+// streamSide runs query on target and feeds every row (up to maxRows) to
+// add. It reports whether the side was truncated. compare only promises to
+// read, so each side is held to the strict read policy whatever the alias's
+// posture, like export_query.
+func (s *MCPMSSQLServer) streamSide(ctx context.Context, target *queryTarget, query string, maxRows int,
+	setColumns func([]string) error, add func([]interface{})) (bool, error) {
+	if err := s.enforcePolicy(policyRequest{target: target, query: query, mode: policyStrictRead}); err != nil {
+		return false, err
+	}
+	if target.db == nil {
+		return false, fmt.Errorf("database not connected")
+	}
+	rows, err := target.db.QueryContext(ctx, query)
+	if err != nil {
+		return false, s.queryFailed(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return false, err
+	}
+	if err := setColumns(columns); err != nil {
+		return false, err
+	}
+
+	count := 0
+	for rows.Next() {
+		if count >= maxRows {
+			return true, nil
+		}
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return false, err
+		}
+		add(values)
+		count++
+	}
+	return false, rows.Err()
+}
+
+// compareAliases implements the compare tool: the same SELECT is run on two
+// aliases, each under its own read policy, and the results are diffed by the
+// key columns.
+func (s *MCPMSSQLServer) compareAliases(ctx context.Context, args map[string]interface{}) (*compareResult, error) {
+	leftAlias, _ := args["left_alias"].(string)
+	rightAlias, _ := args["right_alias"].(string)
+	if strings.TrimSpace(leftAlias) == "" || strings.TrimSpace(rightAlias) == "" {
+		return nil, fmt.Errorf("'left_alias' and 'right_alias' are required")
+	}
+	keyColumns := stringListArg(args["key_columns"])
+	if len(keyColumns) == 0 {
+		return nil, fmt.Errorf("'key_columns' is required (e.g. \"id\" or [\"order_id\", \"line_no\"])")
+	}
+	for _, k := range keyColumns {
+		if !validIdentifierPattern.MatchString(k) {
+			return nil, fmt.Errorf("invalid key column name '%s'", k)
+		}
+	}
+	query, err := s.compareSource(args)
+	if err != nil {
+		return nil, err
+	}
+	sampleSize := intArg(args, "sample_size", defaultCompareSampleSize, maxCompareSampleSize)
+	maxRows := intArg(args, "max_rows", defaultCompareMaxRows, maxCompareMaxRows)
+
+	left, err := s.resolveTarget(map[string]interface{}{"alias": leftAlias})
+	if err != nil {
+		return nil, fmt.Errorf("left alias: %w", err)
+	}
+	right, err := s.resolveTarget(map[string]interface{}{"alias": rightAlias})
+	if err != nil {
+		return nil, fmt.Errorf("right alias: %w", err)
+	}
+	left.tool, right.tool = "compare", "compare"
+
+	d := newRowDiffer(keyColumns, sampleSize)
+	leftTruncated, err := s.streamSide(ctx, left, query, maxRows, d.setLeftColumns, d.addLeft)
+	if err != nil {
+		return nil, fmt.Errorf("left alias '%s': %w", left.alias, err)
+	}
+	rightTruncated, err := s.streamSide(ctx, right, query, maxRows, d.setRightColumns, d.addRight)
+	if err != nil {
+		return nil, fmt.Errorf("right alias '%s': %w", right.alias, err)
+	}
+
+	result := d.finish()
+	result.LeftAlias, result.RightAlias = left.alias, right.alias
+	if leftTruncated || rightTruncated {
+		result.Truncated = true
+		result.Warnings = append(result.Warnings, fmt.Sprintf("At least one side has more than %d rows; counts cover only the rows read. Narrow the query or raise max_rows.", maxRows))
+	}
+	if result.DuplicateKeysLeft > 0 || result.DuplicateKeysRight > 0 {
+		result.Warnings = append(result.Warnings, "Key columns are not unique; rows with a repeated key were skipped.")
+	}
+	s.secLogger.Printf("compare %s vs %s: left=%d right=%d onlyLeft=%d onlyRight=%d changed=%d",
+		left.alias, right.alias, result.LeftRows, result.RightRows, result.OnlyLeft, result.OnlyRight, result.Changed)
+	return &result, nil
+}
+
+// handleCompare is the tools/call entry point of the compare tool.
+func (s *MCPMSSQLServer) handleCompare(id interface{}, args map[string]interface{}) *MCPResponse {
+	if !s.isDynamic {
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result: CallToolResult{
+				Content: []ContentItem{{Type: "text", Text: "Error: compare is only available in dynamic multi-connection mode."}},
+				IsError: true,
+			},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), compareTimeout)
+	defer cancel()
+
+	result, err := s.compareAliases(ctx, args)
+	if err != nil {
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result: CallToolResult{
+				Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Compare Error: %v", err)}},
+				IsError: true,
+			},
+		}
+	}
+
+	resultBytes, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result: CallToolResult{
+				Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Error formatting results: %v", err)}},
+				IsError: true,
+			},
+		}
+	}
+	return &MCPResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Result: CallToolResult{
+			Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Comparison of '%s' vs '%s':\n%s", result.LeftAlias, result.RightAlias, string(resultBytes))}},
+		},
+	}
+}
@@ -0,0 +1,745 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// maxDiagramTables keeps diagrams readable; larger selections are
+	// refused rather than silently cut.
+	maxDiagramTables = 60
+	// maxDiagramColumns caps the columns drawn per table with columns=all.
+	maxDiagramColumns  = 30
+	defaultDiagramHops = 1
+	maxDiagramHops     = 3
+	diagramTimeout     = 60 * time.Second
+)
+
+// diagramFormats are the text formats the diagram tool writes.
+var diagramFormats = map[string]bool{"mermaid": true, "plantuml": true, "dot": true}
+
+// diagramIdentifier is what Mermaid and PlantUML accept as a bare name.
+var diagramIdentifier = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// diagramColumn is one column drawn in a table box.
+type diagramColumn struct {
+	name     string
+	typ      string
+	nullable bool
+	pk, fk   bool
+}
+
+// diagramTable is one table of the diagram; id is its name in the diagram
+// languages.
+type diagramTable struct {
+	key     string // "schema.table" as in the catalog
+	id      string
+	columns []diagramColumn
+	hidden  int // columns not drawn
+}
+
+// diagramRelation is a foreign key between two tables of the diagram. The
+// parent side is zero-or-one when a referencing column is nullable; the
+// child side is zero-or-one when the referencing columns are unique.
+type diagramRelation struct {
+	name          string
+	child, parent *diagramTable
+	childCols     []string
+	parentCols    []string
+	optional      bool
+	oneToOne      bool
+}
+
+// parentMark and childMark are the crow's foot ends, as written by Mermaid
+// and PlantUML.
+func (r diagramRelation) parentMark() string {
+	if r.optional {
+		return "|o"
+	}
+	return "||"
+}
+
+func (r diagramRelation) childMark() string {
+	if r.oneToOne {
+		return "o|"
+	}
+	return "o{"
+}
+
+type diagram struct {
+	tables    []*diagramTable
+	relations []diagramRelation
+	outside   int // foreign keys to tables left out
+}
+
+func splitList(list string) []string {
+	var out []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(item), " DESC")); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func sameColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := map[string]bool{}
+	for _, c := range a {
+		set[strings.ToLower(c)] = true
+	}
+	for _, c := range b {
+		if !set[strings.ToLower(c)] {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveSnapshotTable finds the catalog key of a user-supplied table name.
+// Without schema the name must be unique across schemas, or exist in dbo.
+func resolveSnapshotTable(snap *schemaSnapshot, name string) (string, error) {
+	qualified := strings.Contains(name, ".")
+	schema, table, err := splitQualifiedName(name, "dbo")
+	if err != nil {
+		return "", err
+	}
+	var found []string
+	for key := range snap.Tables {
+		ks, kt, _ := strings.Cut(key, ".")
+		if !strings.EqualFold(kt, table) {
+			continue
+		}
+		if strings.EqualFold(ks, schema) {
+			return key, nil
+		}
+		if !qualified {
+			found = append(found, key)
+		}
+	}
+	switch len(found) {
+	case 0:
+		if qualified {
+			return "", fmt.Errorf("table %s.%s not found", schema, table)
+		}
+		return "", fmt.Errorf("table %s not found", table)
+	case 1:
+		return found[0], nil
+	}
+	sort.Strings(found)
+	return "", fmt.Errorf("table %s exists in several schemas (%s); qualify it", table, strings.Join(found, ", "))
+}
+
+// selectDiagramTables picks the tables to draw: those of a schema, a list,
+// or the tables within hops foreign keys of one table.
+func selectDiagramTables(snap *schemaSnapshot, schema string, tables []string, center string, hops int) ([]string, error) {
+	selected := map[string]bool{}
+	switch {
+	case schema != "":
+		for key := range snap.Tables {
+			if ks, _, _ := strings.Cut(key, "."); strings.EqualFold(ks, schema) {
+				selected[key] = true
+			}
+		}
+		if len(selected) == 0 {
+			return nil, fmt.Errorf("schema %s has no tables", schema)
+		}
+	case len(tables) > 0:
+		for _, name := range tables {
+			key, err := resolveSnapshotTable(snap, name)
+			if err != nil {
+				return nil, err
+			}
+			selected[key] = true
+		}
+	default:
+		start, err := resolveSnapshotTable(snap, center)
+		if err != nil {
+			return nil, err
+		}
+		neighbours := map[string][]string{}
+		for key, t := range snap.Tables {
+			for _, fk := range t.ForeignKeys {
+				neighbours[key] = append(neighbours[key], fk.ReferencedTable)
+				neighbours[fk.ReferencedTable] = append(neighbours[fk.ReferencedTable], key)
+			}
+		}
+		selected[start] = true
+		frontier := []string{start}
+		for i := 0; i < hops; i++ {
+			var next []string
+			for _, n := range frontier {
+				for _, m := range neighbours[n] {
+					if _, ok := snap.Tables[m]; ok && !selected[m] {
+						selected[m] = true
+						next = append(next, m)
+					}
+				}
+			}
+			frontier = next
+		}
+	}
+	if len(selected) > maxDiagramTables {
+		return nil, fmt.Errorf("the selection has %d tables; a diagram draws at most %d: list the tables or use 'table' with fewer 'hops'", len(selected), maxDiagramTables)
+	}
+	return sortedKeys(selected), nil
+}
+
+// buildDiagram collects the tables, columns and relationships to draw.
+// columns is all, keys (primary and foreign key columns only) or none.
+func buildDiagram(snap *schemaSnapshot, keys []string, columns string) *diagram {
+	d := &diagram{}
+	byKey := map[string]*diagramTable{}
+	schemas := map[string]bool{}
+	for _, key := range keys {
+		ks, _, _ := strings.Cut(key, ".")
+		schemas[strings.ToLower(ks)] = true
+	}
+	used := map[string]bool{}
+	for _, key := range keys {
+		t := snap.Tables[key]
+		id := key
+		if len(schemas) == 1 {
+			_, id, _ = strings.Cut(key, ".")
+		}
+		id = diagramIdentifier.ReplaceAllString(id, "_")
+		for base, i := id, 2; used[strings.ToLower(id)]; i++ {
+			id = fmt.Sprintf("%s_%d", base, i)
+		}
+		used[strings.ToLower(id)] = true
+
+		pk := map[string]bool{}
+		for _, ix := range t.Indexes {
+			if ix.PrimaryKey {
+				for _, c := range splitList(ix.Columns) {
+					pk[strings.ToLower(c)] = true
+				}
+			}
+		}
+		fk := map[string]bool{}
+		for _, f := range t.ForeignKeys {
+			for _, c := range splitList(f.Columns) {
+				fk[strings.ToLower(c)] = true
+			}
+		}
+		names := sortedKeys(t.Columns)
+		sort.SliceStable(names, func(i, j int) bool { return t.Columns[names[i]].Position < t.Columns[names[j]].Position })
+		dt := &diagramTable{key: key, id: id}
+		for _, name := range names {
+			c := diagramColumn{name: name, typ: t.Columns[name].DataType, nullable: t.Columns[name].Nullable, pk: pk[strings.ToLower(name)], fk: fk[strings.ToLower(name)]}
+			switch {
+			case columns == "none", columns == "keys" && !c.pk && !c.fk:
+				dt.hidden++
+			case columns == "all" && len(dt.columns) == maxDiagramColumns:
+				dt.hidden++
+			default:
+				dt.columns = append(dt.columns, c)
+			}
+		}
+		d.tables = append(d.tables, dt)
+		byKey[key] = dt
+	}
+
+	for _, key := range keys {
+		t := snap.Tables[key]
+		for _, name := range sortedKeys(t.ForeignKeys) {
+			f := t.ForeignKeys[name]
+			parent := byKey[f.ReferencedTable]
+			if parent == nil {
+				d.outside++
+				continue
+			}
+			r := diagramRelation{name: name, child: byKey[key], parent: parent, childCols: splitList(f.Columns), parentCols: splitList(f.ReferencedColumns)}
+			for _, c := range r.childCols {
+				if col, ok := t.Columns[c]; ok && col.Nullable {
+					r.optional = true
+				}
+			}
+			for _, ix := range t.Indexes {
+				if ix.Unique && sameColumns(splitList(ix.Columns), r.childCols) {
+					r.oneToOne = true
+				}
+			}
+			d.relations = append(d.relations, r)
+		}
+	}
+	return d
+}
+
+// keyMarks is the PK/FK marker of a column, e.g. "PK, FK".
+func (c diagramColumn) keyMarks() string {
+	var marks []string
+	if c.pk {
+		marks = append(marks, "PK")
+	}
+	if c.fk {
+		marks = append(marks, "FK")
+	}
+	return strings.Join(marks, ", ")
+}
+
+// renderMermaid writes a Mermaid erDiagram. Mermaid types are single
+// words, so the full SQL type goes into the comment when it has a length
+// or precision.
+func renderMermaid(d *diagram) string {
+	var b strings.Builder
+	b.WriteString("erDiagram\n")
+	for _, t := range d.tables {
+		fmt.Fprintf(&b, "    %s {\n", t.id)
+		for _, c := range t.columns {
+			base, _, _ := strings.Cut(c.typ, "(")
+			line := fmt.Sprintf("        %s %s", diagramIdentifier.ReplaceAllString(base, "_"), diagramIdentifier.ReplaceAllString(c.name, "_"))
+			if m := c.keyMarks(); m != "" {
+				line += " " + m
+			}
+			var comment []string
+			if base != c.typ {
+				comment = append(comment, c.typ)
+			}
+			if c.name != diagramIdentifier.ReplaceAllString(c.name, "_") {
+				comment = append(comment, c.name)
+			}
+			if c.nullable {
+				comment = append(comment, "NULL")
+			}
+			if len(comment) > 0 {
+				line += fmt.Sprintf(" %q", strings.ReplaceAll(strings.Join(comment, " "), `"`, "'"))
+			}
+			b.WriteString(line + "\n")
+		}
+		b.WriteString("    }\n")
+	}
+	for _, r := range d.relations {
+		fmt.Fprintf(&b, "    %s %s--%s %s : %q\n", r.parent.id, r.parentMark(), r.childMark(), r.child.id, r.name)
+	}
+	return b.String()
+}
+
+// renderPlantUML writes a PlantUML entity diagram in IE notation: key
+// columns above the separator, * before mandatory columns.
+func renderPlantUML(d *diagram) string {
+	var b strings.Builder
+	b.WriteString("@startuml\nhide circle\nskinparam linetype ortho\n\n")
+	for _, t := range d.tables {
+		fmt.Fprintf(&b, "entity %q as %s {\n", t.key, t.id)
+		var keys, rest []string
+		for _, c := range t.columns {
+			line := "  "
+			if !c.nullable {
+				line += "* "
+			}
+			line += c.name + " : " + c.typ
+			if c.pk {
+				line += " <<PK>>"
+			}
+			if c.fk {
+				line += " <<FK>>"
+			}
+			if c.pk {
+				keys = append(keys, line)
+			} else {
+				rest = append(rest, line)
+			}
+		}
+		for _, l := range keys {
+			b.WriteString(l + "\n")
+		}
+		if len(keys) > 0 {
+			b.WriteString("  --\n")
+		}
+		for _, l := range rest {
+			b.WriteString(l + "\n")
+		}
+		if t.hidden > 0 {
+			fmt.Fprintf(&b, "  .. %d more ..\n", t.hidden)
+		}
+		b.WriteString("}\n\n")
+	}
+	for _, r := range d.relations {
+		fmt.Fprintf(&b, "%s %s--%s %s : %s\n", r.parent.id, r.parentMark(), r.childMark(), r.child.id, r.name)
+	}
+	b.WriteString("@enduml\n")
+	return b.String()
+}
+
+// dotString quotes s for Graphviz.
+func dotString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// renderDOT writes a Graphviz digraph with one HTML-label node per table
+// and crow's foot arrows from the referencing to the referenced columns.
+func renderDOT(d *diagram) string {
+	var b strings.Builder
+	b.WriteString("digraph ER {\n  graph [rankdir=RL, splines=true, nodesep=0.6];\n  node [shape=plain, fontname=\"Helvetica\", fontsize=11];\n  edge [fontname=\"Helvetica\", fontsize=9, dir=both];\n\n")
+	port := map[string]int{}
+	for _, t := range d.tables {
+		fmt.Fprintf(&b, "  %s [label=<<table border=\"0\" cellborder=\"1\" cellspacing=\"0\" cellpadding=\"4\">\n    <tr><td bgcolor=\"#dde4f0\"><b>%s</b></td></tr>\n", dotString(t.key), html.EscapeString(t.key))
+		for i, c := range t.columns {
+			port[t.key+"."+strings.ToLower(c.name)] = i + 1
+			text := html.EscapeString(c.name + " : " + c.typ)
+			if c.pk {
+				text = "<u>" + text + "</u>"
+			}
+			if m := c.keyMarks(); m != "" {
+				text = m + " " + text
+			}
+			if !c.nullable {
+				text += " *"
+			}
+			fmt.Fprintf(&b, "    <tr><td port=\"c%d\" align=\"left\">%s</td></tr>\n", i+1, text)
+		}
+		if t.hidden > 0 {
+			fmt.Fprintf(&b, "    <tr><td align=\"left\"><i>%d more</i></td></tr>\n", t.hidden)
+		}
+		b.WriteString("  </table>>];\n")
+	}
+	b.WriteString("\n")
+	for _, r := range d.relations {
+		from, to := dotString(r.child.key), dotString(r.parent.key)
+		if p := port[r.child.key+"."+strings.ToLower(r.childCols[0])]; p > 0 {
+			from += fmt.Sprintf(":c%d", p)
+		}
+		if p := port[r.parent.key+"."+strings.ToLower(r.parentCols[0])]; p > 0 {
+			to += fmt.Sprintf(":c%d", p)
+		}
+		tail, head := "crowodot", "teetee"
+		if r.oneToOne {
+			tail = "teeodot"
+		}
+		if r.optional {
+			head = "teeodot"
+		}
+		fmt.Fprintf(&b, "  %s -> %s [arrowtail=%s, arrowhead=%s, label=%s];\n", from, to, tail, head, dotString(r.name))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// SVG layout metrics, in pixels, for a 12px monospace font.
+const (
+	svgCharWidth = 7.2
+	svgRow       = 18
+	svgHeader    = 24
+	svgPad       = 10
+	svgColGap    = 90
+	svgRowGap    = 30
+	svgMargin    = 20
+)
+
+type svgBox struct {
+	x, y, w, h float64
+	lines      []string
+}
+
+// rowY is the vertical centre of the row showing column name, or of the
+// header when the column is not drawn.
+func (b svgBox) rowY(t *diagramTable, name string) float64 {
+	for i, c := range t.columns {
+		if strings.EqualFold(c.name, name) {
+			return b.y + svgHeader + float64(i)*svgRow + svgRow/2
+		}
+	}
+	return b.y + svgHeader/2
+}
+
+// diagramLevels places referenced tables to the left of the tables that
+// reference them: a table's level is one more than its parents' deepest.
+func diagramLevels(d *diagram) map[*diagramTable]int {
+	parents := map[*diagramTable][]*diagramTable{}
+	for _, r := range d.relations {
+		if r.child != r.parent {
+			parents[r.child] = append(parents[r.child], r.parent)
+		}
+	}
+	level := map[*diagramTable]int{}
+	visiting := map[*diagramTable]bool{}
+	var walk func(t *diagramTable) int
+	walk = func(t *diagramTable) int {
+		if l, ok := level[t]; ok {
+			return l
+		}
+		if visiting[t] {
+			return 0 // a cycle: break it here
+		}
+		visiting[t] = true
+		l := 0
+		for _, p := range parents[t] {
+			l = max(l, walk(p)+1)
+		}
+		visiting[t] = false
+		level[t] = l
+		return l
+	}
+	for _, t := range d.tables {
+		walk(t)
+	}
+	return level
+}
+
+// renderSVG draws the diagram as a standalone SVG: tables in columns by
+// level, relationships as orthogonal lines with crow's foot ends.
+func renderSVG(d *diagram) string {
+	level := diagramLevels(d)
+	boxes := map[*diagramTable]*svgBox{}
+	var columns [][]*diagramTable
+	for _, t := range d.tables {
+		l := level[t]
+		for len(columns) <= l {
+			columns = append(columns, nil)
+		}
+		columns[l] = append(columns[l], t)
+		box := &svgBox{lines: []string{t.key}}
+		for _, c := range t.columns {
+			line := c.name + " : " + c.typ
+			if m := c.keyMarks(); m != "" {
+				line = m + " " + line
+			}
+			if !c.nullable {
+				line += " *"
+			}
+			box.lines = append(box.lines, line)
+		}
+		if t.hidden > 0 {
+			box.lines = append(box.lines, fmt.Sprintf("… %d more", t.hidden))
+		}
+		width := 0
+		for _, l := range box.lines {
+			width = max(width, len([]rune(l)))
+		}
+		box.w = float64(width)*svgCharWidth + 2*svgPad
+		box.h = svgHeader + float64(len(box.lines)-1)*svgRow
+		if len(box.lines) == 1 {
+			box.h = svgHeader
+		}
+		boxes[t] = box
+	}
+	x, height := float64(svgMargin), 0.0
+	for _, col := range columns {
+		y, w := float64(svgMargin), 0.0
+		for _, t := range col {
+			b := boxes[t]
+			b.x, b.y = x, y
+			y += b.h + svgRowGap
+			w = max(w, b.w)
+		}
+		height = max(height, y-svgRowGap+svgMargin)
+		x += w + svgColGap
+	}
+	width := x - svgColGap + svgMargin
+
+	var out strings.Builder
+	fmt.Fprintf(&out, `<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" viewBox="0 0 %.0f %.0f" font-family="Menlo, Consolas, monospace" font-size="12">`+"\n", width, height, width, height)
+	out.WriteString(`<defs>
+<marker id="one" viewBox="0 0 20 20" refX="20" refY="10" markerWidth="20" markerHeight="20" markerUnits="userSpaceOnUse" orient="auto-start-reverse"><path d="M12,3 V17 M16,3 V17" stroke="#445" fill="none"/></marker>
+<marker id="zero-one" viewBox="0 0 20 20" refX="20" refY="10" markerWidth="20" markerHeight="20" markerUnits="userSpaceOnUse" orient="auto-start-reverse"><path d="M16,3 V17" stroke="#445" fill="none"/><circle cx="8" cy="10" r="3.5" stroke="#445" fill="#fff"/></marker>
+<marker id="zero-many" viewBox="0 0 20 20" refX="20" refY="10" markerWidth="20" markerHeight="20" markerUnits="userSpaceOnUse" orient="auto-start-reverse"><path d="M10,10 L20,3 M10,10 L20,17 M10,10 H20" stroke="#445" fill="none"/><circle cx="5" cy="10" r="3.5" stroke="#445" fill="#fff"/></marker>
+</defs>
+<rect width="100%" height="100%" fill="#fff"/>
+`)
+	for _, r := range d.relations {
+		cb, pb := boxes[r.child], boxes[r.parent]
+		y1, y2 := cb.rowY(r.child, r.childCols[0]), pb.rowY(r.parent, r.parentCols[0])
+		var path string
+		switch {
+		case pb.x+pb.w <= cb.x: // parent on the left
+			mid := (pb.x + pb.w + cb.x) / 2
+			path = fmt.Sprintf("M%.1f,%.1f H%.1f V%.1f H%.1f", cb.x, y1, mid, y2, pb.x+pb.w)
+		case cb.x+cb.w <= pb.x: // parent on the right
+			mid := (cb.x + cb.w + pb.x) / 2
+			path = fmt.Sprintf("M%.1f,%.1f H%.1f V%.1f H%.1f", cb.x+cb.w, y1, mid, y2, pb.x)
+		default: // same column, or a self-reference: loop out on the right
+			right := max(cb.x+cb.w, pb.x+pb.w) + svgColGap/3
+			path = fmt.Sprintf("M%.1f,%.1f H%.1f V%.1f H%.1f", cb.x+cb.w, y1, right, y2, pb.x+pb.w)
+		}
+		childEnd, parentEnd := "zero-many", "one"
+		if r.oneToOne {
+			childEnd = "zero-one"
+		}
+		if r.optional {
+			parentEnd = "zero-one"
+		}
+		fmt.Fprintf(&out, `<path d="%s" stroke="#445" fill="none" marker-start="url(#%s)" marker-end="url(#%s)"><title>%s</title></path>`+"\n", path, childEnd, parentEnd, html.EscapeString(r.name))
+	}
+	for _, t := range d.tables {
+		b := boxes[t]
+		fmt.Fprintf(&out, `<g><rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="#fff" stroke="#445"/>`, b.x, b.y, b.w, b.h)
+		fmt.Fprintf(&out, `<rect x="%.1f" y="%.1f" width="%.1f" height="%d" fill="#dde4f0" stroke="#445"/>`, b.x, b.y, b.w, svgHeader)
+		fmt.Fprintf(&out, `<text x="%.1f" y="%.1f" font-weight="bold">%s</text>`, b.x+svgPad, b.y+16, html.EscapeString(b.lines[0]))
+		for i, l := range b.lines[1:] {
+			decoration := ""
+			if i < len(t.columns) && t.columns[i].pk {
+				decoration = ` text-decoration="underline"`
+			}
+			fmt.Fprintf(&out, `<text x="%.1f" y="%.1f"%s>%s</text>`, b.x+svgPad, b.y+svgHeader+float64(i)*svgRow+13, decoration, html.EscapeString(l))
+		}
+		out.WriteString("</g>\n")
+	}
+	out.WriteString("</svg>\n")
+	return out.String()
+}
+
+// handleDiagram is the tools/call entry point of the diagram tool.
+func (s *MCPMSSQLServer) handleDiagram(id interface{}, args map[string]interface{}) *MCPResponse {
+	errorResponse := func(err error) *MCPResponse {
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result: CallToolResult{
+				Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Diagram Error: %v", err)}},
+				IsError: true,
+			},
+		}
+	}
+	target, err := s.resolveTarget(args)
+	if err != nil {
+		return errorResponse(err)
+	}
+	target.tool = "diagram"
+
+	format, _ := args["format"].(string)
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format == "" {
+		format = "mermaid"
+	}
+	if !diagramFormats[format] {
+		return errorResponse(fmt.Errorf("invalid format '%s' (use mermaid, plantuml or dot)", format))
+	}
+	columns, _ := args["columns"].(string)
+	columns = strings.ToLower(strings.TrimSpace(columns))
+	if columns == "" {
+		columns = "all"
+	}
+	if columns != "all" && columns != "keys" && columns != "none" {
+		return errorResponse(fmt.Errorf("invalid columns '%s' (use all, keys or none)", columns))
+	}
+	schema, _ := args["schema"].(string)
+	schema = strings.Trim(strings.TrimSpace(schema), "[]")
+	tables := stringListArg(args["tables"])
+	center, _ := args["table"].(string)
+	center = strings.TrimSpace(center)
+	given := 0
+	for _, set := range []bool{schema != "", len(tables) > 0, center != ""} {
+		if set {
+			given++
+		}
+	}
+	if given != 1 {
+		return errorResponse(fmt.Errorf("give exactly one of 'schema', 'tables' or 'table'"))
+	}
+	if schema != "" && !validIdentifierPattern.MatchString(schema) {
+		return errorResponse(fmt.Errorf("invalid schema name '%s'", schema))
+	}
+	hops := defaultDiagramHops
+	if n, ok := args["hops"].(float64); ok {
+		if center == "" {
+			return errorResponse(fmt.Errorf("'hops' only applies with 'table'"))
+		}
+		hops = int(max(0, min(n, maxDiagramHops)))
+	}
+	withSVG, _ := args["svg"].(bool)
+
+	ctx, cancel := context.WithTimeout(context.Background(), diagramTimeout)
+	defer cancel()
+	snap, err := s.captureTableSchema(ctx, target, schema)
+	if err != nil {
+		return errorResponse(err)
+	}
+	keys, err := selectDiagramTables(snap, schema, tables, center, hops)
+	if err != nil {
+		return errorResponse(err)
+	}
+	d := buildDiagram(snap, keys, columns)
+
+	var text string
+	switch format {
+	case "plantuml":
+		text = renderPlantUML(d)
+	case "dot":
+		text = renderDOT(d)
+	default:
+		text = renderMermaid(d)
+	}
+	content := []ContentItem{{Type: "text", Text: text}}
+	var notes []string
+	if d.outside > 0 {
+		notes = append(notes, fmt.Sprintf("%d foreign key(s) reference tables outside the diagram and are not drawn", d.outside))
+	}
+	hidden := 0
+	for _, t := range d.tables {
+		if columns == "all" {
+			hidden += t.hidden
+		}
+	}
+	if hidden > 0 {
+		notes = append(notes, fmt.Sprintf("tables with more than %d columns show the first %d (%d columns left out); columns=keys draws only key columns", maxDiagramColumns, maxDiagramColumns, hidden))
+	}
+	if len(notes) > 0 {
+		content = append(content, ContentItem{Type: "text", Text: "Notes:\n- " + strings.Join(notes, "\n- ")})
+	}
+	if withSVG {
+		content = append(content, ContentItem{Type: "image", Data: base64.StdEncoding.EncodeToString([]byte(renderSVG(d))), MimeType: "image/svg+xml"})
+	}
+	return &MCPResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Result:  CallToolResult{Content: content},
+	}
+}
+
+// diagramTool describes the diagram tool.
+func diagramTool() Tool {
+	return Tool{
+		Name:        "diagram",
+		Title:       "ER Diagram",
+		Description: "Render an entity-relationship diagram of a schema, a list of tables, or the tables within N foreign-key hops of one table, with columns, PK/FK markers and crow's foot cardinality, as Mermaid (default), PlantUML or Graphviz DOT text ready to paste into docs. With svg=true it also returns the diagram drawn as an SVG image.",
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]Property{
+				"schema": {
+					Type:        "string",
+					Description: "Draw every table of this schema",
+				},
+				"tables": {
+					Type:        "string",
+					Description: "Draw these tables, comma-separated ('dbo.Orders' or 'Orders')",
+				},
+				"table": {
+					Type:        "string",
+					Description: "Draw this table and its neighbours over foreign keys (see hops)",
+				},
+				"hops": {
+					Type:        "integer",
+					Description: fmt.Sprintf("With 'table': foreign-key hops to include (default %d, max %d)", defaultDiagramHops, maxDiagramHops),
+				},
+				"format": {
+					Type:        "string",
+					Description: "'mermaid' (default), 'plantuml' or 'dot'",
+				},
+				"columns": {
+					Type:        "string",
+					Description: fmt.Sprintf("'all' (default, up to %d per table), 'keys' (PK and FK columns only) or 'none'", maxDiagramColumns),
+				},
+				"svg": {
+					Type:        "boolean",
+					Description: "Also return the diagram as an SVG image (default false)",
+				},
+			},
+		},
+		Annotations: &ToolAnnotations{
+			ReadOnlyHint:    boolPtr(true),
+			DestructiveHint: boolPtr(false),
+			IdempotentHint:  boolPtr(true),
+			OpenWorldHint:   boolPtr(false),
+		},
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// dmlSavepoint is the savepoint a guarded statement runs under inside an
+// explicit transaction, so that it alone can be rolled back.
+const dmlSavepoint = "mcp_dml"
+
+// guardedOperations are the statements max_affected_rows applies to.
+var guardedOperations = map[string]bool{"INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true}
+
+// dmlGuardPolicy applies the alias's DML guards. Both need to see the
+// statement they count, so they fail closed on anything that is not one
+// plain SELECT, INSERT, UPDATE, DELETE or MERGE: batches of several
+// statements (with or without semicolons), EXEC and dynamic SQL, and
+// statements led by SET, DECLARE or a parenthesis are refused.
+//   - require_where: UPDATE and DELETE need a WHERE clause of their own
+//     (one inside a subquery does not count); TRUNCATE is refused, and so is
+//     MERGE, whose ON/USING clauses cannot be checked for a row filter;
+//   - max_affected_rows: TRUNCATE is refused too, since the server does not
+//     report how many rows it removed.
+func (s *MCPMSSQLServer) dmlGuardPolicy(target *queryTarget, query string) error {
+	cfg := target.config
+	if !cfg.requireWhere && cfg.maxAffectedRows == 0 {
+		return nil
+	}
+	stmts := splitStatements(query)
+	if len(stmts) != 1 {
+		return fmt.Errorf("alias '%s' checks each DML statement (REQUIRE_WHERE/MAX_AFFECTED_ROWS): send one statement per request", target.alias)
+	}
+	st := stmts[0]
+	if st.keyword == "TRUNCATE" {
+		return fmt.Errorf("TRUNCATE is not allowed on alias '%s' (REQUIRE_WHERE/MAX_AFFECTED_ROWS): use DELETE with a WHERE clause", target.alias)
+	}
+	if st.keyword != "SELECT" && !guardedOperations[st.keyword] {
+		return fmt.Errorf("alias '%s' only accepts plain SELECT, INSERT, UPDATE, DELETE or MERGE statements (REQUIRE_WHERE/MAX_AFFECTED_ROWS)", target.alias)
+	}
+	if st.callsDynamicSQL() {
+		return fmt.Errorf("EXEC and dynamic SQL are not allowed on alias '%s' (REQUIRE_WHERE/MAX_AFFECTED_ROWS)", target.alias)
+	}
+	if cfg.requireWhere && st.keyword == "MERGE" {
+		return fmt.Errorf("MERGE is not allowed on alias '%s' (REQUIRE_WHERE): use UPDATE, DELETE or INSERT with a WHERE clause", target.alias)
+	}
+	if !cfg.requireWhere || (st.keyword != "UPDATE" && st.keyword != "DELETE") {
+		return nil
+	}
+	for _, t := range st.tokens {
+		if t.depth == 0 && t.upper == "WHERE" {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s without a WHERE clause is not allowed on alias '%s' (REQUIRE_WHERE)", st.keyword, target.alias)
+}
+
+// dmlScope is the transaction a guarded statement runs in: one of its own,
+// or a savepoint of the explicit transaction the statement belongs to.
+type dmlScope struct {
+	tx  *sql.Tx
+	own bool
+}
+
+func (s *MCPMSSQLServer) openDMLScope(ctx context.Context, target *queryTarget) (*dmlScope, error) {
+	if target.tx != nil {
+		tx := target.tx.sqlTx
+		if _, err := tx.ExecContext(ctx, "SAVE TRANSACTION "+dmlSavepoint); err != nil {
+			return nil, s.queryFailed(err)
+		}
+		return &dmlScope{tx: tx}, nil
+	}
+	tx, err := target.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, s.queryFailed(err)
+	}
+	return &dmlScope{tx: tx, own: true}, nil
+}
+
+// abort rolls the statement back.
+func (d *dmlScope) abort() {
+	if d.own {
+		_ = d.tx.Rollback()
+		return
+	}
+	_, _ = d.tx.ExecContext(context.Background(), "ROLLBACK TRANSACTION "+dmlSavepoint)
+}
+
+// commit commits an own transaction; a savepoint is left to the explicit
+// transaction.
+func (d *dmlScope) commit() error {
+	if !d.own {
+		return nil
+	}
+	return d.tx.Commit()
+}
+
+// maxAffectedRowsApplies reports whether query runs under the alias's
+// max_affected_rows limit. Anything dmlGuardPolicy would not recognise is
+// refused by the policy before it runs.
+func (s *MCPMSSQLServer) maxAffectedRowsApplies(target *queryTarget, query string) bool {
+	if target.alias == "" || target.config.maxAffectedRows == 0 {
+		return false
+	}
+	stmts := splitStatements(query)
+	return len(stmts) == 1 && guardedOperations[stmts[0].keyword]
+}
+
+// tooManyRows is the error for a statement rolled back by max_affected_rows.
+func tooManyRows(target *queryTarget, affected int64) error {
+	return fmt.Errorf("the statement would have affected %d rows, more than the %d allowed on alias '%s' (MAX_AFFECTED_ROWS); it was rolled back. Narrow the WHERE clause or work in batches", affected, target.config.maxAffectedRows, target.alias)
+}
+
+// executeDMLOn is executeSecureQueryOn with the alias's max_affected_rows
+// limit applied to DML.
+func (s *MCPMSSQLServer) executeDMLOn(ctx context.Context, target *queryTarget, query string, args []interface{}) ([]map[string]interface{}, error) {
+	if s.maxAffectedRowsApplies(target, query) {
+		return s.executeGuardedOn(ctx, target, query, args)
+	}
+	return s.executeSecureQueryOn(ctx, target, query, args...)
+}
+
+// executeGuardedOn runs a DML statement in a transaction and rolls it back
+// when the server reports more affected rows than max_affected_rows.
+func (s *MCPMSSQLServer) executeGuardedOn(ctx context.Context, target *queryTarget, query string, args []interface{}) ([]map[string]interface{}, error) {
+	if err := s.enforcePolicy(policyRequest{target: target, query: query}); err != nil {
+		return nil, err
+	}
+	if target.tx != nil {
+		target.tx.record(s.statementOperation(query), query, len(args))
+	}
+	scope, err := s.openDMLScope(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	res, err := scope.tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		scope.abort()
+		return nil, s.queryFailed(err)
+	}
+	// RowsAffected is the server's @@ROWCOUNT for the statement.
+	affected, err := res.RowsAffected()
+	if err != nil {
+		scope.abort()
+		return nil, s.queryFailed(err)
+	}
+	if affected > int64(target.config.maxAffectedRows) {
+		scope.abort()
+		s.secLogger.Printf("DML on alias '%s' rolled back: %d rows affected, limit %d", target.alias, affected, target.config.maxAffectedRows)
+		return nil, tooManyRows(target, affected)
+	}
+	if err := scope.commit(); err != nil {
+		return nil, s.queryFailed(err)
+	}
+	return []map[string]interface{}{{"rows_affected": affected}}, nil
+}
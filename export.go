@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultExportMaxBytes caps the size of one export file
+	// (MSSQL_EXPORT_MAX_BYTES).
+	defaultExportMaxBytes = 512 << 20
+	// defaultExportTimeout bounds one export (MSSQL_EXPORT_TIMEOUT): unlike
+	// query_database it streams the whole result.
+	defaultExportTimeout = 10 * time.Minute
+)
+
+// exportExtensions are the formats export_query writes, with their file
+// extension.
+var exportExtensions = map[string]string{
+	"csv":     ".csv",
+	"jsonl":   ".jsonl",
+	"xlsx":    ".xlsx",
+	"parquet": ".parquet",
+}
+
+// exportColumn is one column of an exported result. Names are made unique
+// (a result may repeat a name or have unnamed columns); Type is the SQL type
+// in lower case.
+type exportColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// exportWriter writes rows in one format. close writes whatever the format
+// needs after the last row; it does not close the underlying writer.
+type exportWriter interface {
+	writeRow(values []interface{}) error
+	close() error
+}
+
+// exportResult is the reply of export_query.
+type exportResult struct {
+	File    string         `json:"file"`
+	Format  string         `json:"format"`
+	Rows    int64          `json:"rows"`
+	Bytes   int64          `json:"bytes"`
+	SHA256  string         `json:"sha256"`
+	Columns []exportColumn `json:"columns"`
+	Notes   []string       `json:"notes,omitempty"`
+}
+
+// errExportTooLarge stops an export at MSSQL_EXPORT_MAX_BYTES.
+var errExportTooLarge = errors.New("export size limit reached")
+
+// exportSink counts and hashes what is written to the export file, and
+// refuses to go past the byte cap.
+type exportSink struct {
+	w     io.Writer
+	hash  hash.Hash
+	n     int64
+	limit int64
+}
+
+func (s *exportSink) Write(p []byte) (int, error) {
+	if s.n+int64(len(p)) > s.limit {
+		return 0, errExportTooLarge
+	}
+	n, err := s.w.Write(p)
+	s.n += int64(n)
+	s.hash.Write(p[:n])
+	return n, err
+}
+
+func exportMaxBytes() int64 {
+	if n, err := strconv.ParseInt(strings.TrimSpace(os.Getenv("MSSQL_EXPORT_MAX_BYTES")), 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return defaultExportMaxBytes
+}
+
+// exportColumns names the result columns, making them unique.
+func exportColumns(names, types []string) []exportColumn {
+	cols := make([]exportColumn, len(names))
+	seen := make(map[string]bool, len(names))
+	for i, name := range names {
+		if name == "" {
+			name = fmt.Sprintf("column_%d", i+1)
+		}
+		unique := name
+		for n := 2; seen[strings.ToLower(unique)]; n++ {
+			unique = fmt.Sprintf("%s_%d", name, n)
+		}
+		seen[strings.ToLower(unique)] = true
+		cols[i] = exportColumn{Name: unique, Type: strings.ToLower(types[i])}
+	}
+	return cols
+}
+
+// exportValue converts a scanned value the way the undo journal does
+// (decimals as text, GUIDs and binary readable, dates in ISO 8601), except
+// that bigint stays a number, as export files are not read by JavaScript,
+// and real keeps its single precision.
+func exportValue(col exportColumn, v interface{}) interface{} {
+	switch v.(type) {
+	case int64, float32:
+		return v
+	}
+	return encodeUndoValue(undoColumn{Type: col.Type}, v)
+}
+
+// exportText renders a value as text; ok is false for NULL.
+func exportText(col exportColumn, v interface{}) (string, bool) {
+	switch t := exportValue(col, v).(type) {
+	case nil:
+		return "", false
+	case string:
+		return t, true
+	case int64:
+		return strconv.FormatInt(t, 10), true
+	case float64:
+		return strconv.FormatFloat(t, 'g', -1, 64), true
+	case float32:
+		return strconv.FormatFloat(float64(t), 'g', -1, 32), true
+	case bool:
+		return strconv.FormatBool(t), true
+	default:
+		return fmt.Sprint(t), true
+	}
+}
+
+// csvExport writes a header line and one line per row; NULL is an empty
+// field.
+type csvExport struct {
+	w      *csv.Writer
+	cols   []exportColumn
+	record []string
+}
+
+func newCSVExport(w io.Writer, cols []exportColumn) (exportWriter, error) {
+	e := &csvExport{w: csv.NewWriter(w), cols: cols, record: make([]string, len(cols))}
+	for i, c := range cols {
+		e.record[i] = c.Name
+	}
+	return e, e.w.Write(e.record)
+}
+
+func (e *csvExport) writeRow(values []interface{}) error {
+	for i, v := range values {
+		e.record[i], _ = exportText(e.cols[i], v)
+	}
+	return e.w.Write(e.record)
+}
+
+func (e *csvExport) close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// jsonlExport writes one JSON object per row, keys in column order.
+type jsonlExport struct {
+	w    *bufio.Writer
+	buf  bytes.Buffer
+	enc  *json.Encoder
+	cols []exportColumn
+}
+
+func newJSONLExport(w io.Writer, cols []exportColumn) (exportWriter, error) {
+	e := &jsonlExport{w: bufio.NewWriter(w), cols: cols}
+	e.enc = json.NewEncoder(&e.buf)
+	e.enc.SetEscapeHTML(false)
+	return e, nil
+}
+
+// value writes v without the newline json.Encoder appends.
+func (e *jsonlExport) value(v interface{}) error {
+	e.buf.Reset()
+	if err := e.enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := e.w.Write(bytes.TrimSuffix(e.buf.Bytes(), []byte("\n")))
+	return err
+}
+
+func (e *jsonlExport) writeRow(values []interface{}) error {
+	_ = e.w.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			_ = e.w.WriteByte(',')
+		}
+		if err := e.value(e.cols[i].Name); err != nil {
+			return err
+		}
+		_ = e.w.WriteByte(':')
+		if err := e.value(exportValue(e.cols[i], v)); err != nil {
+			return fmt.Errorf("column %s: %w", e.cols[i].Name, err)
+		}
+	}
+	_, err := e.w.WriteString("}\n")
+	return err
+}
+
+func (e *jsonlExport) close() error {
+	return e.w.Flush()
+}
+
+func newExportWriter(format string, w io.Writer, cols []exportColumn) (exportWriter, error) {
+	switch format {
+	case "csv":
+		return newCSVExport(w, cols)
+	case "jsonl":
+		return newJSONLExport(w, cols)
+	case "xlsx":
+		return newXLSXExport(w, cols)
+	case "parquet":
+		return newParquetExport(w, cols)
+	}
+	return nil, fmt.Errorf("invalid format '%s' (use csv, jsonl, xlsx or parquet)", format)
+}
+
+// exportQuery runs query and streams every row into a new file of the
+// export directory. The file is written under a temporary name and renamed
+// once complete, so a failed export never leaves a partial file behind.
+func (s *MCPMSSQLServer) exportQuery(ctx context.Context, target *queryTarget, query string, queryArgs []interface{}, format, name string, overwrite bool) (*exportResult, error) {
+	ext, ok := exportExtensions[format]
+	if !ok {
+		return nil, fmt.Errorf("invalid format '%s' (use csv, jsonl, xlsx or parquet)", format)
+	}
+	dir, err := sandboxDir("MSSQL_EXPORT_DIR", "exports")
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = "export-" + time.Now().Format("20060102-150405") + ext
+	}
+	if given := filepath.Ext(name); given != "" && !strings.EqualFold(given, ext) {
+		return nil, fmt.Errorf("file name '%s' does not match format '%s' (use a %s file name)", name, format, ext)
+	}
+	path, err := sandboxFile(dir, name, ext)
+	if err != nil {
+		return nil, err
+	}
+	if !overwrite {
+		if _, err := os.Stat(path); err == nil {
+			return nil, fmt.Errorf("file '%s' already exists in the export directory (set overwrite=true to replace it)", filepath.Base(path))
+		}
+	}
+
+	// Only a single SELECT that reads, whatever the posture: the same
+	// pipeline as explain_query mode=actual.
+	if err := s.enforcePolicy(policyRequest{target: target, query: query, mode: policyStrictRead}); err != nil {
+		return nil, err
+	}
+	if target.db == nil {
+		return nil, fmt.Errorf("database not connected")
+	}
+	rows, err := target.db.QueryContext(ctx, query, queryArgs...)
+	if err != nil {
+		return nil, s.queryFailed(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	names, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	types := make([]string, len(colTypes))
+	for i, ct := range colTypes {
+		types[i] = ct.DatabaseTypeName()
+	}
+	cols := exportColumns(names, types)
+
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(dir, ".export-*.partial")
+	if err != nil {
+		return nil, err
+	}
+	done := false
+	defer func() {
+		if !done {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	sink := &exportSink{w: tmp, hash: sha256.New(), limit: exportMaxBytes()}
+	result := &exportResult{Format: format, Columns: cols}
+	fail := func(err error) error {
+		if errors.Is(err, errExportTooLarge) {
+			return fmt.Errorf("export stopped after %d rows: the file would exceed %d bytes (MSSQL_EXPORT_MAX_BYTES). Narrow the query or raise the limit", result.Rows, sink.limit)
+		}
+		return err
+	}
+	w, err := newExportWriter(format, sink, cols)
+	if err != nil {
+		return nil, fail(err)
+	}
+
+	values := make([]interface{}, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		if err := w.writeRow(values); err != nil {
+			return nil, fail(err)
+		}
+		result.Rows++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, s.queryFailed(err)
+	}
+	if err := w.close(); err != nil {
+		return nil, fail(err)
+	}
+	if x, ok := w.(*xlsxExport); ok && x.truncated > 0 {
+		result.Notes = append(result.Notes, fmt.Sprintf("%d cells longer than %d characters were truncated (an XLSX cell limit)", x.truncated, xlsxMaxCellChars))
+	}
+
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, err
+	}
+	done = true
+
+	result.File = path
+	result.Bytes = sink.n
+	result.SHA256 = hex.EncodeToString(sink.hash.Sum(nil))
+	s.secLogger.Printf("export_query: %d rows (%d bytes, %s) exported from alias '%s' to %s", result.Rows, result.Bytes, format, target.alias, path)
+	return result, nil
+}
+
+// handleExportQuery is the tools/call entry point of the export_query tool.
+func (s *MCPMSSQLServer) handleExportQuery(id interface{}, args map[string]interface{}) *MCPResponse {
+	errorResponse := func(err error) *MCPResponse {
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result: CallToolResult{
+				Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Export Error: %v", err)}},
+				IsError: true,
+			},
+		}
+	}
+
+	if !s.getGlobalConfig().exportEnabled {
+		return errorResponse(fmt.Errorf("export_query is not enabled: set MSSQL_EXPORT_DIR to the directory exports are written to"))
+	}
+	target, err := s.resolveTarget(args)
+	if err != nil {
+		return errorResponse(err)
+	}
+	target.tool = "export_query"
+	query, _ := args["query"].(string)
+	if strings.TrimSpace(query) == "" {
+		return errorResponse(fmt.Errorf("missing or invalid 'query' parameter"))
+	}
+	queryArgs, err := parseQueryParams(args["params"])
+	if err != nil {
+		return errorResponse(err)
+	}
+	format, _ := args["format"].(string)
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format == "" {
+		format = "csv"
+	}
+	name, _ := args["file_name"].(string)
+	overwrite, _ := args["overwrite"].(bool)
+
+	ctx, cancel := context.WithTimeout(context.Background(), envSeconds("MSSQL_EXPORT_TIMEOUT", defaultExportTimeout))
+	defer cancel()
+
+	result, err := s.exportQuery(ctx, target, query, queryArgs, format, strings.TrimSpace(name), overwrite)
+	if err != nil {
+		return errorResponse(err)
+	}
+	out, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return errorResponse(err)
+	}
+	return &MCPResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Result: CallToolResult{
+			Content: []ContentItem{{Type: "text", Text: string(out)}},
+		},
+	}
+}
+
+// exportTool describes export_query.
+func exportTool() Tool {
+	return Tool{
+		Name:        "export_query",
+		Title:       "Export Query Results",
+		Description: "Run a single read-only SELECT and stream ALL its rows (no 500-row cap) into a CSV, JSON Lines, XLSX or Parquet file in the export directory (MSSQL_EXPORT_DIR). Returns the file path, row count, size and SHA-256 checksum. The same read policy as explain_query mode=actual applies; files are capped at MSSQL_EXPORT_MAX_BYTES.",
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]Property{
+				"query": {
+					Type:        "string",
+					Description: "SELECT query whose full result is exported",
+				},
+				"params": {
+					Types:       []string{"array", "object", "string"},
+					Description: "Query parameters, as in query_database (optional): an array for @p1..@pN or an object for named parameters",
+				},
+				"format": {
+					Type:        "string",
+					Description: "'csv' (default), 'jsonl', 'xlsx' or 'parquet'",
+				},
+				"file_name": {
+					Type:        "string",
+					Description: "Plain file name in the export directory, no directories (default: export-<timestamp>.<format>)",
+				},
+				"overwrite": {
+					Type:        "boolean",
+					Description: "Replace an existing file with the same name (default false)",
+				},
+			},
+			Required: []string{"query"},
+		},
+		Annotations: &ToolAnnotations{
+			ReadOnlyHint:    boolPtr(false), // writes a local file
+			DestructiveHint: boolPtr(false),
+			IdempotentHint:  boolPtr(false),
+			OpenWorldHint:   boolPtr(false),
+		},
+	}
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// The export writes the simplest Parquet a reader accepts: every column
+// OPTIONAL, one uncompressed PLAIN data page (v1) per column chunk, and a
+// row group every parquetRowGroupRows rows or parquetRowGroupBytes of
+// buffered values. The footer is Thrift compact protocol, written by hand
+// (thriftWriter) for the few structures involved.
+const (
+	parquetRowGroupRows  = 65536
+	parquetRowGroupBytes = 32 << 20
+	parquetCreatedBy     = "mcp-go-mssql export_query"
+	parquetDayMicros     = 86400 * 1000000
+)
+
+// Parquet physical types, converted types and encodings used here.
+const (
+	parquetBoolean   = 0
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetConvertedNone            = -1
+	parquetConvertedUTF8            = 0
+	parquetConvertedDate            = 6
+	parquetConvertedTimestampMicros = 10
+
+	parquetEncodingPlain = 0
+	parquetEncodingRLE   = 3
+)
+
+// parquetLogical is the logical type annotation of a column.
+type parquetLogical int
+
+const (
+	parquetLogicalNone parquetLogical = iota
+	parquetLogicalString
+	parquetLogicalDate
+	parquetLogicalTimestampLocal // datetime, datetime2: wall clock time
+	parquetLogicalTimestampUTC   // datetimeoffset: an instant
+)
+
+// parquetColumn is one column and its values buffered for the current row
+// group.
+type parquetColumn struct {
+	exportColumn
+	physical  int32
+	converted int32
+	logical   parquetLogical
+
+	defined []bool // definition level of each row
+	bools   []bool
+	values  bytes.Buffer // PLAIN-encoded non-null values (except booleans)
+
+	chunks []parquetChunk
+}
+
+// parquetChunk is where a column chunk was written.
+type parquetChunk struct {
+	offset int64
+	size   int64
+	values int64
+}
+
+// parquetExport buffers a row group at a time and writes the footer on
+// close.
+type parquetExport struct {
+	w         io.Writer
+	offset    int64
+	cols      []*parquetColumn
+	rows      int   // rows in the current row group
+	groupRows []int // rows of each written row group
+	total     int64
+}
+
+// parquetColumnFor maps an SQL type to its Parquet representation. Types
+// without an exact Parquet equivalent (decimal, money, time,
+// uniqueidentifier, xml...) are written as strings, like in the other
+// formats.
+func parquetColumnFor(col exportColumn) *parquetColumn {
+	c := &parquetColumn{exportColumn: col, physical: parquetByteArray, converted: parquetConvertedUTF8, logical: parquetLogicalString}
+	switch col.Type {
+	case "bit":
+		c.physical, c.converted, c.logical = parquetBoolean, parquetConvertedNone, parquetLogicalNone
+	case "tinyint", "smallint", "int":
+		c.physical, c.converted, c.logical = parquetInt32, parquetConvertedNone, parquetLogicalNone
+	case "bigint":
+		c.physical, c.converted, c.logical = parquetInt64, parquetConvertedNone, parquetLogicalNone
+	case "real", "float":
+		c.physical, c.converted, c.logical = parquetDouble, parquetConvertedNone, parquetLogicalNone
+	case "date":
+		c.physical, c.converted, c.logical = parquetInt32, parquetConvertedDate, parquetLogicalDate
+	case "datetime", "datetime2", "smalldatetime":
+		c.physical, c.converted, c.logical = parquetInt64, parquetConvertedNone, parquetLogicalTimestampLocal
+	case "datetimeoffset":
+		c.physical, c.converted, c.logical = parquetInt64, parquetConvertedTimestampMicros, parquetLogicalTimestampUTC
+	case "binary", "varbinary", "image", "timestamp", "rowversion":
+		c.converted, c.logical = parquetConvertedNone, parquetLogicalNone
+	}
+	return c
+}
+
+func newParquetExport(w io.Writer, cols []exportColumn) (exportWriter, error) {
+	e := &parquetExport{w: w}
+	for _, col := range cols {
+		e.cols = append(e.cols, parquetColumnFor(col))
+	}
+	return e, e.write([]byte("PAR1"))
+}
+
+func (e *parquetExport) write(p []byte) error {
+	n, err := e.w.Write(p)
+	e.offset += int64(n)
+	return err
+}
+
+// wallMicros is the wall clock time of t as microseconds since the epoch,
+// ignoring its location.
+func wallMicros(t time.Time) int64 {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC).UnixMicro()
+}
+
+func (c *parquetColumn) add(v interface{}) error {
+	if v == nil {
+		c.defined = append(c.defined, false)
+		return nil
+	}
+	var buf [8]byte
+	switch c.physical {
+	case parquetBoolean:
+		b, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("column %s: unexpected %T value", c.Name, v)
+		}
+		c.bools = append(c.bools, b)
+	case parquetInt32:
+		var n int64
+		switch t := v.(type) {
+		case int64:
+			n = t
+		case time.Time:
+			// DATE counts days since 1970-01-01.
+			micros := wallMicros(t)
+			n = micros / parquetDayMicros
+			if micros%parquetDayMicros < 0 {
+				n--
+			}
+		default:
+			return fmt.Errorf("column %s: unexpected %T value", c.Name, v)
+		}
+		binary.LittleEndian.PutUint32(buf[:4], uint32(int32(n)))
+		c.values.Write(buf[:4])
+	case parquetInt64:
+		var n int64
+		switch t := v.(type) {
+		case int64:
+			n = t
+		case time.Time:
+			if c.logical == parquetLogicalTimestampUTC {
+				n = t.UnixMicro()
+			} else {
+				n = wallMicros(t)
+			}
+		default:
+			return fmt.Errorf("column %s: unexpected %T value", c.Name, v)
+		}
+		binary.LittleEndian.PutUint64(buf[:], uint64(n))
+		c.values.Write(buf[:])
+	case parquetDouble:
+		var f float64
+		switch t := v.(type) {
+		case float64:
+			f = t
+		case float32:
+			f = float64(t)
+		default:
+			return fmt.Errorf("column %s: unexpected %T value", c.Name, v)
+		}
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(f))
+		c.values.Write(buf[:])
+	default:
+		var data []byte
+		if b, ok := v.([]byte); ok && c.logical == parquetLogicalNone {
+			data = b
+		} else {
+			text, _ := exportText(c.exportColumn, v)
+			data = []byte(text)
+		}
+		binary.LittleEndian.PutUint32(buf[:4], uint32(len(data)))
+		c.values.Write(buf[:4])
+		c.values.Write(data)
+	}
+	c.defined = append(c.defined, true)
+	return nil
+}
+
+func (e *parquetExport) writeRow(values []interface{}) error {
+	buffered := 0
+	for i, v := range values {
+		if err := e.cols[i].add(v); err != nil {
+			return err
+		}
+		buffered += e.cols[i].values.Len()
+	}
+	e.rows++
+	e.total++
+	if e.rows >= parquetRowGroupRows || buffered >= parquetRowGroupBytes {
+		return e.flushRowGroup()
+	}
+	return nil
+}
+
+// rleLevels encodes definition levels (bit width 1) as RLE runs of the
+// RLE/bit-packed hybrid encoding, preceded by their length.
+func rleLevels(defined []bool) []byte {
+	var runs bytes.Buffer
+	var tmp [binary.MaxVarintLen64]byte
+	for i := 0; i < len(defined); {
+		j := i
+		for j < len(defined) && defined[j] == defined[i] {
+			j++
+		}
+		runs.Write(tmp[:binary.PutUvarint(tmp[:], uint64(j-i)<<1)])
+		if defined[i] {
+			runs.WriteByte(1)
+		} else {
+			runs.WriteByte(0)
+		}
+		i = j
+	}
+	out := make([]byte, 4, 4+runs.Len())
+	binary.LittleEndian.PutUint32(out, uint32(runs.Len()))
+	return append(out, runs.Bytes()...)
+}
+
+// packBools is the PLAIN encoding of booleans: one bit each, LSB first.
+func packBools(values []bool) []byte {
+	out := make([]byte, (len(values)+7)/8)
+	for i, b := range values {
+		if b {
+			out[i/8] |= 1 << (i % 8)
+		}
+	}
+	return out
+}
+
+func (e *parquetExport) flushRowGroup() error {
+	if e.rows == 0 {
+		return nil
+	}
+	for _, c := range e.cols {
+		page := rleLevels(c.defined)
+		if c.physical == parquetBoolean {
+			page = append(page, packBools(c.bools)...)
+		} else {
+			page = append(page, c.values.Bytes()...)
+		}
+
+		var h thriftWriter
+		h.i32(1, 0) // DATA_PAGE
+		h.i32(2, int32(len(page)))
+		h.i32(3, int32(len(page)))
+		h.beginStruct(5) // DataPageHeader
+		h.i32(1, int32(len(c.defined)))
+		h.i32(2, parquetEncodingPlain)
+		h.i32(3, parquetEncodingRLE)
+		h.i32(4, parquetEncodingRLE)
+		h.endStruct()
+		h.stop()
+
+		chunk := parquetChunk{offset: e.offset, size: int64(h.buf.Len() + len(page)), values: int64(len(c.defined))}
+		if err := e.write(h.buf.Bytes()); err != nil {
+			return err
+		}
+		if err := e.write(page); err != nil {
+			return err
+		}
+		c.chunks = append(c.chunks, chunk)
+		c.defined, c.bools = c.defined[:0], c.bools[:0]
+		c.values.Reset()
+	}
+	e.groupRows = append(e.groupRows, e.rows)
+	e.rows = 0
+	return nil
+}
+
+func (e *parquetExport) close() error {
+	if err := e.flushRowGroup(); err != nil {
+		return err
+	}
+
+	var f thriftWriter
+	f.i32(1, 1) // version
+	f.beginList(2, thriftStruct, len(e.cols)+1)
+	f.beginElem()
+	f.binary(4, []byte("schema"))
+	f.i32(5, int32(len(e.cols)))
+	f.endElem()
+	for _, c := range e.cols {
+		f.beginElem()
+		f.i32(1, c.physical)
+		f.i32(3, 1) // OPTIONAL
+		f.binary(4, []byte(c.Name))
+		if c.converted != parquetConvertedNone {
+			f.i32(6, c.converted)
+		}
+		if c.logical != parquetLogicalNone {
+			f.beginStruct(10) // LogicalType union
+			switch c.logical {
+			case parquetLogicalString:
+				f.emptyStruct(1)
+			case parquetLogicalDate:
+				f.emptyStruct(6)
+			case parquetLogicalTimestampLocal, parquetLogicalTimestampUTC:
+				f.beginStruct(8) // TimestampType
+				f.boolean(1, c.logical == parquetLogicalTimestampUTC)
+				f.beginStruct(2) // TimeUnit union
+				f.emptyStruct(2) // MICROS
+				f.endStruct()
+				f.endStruct()
+			}
+			f.endStruct()
+		}
+		f.endElem()
+	}
+	f.i64(3, e.total)
+	f.beginList(4, thriftStruct, len(e.groupRows))
+	for g, rows := range e.groupRows {
+		f.beginElem()
+		f.beginList(1, thriftStruct, len(e.cols))
+		var groupSize int64
+		for _, c := range e.cols {
+			chunk := c.chunks[g]
+			groupSize += chunk.size
+			f.beginElem()
+			f.i64(2, chunk.offset)
+			f.beginStruct(3) // ColumnMetaData
+			f.i32(1, c.physical)
+			f.beginList(2, thriftI32, 2)
+			f.listI32(parquetEncodingPlain)
+			f.listI32(parquetEncodingRLE)
+			f.beginList(3, thriftBinary, 1)
+			f.listBinary([]byte(c.Name))
+			f.i32(4, 0) // UNCOMPRESSED
+			f.i64(5, chunk.values)
+			f.i64(6, chunk.size)
+			f.i64(7, chunk.size)
+			f.i64(9, chunk.offset)
+			f.endStruct()
+			f.endElem()
+		}
+		f.i64(2, groupSize)
+		f.i64(3, int64(rows))
+		f.endElem()
+	}
+	f.binary(6, []byte(parquetCreatedBy))
+	f.stop()
+
+	footer := f.buf.Bytes()
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(footer)))
+	for _, p := range [][]byte{footer, size[:], []byte("PAR1")} {
+		if err := e.write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Thrift compact protocol type ids.
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter writes Thrift compact protocol structs. Fields must be
+// written in increasing id order within a struct; list elements that are
+// structs are bracketed by beginElem/endElem.
+type thriftWriter struct {
+	buf   bytes.Buffer
+	last  int16
+	stack []int16
+}
+
+func (t *thriftWriter) uvarint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	t.buf.Write(tmp[:binary.PutUvarint(tmp[:], v)])
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if delta := id - t.last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.uvarint(uint64(uint16((id << 1) ^ (id >> 15))))
+	}
+	t.last = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.uvarint(uint64(uint32((v << 1) ^ (v >> 31))))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.uvarint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (t *thriftWriter) binary(id int16, v []byte) {
+	t.field(id, thriftBinary)
+	t.listBinary(v)
+}
+
+func (t *thriftWriter) boolean(id int16, v bool) {
+	if v {
+		t.field(id, thriftTrue)
+	} else {
+		t.field(id, thriftFalse)
+	}
+}
+
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.beginElem()
+}
+
+func (t *thriftWriter) endStruct() {
+	t.endElem()
+}
+
+func (t *thriftWriter) emptyStruct(id int16) {
+	t.beginStruct(id)
+	t.endStruct()
+}
+
+func (t *thriftWriter) beginElem() {
+	t.stack = append(t.stack, t.last)
+	t.last = 0
+}
+
+func (t *thriftWriter) endElem() {
+	t.stop()
+	t.last = t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+}
+
+func (t *thriftWriter) stop() {
+	t.buf.WriteByte(0)
+}
+
+func (t *thriftWriter) beginList(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf.WriteByte(byte(n)<<4 | elem)
+		return
+	}
+	t.buf.WriteByte(0xf0 | elem)
+	t.uvarint(uint64(n))
+}
+
+func (t *thriftWriter) listI32(v int32) {
+	t.uvarint(uint64(uint32((v << 1) ^ (v >> 31))))
+}
+
+func (t *thriftWriter) listBinary(v []byte) {
+	t.uvarint(uint64(len(v)))
+	t.buf.Write(v)
+}
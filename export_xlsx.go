@@ -0,0 +1,235 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	xlsxMaxRows      = 1048576
+	xlsxMaxColumns   = 16384
+	xlsxMaxCellChars = 32767
+	// xlsxMaxExactDigits is the longest number Excel stores exactly (it
+	// keeps 15 significant digits); longer ones are written as text.
+	xlsxMaxExactDigits = 15
+)
+
+// Cell styles of xlsxStyles: 0 default, 1 date, 2 date and time, 3 header.
+const (
+	xlsxStyleDate     = 1
+	xlsxStyleDateTime = 2
+	xlsxStyleHeader   = 3
+)
+
+// The fixed parts of a one-sheet workbook. Strings are written inline in
+// the sheet, so there is no shared string table to build in memory.
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/><Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/></Types>`
+	xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Export" sheetId="1" r:id="rId1"/></sheets></workbook>`
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/></Relationships>`
+	xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><numFmts count="1"><numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm:ss"/></numFmts><fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts><fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills><borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders><cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs><cellXfs count="4"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="14" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/><xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs></styleSheet>`
+	xlsxSheetStart = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews><sheetData>`
+	xlsxSheetEnd = `</sheetData></worksheet>`
+)
+
+// xlsxEpoch is day zero of Excel's date serial numbers (1900 date system);
+// before xlsxFirstSerial they are off by Excel's fictitious 29 Feb 1900.
+var (
+	xlsxEpoch       = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	xlsxFirstSerial = time.Date(1900, 3, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// xlsxExport streams the rows into the worksheet of a minimal workbook: the
+// sheet is the last zip entry written, after the fixed parts.
+type xlsxExport struct {
+	zip       *zip.Writer
+	sheet     *bufio.Writer
+	cols      []exportColumn
+	refs      []string // column letters
+	row       int
+	truncated int
+}
+
+func newXLSXExport(w io.Writer, cols []exportColumn) (exportWriter, error) {
+	if len(cols) > xlsxMaxColumns {
+		return nil, fmt.Errorf("the result has %d columns, more than an XLSX sheet holds (%d); use csv, jsonl or parquet", len(cols), xlsxMaxColumns)
+	}
+	e := &xlsxExport{zip: zip.NewWriter(w), cols: cols}
+	for _, part := range []struct{ name, body string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/styles.xml", xlsxStyles},
+	} {
+		f, err := e.zip.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.body); err != nil {
+			return nil, err
+		}
+	}
+	f, err := e.zip.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	e.sheet = bufio.NewWriter(f)
+	_, _ = e.sheet.WriteString(xlsxSheetStart)
+
+	e.refs = make([]string, len(cols))
+	for i := range cols {
+		e.refs[i] = xlsxColumnName(i)
+	}
+	e.startRow()
+	for i, c := range cols {
+		e.inlineString(i, c.Name, xlsxStyleHeader)
+	}
+	_, err = e.sheet.WriteString("</row>")
+	return e, err
+}
+
+// xlsxColumnName returns the letters of the zero-based column i (A, ..., Z,
+// AA, ...).
+func xlsxColumnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+func (e *xlsxExport) startRow() {
+	e.row++
+	fmt.Fprintf(e.sheet, `<row r="%d">`, e.row)
+}
+
+func (e *xlsxExport) ref(i int) string {
+	return e.refs[i] + strconv.Itoa(e.row)
+}
+
+func (e *xlsxExport) inlineString(i int, s string, style int) {
+	if utf8.RuneCountInString(s) > xlsxMaxCellChars {
+		s = string([]rune(s)[:xlsxMaxCellChars])
+		e.truncated++
+	}
+	fmt.Fprintf(e.sheet, `<c r="%s" t="inlineStr"`, e.ref(i))
+	if style != 0 {
+		fmt.Fprintf(e.sheet, ` s="%d"`, style)
+	}
+	_, _ = e.sheet.WriteString(`><is><t xml:space="preserve">`)
+	_ = xml.EscapeText(e.sheet, []byte(s))
+	_, _ = e.sheet.WriteString(`</t></is></c>`)
+}
+
+func (e *xlsxExport) number(i int, n string, style int) {
+	fmt.Fprintf(e.sheet, `<c r="%s"`, e.ref(i))
+	if style != 0 {
+		fmt.Fprintf(e.sheet, ` s="%d"`, style)
+	}
+	fmt.Fprintf(e.sheet, `><v>%s</v></c>`, n)
+}
+
+// exactNumber reports whether the decimal text n is a plain number Excel
+// keeps without losing significant digits.
+func exactNumber(n string) bool {
+	digits := strings.TrimPrefix(n, "-")
+	if strings.Count(digits, ".") > 1 {
+		return false
+	}
+	digits = strings.Replace(digits, ".", "", 1)
+	if digits == "" {
+		return false
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(strings.Trim(digits, "0")) <= xlsxMaxExactDigits
+}
+
+// xlsxSerial converts a date and time to an Excel serial number, keeping
+// the wall clock time as written in the database. Excel serials are only
+// right from March 1900 on; earlier values are written as text.
+func xlsxSerial(t time.Time) (string, bool) {
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	if wall.Before(xlsxFirstSerial) {
+		return "", false
+	}
+	secs := float64(wall.Unix()-xlsxEpoch.Unix()) + float64(wall.Nanosecond())/1e9
+	return strconv.FormatFloat(secs/86400, 'f', -1, 64), true
+}
+
+func (e *xlsxExport) writeRow(values []interface{}) error {
+	if e.row >= xlsxMaxRows {
+		return fmt.Errorf("the result has more rows than an XLSX sheet holds (%d including the header); use csv, jsonl or parquet", xlsxMaxRows)
+	}
+	e.startRow()
+	for i, v := range values {
+		col := e.cols[i]
+		switch t := v.(type) {
+		case nil:
+			continue
+		case bool:
+			b := "0"
+			if t {
+				b = "1"
+			}
+			fmt.Fprintf(e.sheet, `<c r="%s" t="b"><v>%s</v></c>`, e.ref(i), b)
+			continue
+		case time.Time:
+			style := xlsxStyleDateTime
+			switch col.Type {
+			case "date":
+				style = xlsxStyleDate
+			case "datetime", "datetime2", "smalldatetime":
+			default:
+				style = 0 // time and datetimeoffset stay text
+			}
+			if serial, ok := xlsxSerial(t); ok && style != 0 {
+				e.number(i, serial, style)
+				continue
+			}
+		case float64:
+			e.number(i, strconv.FormatFloat(t, 'g', -1, 64), 0)
+			continue
+		case float32:
+			e.number(i, strconv.FormatFloat(float64(t), 'g', -1, 32), 0)
+			continue
+		}
+		text, _ := exportText(col, v)
+		switch col.Type {
+		case "tinyint", "smallint", "int", "bigint", "decimal", "numeric", "money", "smallmoney":
+			if exactNumber(text) {
+				e.number(i, text, 0)
+				continue
+			}
+		}
+		e.inlineString(i, text, 0)
+	}
+	_, err := e.sheet.WriteString("</row>")
+	return err
+}
+
+func (e *xlsxExport) close() error {
+	_, _ = e.sheet.WriteString(xlsxSheetEnd)
+	if err := e.sheet.Flush(); err != nil {
+		return err
+	}
+	return e.zip.Close()
+}
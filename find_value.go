@@ -0,0 +1,540 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultFindValueBudget = 30 * time.Second
+	// findValueColumnTimeout bounds each per-column query, so one huge
+	// unindexed column cannot use the whole budget.
+	findValueColumnTimeout = 10 * time.Second
+	defaultFindValueRate   = 10
+	defaultFindValueMax    = 20
+	maxFindValueMax        = 200
+	// findValueSamples is the number of matching rows reported per column.
+	findValueSamples     = 3
+	defaultFindValueCols = 500
+	maxFindValueCols     = 5000
+	findValueValueLimit  = 200
+)
+
+// findValueColumnsQuery lists every column of the user tables with what
+// decides whether and how it is searched, and its position in the primary
+// key (0 when not part of it). Views are left out: their rows are found
+// in their base tables.
+const findValueColumnsQuery = `SELECT s.name, t.name, c.name, TYPE_NAME(c.system_type_id), c.max_length, c.precision, c.scale,
+	CAST(ISNULL(COLUMNPROPERTY(c.object_id, c.name, 'IsMasked'), 0) AS bit),
+	ISNULL((SELECT ic.key_ordinal FROM sys.indexes i
+		JOIN sys.index_columns ic ON ic.object_id = i.object_id AND ic.index_id = i.index_id
+		WHERE i.object_id = c.object_id AND i.is_primary_key = 1 AND ic.column_id = c.column_id), 0)
+FROM sys.columns c
+JOIN sys.tables t ON t.object_id = c.object_id
+JOIN sys.schemas s ON s.schema_id = t.schema_id
+WHERE t.is_ms_shipped = 0
+ORDER BY s.name, t.name, c.column_id`
+
+// findValueNumber is the shape of a value searched in numeric columns;
+// anything else is only searched as text.
+var findValueNumber = regexp.MustCompile(`^[+-]?\d+(\.\d+)?$`)
+
+// findValueColumn is one column of a searched table.
+type findValueColumn struct {
+	schema, table, name string
+	typ                 queryParamType
+	masked              bool
+	keyOrdinal          int
+}
+
+// findValueTable groups the columns of one table with its primary key.
+type findValueTable struct {
+	schema, table string
+	key           []string
+	columns       []findValueColumn
+}
+
+func (t *findValueTable) name() string { return t.schema + "." + t.table }
+
+// findValueColumnType turns sys.columns metadata into a queryParamType,
+// lengths in characters for the Unicode types.
+func findValueColumnType(typeName string, maxLength, precision, scale int) queryParamType {
+	t := queryParamType{base: strings.ToLower(typeName), length: maxLength, precision: precision, scale: scale}
+	if (t.base == "nchar" || t.base == "nvarchar") && maxLength > 0 {
+		t.length = maxLength / 2
+	}
+	return t
+}
+
+// findValueTables groups the columns by table, keeping the tables selected
+// by schemas and tables (comma-separated; a table without schema matches it
+// in any schema). Naming a table that does not exist is an error.
+func findValueTables(cols []findValueColumn, schemas, tables string) ([]*findValueTable, error) {
+	splitList := func(list string) map[string]bool {
+		set := map[string]bool{}
+		for _, item := range strings.Split(list, ",") {
+			if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+				set[item] = true
+			}
+		}
+		return set
+	}
+	schemaSet := splitList(schemas)
+	wanted := map[string]bool{}
+	for name := range splitList(tables) {
+		if strings.Contains(name, ".") {
+			schema, table, err := splitQualifiedName(name, "dbo")
+			if err != nil {
+				return nil, err
+			}
+			name = schema + "." + table
+		}
+		wanted[strings.ToLower(name)] = false
+	}
+
+	var out []*findValueTable
+	var current *findValueTable
+	skip := false
+	for i, c := range cols {
+		if i == 0 || c.schema != cols[i-1].schema || c.table != cols[i-1].table {
+			current, skip = nil, len(schemaSet) > 0 && !schemaSet[strings.ToLower(c.schema)]
+			if !skip && len(wanted) > 0 {
+				full, short := strings.ToLower(c.schema+"."+c.table), strings.ToLower(c.table)
+				_, byFull := wanted[full]
+				_, byShort := wanted[short]
+				skip = !byFull && !byShort
+				if byFull {
+					wanted[full] = true
+				}
+				if byShort {
+					wanted[short] = true
+				}
+			}
+			if !skip {
+				current = &findValueTable{schema: c.schema, table: c.table}
+				out = append(out, current)
+			}
+		}
+		if !skip {
+			current.columns = append(current.columns, c)
+		}
+	}
+	var missing []string
+	for name, found := range wanted {
+		if !found {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("table %s not found", strings.Join(missing, ", "))
+	}
+	for _, t := range out {
+		keys := make([]string, len(t.columns))
+		n := 0
+		for _, c := range t.columns {
+			if c.keyOrdinal > 0 && c.keyOrdinal <= len(keys) {
+				keys[c.keyOrdinal-1] = c.name
+				n++
+			}
+		}
+		t.key = keys[:n]
+	}
+	return out, nil
+}
+
+// escapeLike escapes the LIKE wildcards of s for ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`, `[`, `\[`).Replace(s)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// findValuePredicate returns the WHERE condition and its parameter for
+// searching column c, or ok=false when the column cannot hold the value:
+// a type the value does not convert to, a text column too short for it,
+// or a non-text column for a text match.
+func findValuePredicate(c findValueColumn, value, match string) (cond string, param interface{}, ok bool) {
+	q := quoteIdentifier(c.name)
+	switch c.typ.base {
+	case "char", "varchar", "nchar", "nvarchar":
+		// A LIKE pattern's length says little about what it matches.
+		if match != "like" && c.typ.length > 0 && utf8.RuneCountInString(value) > c.typ.length {
+			return "", nil, false
+		}
+		var arg string
+		switch match {
+		case "exact":
+			cond, arg = q+" = @p1", value
+		case "prefix":
+			cond, arg = q+` LIKE @p1 ESCAPE '\'`, escapeLike(value)+"%"
+		case "contains":
+			cond, arg = q+` LIKE @p1 ESCAPE '\'`, "%"+escapeLike(value)+"%"
+		default: // like
+			cond, arg = q+" LIKE @p1", value
+		}
+		// A varchar parameter keeps index seeks on varchar columns; text
+		// outside ASCII is sent as nvarchar so no character is lost to the
+		// column's code page.
+		if (c.typ.base == "char" || c.typ.base == "varchar") && isASCII(arg) {
+			p, err := coerceQueryParam(queryParamType{base: "varchar"}, arg)
+			return cond, p, err == nil
+		}
+		return cond, arg, true
+	case "tinyint", "smallint", "int", "bigint", "decimal", "numeric", "money", "smallmoney", "float", "real":
+		if match != "exact" || !findValueNumber.MatchString(value) {
+			return "", nil, false
+		}
+	case "uniqueidentifier":
+		if match != "exact" || !guidPattern.MatchString(value) {
+			return "", nil, false
+		}
+	default:
+		return "", nil, false
+	}
+	p, err := coerceQueryParam(c.typ, value)
+	if err != nil {
+		return "", nil, false
+	}
+	return q + " = @p1", p, true
+}
+
+// findValueQuery selects the sample rows of a column match: the primary key
+// columns, and the matched value for pattern matches (or when the table has
+// no primary key).
+func findValueQuery(t *findValueTable, c findValueColumn, cond string, withValue bool) string {
+	var sel []string
+	for _, k := range t.key {
+		sel = append(sel, quoteIdentifier(k))
+	}
+	if withValue || len(t.key) == 0 {
+		sel = append(sel, quoteIdentifier(c.name))
+	}
+	return fmt.Sprintf("SELECT TOP (%d) %s FROM %s WHERE %s", findValueSamples, strings.Join(sel, ", "), qualifiedTable(t.schema, t.table), cond)
+}
+
+// findValueMatch is a column where the value was found.
+type findValueMatch struct {
+	Table  string                   `json:"table"`
+	Column string                   `json:"column"`
+	Type   string                   `json:"type"`
+	Keys   []map[string]interface{} `json:"sample_keys,omitempty"`
+	Values []interface{}            `json:"sample_values,omitempty"`
+}
+
+// findValueReport is the reply of find_value.
+type findValueReport struct {
+	Value           string           `json:"value"`
+	Match           string           `json:"match"`
+	Matches         []findValueMatch `json:"matches"`
+	Tables          int              `json:"tables"`
+	ColumnsEligible int              `json:"columns_eligible"`
+	ColumnsSearched int              `json:"columns_searched"`
+	Complete        bool             `json:"complete"`
+	TimedOut        []string         `json:"timed_out_columns,omitempty"`
+	Failed          []string         `json:"failed_columns,omitempty"`
+	ElapsedMs       int64            `json:"elapsed_ms"`
+	Notes           []string         `json:"notes,omitempty"`
+}
+
+// findValueRate is how many per-column queries find_value runs per second
+// at most (MSSQL_FIND_VALUE_RATE).
+func findValueRate() int {
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("MSSQL_FIND_VALUE_RATE"))); err == nil && n > 0 {
+		return n
+	}
+	return defaultFindValueRate
+}
+
+// findValueOptions are the bounds of one search.
+type findValueOptions struct {
+	match      string
+	maxMatches int
+	maxColumns int
+	budget     time.Duration
+	rate       int
+}
+
+// findValueOn searches value in the eligible columns of tables, one bounded
+// parameterized query per column, paced to opt.rate queries per second,
+// until every column is searched, opt.maxMatches columns matched or the
+// budget runs out. Masked columns are skipped unless the login has UNMASK:
+// a filter on them would reveal the values the mask hides.
+func (s *MCPMSSQLServer) findValueOn(ctx context.Context, target *queryTarget, tables []*findValueTable, value string, unmask bool, opt findValueOptions) *findValueReport {
+	start := time.Now()
+	report := &findValueReport{Value: value, Match: opt.match, Matches: []findValueMatch{}, Tables: len(tables), Complete: true}
+	type job struct {
+		table *findValueTable
+		col   findValueColumn
+		cond  string
+		param interface{}
+	}
+	var jobs []job
+	maskedSkipped := 0
+	for _, t := range tables {
+		for _, c := range t.columns {
+			cond, param, ok := findValuePredicate(c, value, opt.match)
+			if !ok {
+				continue
+			}
+			if c.masked && !unmask {
+				maskedSkipped++
+				continue
+			}
+			jobs = append(jobs, job{t, c, cond, param})
+		}
+	}
+	report.ColumnsEligible = len(jobs)
+	if maskedSkipped > 0 {
+		report.Notes = append(report.Notes, fmt.Sprintf("%d masked column(s) were not searched: this login cannot see their values", maskedSkipped))
+	}
+	if len(jobs) > opt.maxColumns {
+		report.Notes = append(report.Notes, fmt.Sprintf("only the first %d of %d eligible columns were searched; narrow 'schemas' or 'tables', or raise 'max_columns'", opt.maxColumns, len(jobs)))
+		jobs = jobs[:opt.maxColumns]
+		report.Complete = false
+	}
+
+	budgetCtx, cancel := context.WithTimeout(ctx, opt.budget)
+	defer cancel()
+	interval := time.Second / time.Duration(opt.rate)
+	next := time.Now()
+	withValue := opt.match != "exact"
+	budgetUsed := func(searched int) {
+		report.Notes = append(report.Notes, fmt.Sprintf("time budget of %s used up after %d of %d columns", opt.budget, searched, len(jobs)))
+		report.Complete = false
+	}
+search:
+	for i, j := range jobs {
+		if len(report.Matches) >= opt.maxMatches {
+			report.Notes = append(report.Notes, fmt.Sprintf("stopped after %d matching columns (max_matches)", opt.maxMatches))
+			report.Complete = false
+			break
+		}
+		if wait := time.Until(next); wait > 0 {
+			select {
+			case <-budgetCtx.Done():
+			case <-time.After(wait):
+			}
+		}
+		if budgetCtx.Err() != nil {
+			budgetUsed(i)
+			break
+		}
+		next = time.Now().Add(interval)
+
+		colName := j.table.name() + "." + j.col.name
+		m := findValueMatch{Table: j.table.name(), Column: j.col.name, Type: j.col.typ.base}
+		colCtx, colCancel := context.WithTimeout(budgetCtx, findValueColumnTimeout)
+		err := s.scanQuery(colCtx, target, findValueQuery(j.table, j.col, j.cond, withValue), []interface{}{j.param}, func(rows *sql.Rows) error {
+			names, err := rows.Columns()
+			if err != nil {
+				return err
+			}
+			vals := make([]interface{}, len(names))
+			ptrs := make([]interface{}, len(names))
+			for k := range vals {
+				ptrs[k] = &vals[k]
+			}
+			if err := rows.Scan(ptrs...); err != nil {
+				return err
+			}
+			if len(j.table.key) > 0 {
+				key := map[string]interface{}{}
+				for k, name := range j.table.key {
+					key[name] = exportValue(exportColumn{Name: name}, vals[k])
+				}
+				m.Keys = append(m.Keys, key)
+			}
+			if withValue || len(j.table.key) == 0 {
+				v := exportValue(exportColumn{Name: j.col.name, Type: j.col.typ.base}, vals[len(vals)-1])
+				if str, ok := v.(string); ok {
+					v = truncateRunes(str, findValueValueLimit)
+				}
+				m.Values = append(m.Values, v)
+			}
+			return nil
+		})
+		timedOut := errors.Is(colCtx.Err(), context.DeadlineExceeded)
+		colCancel()
+		switch {
+		case err == nil:
+			report.ColumnsSearched++
+			if len(m.Keys) > 0 || len(m.Values) > 0 {
+				report.Matches = append(report.Matches, m)
+			}
+		case budgetCtx.Err() != nil:
+			budgetUsed(i)
+			break search
+		case timedOut:
+			report.ColumnsSearched++
+			report.TimedOut = append(report.TimedOut, colName)
+			report.Complete = false
+		default:
+			report.ColumnsSearched++
+			report.Failed = append(report.Failed, colName+": "+err.Error())
+			report.Complete = false
+		}
+	}
+	if report.ColumnsEligible == 0 {
+		report.Notes = append(report.Notes, "no column can hold this value (numeric and GUID columns are only searched with match=exact)")
+	}
+	report.ElapsedMs = time.Since(start).Milliseconds()
+	return report
+}
+
+// handleFindValue is the tools/call entry point of the find_value tool.
+func (s *MCPMSSQLServer) handleFindValue(id interface{}, args map[string]interface{}) *MCPResponse {
+	errorResponse := func(err error) *MCPResponse {
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result: CallToolResult{
+				Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Find Value Error: %v", err)}},
+				IsError: true,
+			},
+		}
+	}
+	target, err := s.resolveTarget(args)
+	if err != nil {
+		return errorResponse(err)
+	}
+	target.tool = "find_value"
+
+	var value string
+	switch v := args["value"].(type) {
+	case string:
+		value = v
+	case float64:
+		value = strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	if strings.TrimSpace(value) == "" {
+		return errorResponse(fmt.Errorf("missing or invalid 'value' parameter"))
+	}
+	opt := findValueOptions{match: "exact", maxMatches: defaultFindValueMax, maxColumns: defaultFindValueCols, budget: envSeconds("MSSQL_FIND_VALUE_TIMEOUT", defaultFindValueBudget), rate: findValueRate()}
+	if m, _ := args["match"].(string); strings.TrimSpace(m) != "" {
+		opt.match = strings.ToLower(strings.TrimSpace(m))
+	}
+	switch opt.match {
+	case "exact", "contains", "prefix", "like":
+	default:
+		return errorResponse(fmt.Errorf("invalid match '%s' (use exact, contains, prefix or like)", opt.match))
+	}
+	if opt.match == "like" && strings.Trim(value, "%_") == "" {
+		return errorResponse(fmt.Errorf("a LIKE pattern made only of wildcards matches every row"))
+	}
+	if n, ok := args["max_matches"].(float64); ok && n >= 1 {
+		opt.maxMatches = int(min(n, maxFindValueMax))
+	}
+	if n, ok := args["max_columns"].(float64); ok && n >= 1 {
+		opt.maxColumns = int(min(n, maxFindValueCols))
+	}
+	// The budget may be shortened per call, never lengthened.
+	if n, ok := args["time_budget_seconds"].(float64); ok && n >= 1 && time.Duration(n)*time.Second < opt.budget {
+		opt.budget = time.Duration(n) * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opt.budget+findValueColumnTimeout)
+	defer cancel()
+	var unmask bool
+	if err := s.scanQuery(ctx, target, "SELECT "+unmaskExpr, nil, func(rows *sql.Rows) error {
+		return rows.Scan(&unmask)
+	}); err != nil {
+		return errorResponse(err)
+	}
+	var cols []findValueColumn
+	err = s.scanQuery(ctx, target, findValueColumnsQuery, nil, func(rows *sql.Rows) error {
+		var c findValueColumn
+		var typeName sql.NullString
+		var maxLength, precision, scale int
+		if err := rows.Scan(&c.schema, &c.table, &c.name, &typeName, &maxLength, &precision, &scale, &c.masked, &c.keyOrdinal); err != nil {
+			return err
+		}
+		c.typ = findValueColumnType(typeName.String, maxLength, precision, scale)
+		cols = append(cols, c)
+		return nil
+	})
+	if err != nil {
+		return errorResponse(err)
+	}
+	schemas, _ := args["schemas"].(string)
+	tableList, _ := args["tables"].(string)
+	tables, err := findValueTables(cols, schemas, tableList)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	report := s.findValueOn(ctx, target, tables, value, unmask, opt)
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return errorResponse(err)
+	}
+	return &MCPResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Result:  CallToolResult{Content: []ContentItem{{Type: "text", Text: string(out)}}},
+	}
+}
+
+// findValueTool describes the find_value tool.
+func findValueTool() Tool {
+	return Tool{
+		Name:        "find_value",
+		Title:       "Find Value",
+		Description: "Find where a value appears in the data (\"where does customer code ABC123 appear?\"): searches the text columns, and for exact matches also the numeric and GUID columns that can hold the value, of every user table or of the selected schemas/tables. Runs one bounded, parameterized query per column, paced and within a time budget, and returns the table, column and sample primary-key values of each match. Unlike explore type=search, which searches object names and definitions, this searches rows.",
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]Property{
+				"value": {
+					Type:        "string",
+					Description: "Value to search for",
+				},
+				"match": {
+					Type:        "string",
+					Description: "'exact' (default; also searches numeric and GUID columns), 'contains', 'prefix', or 'like' (value is a LIKE pattern with % and _)",
+				},
+				"schemas": {
+					Type:        "string",
+					Description: "Only search these schemas, comma-separated (default: all)",
+				},
+				"tables": {
+					Type:        "string",
+					Description: "Only search these tables, comma-separated ('dbo.Orders' or 'Orders'; default: all)",
+				},
+				"max_matches": {
+					Type:        "integer",
+					Description: fmt.Sprintf("Stop after this many matching columns (default %d, max %d)", defaultFindValueMax, maxFindValueMax),
+				},
+				"max_columns": {
+					Type:        "integer",
+					Description: fmt.Sprintf("Search at most this many columns (default %d, max %d)", defaultFindValueCols, maxFindValueCols),
+				},
+				"time_budget_seconds": {
+					Type:        "integer",
+					Description: "Stop searching after this many seconds (default and maximum: MSSQL_FIND_VALUE_TIMEOUT, 30)",
+				},
+			},
+			Required: []string{"value"},
+		},
+		Annotations: &ToolAnnotations{
+			ReadOnlyHint:    boolPtr(true),
+			DestructiveHint: boolPtr(false),
+			IdempotentHint:  boolPtr(true),
+			OpenWorldHint:   boolPtr(false),
+		},
+	}
+}
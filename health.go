@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// classicTarget is the history key used for the classic (MSSQL_* env) pool.
+// Dynamic pools are recorded under their alias name.
+const classicTarget = "(classic)"
+
+// Connection states recorded in the health history.
+const (
+	connStateUp          = "up"
+	connStateDown        = "down"
+	connStateReconnected = "reconnected"
+)
+
+const (
+	defaultHealthCheckInterval = 30 * time.Second
+	defaultReconnectMaxBackoff = 60 * time.Second
+	minReconnectBackoff        = 1 * time.Second
+	healthPingTimeout          = 10 * time.Second
+	maxConnHistory             = 50
+)
+
+// connEvent is one state transition of a connection pool, reported by
+// get_database_info so the model can tell a flapping server from a dead one.
+type connEvent struct {
+	Time   time.Time
+	Target string
+	State  string
+	Detail string
+}
+
+// recordConnState appends a state transition to the bounded history. Repeated
+// identical states for the same target are collapsed so a long outage does not
+// push everything else out of the window.
+func (s *MCPMSSQLServer) recordConnState(target, state string, err error) {
+	detail := ""
+	if err != nil {
+		// Driver errors can echo parts of the DSN; never keep credentials.
+		detail = s.secLogger.sanitizeForLogging(err.Error())
+		if !s.devMode {
+			detail = "connection error"
+		}
+	}
+
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+	if s.connStates == nil {
+		s.connStates = make(map[string]string)
+	}
+	last := s.connStates[target]
+	if last == state && state != connStateReconnected {
+		return
+	}
+	if last == connStateReconnected && state == connStateUp {
+		// The first healthy ping after a reopen is not a new transition.
+		s.connStates[target] = state
+		return
+	}
+	s.connStates[target] = state
+	s.connHistory = append(s.connHistory, connEvent{Time: time.Now(), Target: target, State: state, Detail: detail})
+	if len(s.connHistory) > maxConnHistory {
+		s.connHistory = s.connHistory[len(s.connHistory)-maxConnHistory:]
+	}
+	s.secLogger.Printf("Connection state: %s -> %s", target, state)
+}
+
+// connStateHistory returns a copy of the recorded transitions, oldest first.
+func (s *MCPMSSQLServer) connStateHistory() []connEvent {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+	return append([]connEvent(nil), s.connHistory...)
+}
+
+// formatConnStateHistory renders the current state per target and the most
+// recent transitions for get_database_info.
+func (s *MCPMSSQLServer) formatConnStateHistory(limit int) string {
+	history := s.connStateHistory()
+	if len(history) == 0 {
+		return ""
+	}
+
+	s.healthMu.Lock()
+	targets := make([]string, 0, len(s.connStates))
+	for t := range s.connStates {
+		targets = append(targets, t)
+	}
+	states := make(map[string]string, len(s.connStates))
+	for t, st := range s.connStates {
+		states[t] = st
+	}
+	s.healthMu.Unlock()
+	sort.Strings(targets)
+
+	var sb strings.Builder
+	sb.WriteString("\n=== Connection Health ===\n")
+	for _, t := range targets {
+		fmt.Fprintf(&sb, "%s: %s\n", t, states[t])
+	}
+	if len(history) > limit {
+		history = history[len(history)-limit:]
+	}
+	sb.WriteString("Recent state changes:\n")
+	for _, ev := range history {
+		line := fmt.Sprintf("  %s  %s -> %s", ev.Time.Format(time.RFC3339), ev.Target, ev.State)
+		if ev.Detail != "" {
+			line += " (" + ev.Detail + ")"
+		}
+		sb.WriteString(line + "\n")
+	}
+	return sb.String()
+}
+
+// nextBackoff doubles the current delay, capped at max.
+func nextBackoff(cur, max time.Duration) time.Duration {
+	if cur < minReconnectBackoff {
+		return minReconnectBackoff
+	}
+	next := cur * 2
+	if next > max {
+		return max
+	}
+	return next
+}
+
+// envSeconds reads a positive number of seconds from the environment.
+func envSeconds(key string, def time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+			return time.Duration(secs) * time.Second
+		}
+	}
+	return def
+}
+
+// superviseConnections keeps the server's pools usable for the lifetime of
+// ctx. When classic is true it first establishes the classic connection,
+// retrying with exponential backoff instead of giving up after one attempt.
+// Afterwards it periodically pings the classic pool and every open dynamic
+// alias pool, and transparently reopens any pool that stops answering (e.g.
+// after a failover or a SQL Server restart). kickSupervisor starts the next
+// pass early.
+func (s *MCPMSSQLServer) superviseConnections(ctx context.Context, classic bool) {
+	interval := envSeconds("MSSQL_HEALTH_CHECK_INTERVAL", defaultHealthCheckInterval)
+	maxBackoff := envSeconds("MSSQL_RECONNECT_MAX_BACKOFF", defaultReconnectMaxBackoff)
+	backoff := minReconnectBackoff
+
+	for {
+		wait := interval
+		healthy := true
+		if classic && s.getDB() == nil {
+			if err := s.connectClassic(ctx); err != nil {
+				healthy = false
+			} else {
+				s.recordConnState(classicTarget, connStateUp, nil)
+				s.kickProcedureTools()
+			}
+		}
+		if !s.checkPools(ctx, classic) {
+			healthy = false
+		}
+		if healthy {
+			backoff = minReconnectBackoff
+		} else {
+			wait = backoff
+			backoff = nextBackoff(backoff, maxBackoff)
+			s.secLogger.Printf("Connection supervisor: retrying in %s", wait)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		case <-s.supervisorKick:
+			backoff = minReconnectBackoff
+		}
+	}
+}
+
+// kickSupervisor asks the connection supervisor to run its next pass now
+// (e.g. after a config reload closed the classic pool) instead of waiting
+// for the health check interval. It is a no-op when no supervisor channel
+// was set up (tests).
+func (s *MCPMSSQLServer) kickSupervisor() {
+	if s.supervisorKick == nil {
+		return
+	}
+	select {
+	case s.supervisorKick <- struct{}{}:
+	default: // a pass is already pending
+	}
+}
+
+// checkPools pings every open pool once and reopens the ones that fail.
+// It reports whether all pools are healthy.
+func (s *MCPMSSQLServer) checkPools(ctx context.Context, classic bool) bool {
+	healthy := true
+
+	if classic {
+		if db := s.getDB(); db != nil {
+			if err := pingPool(ctx, db); err != nil {
+				s.recordConnState(classicTarget, connStateDown, err)
+				// Keep serving from the old pool (database/sql may recover on
+				// its own) until a fresh one has answered a ping.
+				if err := s.connectClassic(ctx); err != nil {
+					healthy = false
+				} else {
+					s.recordConnState(classicTarget, connStateReconnected, nil)
+					s.kickProcedureTools()
+				}
+			} else {
+				s.recordConnState(classicTarget, connStateUp, nil)
+			}
+		}
+	}
+
+	s.dynamicMu.RLock()
+	pools := make(map[string]*sql.DB, len(s.connections))
+	for name, db := range s.connections {
+		if db != nil {
+			pools[name] = db
+		}
+	}
+	s.dynamicMu.RUnlock()
+
+	for name, db := range pools {
+		if err := pingPool(ctx, db); err != nil {
+			s.recordConnState(name, connStateDown, err)
+			if err := s.reopenAliasPool(ctx, name, db); err != nil {
+				healthy = false
+			} else {
+				s.recordConnState(name, connStateReconnected, nil)
+			}
+			continue
+		}
+		s.recordConnState(name, connStateUp, nil)
+	}
+	return healthy
+}
+
+func pingPool(ctx context.Context, db *sql.DB) error {
+	pingCtx, cancel := context.WithTimeout(ctx, healthPingTimeout)
+	defer cancel()
+	return db.PingContext(pingCtx)
+}
+
+// reopenAliasPool opens a fresh pool for a dynamic alias and swaps it in for
+// stale, unless the pool was closed or replaced concurrently (dynamic_connect,
+// dynamic_disconnect or a config reload), in which case the new pool is
+// discarded.
+func (s *MCPMSSQLServer) reopenAliasPool(ctx context.Context, name string, stale *sql.DB) error {
+	s.dynamicMu.RLock()
+	alias, ok := s.dynamicAliases[name]
+	s.dynamicMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("alias '%s' no longer configured", name)
+	}
+
+	connStr, err := buildAliasConnectionString(&alias, s.devMode, name)
+	if err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlserver", connStr)
+	if err != nil {
+		return err
+	}
+	configurePool(db)
+	if err := pingPool(ctx, db); err != nil {
+		_ = db.Close()
+		return err
+	}
+
+	s.dynamicMu.Lock()
+	if s.connections[name] != stale {
+		s.dynamicMu.Unlock()
+		_ = db.Close()
+		return nil
+	}
+	s.connections[name] = db
+	s.dbMu.Lock()
+	if s.db == stale {
+		s.db = db
+	}
+	s.dbMu.Unlock()
+	s.dynamicMu.Unlock()
+
+	_ = stale.Close()
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// validIdentifierPattern accepts a single unquoted SQL Server identifier.
+// Table, schema and column names passed as tool arguments must match it
+// before they are bracket-quoted into generated SQL.
+var validIdentifierPattern = regexp.MustCompile(`^[\p{L}_][\p{L}\p{N}_$#@]*$`)
+
+// quoteIdentifier bracket-quotes an identifier already checked against
+// validIdentifierPattern.
+func quoteIdentifier(name string) string {
+	return "[" + strings.ReplaceAll(name, "]", "]]") + "]"
+}
+
+// qualifiedTable is the bracket-quoted schema.table used in generated SQL.
+func qualifiedTable(schema, table string) string {
+	return quoteIdentifier(schema) + "." + quoteIdentifier(table)
+}
+
+// splitQualifiedName splits "schema.table" (brackets optional) into its
+// parts, defaulting the schema to defaultSchema, and validates both.
+func splitQualifiedName(name, defaultSchema string) (string, string, error) {
+	schema := defaultSchema
+	table := strings.TrimSpace(name)
+	if parts := strings.Split(table, "."); len(parts) == 2 {
+		schema, table = parts[0], parts[1]
+	} else if len(parts) > 2 {
+		return "", "", fmt.Errorf("invalid table name '%s': use 'table' or 'schema.table'", name)
+	}
+	schema = strings.Trim(strings.TrimSpace(schema), "[]")
+	table = strings.Trim(strings.TrimSpace(table), "[]")
+	if !validIdentifierPattern.MatchString(schema) || !validIdentifierPattern.MatchString(table) {
+		return "", "", fmt.Errorf("invalid table name '%s'", name)
+	}
+	return schema, table, nil
+}
+
+// stringListArg reads a tool argument given either as a JSON array of strings
+// or as a comma-separated string.
+func stringListArg(v interface{}) []string {
+	var out []string
+	switch t := v.(type) {
+	case string:
+		for _, part := range strings.Split(t, ",") {
+			if p := strings.TrimSpace(part); p != "" {
+				out = append(out, p)
+			}
+		}
+	case []interface{}:
+		for _, item := range t {
+			if str, ok := item.(string); ok && strings.TrimSpace(str) != "" {
+				out = append(out, strings.TrimSpace(str))
+			}
+		}
+	}
+	return out
+}
+
+// intArg reads a positive integer argument, clamped to max.
+func intArg(args map[string]interface{}, name string, def, max int) int {
+	n := def
+	if v, ok := args[name].(float64); ok && v > 0 {
+		n = int(v)
+	}
+	if n > max {
+		n = max
+	}
+	return n
+}
@@ -0,0 +1,847 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang-sql/civil"
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/shopspring/decimal"
+	"golang.org/x/text/encoding/charmap"
+)
+
+const (
+	// defaultImportMaxBytes caps the size of a file import_data reads
+	// (MSSQL_IMPORT_MAX_BYTES).
+	defaultImportMaxBytes = 512 << 20
+	// defaultImportTimeout bounds one import_data call
+	// (MSSQL_IMPORT_TIMEOUT), both reading passes included.
+	defaultImportTimeout = 10 * time.Minute
+	// defaultImportBatchSize is the number of rows sent per bulk copy.
+	defaultImportBatchSize = 1000
+	maxImportBatchSize     = 100000
+	// maxImportErrors is how many row errors a reply lists.
+	maxImportErrors = 50
+)
+
+// importFormats maps the file extensions import_data reads to their format.
+var importFormats = map[string]string{
+	".csv":    "csv",
+	".jsonl":  "jsonl",
+	".ndjson": "jsonl",
+}
+
+// importColumnsQuery lists the columns of a table with what the import
+// needs to check a file against them.
+const importColumnsQuery = `SELECT c.COLUMN_NAME, c.DATA_TYPE,
+	ISNULL(c.CHARACTER_MAXIMUM_LENGTH, 0), ISNULL(CAST(c.NUMERIC_PRECISION AS int), 0), ISNULL(c.NUMERIC_SCALE, 0),
+	CAST(CASE WHEN c.IS_NULLABLE = 'YES' THEN 1 ELSE 0 END AS bit),
+	CAST(CASE WHEN c.COLUMN_DEFAULT IS NULL THEN 0 ELSE 1 END AS bit),
+	ISNULL(CAST(COLLATIONPROPERTY(c.COLLATION_NAME, 'CodePage') AS int), 0),
+	CAST(ISNULL(COLUMNPROPERTY(OBJECT_ID(QUOTENAME(c.TABLE_SCHEMA) + '.' + QUOTENAME(c.TABLE_NAME)), c.COLUMN_NAME, 'IsIdentity'), 0) AS bit),
+	CAST(ISNULL(COLUMNPROPERTY(OBJECT_ID(QUOTENAME(c.TABLE_SCHEMA) + '.' + QUOTENAME(c.TABLE_NAME)), c.COLUMN_NAME, 'IsComputed'), 0) AS bit)
+FROM INFORMATION_SCHEMA.COLUMNS c
+JOIN INFORMATION_SCHEMA.TABLES t ON t.TABLE_SCHEMA = c.TABLE_SCHEMA AND t.TABLE_NAME = c.TABLE_NAME
+WHERE c.TABLE_SCHEMA = @p1 AND c.TABLE_NAME = @p2 AND t.TABLE_TYPE = 'BASE TABLE'
+ORDER BY c.ORDINAL_POSITION`
+
+// importColumn is a table column as INFORMATION_SCHEMA.COLUMNS describes it.
+type importColumn struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	sqlType    queryParamType
+	nullable   bool
+	hasDefault bool
+	identity   bool
+	computed   bool
+	codePage   int // of the collation of char/varchar columns
+}
+
+// newImportColumn builds the column from its catalog row. maxLength is
+// CHARACTER_MAXIMUM_LENGTH: characters, bytes for binary, -1 for max.
+func newImportColumn(name, dataType string, maxLength, precision, scale int) importColumn {
+	t := queryParamType{base: strings.ToLower(dataType)}
+	typeName := t.base
+	switch t.base {
+	case "decimal", "numeric":
+		t.precision, t.scale = precision, scale
+		typeName = fmt.Sprintf("%s(%d,%d)", t.base, precision, scale)
+	case "char", "varchar", "nchar", "nvarchar", "binary", "varbinary":
+		t.length = maxLength
+		if maxLength < 0 {
+			typeName += "(max)"
+		} else {
+			typeName = fmt.Sprintf("%s(%d)", t.base, maxLength)
+		}
+	}
+	return importColumn{Name: name, Type: typeName, sqlType: t}
+}
+
+// importPlan is what import_data found in a file: the reply of a preview,
+// and the row count an import must confirm.
+type importPlan struct {
+	File        string         `json:"file"`
+	Format      string         `json:"format"`
+	Table       string         `json:"table"`
+	Rows        int            `json:"rows"`
+	ValidRows   int            `json:"valid_rows"`
+	InvalidRows int            `json:"invalid_rows"`
+	Columns     []importColumn `json:"columns"`
+	Defaulted   []string       `json:"defaulted_columns,omitempty"`
+	Errors      []string       `json:"errors,omitempty"`
+	Next        string         `json:"next,omitempty"`
+}
+
+// importRowError is a problem with one row of the file; the rest of the
+// file is still read.
+type importRowError struct {
+	line int
+	msg  string
+}
+
+func (e *importRowError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.line, e.msg)
+}
+
+// importReader reads the rows of an import file. next returns io.EOF after
+// the last row, an *importRowError for a row that cannot be read, and any
+// other error when the file cannot be read further.
+type importReader interface {
+	header() []string
+	next() (line int, values []interface{}, err error)
+}
+
+// csvImport reads a CSV file with a header row. Empty fields are NULL.
+type csvImport struct {
+	r    *csv.Reader
+	cols []string
+}
+
+func newCSVImport(f io.Reader, delim rune) (*csvImport, error) {
+	r := csv.NewReader(f)
+	r.Comma = delim
+	r.FieldsPerRecord = -1
+	head, err := r.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("the file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	cols := make([]string, len(head))
+	for i, h := range head {
+		cols[i] = strings.TrimSpace(h)
+	}
+	cols[0] = strings.TrimPrefix(cols[0], "\ufeff")
+	return &csvImport{r: r, cols: cols}, nil
+}
+
+func (c *csvImport) header() []string { return c.cols }
+
+func (c *csvImport) next() (int, []interface{}, error) {
+	rec, err := c.r.Read()
+	if err != nil {
+		return 0, nil, err
+	}
+	line, _ := c.r.FieldPos(0)
+	if len(rec) != len(c.cols) {
+		return line, nil, &importRowError{line, fmt.Sprintf("%d fields, the header has %d", len(rec), len(c.cols))}
+	}
+	values := make([]interface{}, len(rec))
+	for i, f := range rec {
+		if f != "" {
+			values[i] = f
+		}
+	}
+	return line, values, nil
+}
+
+// jsonlImport reads one JSON object per line. The keys of the first object
+// are the columns; a later object may leave keys out (NULL) but not add
+// new ones.
+type jsonlImport struct {
+	r       *bufio.Reader
+	line    int
+	cols    []string
+	index   map[string]int
+	pending []interface{}
+	first   int
+}
+
+func newJSONLImport(f io.Reader) (*jsonlImport, error) {
+	j := &jsonlImport{r: bufio.NewReader(f), index: map[string]int{}}
+	data, err := j.readLine()
+	if err == io.EOF {
+		return nil, fmt.Errorf("the file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	keys, values, err := decodeJSONLRow(bytes.TrimPrefix(data, []byte("\ufeff")))
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %v", j.line, err)
+	}
+	for i, k := range keys {
+		j.index[strings.ToLower(k)] = i
+	}
+	j.cols, j.pending, j.first = keys, values, j.line
+	return j, nil
+}
+
+// readLine returns the next non-blank line.
+func (j *jsonlImport) readLine() ([]byte, error) {
+	for {
+		data, err := j.r.ReadBytes('\n')
+		if len(data) == 0 && err != nil {
+			return nil, err
+		}
+		j.line++
+		if data = bytes.TrimSpace(data); len(data) > 0 {
+			return data, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (j *jsonlImport) header() []string { return j.cols }
+
+func (j *jsonlImport) next() (int, []interface{}, error) {
+	if j.pending != nil {
+		values := j.pending
+		j.pending = nil
+		return j.first, values, nil
+	}
+	data, err := j.readLine()
+	if err != nil {
+		return 0, nil, err
+	}
+	keys, raw, err := decodeJSONLRow(data)
+	if err != nil {
+		return j.line, nil, &importRowError{j.line, err.Error()}
+	}
+	values := make([]interface{}, len(j.cols))
+	for i, k := range keys {
+		pos, ok := j.index[strings.ToLower(k)]
+		if !ok {
+			return j.line, nil, &importRowError{j.line, fmt.Sprintf("key %q is not in the first object", k)}
+		}
+		values[pos] = raw[i]
+	}
+	return j.line, values, nil
+}
+
+// decodeJSONLRow decodes one JSON object keeping its keys in order.
+// Numbers are kept as their text so that no digits are lost.
+func decodeJSONLRow(data []byte) ([]string, []interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, nil, fmt.Errorf("expected a JSON object")
+	}
+	var keys []string
+	var values []interface{}
+	seen := map[string]bool{}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid JSON: %v", err)
+		}
+		key, _ := tok.(string)
+		if seen[strings.ToLower(key)] {
+			return nil, nil, fmt.Errorf("duplicate key %q", key)
+		}
+		seen[strings.ToLower(key)] = true
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return nil, nil, fmt.Errorf("invalid JSON: %v", err)
+		}
+		switch t := v.(type) {
+		case json.Number:
+			v = t.String()
+		case map[string]interface{}, []interface{}:
+			return nil, nil, fmt.Errorf("key %q: nested objects and arrays are not supported", key)
+		}
+		keys = append(keys, key)
+		values = append(values, v)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, nil, fmt.Errorf("unexpected data after the JSON object")
+	}
+	return keys, values, nil
+}
+
+// mapImportColumns matches the file header against the table columns (case
+// insensitively) and returns the table column of each file column, and the
+// table columns the file leaves to their default or NULL.
+func mapImportColumns(header []string, table []importColumn) ([]importColumn, []string, error) {
+	if len(header) == 0 {
+		return nil, nil, fmt.Errorf("the file has no columns")
+	}
+	byName := make(map[string]importColumn, len(table))
+	for _, c := range table {
+		byName[strings.ToLower(c.Name)] = c
+	}
+	cols := make([]importColumn, len(header))
+	inFile := make(map[string]bool, len(header))
+	var unknown []string
+	for i, h := range header {
+		if h == "" {
+			return nil, nil, fmt.Errorf("column %d of the file has no name", i+1)
+		}
+		if inFile[strings.ToLower(h)] {
+			return nil, nil, fmt.Errorf("column '%s' appears twice in the file", h)
+		}
+		inFile[strings.ToLower(h)] = true
+		c, ok := byName[strings.ToLower(h)]
+		switch {
+		case !ok:
+			unknown = append(unknown, h)
+			continue
+		case c.identity:
+			return nil, nil, fmt.Errorf("column '%s' is an identity column; leave it out of the file", c.Name)
+		case c.computed || c.sqlType.base == "timestamp":
+			return nil, nil, fmt.Errorf("column '%s' is computed by the server; leave it out of the file", c.Name)
+		case !queryParamTypes[c.sqlType.base]:
+			return nil, nil, fmt.Errorf("column '%s' has type %s, which import_data does not support", c.Name, c.Type)
+		}
+		cols[i] = c
+	}
+	if len(unknown) > 0 {
+		return nil, nil, fmt.Errorf("the table has no column %s", strings.Join(unknown, ", "))
+	}
+
+	var defaulted, missing []string
+	for _, c := range table {
+		if inFile[strings.ToLower(c.Name)] {
+			continue
+		}
+		if c.nullable || c.hasDefault || c.identity || c.computed || c.sqlType.base == "timestamp" {
+			defaulted = append(defaulted, c.Name)
+		} else {
+			missing = append(missing, c.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, nil, fmt.Errorf("the file has no column %s, which cannot be NULL and has no default", strings.Join(missing, ", "))
+	}
+	return cols, defaulted, nil
+}
+
+// importValue converts a value read from the file to the Go value the bulk
+// copy writes as the column type, with the checks query_database applies to
+// typed parameters (ranges, lengths, decimal precision).
+func importValue(c importColumn, v interface{}) (interface{}, error) {
+	if v == nil {
+		if !c.nullable {
+			return nil, fmt.Errorf("NULL is not allowed")
+		}
+		return nil, nil
+	}
+	if s, ok := v.(string); ok && c.sqlType.base == "bigint" {
+		n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected an integer, got %q", s)
+		}
+		return n, nil
+	}
+	val, err := coerceQueryParam(c.sqlType, v)
+	if err != nil {
+		return nil, err
+	}
+	// The bulk copy only takes plain Go types.
+	switch t := val.(type) {
+	case uint8:
+		return int64(t), nil
+	case int16:
+		return int64(t), nil
+	case int32:
+		return int64(t), nil
+	case mssql.VarChar:
+		return varcharValue(c, string(t))
+	case civil.Date:
+		return t.In(time.UTC), nil
+	case civil.DateTime:
+		return t.In(time.UTC), nil
+	case civil.Time:
+		return time.Date(1900, 1, 1, t.Hour, t.Minute, t.Second, t.Nanosecond, time.UTC), nil
+	case mssql.DateTime1:
+		return time.Time(t), nil
+	case mssql.DateTimeOffset:
+		return time.Time(t), nil
+	case mssql.UniqueIdentifier:
+		return t.Value()
+	case decimal.Decimal:
+		return t.String(), nil
+	}
+	return val, nil
+}
+
+// varcharValue encodes text for a char/varchar column. The bulk copy sends
+// the bytes as they are, so they must already be in the code page of the
+// column's collation: UTF-8 collations and code page 1252 (the Latin1
+// collations) take any text they can represent, other code pages ASCII only.
+func varcharValue(c importColumn, s string) (interface{}, error) {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+	switch {
+	case c.codePage == 65001:
+		if c.sqlType.length > 0 && len(s) > c.sqlType.length {
+			return nil, fmt.Errorf("value longer than %s (%d bytes in UTF-8)", c.Type, len(s))
+		}
+		return s, nil
+	case ascii:
+		return s, nil
+	case c.codePage == 1252:
+		b, err := charmap.Windows1252.NewEncoder().String(s)
+		if err != nil {
+			return nil, fmt.Errorf("text has characters that code page 1252 of the column cannot hold; use an nvarchar column")
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("non-ASCII text for a column with code page %d; use an nvarchar column or a UTF-8 collation", c.codePage)
+}
+
+// importMaxBytes is MSSQL_IMPORT_MAX_BYTES, or defaultImportMaxBytes.
+func importMaxBytes() int64 {
+	if n, err := strconv.ParseInt(strings.TrimSpace(os.Getenv("MSSQL_IMPORT_MAX_BYTES")), 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return defaultImportMaxBytes
+}
+
+// importFile resolves name in the import directory and returns its path
+// and format (from the extension unless format is given).
+func importFile(name, format string) (string, string, error) {
+	dir, err := sandboxDir("MSSQL_IMPORT_DIR", "imports")
+	if err != nil {
+		return "", "", err
+	}
+	path, err := sandboxFile(dir, name, "")
+	if err != nil {
+		return "", "", err
+	}
+	if format == "" {
+		format = importFormats[strings.ToLower(filepath.Ext(path))]
+	}
+	if format != "csv" && format != "jsonl" {
+		return "", "", fmt.Errorf("cannot tell the format of '%s': use a .csv or .jsonl file, or set format", name)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", "", fmt.Errorf("file '%s' not found in the import directory", filepath.Base(path))
+	}
+	if !info.Mode().IsRegular() {
+		return "", "", fmt.Errorf("'%s' is not a file", filepath.Base(path))
+	}
+	if max := importMaxBytes(); info.Size() > max {
+		return "", "", fmt.Errorf("file '%s' has %d bytes, more than the %d allowed (MSSQL_IMPORT_MAX_BYTES)", filepath.Base(path), info.Size(), max)
+	}
+	return path, format, nil
+}
+
+// scanImport reads the whole file, checks every row against the table
+// columns and hands the converted values of each valid row to fn (when not
+// nil). Invalid rows are counted and the first maxImportErrors reported.
+func scanImport(ctx context.Context, path, format string, delim rune, table []importColumn, fn func(line int, values []interface{}) error) (*importPlan, error) {
+	f, err := os.Open(path) // #nosec G304 -- resolved inside the import directory by sandboxFile
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var r importReader
+	if format == "csv" {
+		r, err = newCSVImport(f, delim)
+	} else {
+		r, err = newJSONLImport(f)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cols, defaulted, err := mapImportColumns(r.header(), table)
+	if err != nil {
+		return nil, err
+	}
+	plan := &importPlan{File: path, Format: format, Columns: cols, Defaulted: defaulted}
+	rowError := func(e *importRowError) {
+		plan.InvalidRows++
+		if len(plan.Errors) < maxImportErrors {
+			plan.Errors = append(plan.Errors, e.Error())
+		}
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		line, raw, err := r.next()
+		if err == io.EOF {
+			break
+		}
+		var rowErr *importRowError
+		if errors.As(err, &rowErr) {
+			plan.Rows++
+			rowError(rowErr)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		plan.Rows++
+		values := make([]interface{}, len(cols))
+		var problems []string
+		for i, c := range cols {
+			v, err := importValue(c, raw[i])
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("column %s: %v", c.Name, err))
+				continue
+			}
+			values[i] = v
+		}
+		if len(problems) > 0 {
+			rowError(&importRowError{line, strings.Join(problems, "; ")})
+			continue
+		}
+		plan.ValidRows++
+		if fn != nil {
+			if err := fn(line, values); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if plan.InvalidRows > len(plan.Errors) {
+		plan.Errors = append(plan.Errors, fmt.Sprintf("... and %d more", plan.InvalidRows-len(plan.Errors)))
+	}
+	return plan, nil
+}
+
+// importTableColumns reads the columns of schema.table. Catalog reads never
+// run inside an explicit transaction.
+func (s *MCPMSSQLServer) importTableColumns(ctx context.Context, target *queryTarget, schema, table string) ([]importColumn, error) {
+	meta := *target
+	meta.tx = nil
+	var cols []importColumn
+	err := s.scanQuery(ctx, &meta, importColumnsQuery, []interface{}{schema, table}, func(rows *sql.Rows) error {
+		var name, dataType string
+		var maxLength, precision, scale int
+		var nullable, hasDefault, identity, computed bool
+		var codePage int
+		if err := rows.Scan(&name, &dataType, &maxLength, &precision, &scale, &nullable, &hasDefault, &codePage, &identity, &computed); err != nil {
+			return err
+		}
+		c := newImportColumn(name, dataType, maxLength, precision, scale)
+		c.nullable, c.hasDefault, c.identity, c.computed, c.codePage = nullable, hasDefault, identity, computed, codePage
+		cols = append(cols, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(cols) == 0 {
+		return nil, fmt.Errorf("table %s.%s not found", schema, table)
+	}
+	return cols, nil
+}
+
+// importPolicy allows a bulk import only into a whitelisted table of a
+// writable dynamic alias, within the alias's MAX_AFFECTED_ROWS, and once
+// confirm_operation has confirmed that table and row count.
+func (s *MCPMSSQLServer) importPolicy(target *queryTarget, table string, rows int) error {
+	if target.alias == "" || target.config.readOnly {
+		return fmt.Errorf("import_data can only write to a writable dynamic alias")
+	}
+	_, name, err := splitQualifiedName(table, "dbo")
+	if err != nil {
+		return err
+	}
+	whitelisted := false
+	for _, t := range target.config.whitelistTables {
+		if t == strings.ToLower(name) {
+			whitelisted = true
+			break
+		}
+	}
+	if !whitelisted {
+		return fmt.Errorf("permission denied: table '%s' is not whitelisted on alias '%s' (import_data only loads tables listed in MSSQL_DYNAMIC_%s_WHITELIST_TABLES)", name, target.alias, target.alias)
+	}
+	if max := target.config.maxAffectedRows; max > 0 && rows > max {
+		return fmt.Errorf("the import has %d rows, more than the %d allowed on alias '%s' (MAX_AFFECTED_ROWS)", rows, max, target.alias)
+	}
+	keys := []string{fmt.Sprintf("%d rows into %s", rows, table)}
+	if s.isOperationConfirmed(target.alias, "IMPORT", keys) {
+		return nil
+	}
+	return s.requireConfirmationForModification(target.alias, "IMPORT", keys)
+}
+
+// bulkImport reads the file again and sends its valid rows to the table
+// with the bulk copy API, batchSize rows per INSERT BULK, all in one
+// transaction: a failed batch rolls the whole import back.
+func (s *MCPMSSQLServer) bulkImport(ctx context.Context, target *queryTarget, plan *importPlan, delim rune, table []importColumn, qualified string, batchSize int) (int64, int, error) {
+	tx, err := target.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, s.queryFailed(err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	names := make([]string, len(plan.Columns))
+	for i, c := range plan.Columns {
+		names[i] = c.Name
+	}
+	copyIn := mssql.CopyIn(qualified, mssql.BulkOptions{CheckConstraints: true, FireTriggers: true, KeepNulls: true}, names...)
+
+	var stmt *sql.Stmt
+	var imported int64
+	batches, inBatch, firstLine, lastLine := 0, 0, 0, 0
+	flush := func() error {
+		if stmt == nil {
+			return nil
+		}
+		res, err := stmt.ExecContext(ctx)
+		_ = stmt.Close()
+		stmt = nil
+		if err != nil {
+			return fmt.Errorf("the batch of lines %d-%d failed, nothing was imported: %v", firstLine, lastLine, s.queryFailed(err))
+		}
+		n, _ := res.RowsAffected()
+		imported += n
+		batches++
+		inBatch = 0
+		return nil
+	}
+	defer func() {
+		if stmt != nil {
+			_ = stmt.Close()
+		}
+	}()
+
+	check, err := scanImport(ctx, plan.File, plan.Format, delim, table, func(line int, values []interface{}) error {
+		if stmt == nil {
+			prepared, err := tx.PrepareContext(ctx, copyIn)
+			if err != nil {
+				return s.queryFailed(err)
+			}
+			stmt, firstLine = prepared, line
+		}
+		if _, err := stmt.ExecContext(ctx, values...); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		lastLine = line
+		if inBatch++; inBatch == batchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := flush(); err != nil {
+		return 0, 0, err
+	}
+	if check.ValidRows != plan.ValidRows || imported != int64(plan.ValidRows) {
+		return 0, 0, fmt.Errorf("the file changed while it was imported (%d valid rows confirmed, %d read, %d inserted); nothing was imported", plan.ValidRows, check.ValidRows, imported)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, s.queryFailed(err)
+	}
+	return imported, batches, nil
+}
+
+// handleImportData is the tools/call entry point of the import_data tool.
+func (s *MCPMSSQLServer) handleImportData(id interface{}, args map[string]interface{}) *MCPResponse {
+	errorResponse := func(err error) *MCPResponse {
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result: CallToolResult{
+				Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Import Error: %v", err)}},
+				IsError: true,
+			},
+		}
+	}
+	textResponse := func(v interface{}) *MCPResponse {
+		out, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result:  CallToolResult{Content: []ContentItem{{Type: "text", Text: string(out)}}},
+		}
+	}
+
+	if !s.isDynamic {
+		return errorResponse(fmt.Errorf("import_data is only available in dynamic multi-connection mode, on writable aliases"))
+	}
+	target, err := s.resolveTarget(args)
+	if err != nil {
+		return errorResponse(err)
+	}
+	target.tool = "import_data"
+	if target.alias == "" || target.config.readOnly {
+		return errorResponse(fmt.Errorf("import_data can only write to a writable dynamic alias"))
+	}
+	if tx := s.transactionFor(target); tx != nil {
+		return errorResponse(fmt.Errorf("transaction %d is open on alias '%s': commit or roll it back first", tx.id, tx.alias))
+	}
+
+	fileName, _ := args["file_name"].(string)
+	tableArg, _ := args["table"].(string)
+	if strings.TrimSpace(fileName) == "" || strings.TrimSpace(tableArg) == "" {
+		return errorResponse(fmt.Errorf("'file_name' and 'table' are required"))
+	}
+	format, _ := args["format"].(string)
+	path, format, err := importFile(fileName, strings.ToLower(strings.TrimSpace(format)))
+	if err != nil {
+		return errorResponse(err)
+	}
+	schema, table, err := splitQualifiedName(tableArg, "dbo")
+	if err != nil {
+		return errorResponse(err)
+	}
+	delim := ','
+	if d, _ := args["delimiter"].(string); d != "" {
+		if d == `\t` {
+			d = "\t"
+		}
+		r, size := utf8.DecodeRuneInString(d)
+		if size != len(d) || r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+			return errorResponse(fmt.Errorf("invalid delimiter %q: use a single character such as ',' or ';'", d))
+		}
+		delim = r
+	}
+	batchSize := defaultImportBatchSize
+	if n, ok := args["batch_size"].(float64); ok {
+		if n < 1 || n > maxImportBatchSize || n != float64(int(n)) {
+			return errorResponse(fmt.Errorf("batch_size must be a whole number between 1 and %d", maxImportBatchSize))
+		}
+		batchSize = int(n)
+	}
+	apply, _ := args["apply"].(bool)
+	skipInvalid, _ := args["skip_invalid_rows"].(bool)
+
+	ctx, cancel := context.WithTimeout(context.Background(), envSeconds("MSSQL_IMPORT_TIMEOUT", defaultImportTimeout))
+	defer cancel()
+
+	columns, err := s.importTableColumns(ctx, target, schema, table)
+	if err != nil {
+		return errorResponse(err)
+	}
+	plan, err := scanImport(ctx, path, format, delim, columns, nil)
+	if err != nil {
+		return errorResponse(err)
+	}
+	plan.Table = schema + "." + table
+
+	if !apply {
+		switch {
+		case plan.ValidRows == 0:
+			plan.Next = "No valid rows to import."
+		case plan.InvalidRows > 0:
+			plan.Next = fmt.Sprintf("Fix the invalid rows, or call import_data again with apply=true and skip_invalid_rows=true to import the %d valid rows (confirmation required).", plan.ValidRows)
+		default:
+			plan.Next = fmt.Sprintf("Call import_data again with apply=true to import the %d rows (confirmation required).", plan.ValidRows)
+		}
+		return textResponse(plan)
+	}
+	if plan.InvalidRows > 0 && !skipInvalid {
+		return errorResponse(fmt.Errorf("%d of %d rows are invalid; fix them or set skip_invalid_rows=true:\n- %s", plan.InvalidRows, plan.Rows, strings.Join(plan.Errors, "\n- ")))
+	}
+	if plan.ValidRows == 0 {
+		return errorResponse(fmt.Errorf("the file has no valid rows to import"))
+	}
+	if err := s.enforcePolicy(policyRequest{target: target, query: "IMPORT", mode: policyImport, table: plan.Table, rows: plan.ValidRows}); err != nil {
+		return errorResponse(err)
+	}
+
+	imported, batches, err := s.bulkImport(ctx, target, plan, delim, columns, qualifiedTable(schema, table), batchSize)
+	if err != nil {
+		return errorResponse(err)
+	}
+	s.secLogger.Printf("import_data: %d rows imported into %s on alias '%s' from %s (%d batches, %d invalid rows skipped)", imported, plan.Table, target.alias, path, batches, plan.InvalidRows)
+	return textResponse(map[string]interface{}{
+		"file":          path,
+		"table":         plan.Table,
+		"rows_imported": imported,
+		"batches":       batches,
+		"skipped_rows":  plan.InvalidRows,
+		"errors":        plan.Errors,
+	})
+}
+
+// importDataTool describes import_data.
+func importDataTool() Tool {
+	return Tool{
+		Name:        "import_data",
+		Title:       "Import Data",
+		Description: "Bulk load a CSV (with header) or JSON Lines file from the import directory (MSSQL_IMPORT_DIR) into a whitelisted table of a writable dynamic alias, using bulk copy instead of INSERT statements. File columns are matched by name to the table columns and every value is checked against the column type. Without apply it returns a preview: row count, column mapping and per-row errors. With apply=true and after confirm_operation (e.g. 'IMPORT 1250 rows into dbo.countries') it loads the valid rows in batches within one transaction.",
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]Property{
+				"alias": {
+					Type:        "string",
+					Description: "Writable dynamic alias to load into (defaults to the active connection)",
+				},
+				"file_name": {
+					Type:        "string",
+					Description: "Plain file name in the import directory (.csv or .jsonl)",
+				},
+				"table": {
+					Type:        "string",
+					Description: "Target table ('table' or 'schema.table'); must be whitelisted on the alias",
+				},
+				"format": {
+					Type:        "string",
+					Description: "'csv' or 'jsonl' (default: from the file extension)",
+				},
+				"delimiter": {
+					Type:        "string",
+					Description: "CSV field delimiter (default ','; e.g. ';' or '\\t')",
+				},
+				"batch_size": {
+					Type:        "integer",
+					Description: fmt.Sprintf("Rows per bulk copy batch (default %d, max %d)", defaultImportBatchSize, maxImportBatchSize),
+				},
+				"skip_invalid_rows": {
+					Type:        "boolean",
+					Description: "Import the valid rows even if some rows are invalid (default false)",
+				},
+				"apply": {
+					Type:        "boolean",
+					Description: "Load the rows (default false: preview only)",
+				},
+			},
+			Required: []string{"file_name", "table"},
+		},
+		Annotations: &ToolAnnotations{
+			ReadOnlyHint:    boolPtr(false),
+			DestructiveHint: boolPtr(false),
+			IdempotentHint:  boolPtr(false),
+			OpenWorldHint:   boolPtr(false),
+		},
+	}
+}
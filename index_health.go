@@ -0,0 +1,512 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	// Fragmentation is only worth acting on for indexes of at least
+	// minFragmentationPages pages; between the two thresholds the index is
+	// reorganized, above rebuildFragmentationPct it is rebuilt.
+	minFragmentationPages       = 1000
+	reorganizeFragmentationPct  = 5.0
+	rebuildFragmentationPct     = 30.0
+	minUptimeHoursForUnusedHint = 7 * 24
+)
+
+// indexObjectQuery resolves the table to inspect; every other health query
+// takes its object_id so a missing table never widens a DMV call to the
+// whole database.
+const indexObjectQuery = `SELECT OBJECT_ID(QUOTENAME(@p1) + '.' + QUOTENAME(@p2), 'U')`
+
+// indexDefinitionQuery lists the table's indexes with their key (in order,
+// with direction) and included columns, both as "[col], [col] DESC" lists.
+const indexDefinitionQuery = `SELECT i.index_id, i.name, i.type_desc, i.is_unique, i.is_primary_key,
+	i.is_unique_constraint, i.is_disabled, ISNULL(i.filter_definition, '') AS filter_definition,
+	ISNULL((SELECT STRING_AGG(QUOTENAME(c.name) + CASE WHEN ic.is_descending_key = 1 THEN ' DESC' ELSE '' END, ', ')
+			WITHIN GROUP (ORDER BY ic.key_ordinal)
+		FROM sys.index_columns ic
+		JOIN sys.columns c ON c.object_id = ic.object_id AND c.column_id = ic.column_id
+		WHERE ic.object_id = i.object_id AND ic.index_id = i.index_id AND ic.key_ordinal > 0), '') AS key_columns,
+	ISNULL((SELECT STRING_AGG(QUOTENAME(c.name), ', ') WITHIN GROUP (ORDER BY c.name)
+		FROM sys.index_columns ic
+		JOIN sys.columns c ON c.object_id = ic.object_id AND c.column_id = ic.column_id
+		WHERE ic.object_id = i.object_id AND ic.index_id = i.index_id AND ic.is_included_column = 1), '') AS included_columns
+FROM sys.indexes i
+WHERE i.object_id = @p1 AND i.index_id > 0 AND i.is_hypothetical = 0
+ORDER BY i.index_id`
+
+// indexFragmentationQuery reads leaf-level fragmentation in LIMITED mode,
+// the cheapest scan: it only reads the parent level of each index.
+const indexFragmentationQuery = `SELECT ps.index_id, ps.avg_fragmentation_in_percent, ps.page_count
+FROM sys.dm_db_index_physical_stats(DB_ID(), @p1, NULL, NULL, 'LIMITED') ps
+WHERE ps.index_id > 0 AND ps.alloc_unit_type_desc = 'IN_ROW_DATA' AND ps.index_level = 0`
+
+// indexUsageQuery reads the usage counters, which are reset when the
+// instance restarts.
+const indexUsageQuery = `SELECT us.index_id, us.user_seeks, us.user_scans, us.user_lookups, us.user_updates,
+	(SELECT MAX(v) FROM (VALUES (us.last_user_seek), (us.last_user_scan), (us.last_user_lookup)) AS reads(v)) AS last_read
+FROM sys.dm_db_index_usage_stats us
+WHERE us.database_id = DB_ID() AND us.object_id = @p1`
+
+const serverUptimeQuery = `SELECT DATEDIFF(hour, sqlserver_start_time, SYSDATETIME()) FROM sys.dm_os_sys_info`
+
+// statisticsQuery lists the table's statistics with their age and the
+// modifications since their last update.
+const statisticsQuery = `SELECT st.name, st.auto_created, st.user_created, st.no_recompute,
+	sp.last_updated, ISNULL(sp.rows, 0) AS rows, ISNULL(sp.rows_sampled, 0) AS rows_sampled,
+	ISNULL(sp.modification_counter, 0) AS modification_counter,
+	ISNULL((SELECT STRING_AGG(QUOTENAME(c.name), ', ') WITHIN GROUP (ORDER BY sc.stats_column_id)
+		FROM sys.stats_columns sc
+		JOIN sys.columns c ON c.object_id = sc.object_id AND c.column_id = sc.column_id
+		WHERE sc.object_id = st.object_id AND sc.stats_id = st.stats_id), '') AS columns
+FROM sys.stats st
+OUTER APPLY sys.dm_db_stats_properties(st.object_id, st.stats_id) sp
+WHERE st.object_id = @p1
+ORDER BY st.stats_id`
+
+// indexUsage is an index's usage since the instance started.
+type indexUsage struct {
+	Seeks    int64  `json:"seeks"`
+	Scans    int64  `json:"scans"`
+	Lookups  int64  `json:"lookups"`
+	Updates  int64  `json:"updates"`
+	LastRead string `json:"last_read,omitempty"`
+}
+
+func (u *indexUsage) reads() int64 {
+	if u == nil {
+		return 0
+	}
+	return u.Seeks + u.Scans + u.Lookups
+}
+
+// indexKeyColumn is one key column of an index.
+type indexKeyColumn struct {
+	Name       string `json:"name"`
+	Descending bool   `json:"descending,omitempty"`
+}
+
+// indexHealth is one index of the table.
+type indexHealth struct {
+	id               int
+	Name             string           `json:"name"`
+	Type             string           `json:"type"`
+	Unique           bool             `json:"unique,omitempty"`
+	PrimaryKey       bool             `json:"primary_key,omitempty"`
+	UniqueConstraint bool             `json:"unique_constraint,omitempty"`
+	Disabled         bool             `json:"disabled,omitempty"`
+	Filter           string           `json:"filter,omitempty"`
+	KeyColumns       []indexKeyColumn `json:"key_columns"`
+	IncludedColumns  []string         `json:"included_columns,omitempty"`
+	Pages            int64            `json:"pages,omitempty"`
+	FragmentationPct *float64         `json:"fragmentation_pct,omitempty"`
+	Usage            *indexUsage      `json:"usage,omitempty"`
+}
+
+// rowstore reports whether the index is a B-tree index, the only kind the
+// duplicate and fragmentation rules apply to.
+func (ix indexHealth) rowstore() bool {
+	return ix.Type == "CLUSTERED" || ix.Type == "NONCLUSTERED"
+}
+
+// constrained reports whether dropping the index would drop a constraint
+// or the table's clustering.
+func (ix indexHealth) constrained() bool {
+	return ix.PrimaryKey || ix.UniqueConstraint || ix.Unique || ix.Type == "CLUSTERED"
+}
+
+// statsHealth is one statistics object of the table.
+type statsHealth struct {
+	Name          string   `json:"name"`
+	Columns       []string `json:"columns"`
+	Origin        string   `json:"origin"`
+	NoRecompute   bool     `json:"no_recompute,omitempty"`
+	LastUpdated   string   `json:"last_updated,omitempty"`
+	Rows          int64    `json:"rows"`
+	SamplePct     float64  `json:"sample_pct,omitempty"`
+	Modifications int64    `json:"modifications"`
+	lastUpdated   bool
+}
+
+// indexFinding is one problem found, with the action that would fix it.
+// DDL is only a suggestion: the server never executes it.
+type indexFinding struct {
+	Kind   string `json:"kind"`
+	Object string `json:"object"`
+	Detail string `json:"detail"`
+	Action string `json:"action"`
+	DDL    string `json:"ddl"`
+}
+
+// indexHealthReport is the result of inspect detail=health.
+type indexHealthReport struct {
+	Table      string         `json:"table"`
+	Summary    string         `json:"summary"`
+	Notes      []string       `json:"notes,omitempty"`
+	Findings   []indexFinding `json:"findings"`
+	Indexes    []indexHealth  `json:"indexes"`
+	Statistics []statsHealth  `json:"statistics"`
+}
+
+// parseIndexKeyColumns parses a "[col], [col] DESC" list of QUOTENAME'd
+// columns, each optionally followed by DESC.
+func parseIndexKeyColumns(list string) []indexKeyColumn {
+	var keys []indexKeyColumn
+	for rest := strings.TrimSpace(list); strings.HasPrefix(rest, "["); {
+		var name strings.Builder
+		i := 1
+		for ; i < len(rest); i++ {
+			if rest[i] == ']' {
+				if i+1 < len(rest) && rest[i+1] == ']' {
+					name.WriteByte(']')
+					i++
+					continue
+				}
+				break
+			}
+			name.WriteByte(rest[i])
+		}
+		rest = strings.TrimSpace(rest[min(i+1, len(rest)):])
+		key := indexKeyColumn{Name: name.String()}
+		if after, ok := strings.CutPrefix(rest, "DESC"); ok {
+			key.Descending, rest = true, strings.TrimSpace(after)
+		}
+		keys = append(keys, key)
+		rest = strings.TrimSpace(strings.TrimPrefix(rest, ","))
+	}
+	return keys
+}
+
+// parseColumnList parses a "[col], [col]" list of QUOTENAME'd columns.
+func parseColumnList(list string) []string {
+	var cols []string
+	for _, k := range parseIndexKeyColumns(list) {
+		cols = append(cols, k.Name)
+	}
+	return cols
+}
+
+// staleStatsThreshold is the number of modifications after which
+// statistics on a table of rows rows are considered stale: the dynamic
+// auto-update threshold of SQL Server 2016 and later.
+func staleStatsThreshold(rows int64) float64 {
+	return math.Min(500+0.2*float64(rows), math.Sqrt(1000*float64(rows)))
+}
+
+// fragmentationFindings recommends REORGANIZE or REBUILD for fragmented
+// indexes large enough for it to matter.
+func fragmentationFindings(schema, table string, indexes []indexHealth) []indexFinding {
+	var findings []indexFinding
+	for _, ix := range indexes {
+		if ix.FragmentationPct == nil || ix.Disabled || !ix.rowstore() || ix.Pages < minFragmentationPages {
+			continue
+		}
+		frag := *ix.FragmentationPct
+		action := ""
+		switch {
+		case frag > rebuildFragmentationPct:
+			action = "REBUILD"
+		case frag > reorganizeFragmentationPct:
+			action = "REORGANIZE"
+		default:
+			continue
+		}
+		findings = append(findings, indexFinding{
+			Kind:   "fragmentation",
+			Object: ix.Name,
+			Detail: fmt.Sprintf("%.1f%% fragmented over %d pages", frag, ix.Pages),
+			Action: action,
+			DDL:    fmt.Sprintf("ALTER INDEX %s ON %s %s;", quoteIdentifier(ix.Name), qualifiedTable(schema, table), action),
+		})
+	}
+	return findings
+}
+
+// unusedIndexFindings reports nonclustered indexes that were never read
+// since the instance started. Indexes backing a constraint are left alone.
+func unusedIndexFindings(schema, table string, indexes []indexHealth) []indexFinding {
+	var findings []indexFinding
+	for _, ix := range indexes {
+		if ix.Type != "NONCLUSTERED" || ix.constrained() || ix.Disabled || ix.Usage.reads() > 0 {
+			continue
+		}
+		detail := "never read since the instance started"
+		if ix.Usage != nil && ix.Usage.Updates > 0 {
+			detail = fmt.Sprintf("never read but maintained by %d writes since the instance started", ix.Usage.Updates)
+		}
+		findings = append(findings, indexFinding{
+			Kind:   "unused",
+			Object: ix.Name,
+			Detail: detail,
+			Action: "DROP (after checking periodic workloads such as month-end reports)",
+			DDL:    fmt.Sprintf("DROP INDEX %s ON %s;", quoteIdentifier(ix.Name), qualifiedTable(schema, table)),
+		})
+	}
+	return findings
+}
+
+// coveredBy reports whether every query a can serve, b can serve too: a's
+// keys are a left prefix of b's (same order and direction), both have the
+// same filter, and b holds a's included columns (a clustered index holds
+// every column).
+func (ix indexHealth) coveredBy(b indexHealth) bool {
+	if len(ix.KeyColumns) == 0 || len(ix.KeyColumns) > len(b.KeyColumns) || ix.Filter != b.Filter {
+		return false
+	}
+	for i, k := range ix.KeyColumns {
+		if !strings.EqualFold(k.Name, b.KeyColumns[i].Name) || k.Descending != b.KeyColumns[i].Descending {
+			return false
+		}
+	}
+	if b.Type == "CLUSTERED" {
+		return true
+	}
+	has := map[string]bool{}
+	for _, k := range b.KeyColumns {
+		has[strings.ToLower(k.Name)] = true
+	}
+	for _, c := range b.IncludedColumns {
+		has[strings.ToLower(c)] = true
+	}
+	for _, c := range ix.IncludedColumns {
+		if !has[strings.ToLower(c)] {
+			return false
+		}
+	}
+	return true
+}
+
+// duplicateIndexFindings reports indexes made redundant by another one:
+// duplicates have the same keys, overlapping indexes a left prefix of the
+// other's keys. Of two droppable duplicates only the newer one is reported.
+func duplicateIndexFindings(schema, table string, indexes []indexHealth) []indexFinding {
+	var findings []indexFinding
+	for _, a := range indexes {
+		if !a.rowstore() || a.constrained() || a.Disabled {
+			continue
+		}
+		for _, b := range indexes {
+			if a.id == b.id || !b.rowstore() || b.Disabled || !a.coveredBy(b) {
+				continue
+			}
+			same := len(a.KeyColumns) == len(b.KeyColumns)
+			if same && b.coveredBy(a) && !b.constrained() && a.id < b.id {
+				continue // b is reported instead
+			}
+			kind, detail := "overlapping", fmt.Sprintf("its keys are a left prefix of %s's", b.Name)
+			if same {
+				kind, detail = "duplicate", fmt.Sprintf("same keys as %s", b.Name)
+			}
+			findings = append(findings, indexFinding{
+				Kind:   kind,
+				Object: a.Name,
+				Detail: detail + ", which covers every query it can serve",
+				Action: "DROP (" + b.Name + " is kept)",
+				DDL:    fmt.Sprintf("DROP INDEX %s ON %s;", quoteIdentifier(a.Name), qualifiedTable(schema, table)),
+			})
+			break
+		}
+	}
+	return findings
+}
+
+// staleStatisticsFindings reports statistics modified past the auto-update
+// threshold, or never computed on a table that has rows.
+func staleStatisticsFindings(schema, table string, stats []statsHealth) []indexFinding {
+	var tableRows int64
+	for _, st := range stats {
+		if st.Rows > tableRows {
+			tableRows = st.Rows
+		}
+	}
+	var findings []indexFinding
+	for _, st := range stats {
+		detail := ""
+		switch {
+		case !st.lastUpdated && tableRows > 0:
+			detail = "never computed"
+		case st.lastUpdated && st.Modifications > 0 && float64(st.Modifications) >= staleStatsThreshold(st.Rows):
+			detail = fmt.Sprintf("%d modifications since %s (%d rows)", st.Modifications, st.LastUpdated, st.Rows)
+		default:
+			continue
+		}
+		if st.NoRecompute {
+			detail += "; NORECOMPUTE disables automatic updates"
+		}
+		findings = append(findings, indexFinding{
+			Kind:   "stale_statistics",
+			Object: st.Name,
+			Detail: detail,
+			Action: "UPDATE STATISTICS",
+			DDL:    fmt.Sprintf("UPDATE STATISTICS %s %s;", qualifiedTable(schema, table), quoteIdentifier(st.Name)),
+		})
+	}
+	return findings
+}
+
+// sectionNote explains a health section that could not be read.
+func (s *MCPMSSQLServer) sectionNote(section string, err error) string {
+	if s.devMode {
+		return fmt.Sprintf("%s unavailable: %v", section, err)
+	}
+	return fmt.Sprintf("%s unavailable: the login lacks the permission to read it", section)
+}
+
+// indexHealthOn builds the index and statistics health report of a table.
+// Sections the login cannot read are explained in the notes instead of
+// failing the report.
+func (s *MCPMSSQLServer) indexHealthOn(ctx context.Context, target *queryTarget, schema, table string) (*indexHealthReport, error) {
+	var objectID sql.NullInt64
+	if err := s.scanQuery(ctx, target, indexObjectQuery, []interface{}{schema, table}, func(rows *sql.Rows) error {
+		return rows.Scan(&objectID)
+	}); err != nil {
+		return nil, err
+	}
+	if !objectID.Valid {
+		return nil, fmt.Errorf("table '%s.%s' not found", schema, table)
+	}
+	report := &indexHealthReport{
+		Table:      schema + "." + table,
+		Findings:   []indexFinding{},
+		Indexes:    []indexHealth{},
+		Statistics: []statsHealth{},
+	}
+	caps, err := s.perfCapabilitiesOn(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := map[int]*indexHealth{}
+	err = s.scanQuery(ctx, target, indexDefinitionQuery, []interface{}{objectID.Int64}, func(rows *sql.Rows) error {
+		var ix indexHealth
+		var name sql.NullString
+		var keys, includes string
+		if err := rows.Scan(&ix.id, &name, &ix.Type, &ix.Unique, &ix.PrimaryKey, &ix.UniqueConstraint,
+			&ix.Disabled, &ix.Filter, &keys, &includes); err != nil {
+			return err
+		}
+		ix.Name = name.String
+		ix.KeyColumns = parseIndexKeyColumns(keys)
+		ix.IncludedColumns = parseColumnList(includes)
+		report.Indexes = append(report.Indexes, ix)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range report.Indexes {
+		byID[report.Indexes[i].id] = &report.Indexes[i]
+	}
+
+	if !caps.databaseState {
+		report.Notes = append(report.Notes, "Fragmentation needs VIEW DATABASE STATE, which this login does not have.")
+	} else {
+		weighted := map[int]float64{}
+		err = s.scanQuery(ctx, target, indexFragmentationQuery, []interface{}{objectID.Int64}, func(rows *sql.Rows) error {
+			var id int
+			var frag float64
+			var pages int64
+			if err := rows.Scan(&id, &frag, &pages); err != nil {
+				return err
+			}
+			if ix := byID[id]; ix != nil {
+				ix.Pages += pages
+				weighted[id] += frag * float64(pages)
+			}
+			return nil
+		})
+		if err != nil {
+			report.Notes = append(report.Notes, s.sectionNote("Fragmentation", err))
+		}
+		for id, sum := range weighted {
+			if ix := byID[id]; ix != nil && ix.Pages > 0 {
+				frag := round1(sum / float64(ix.Pages))
+				ix.FragmentationPct = &frag
+			}
+		}
+	}
+
+	usageRead := false
+	if !caps.dmv {
+		report.Notes = append(report.Notes, viewServerStateNote("Index usage")+". Unused indexes are not reported.")
+	} else {
+		err = s.scanQuery(ctx, target, indexUsageQuery, []interface{}{objectID.Int64}, func(rows *sql.Rows) error {
+			var id int
+			var u indexUsage
+			var lastRead sql.NullTime
+			if err := rows.Scan(&id, &u.Seeks, &u.Scans, &u.Lookups, &u.Updates, &lastRead); err != nil {
+				return err
+			}
+			if lastRead.Valid {
+				u.LastRead = lastRead.Time.Format("2006-01-02 15:04:05")
+			}
+			if ix := byID[id]; ix != nil {
+				ix.Usage = &u
+			}
+			return nil
+		})
+		if err != nil {
+			report.Notes = append(report.Notes, s.sectionNote("Index usage", err))
+		} else {
+			usageRead = true
+			var uptime sql.NullInt64
+			_ = s.scanQuery(ctx, target, serverUptimeQuery, nil, func(rows *sql.Rows) error { // #nosec G104 - uptime only qualifies the usage findings
+				return rows.Scan(&uptime)
+			})
+			if uptime.Valid && uptime.Int64 < minUptimeHoursForUnusedHint {
+				report.Notes = append(report.Notes, fmt.Sprintf("The instance started %d hours ago: usage counters cover a short period, so treat unused indexes with caution.", uptime.Int64))
+			}
+		}
+	}
+
+	err = s.scanQuery(ctx, target, statisticsQuery, []interface{}{objectID.Int64}, func(rows *sql.Rows) error {
+		var st statsHealth
+		var autoCreated, userCreated bool
+		var lastUpdated sql.NullTime
+		var sampled int64
+		var columns string
+		if err := rows.Scan(&st.Name, &autoCreated, &userCreated, &st.NoRecompute, &lastUpdated,
+			&st.Rows, &sampled, &st.Modifications, &columns); err != nil {
+			return err
+		}
+		st.Columns = parseColumnList(columns)
+		switch {
+		case autoCreated:
+			st.Origin = "auto_created"
+		case userCreated:
+			st.Origin = "user_created"
+		default:
+			st.Origin = "index"
+		}
+		if lastUpdated.Valid {
+			st.lastUpdated = true
+			st.LastUpdated = lastUpdated.Time.Format("2006-01-02 15:04:05")
+		}
+		if st.Rows > 0 {
+			st.SamplePct = round1(100 * float64(sampled) / float64(st.Rows))
+		}
+		report.Statistics = append(report.Statistics, st)
+		return nil
+	})
+	if err != nil {
+		report.Notes = append(report.Notes, s.sectionNote("Statistics", err))
+	}
+
+	report.Findings = append(report.Findings, fragmentationFindings(schema, table, report.Indexes)...)
+	if usageRead {
+		report.Findings = append(report.Findings, unusedIndexFindings(schema, table, report.Indexes)...)
+	}
+	report.Findings = append(report.Findings, duplicateIndexFindings(schema, table, report.Indexes)...)
+	report.Findings = append(report.Findings, staleStatisticsFindings(schema, table, report.Statistics)...)
+
+	report.Summary = fmt.Sprintf("%d index(es), %d statistics, %d finding(s)", len(report.Indexes), len(report.Statistics), len(report.Findings))
+	if len(report.Findings) > 0 {
+		report.Notes = append(report.Notes, "The DDL of each finding is a recommendation to review; it is never executed by this server.")
+	}
+	return report, nil
+}
@@ -0,0 +1,675 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	defaultJoinMaxHops = 4
+	maxJoinMaxHops     = 8
+	defaultJoinPaths   = 3
+	maxJoinPaths       = 10
+	maxJoinTables      = 10
+	// joinSearchSteps bounds the path enumeration on dense graphs.
+	joinSearchSteps = 200000
+	joinPathTimeout = 30 * time.Second
+)
+
+// joinEdge is a relationship between a child table (holding the
+// referencing columns) and its parent. Inferred edges come from naming
+// conventions rather than a declared foreign key.
+type joinEdge struct {
+	name          string
+	child, parent string // table keys, see joinGraph
+	childCols     []string
+	parentCols    []string
+	inferred      bool
+}
+
+func (e *joinEdge) other(table string) string {
+	if e.child == table {
+		return e.parent
+	}
+	return e.child
+}
+
+// joinGraph is the foreign-key graph of a database. Tables are keyed by
+// their lower-case "schema.table" name.
+type joinGraph struct {
+	tables map[string]*findValueTable
+	adj    map[string][]*joinEdge
+}
+
+func joinKey(schema, table string) string { return strings.ToLower(schema + "." + table) }
+
+func newJoinGraph(tables []*findValueTable) *joinGraph {
+	g := &joinGraph{tables: map[string]*findValueTable{}, adj: map[string][]*joinEdge{}}
+	for _, t := range tables {
+		g.tables[joinKey(t.schema, t.table)] = t
+	}
+	return g
+}
+
+// add links both tables of e. Self-references are kept out: a path never
+// needs them.
+func (g *joinGraph) add(e *joinEdge) {
+	if e.child == e.parent {
+		return
+	}
+	g.adj[e.child] = append(g.adj[e.child], e)
+	g.adj[e.parent] = append(g.adj[e.parent], e)
+}
+
+// resolve finds the table a user-supplied name refers to. Without schema it
+// must be unique across schemas, or exist in dbo.
+func (g *joinGraph) resolve(name string) (string, error) {
+	if strings.Contains(name, ".") {
+		schema, table, err := splitQualifiedName(name, "dbo")
+		if err != nil {
+			return "", err
+		}
+		if _, ok := g.tables[joinKey(schema, table)]; !ok {
+			return "", fmt.Errorf("table %s.%s not found", schema, table)
+		}
+		return joinKey(schema, table), nil
+	}
+	_, table, err := splitQualifiedName(name, "dbo")
+	if err != nil {
+		return "", err
+	}
+	if _, ok := g.tables[joinKey("dbo", table)]; ok {
+		return joinKey("dbo", table), nil
+	}
+	var found []string
+	for key, t := range g.tables {
+		if strings.EqualFold(t.table, table) {
+			found = append(found, key)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("table %s not found", table)
+	case 1:
+		return found[0], nil
+	}
+	sort.Strings(found)
+	return "", fmt.Errorf("table %s exists in several schemas (%s); qualify it", table, strings.Join(found, ", "))
+}
+
+// distances is a breadth-first search from table: the number of hops to
+// every table connected to it.
+func (g *joinGraph) distances(from string) map[string]int {
+	dist := map[string]int{from: 0}
+	queue := []string{from}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for _, e := range g.adj[n] {
+			if m := e.other(n); m != n {
+				if _, seen := dist[m]; !seen {
+					dist[m] = dist[n] + 1
+					queue = append(queue, m)
+				}
+			}
+		}
+	}
+	return dist
+}
+
+// paths lists the simple paths from one table to another that are at most
+// one hop longer than the shortest and no longer than maxHops, best first:
+// fewer hops, then fewer inferred relationships.
+func (g *joinGraph) paths(from, to string, maxHops, want int) [][]*joinEdge {
+	dist := g.distances(to)
+	shortest, ok := dist[from]
+	if !ok || shortest > maxHops {
+		return nil
+	}
+	limit := min(shortest+1, maxHops)
+	var found [][]*joinEdge
+	visited := map[string]bool{from: true}
+	var path []*joinEdge
+	steps := 0
+	var walk func(n string)
+	walk = func(n string) {
+		if n == to {
+			found = append(found, append([]*joinEdge(nil), path...))
+			return
+		}
+		for _, e := range g.adj[n] {
+			m := e.other(n)
+			d, reachable := dist[m]
+			if visited[m] || !reachable || len(path)+1+d > limit {
+				continue
+			}
+			if steps++; steps > joinSearchSteps {
+				return
+			}
+			visited[m] = true
+			path = append(path, e)
+			walk(m)
+			path = path[:len(path)-1]
+			visited[m] = false
+		}
+	}
+	walk(from)
+	sort.SliceStable(found, func(i, j int) bool {
+		if len(found[i]) != len(found[j]) {
+			return len(found[i]) < len(found[j])
+		}
+		return countInferred(found[i]) < countInferred(found[j])
+	})
+	if len(found) > want {
+		found = found[:want]
+	}
+	return found
+}
+
+func countInferred(edges []*joinEdge) int {
+	n := 0
+	for _, e := range edges {
+		if e.inferred {
+			n++
+		}
+	}
+	return n
+}
+
+// tree connects several tables: starting from the first, it repeatedly
+// joins the nearest table not yet connected by its shortest path from any
+// table already in the tree. It returns the edges in join order, or the
+// tables that cannot be reached within maxHops.
+func (g *joinGraph) tree(tables []string, maxHops int) ([]*joinEdge, []string) {
+	in := map[string]bool{tables[0]: true}
+	order := []string{tables[0]} // tree tables, in the order they joined
+	var edges []*joinEdge
+	remaining := append([]string(nil), tables[1:]...)
+	for len(remaining) > 0 {
+		// Multi-source search from every table of the tree.
+		via := map[string]*joinEdge{}
+		dist := map[string]int{}
+		queue := append([]string(nil), order...)
+		for _, n := range order {
+			dist[n] = 0
+		}
+		for len(queue) > 0 {
+			n := queue[0]
+			queue = queue[1:]
+			if dist[n] >= maxHops {
+				continue
+			}
+			for _, e := range g.adj[n] {
+				if m := e.other(n); m != n {
+					if _, seen := dist[m]; !seen {
+						dist[m] = dist[n] + 1
+						via[m] = e
+						queue = append(queue, m)
+					}
+				}
+			}
+		}
+		next := -1
+		for i, t := range remaining {
+			if d, ok := dist[t]; ok && (next < 0 || d < dist[remaining[next]]) {
+				next = i
+			}
+		}
+		if next < 0 {
+			return edges, remaining
+		}
+		var branch []*joinEdge
+		for n := remaining[next]; !in[n]; {
+			e := via[n]
+			branch = append(branch, e)
+			n = e.other(n)
+		}
+		for i := len(branch) - 1; i >= 0; i-- {
+			e := branch[i]
+			for _, n := range []string{e.child, e.parent} {
+				if !in[n] {
+					in[n] = true
+					order = append(order, n)
+				}
+			}
+			edges = append(edges, e)
+		}
+		var left []string
+		for _, t := range remaining {
+			if !in[t] {
+				left = append(left, t)
+			}
+		}
+		remaining = left
+	}
+	return edges, nil
+}
+
+// joinStep is one JOIN of a path: To is joined to From, already in the
+// query. Cardinality is many-to-one when To is the referenced table (rows
+// are not multiplied) and one-to-many otherwise.
+type joinStep struct {
+	From        string `json:"from"`
+	To          string `json:"to"`
+	On          string `json:"on"`
+	Constraint  string `json:"constraint,omitempty"`
+	Cardinality string `json:"cardinality"`
+	Inferred    bool   `json:"inferred,omitempty"`
+}
+
+// joinPath is a way to join the requested tables.
+type joinPath struct {
+	Hops     int        `json:"hops"`
+	Tables   []string   `json:"tables"`
+	Steps    []joinStep `json:"joins"`
+	Bridges  []string   `json:"bridge_tables,omitempty"`
+	Inferred bool       `json:"uses_inferred,omitempty"`
+	SQL      string     `json:"sql"`
+}
+
+// joinAliasReserved are short words a generated alias must not be.
+var joinAliasReserved = map[string]bool{
+	"as": true, "at": true, "by": true, "go": true, "if": true, "in": true, "is": true, "of": true, "on": true, "or": true, "to": true,
+	"add": true, "all": true, "and": true, "any": true, "asc": true, "end": true, "for": true, "key": true, "not": true, "set": true, "top": true, "use": true,
+}
+
+// joinAlias abbreviates a table name to the initials of its words:
+// OrderLines and order_lines become ol.
+func joinAlias(table string) string {
+	var b strings.Builder
+	prevLower := false
+	start := true
+	for _, r := range table {
+		switch {
+		case r == '_' || r == ' ' || r == '-':
+			start = true
+			prevLower = false
+			continue
+		case unicode.IsUpper(r) && prevLower:
+			start = true
+		}
+		if start && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(unicode.ToLower(r))
+			start = false
+		}
+		prevLower = unicode.IsLower(r) || unicode.IsDigit(r)
+	}
+	alias := b.String()
+	if alias == "" || !unicode.IsLetter([]rune(alias)[0]) {
+		alias = "t" + alias
+	}
+	return alias
+}
+
+// renderJoin turns edges, in join order starting at first, into steps and
+// the FROM clause that joins them.
+func (g *joinGraph) renderJoin(first string, edges []*joinEdge, requested []string) joinPath {
+	name := func(key string) string {
+		t := g.tables[key]
+		return t.schema + "." + t.table
+	}
+	aliases := map[string]string{}
+	used := map[string]bool{}
+	alias := func(key string) string {
+		if a, ok := aliases[key]; ok {
+			return a
+		}
+		base := joinAlias(g.tables[key].table)
+		a := base
+		for i := 2; used[a] || joinAliasReserved[a]; i++ {
+			a = fmt.Sprintf("%s%d", base, i)
+		}
+		aliases[key], used[a] = a, true
+		return a
+	}
+	t := g.tables[first]
+	p := joinPath{Hops: len(edges), Tables: []string{name(first)}}
+	sqlText := "FROM " + qualifiedTable(t.schema, t.table) + " AS " + alias(first)
+	in := map[string]bool{first: true}
+	childOf := map[string]int{}
+	for _, e := range edges {
+		from, to := e.parent, e.child
+		if in[e.child] {
+			from, to = e.child, e.parent
+		}
+		in[to] = true
+		var on []string
+		toCols, fromCols := e.childCols, e.parentCols
+		if to == e.parent {
+			toCols, fromCols = e.parentCols, e.childCols
+		}
+		for i := range toCols {
+			on = append(on, fmt.Sprintf("%s.%s = %s.%s", alias(to), quoteIdentifier(toCols[i]), alias(from), quoteIdentifier(fromCols[i])))
+		}
+		cardinality := "one-to-many"
+		if to == e.parent {
+			cardinality = "many-to-one"
+		}
+		step := joinStep{From: name(from), To: name(to), On: strings.Join(on, " AND "), Constraint: e.name, Cardinality: cardinality, Inferred: e.inferred}
+		p.Steps = append(p.Steps, step)
+		p.Tables = append(p.Tables, name(to))
+		p.Inferred = p.Inferred || e.inferred
+		childOf[e.child]++
+		tt := g.tables[to]
+		sqlText += "\nJOIN " + qualifiedTable(tt.schema, tt.table) + " AS " + alias(to) + " ON " + step.On
+		if e.inferred {
+			sqlText += " -- inferred"
+		}
+	}
+	// A table that is not one of the requested ones and references two
+	// tables of the join is a many-to-many bridge.
+	for _, key := range sortedKeys(childOf) {
+		if childOf[key] >= 2 && !slices.Contains(requested, key) {
+			p.Bridges = append(p.Bridges, name(key))
+		}
+	}
+	p.SQL = sqlText
+	return p
+}
+
+// singular strips the usual English plural endings from a table name.
+func singular(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, "ies") && len(lower) > 3:
+		return lower[:len(lower)-3] + "y"
+	case strings.HasSuffix(lower, "sses"), strings.HasSuffix(lower, "xes"), strings.HasSuffix(lower, "ches"), strings.HasSuffix(lower, "shes"):
+		return lower[:len(lower)-2]
+	case strings.HasSuffix(lower, "s") && !strings.HasSuffix(lower, "ss"):
+		return lower[:len(lower)-1]
+	}
+	return lower
+}
+
+// inferEdges adds relationships that naming conventions suggest where no
+// foreign key is declared: a column named like a table's single-column
+// primary key (CustomerID), or like the table followed by Id (customer_id
+// for Customers.id), with the same type.
+func (g *joinGraph) inferEdges() int {
+	declared := map[string]bool{}
+	linked := map[string]bool{}
+	for _, edges := range g.adj {
+		for _, e := range edges {
+			for _, c := range e.childCols {
+				declared[e.child+"."+strings.ToLower(c)] = true
+			}
+			linked[e.child+"|"+e.parent] = true
+		}
+	}
+	type target struct {
+		key string
+		pk  findValueColumn
+	}
+	byName := map[string][]target{}
+	for _, key := range sortedKeys(g.tables) {
+		t := g.tables[key]
+		if len(t.key) != 1 {
+			continue
+		}
+		var pk findValueColumn
+		for _, c := range t.columns {
+			if c.name == t.key[0] {
+				pk = c
+			}
+		}
+		names := map[string]bool{}
+		if !strings.EqualFold(pk.name, "id") {
+			names[strings.ToLower(pk.name)] = true
+		}
+		for _, base := range []string{strings.ToLower(t.table), singular(t.table)} {
+			names[base+"id"], names[base+"_id"] = true, true
+		}
+		for n := range names {
+			byName[n] = append(byName[n], target{key, pk})
+		}
+	}
+	added := 0
+	for _, key := range sortedKeys(g.tables) {
+		t := g.tables[key]
+		for _, c := range t.columns {
+			lower := strings.ToLower(c.name)
+			if declared[key+"."+lower] || (len(t.key) == 1 && t.key[0] == c.name) {
+				continue
+			}
+			for _, tg := range byName[lower] {
+				if tg.key == key || tg.pk.typ.base != c.typ.base || linked[key+"|"+tg.key] || linked[tg.key+"|"+key] {
+					continue
+				}
+				g.add(&joinEdge{child: key, parent: tg.key, childCols: []string{c.name}, parentCols: []string{tg.pk.name}, inferred: true})
+				linked[key+"|"+tg.key] = true
+				added++
+			}
+		}
+	}
+	return added
+}
+
+// loadJoinGraph reads every user table with its primary key and every
+// foreign key of the database into a graph.
+func (s *MCPMSSQLServer) loadJoinGraph(ctx context.Context, target *queryTarget) (*joinGraph, error) {
+	var cols []findValueColumn
+	err := s.scanQuery(ctx, target, findValueColumnsQuery, nil, func(rows *sql.Rows) error {
+		var c findValueColumn
+		var typeName sql.NullString
+		var maxLength, precision, scale int
+		if err := rows.Scan(&c.schema, &c.table, &c.name, &typeName, &maxLength, &precision, &scale, &c.masked, &c.keyOrdinal); err != nil {
+			return err
+		}
+		c.typ = findValueColumnType(typeName.String, maxLength, precision, scale)
+		cols = append(cols, c)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading tables: %w", err)
+	}
+	tables, err := findValueTables(cols, "", "")
+	if err != nil {
+		return nil, err
+	}
+	g := newJoinGraph(tables)
+
+	fks := map[string]*joinEdge{}
+	var order []string
+	err = s.scanCatalog(ctx, target, schemaForeignKeysQuery, "", func(rows *sql.Rows) error {
+		var schema, tbl, name, col, refSchema, refTable, refCol, onDelete, onUpdate string
+		if err := rows.Scan(&schema, &tbl, &name, &col, &refSchema, &refTable, &refCol, &onDelete, &onUpdate); err != nil {
+			return err
+		}
+		id := joinKey(schema, tbl) + "." + name
+		e, ok := fks[id]
+		if !ok {
+			e = &joinEdge{name: name, child: joinKey(schema, tbl), parent: joinKey(refSchema, refTable)}
+			fks[id] = e
+			order = append(order, id)
+		}
+		e.childCols = append(e.childCols, col)
+		e.parentCols = append(e.parentCols, refCol)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading foreign keys: %w", err)
+	}
+	for _, id := range order {
+		e := fks[id]
+		if g.tables[e.child] != nil && g.tables[e.parent] != nil {
+			g.add(e)
+		}
+	}
+	return g, nil
+}
+
+// joinPathReport is the reply of join_path.
+type joinPathReport struct {
+	Tables      []string   `json:"tables"`
+	Paths       []joinPath `json:"paths"`
+	ForeignKeys int        `json:"foreign_keys"`
+	Inferred    int        `json:"inferred_relationships,omitempty"`
+	Notes       []string   `json:"notes,omitempty"`
+}
+
+// joinPaths answers join_path on a loaded graph.
+func joinPaths(g *joinGraph, names []string, maxHops, want int, inferred bool) (*joinPathReport, error) {
+	if len(names) < 2 {
+		return nil, fmt.Errorf("'tables' needs at least two tables")
+	}
+	if len(names) > maxJoinTables {
+		return nil, fmt.Errorf("'tables' accepts at most %d tables", maxJoinTables)
+	}
+	report := &joinPathReport{Paths: []joinPath{}}
+	seen := map[string]bool{}
+	var keys []string
+	for _, n := range names {
+		key, err := g.resolve(n)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+			report.Tables = append(report.Tables, g.tables[key].schema+"."+g.tables[key].table)
+		}
+	}
+	if len(keys) < 2 {
+		return nil, fmt.Errorf("'tables' needs at least two different tables")
+	}
+	for _, edges := range g.adj {
+		for _, e := range edges {
+			if !e.inferred {
+				report.ForeignKeys++
+			}
+		}
+	}
+	report.ForeignKeys /= 2
+	if inferred {
+		report.Inferred = g.inferEdges()
+	}
+
+	if len(keys) == 2 {
+		for _, edges := range g.paths(keys[0], keys[1], maxHops, want) {
+			report.Paths = append(report.Paths, g.renderJoin(keys[0], edges, keys))
+		}
+	} else {
+		edges, unreachable := g.tree(keys, maxHops)
+		if len(unreachable) == 0 {
+			report.Paths = append(report.Paths, g.renderJoin(keys[0], edges, keys))
+		} else {
+			var names []string
+			for _, k := range unreachable {
+				names = append(names, g.tables[k].schema+"."+g.tables[k].table)
+			}
+			report.Notes = append(report.Notes, fmt.Sprintf("%s cannot be joined to %s within %d hops", strings.Join(names, ", "), report.Tables[0], maxHops))
+		}
+	}
+	if len(report.Paths) == 0 {
+		note := fmt.Sprintf("no join path within %d hops over the declared foreign keys", maxHops)
+		if inferred {
+			note = fmt.Sprintf("no join path within %d hops, even with inferred relationships", maxHops)
+		} else {
+			note += "; try include_inferred=true or a larger max_hops"
+		}
+		report.Notes = append(report.Notes, note)
+	}
+	for _, p := range report.Paths {
+		if p.Inferred {
+			report.Notes = append(report.Notes, "joins marked inferred follow naming conventions, not declared foreign keys: check them before relying on them")
+			break
+		}
+	}
+	return report, nil
+}
+
+// handleJoinPath is the tools/call entry point of the join_path tool.
+func (s *MCPMSSQLServer) handleJoinPath(id interface{}, args map[string]interface{}) *MCPResponse {
+	errorResponse := func(err error) *MCPResponse {
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result: CallToolResult{
+				Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Join Path Error: %v", err)}},
+				IsError: true,
+			},
+		}
+	}
+	target, err := s.resolveTarget(args)
+	if err != nil {
+		return errorResponse(err)
+	}
+	target.tool = "join_path"
+	names := stringListArg(args["tables"])
+	maxHops := defaultJoinMaxHops
+	if n, ok := args["max_hops"].(float64); ok && n >= 1 {
+		maxHops = int(min(n, maxJoinMaxHops))
+	}
+	want := defaultJoinPaths
+	if n, ok := args["paths"].(float64); ok && n >= 1 {
+		want = int(min(n, maxJoinPaths))
+	}
+	inferred, _ := args["include_inferred"].(bool)
+	if len(names) < 2 {
+		return errorResponse(fmt.Errorf("'tables' needs at least two tables"))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), joinPathTimeout)
+	defer cancel()
+	g, err := s.loadJoinGraph(ctx, target)
+	if err != nil {
+		return errorResponse(err)
+	}
+	report, err := joinPaths(g, names, maxHops, want, inferred)
+	if err != nil {
+		return errorResponse(err)
+	}
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return errorResponse(err)
+	}
+	return &MCPResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Result:  CallToolResult{Content: []ContentItem{{Type: "text", Text: string(out)}}},
+	}
+}
+
+// joinPathTool describes the join_path tool.
+func joinPathTool() Tool {
+	return Tool{
+		Name:        "join_path",
+		Title:       "Join Path",
+		Description: "Find how to join tables instead of guessing join columns: loads every foreign key of the database into a graph and returns the shortest join paths between two tables (or one plan joining three or more), with exact ON clauses including multi-column keys, the cardinality of each join, many-to-many bridge tables on the way, and a ready FROM ... JOIN clause. With include_inferred=true, relationships suggested by column names (CustomerID, customer_id) are used too, marked as inferred.",
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]Property{
+				"tables": {
+					Type:        "string",
+					Description: fmt.Sprintf("Tables to join, comma-separated, 2 to %d ('dbo.Orders' or 'Orders')", maxJoinTables),
+				},
+				"max_hops": {
+					Type:        "integer",
+					Description: fmt.Sprintf("Longest path considered between two tables, in joins (default %d, max %d)", defaultJoinMaxHops, maxJoinMaxHops),
+				},
+				"paths": {
+					Type:        "integer",
+					Description: fmt.Sprintf("Alternative paths returned for two tables (default %d, max %d)", defaultJoinPaths, maxJoinPaths),
+				},
+				"include_inferred": {
+					Type:        "boolean",
+					Description: "Also use relationships inferred from column names where no foreign key is declared (default false)",
+				},
+			},
+			Required: []string{"tables"},
+		},
+		Annotations: &ToolAnnotations{
+			ReadOnlyHint:    boolPtr(true),
+			DestructiveHint: boolPtr(false),
+			IdempotentHint:  boolPtr(true),
+			OpenWorldHint:   boolPtr(false),
+		},
+	}
+}
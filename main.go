@@ -12,10 +12,14 @@ import (
 	osuser "os/user"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	_ "github.com/microsoft/go-mssqldb"
 	// NOTE: Windows Integrated Auth (winsspi) is conditionally imported in
@@ -40,6 +44,14 @@ type MCPResponse struct {
 	Meta    map[string]interface{} `json:"_meta,omitempty"`
 }
 
+// MCPNotification is a server-initiated JSON-RPC notification (no ID, no
+// response expected), e.g. notifications/tools/list_changed.
+type MCPNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
 type MCPError struct {
 	Code    int         `json:"code"`
 	Message string      `json:"message"`
@@ -93,8 +105,40 @@ type InputSchema struct {
 }
 
 type Property struct {
-	Type        string `json:"type"`
-	Description string `json:"description"`
+	Type        string   `json:"type"`
+	Types       []string `json:"-"` // several accepted JSON types; replaces Type when set
+	Description string   `json:"description"`
+}
+
+// MarshalJSON writes Types, when set, as a JSON Schema list of types.
+func (p Property) MarshalJSON() ([]byte, error) {
+	if len(p.Types) == 0 {
+		type plain Property
+		return json.Marshal(plain(p))
+	}
+	return json.Marshal(struct {
+		Type        []string `json:"type"`
+		Description string   `json:"description"`
+	}{p.Types, p.Description})
+}
+
+// UnmarshalJSON reads "type" as a single type or a list of types.
+func (p *Property) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Type        json.RawMessage `json:"type"`
+		Description string          `json:"description"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*p = Property{Description: raw.Description}
+	if len(raw.Type) > 0 && raw.Type[0] == '[' {
+		return json.Unmarshal(raw.Type, &p.Types)
+	}
+	if len(raw.Type) > 0 {
+		return json.Unmarshal(raw.Type, &p.Type)
+	}
+	return nil
 }
 
 type ToolsListResult struct {
@@ -113,9 +157,26 @@ type CallToolResult struct {
 	Meta    map[string]interface{} `json:"_meta,omitempty"`
 }
 
+// ContentItem is one block of a tool result: text, or an image carried as
+// base64 Data with its MimeType.
 type ContentItem struct {
-	Type string `json:"type"`
-	Text string `json:"text"`
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	Data     string `json:"data,omitempty"`
+	MimeType string `json:"mimeType,omitempty"`
+}
+
+// MarshalJSON always writes the text of text items, even when empty, as
+// the MCP schema requires it.
+func (c ContentItem) MarshalJSON() ([]byte, error) {
+	if c.Type == "text" {
+		return json.Marshal(struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		}{c.Type, c.Text})
+	}
+	type plain ContentItem
+	return json.Marshal(plain(c))
 }
 
 type ServerInfo struct {
@@ -235,9 +296,16 @@ func (sl *SecurityLogger) sanitizeForLogging(input string) string {
 
 // serverConfig holds cached configuration read once at startup.
 type serverConfig struct {
-	readOnly        bool
-	whitelistTables []string
-	whitelistProcs  string
+	readOnly            bool
+	whitelistTables     []string
+	whitelistProcs      string
+	procedureTools      bool // MSSQL_PROCEDURE_TOOLS: one tool per whitelisted procedure
+	performanceInsights bool // MSSQL_PERFORMANCE_INSIGHTS: the performance and activity tools
+	allowKill           bool // per-alias only: activity kill_session
+	maxAffectedRows     int  // per-alias only: DML affecting more rows is rolled back (0 = no limit)
+	requireWhere        bool // per-alias only: UPDATE/DELETE need a WHERE clause, TRUNCATE is refused
+	undoJournal         bool // MSSQL_UNDO_JOURNAL=true: journal UPDATE/DELETE on writable dynamic aliases
+	exportEnabled       bool // MSSQL_EXPORT_DIR set: the export_query tool
 }
 
 // DynamicAlias represents one preconfigured dynamic connection with its own security posture.
@@ -253,6 +321,10 @@ type DynamicAlias struct {
 	ConnectionString string // full DSN override (URL or ADO form) — optional, takes precedence; never logged
 	ReadOnly         bool
 	WhitelistTables  []string
+	WhitelistProcs   string // overrides MSSQL_WHITELIST_PROCEDURES for this alias when set
+	AllowKill        bool   // activity kill_session is available on this alias (_ALLOW_KILL=true)
+	MaxAffectedRows  int    // DML affecting more rows is rolled back (_MAX_AFFECTED_ROWS, 0 = no limit)
+	RequireWhere     bool   // UPDATE/DELETE without WHERE and TRUNCATE are refused (_REQUIRE_WHERE=true)
 }
 
 // MSSQL Server
@@ -274,6 +346,34 @@ type MCPMSSQLServer struct {
 	pendingConfirmation *PendingConfirmation
 	confirmMu           sync.Mutex
 
+	// Explicit transaction pinned to a connection (see transaction.go)
+	tx        *pinnedTx
+	txSeq     int
+	txEndNote string // why the last transaction was rolled back automatically
+	txMu      sync.Mutex
+
+	// Connection health history (see health.go)
+	connStates  map[string]string // target -> last recorded state
+	connHistory []connEvent
+	healthMu    sync.Mutex
+
+	// Hot reload of .env-sourced configuration (see reload.go)
+	envFilePath   string            // .env watched for changes ("" = reload from signal only)
+	envFileValues map[string]string // keys last applied from envFilePath -> value
+	reloadMu      sync.Mutex
+
+	// Per-procedure tools (see procedure_tools.go)
+	procTools     []procToolEntry
+	procToolsMu   sync.RWMutex
+	procToolsKick chan struct{}
+
+	// supervisorKick wakes the connection supervisor (see health.go)
+	supervisorKick chan struct{}
+
+	// Outbound stdio channel shared by responses and notifications
+	out   io.Writer
+	outMu sync.Mutex
+
 	rateLimiter struct {
 		mu        sync.Mutex
 		tokens    int
@@ -286,10 +386,12 @@ type MCPMSSQLServer struct {
 // PendingConfirmation represents a confirmation that the AI must explicitly call
 // before performing a potentially destructive operation on a writable dynamic alias.
 type PendingConfirmation struct {
+	Alias       string // dynamic alias the operation targets; a confirmation never carries over to another
 	Operation   string // e.g. "DELETE", "UPDATE", "DROP"
 	Tables      []string
 	Description string
 	ExpiresAt   time.Time
+	Confirmed   bool // set by confirm_operation; consumed by the next matching operation
 }
 
 // checkRateLimit implements a simple token-bucket rate limiter for tool invocations.
@@ -323,45 +425,71 @@ func (s *MCPMSSQLServer) setDB(db *sql.DB) {
 	s.db = db
 }
 
-// connectToDynamicAlias establishes a connection to a preconfigured dynamic alias,
-// closes the previous active connection (if any), and makes the new one the active context.
-// This is the key function for secure multi-database usage within one application.
+// connectToDynamicAlias makes a preconfigured dynamic alias the active context,
+// opening its pool if needed. Pools of previously active aliases stay open in
+// s.connections so tools can still target them per call via the 'alias'
+// argument. This is the key function for secure multi-database usage within
+// one application.
 func (s *MCPMSSQLServer) connectToDynamicAlias(aliasName string) error {
+	aliasName = strings.ToUpper(strings.TrimSpace(aliasName))
+
+	db, err := s.openAliasPool(aliasName)
+	if err != nil {
+		return err
+	}
+
 	s.dynamicMu.Lock()
 	defer s.dynamicMu.Unlock()
 
-	aliasName = strings.ToUpper(strings.TrimSpace(aliasName))
+	if s.connections[aliasName] != db {
+		return fmt.Errorf("connection for alias '%s' was closed while connecting", aliasName)
+	}
+
+	// Make it the active one
+	s.dbMu.Lock()
+	s.db = db
+	s.dbMu.Unlock()
+
+	s.activeAlias = aliasName
+
+	s.secLogger.Printf("Dynamic connection switched to alias '%s' (readOnly=%v)", aliasName, s.dynamicAliases[aliasName].ReadOnly)
+	s.kickProcedureTools()
+	return nil
+}
 
+// openAliasPool returns the open pool for a dynamic alias, opening and
+// pinging a new one if the alias has no pool yet. It does not change the
+// active alias. The ping runs without holding dynamicMu so a slow server does
+// not block tool calls against other aliases.
+func (s *MCPMSSQLServer) openAliasPool(aliasName string) (*sql.DB, error) {
+	s.dynamicMu.RLock()
 	alias, ok := s.dynamicAliases[aliasName]
+	existing := s.connections[aliasName]
+	s.dynamicMu.RUnlock()
+
 	if !ok {
-		return fmt.Errorf("unknown dynamic alias: %s (use dynamic_available to list)", aliasName)
+		return nil, fmt.Errorf("unknown dynamic alias: %s (use dynamic_available to list)", aliasName)
+	}
+	if existing != nil {
+		return existing, nil
 	}
 
-	if alias.Server == "" || alias.Database == "" {
-		return fmt.Errorf("alias '%s' is missing SERVER or DATABASE configuration", aliasName)
+	if alias.ConnectionString == "" && (alias.Server == "" || alias.Database == "") {
+		return nil, fmt.Errorf("alias '%s' is missing SERVER or DATABASE configuration", aliasName)
 	}
 
-	// Build connection string for this alias (respecting its own security posture is handled via getEffectiveConfig)
-	// We reuse the secure connection string logic but construct it manually for the alias.
+	// Build connection string for this alias (respecting its own security posture is handled via getEffectiveConfigFor)
 	// Priority: alias.ConnectionString > per-alias Encrypt/Port > devMode defaults.
 	connStr, err := buildAliasConnectionString(&alias, s.devMode, aliasName)
 	if err != nil {
-		return fmt.Errorf("failed to build connection string for alias '%s': %w", aliasName, err)
-	}
-
-	// Close previous active connection if we had one
-	if s.activeAlias != "" {
-		if oldConn, exists := s.connections[s.activeAlias]; exists && oldConn != nil {
-			_ = oldConn.Close()
-			delete(s.connections, s.activeAlias)
-		}
+		return nil, fmt.Errorf("failed to build connection string for alias '%s': %w", aliasName, err)
 	}
 
-	// Open new connection
 	db, err := sql.Open("sqlserver", connStr)
 	if err != nil {
-		return fmt.Errorf("failed to open connection for alias '%s': %w", aliasName, err)
+		return nil, fmt.Errorf("failed to open connection for alias '%s': %w", aliasName, err)
 	}
+	configurePool(db)
 
 	// Test connection
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
@@ -370,23 +498,137 @@ func (s *MCPMSSQLServer) connectToDynamicAlias(aliasName string) error {
 	if err := db.PingContext(ctx); err != nil {
 		// #nosec G104 -- close error ignored when ping fails; db state is already broken
 		db.Close()
-		return fmt.Errorf("failed to connect to alias '%s': %w", aliasName, err)
+		return nil, fmt.Errorf("failed to connect to alias '%s': %w", aliasName, err)
 	}
 
-	// Store the connection
+	s.dynamicMu.Lock()
+	if _, stillConfigured := s.dynamicAliases[aliasName]; !stillConfigured {
+		s.dynamicMu.Unlock()
+		_ = db.Close()
+		return nil, fmt.Errorf("alias '%s' was removed while connecting", aliasName)
+	}
+	if raced := s.connections[aliasName]; raced != nil {
+		// Another call opened the same alias concurrently; keep theirs.
+		s.dynamicMu.Unlock()
+		_ = db.Close()
+		return raced, nil
+	}
 	if s.connections == nil {
 		s.connections = make(map[string]*sql.DB)
 	}
 	s.connections[aliasName] = db
+	s.dynamicMu.Unlock()
 
-	// Make it the active one
+	s.recordConnState(aliasName, connStateUp, nil)
+	s.secLogger.Printf("Opened pool for dynamic alias '%s' (readOnly=%v)", aliasName, alias.ReadOnly)
+	return db, nil
+}
+
+// configurePool applies the pool limits shared by the classic and alias pools.
+func configurePool(db *sql.DB) {
+	db.SetMaxOpenConns(10)                  // More concurrent connections
+	db.SetMaxIdleConns(5)                   // More idle connections for reuse
+	db.SetConnMaxLifetime(30 * time.Minute) // Shorter lifetime for fresher connections
+	db.SetConnMaxIdleTime(5 * time.Minute)  // Quick cleanup of unused connections
+}
+
+// connectClassic opens and pings the classic (MSSQL_* env) connection pool and
+// installs it as the active database. It is called by the connection
+// supervisor on startup and whenever the pool has to be reopened.
+func (s *MCPMSSQLServer) connectClassic(ctx context.Context) error {
+	// Build secure connection string
+	connStr, err := buildSecureConnectionString()
+	if err != nil {
+		if s.devMode {
+			s.secLogger.Printf("Failed to build connection string: %v", err)
+		} else {
+			s.secLogger.Printf("Failed to build connection string: configuration error")
+		}
+		return fmt.Errorf("failed to build connection string: %w", err)
+	}
+
+	// Connect to MSSQL
+	s.secLogger.Printf("Attempting to connect to MSSQL server...")
+	db, err := sql.Open("sqlserver", connStr)
+	if err != nil {
+		if s.devMode {
+			s.secLogger.Printf("sql.Open failed: %v", err)
+		} else {
+			s.secLogger.Printf("Failed to connect: connection error")
+		}
+		return fmt.Errorf("sql.Open failed: %w", err)
+	}
+	s.secLogger.Printf("sql.Open successful, testing connection...")
+
+	// Configure optimized connection pool
+	configurePool(db)
+
+	// Test connection with longer timeout
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	s.secLogger.Printf("Testing database connection with ping...")
+	if err := db.PingContext(ctx); err != nil {
+		s.secLogger.LogConnectionAttempt(false)
+		s.recordConnState(classicTarget, connStateDown, err)
+		if s.devMode {
+			s.secLogger.Printf("Database ping failed: %v", err)
+			trustCert := "false"
+			if strings.ToLower(os.Getenv("DEVELOPER_MODE")) == "true" {
+				trustCert = "true"
+			}
+
+			auth := strings.ToLower(os.Getenv("MSSQL_AUTH"))
+			if auth == "" {
+				auth = "sql"
+			}
+
+			if customConnStr := os.Getenv("MSSQL_CONNECTION_STRING"); customConnStr != "" {
+				s.secLogger.Printf("Using custom connection string format")
+			} else if auth == "integrated" || auth == "windows" {
+				s.secLogger.Printf("Using Windows Integrated Authentication (SSPI)")
+				s.secLogger.Printf("Troubleshooting tips for integrated auth:")
+				s.secLogger.Printf("  1. Ensure your Windows user has permission in SQL Server")
+				s.secLogger.Printf("  2. Check if SQL Server is configured to allow Windows Authentication")
+				s.secLogger.Printf("  3. Try using server='.' or server='localhost' or server='(local)'")
+				s.secLogger.Printf("  4. Verify TCP/IP or Named Pipes are enabled in SQL Server Configuration Manager")
+
+				// Get current Windows user
+				if u, err := osuser.Current(); err == nil {
+					s.secLogger.Printf("  Running as Windows user: %s\\%s", u.Username, u.Name)
+				}
+			} else {
+				encrypt := "true"
+				if strings.ToLower(os.Getenv("DEVELOPER_MODE")) == "true" {
+					if envEncrypt := os.Getenv("MSSQL_ENCRYPT"); envEncrypt != "" {
+						encrypt = strings.ToLower(envEncrypt)
+					} else {
+						encrypt = "false"
+					}
+				}
+				s.secLogger.Printf("Connection string format: server=SERVER;port=PORT;database=DB;user id=USER;password=***;encrypt=%s;trustservercertificate=%s;connection timeout=30;command timeout=30", encrypt, trustCert)
+			}
+		} else {
+			s.secLogger.Printf("Failed to ping database: connection test failed")
+		}
+		if cerr := db.Close(); cerr != nil {
+			s.secLogger.Printf("Error closing DB after failed ping: %v", cerr)
+		}
+		return fmt.Errorf("ping failed: %w", err)
+	}
+
+	s.secLogger.LogConnectionAttempt(true)
+	s.secLogger.Printf("Database connection established successfully")
+
+	// Update server with working database connection; a pool being replaced
+	// after a failed health check is closed once the new one is in place.
 	s.dbMu.Lock()
+	old := s.db
 	s.db = db
 	s.dbMu.Unlock()
-
-	s.activeAlias = aliasName
-
-	s.secLogger.Printf("Dynamic connection switched to alias '%s' (readOnly=%v)", aliasName, alias.ReadOnly)
+	if old != nil {
+		_ = old.Close()
+	}
 	return nil
 }
 
@@ -674,7 +916,11 @@ func hasExecutableRemainder(tail []rune) bool {
 
 func (s *MCPMSSQLServer) validateReadOnlyQuery(query string) error {
 	// Use effective config (per-alias if a dynamic connection is active)
-	effective := s.getEffectiveConfig()
+	return s.validateReadOnlyQueryFor(s.getEffectiveConfig(), query)
+}
+
+// validateReadOnlyQueryFor applies the read-only rules of an explicit posture.
+func (s *MCPMSSQLServer) validateReadOnlyQueryFor(effective serverConfig, query string) error {
 	if !effective.readOnly {
 		return nil // Read-only mode disabled for current context, allow all queries
 	}
@@ -835,7 +1081,15 @@ func isSafeReadOnlyAdminProcedure(query string) bool {
 
 // getWhitelistedTables returns the cached list of tables/views allowed for modification.
 func (s *MCPMSSQLServer) getWhitelistedTables() []string {
-	return s.config.whitelistTables
+	return s.getGlobalConfig().whitelistTables
+}
+
+// getGlobalConfig returns the server-wide configuration, ignoring any active
+// dynamic alias. It is guarded by dynamicMu because reloadConfig swaps it.
+func (s *MCPMSSQLServer) getGlobalConfig() serverConfig {
+	s.dynamicMu.RLock()
+	defer s.dynamicMu.RUnlock()
+	return s.config
 }
 
 // getEffectiveConfig returns the security configuration that should be applied right now.
@@ -845,45 +1099,135 @@ func (s *MCPMSSQLServer) getWhitelistedTables() []string {
 func (s *MCPMSSQLServer) getEffectiveConfig() serverConfig {
 	s.dynamicMu.RLock()
 	defer s.dynamicMu.RUnlock()
+	return s.effectiveConfigLocked(s.activeAlias)
+}
+
+// getEffectiveConfigFor returns the security posture of a specific dynamic
+// alias ("" = the global configuration). Tools that target an alias per call
+// use this instead of the active alias's posture.
+func (s *MCPMSSQLServer) getEffectiveConfigFor(alias string) serverConfig {
+	s.dynamicMu.RLock()
+	defer s.dynamicMu.RUnlock()
+	return s.effectiveConfigLocked(alias)
+}
 
-	if s.activeAlias != "" {
-		if alias, ok := s.dynamicAliases[s.activeAlias]; ok {
-			return serverConfig{
-				readOnly:        alias.ReadOnly,
-				whitelistTables: alias.WhitelistTables,
-				whitelistProcs:  s.config.whitelistProcs, // global for now
+// effectiveConfigLocked implements getEffectiveConfigFor; dynamicMu must be held.
+func (s *MCPMSSQLServer) effectiveConfigLocked(aliasName string) serverConfig {
+	if aliasName != "" {
+		if alias, ok := s.dynamicAliases[aliasName]; ok {
+			cfg := serverConfig{
+				readOnly:            alias.ReadOnly,
+				whitelistTables:     alias.WhitelistTables,
+				whitelistProcs:      s.config.whitelistProcs,
+				procedureTools:      s.config.procedureTools,
+				performanceInsights: s.config.performanceInsights,
+				allowKill:           alias.AllowKill,
+				maxAffectedRows:     alias.MaxAffectedRows,
+				requireWhere:        alias.RequireWhere,
+				undoJournal:         s.config.undoJournal,
 			}
+			if alias.WhitelistProcs != "" {
+				cfg.whitelistProcs = alias.WhitelistProcs
+			}
+			return cfg
 		}
 	}
 
-	// No active dynamic alias → use global config (with the safety guard already applied at startup)
+	// No dynamic alias → use global config (with the safety guard already applied at startup)
 	return s.config
 }
 
+// queryTarget is the connection and security posture a single tool call runs
+// against: either the active connection (classic or active dynamic alias) or
+// a dynamic alias named explicitly through the tool's 'alias' argument.
+type queryTarget struct {
+	alias  string // "" for the classic connection
+	db     *sql.DB
+	config serverConfig
+	tool   string // tool the statements run for, in policy decision logs
+	// tx is the explicit transaction statements run in (query_database on
+	// the alias of an open begin_transaction), nil otherwise.
+	tx *pinnedTx
+}
+
+// toolName returns the tool recorded on the target, or "internal" for
+// statements the server issues on its own (background refreshes).
+func (t *queryTarget) toolName() string {
+	if t.tool == "" {
+		return "internal"
+	}
+	return t.tool
+}
+
+// activeTarget returns the target for calls that do not name an alias.
+func (s *MCPMSSQLServer) activeTarget() *queryTarget {
+	s.dynamicMu.RLock()
+	defer s.dynamicMu.RUnlock()
+	return &queryTarget{
+		alias:  s.activeAlias,
+		db:     s.getDB(),
+		config: s.effectiveConfigLocked(s.activeAlias),
+	}
+}
+
+// resolveTarget picks the target for a tool call. An explicit 'alias'
+// argument (dynamic mode only) selects that alias's pool — opening it if
+// needed, without changing the active alias — and that alias's posture.
+// Otherwise the active connection is used.
+func (s *MCPMSSQLServer) resolveTarget(args map[string]interface{}) (*queryTarget, error) {
+	aliasArg, _ := args["alias"].(string)
+	aliasName := strings.ToUpper(strings.TrimSpace(aliasArg))
+	if aliasName == "" {
+		target := s.activeTarget()
+		if target.db == nil {
+			return nil, fmt.Errorf("database not connected. Call the get_database_info tool to see current configuration, diagnose the problem, and get specific troubleshooting steps")
+		}
+		return target, nil
+	}
+
+	if !s.isDynamic {
+		return nil, fmt.Errorf("the 'alias' argument is only available in dynamic multi-connection mode")
+	}
+	db, err := s.openAliasPool(aliasName)
+	if err != nil {
+		return nil, err
+	}
+	return &queryTarget{
+		alias:  aliasName,
+		db:     db,
+		config: s.getEffectiveConfigFor(aliasName),
+	}, nil
+}
+
 // requireConfirmationForModification is called when a writable alias attempts a modification.
 // It returns an error that tells the AI it must call confirm_operation first.
-func (s *MCPMSSQLServer) requireConfirmationForModification(operation string, tables []string) error {
+func (s *MCPMSSQLServer) requireConfirmationForModification(alias, operation string, tables []string) error {
 	s.confirmMu.Lock()
 	defer s.confirmMu.Unlock()
 
 	desc := fmt.Sprintf("%s on tables: %s", operation, strings.Join(tables, ", "))
+	if alias != "" {
+		desc += fmt.Sprintf(" (alias %s)", alias)
+	}
 
 	s.pendingConfirmation = &PendingConfirmation{
+		Alias:       alias,
 		Operation:   operation,
 		Tables:      tables,
 		Description: desc,
 		ExpiresAt:   time.Now().Add(90 * time.Second), // 90 seconds to confirm
 	}
 
-	return fmt.Errorf("CONFIRMATION REQUIRED: This is a modification operation (%s) on a writable dynamic alias.\n\nYou must first call the 'confirm_operation' tool with this exact description:\n\"%s\"\n\nOnly after receiving confirmation will the operation be allowed.", operation, desc) //nolint:staticcheck // multi-line user-facing message; capitalization + punctuation are intentional
+	return fmt.Errorf("%w: This is a modification operation (%s) on a writable dynamic alias.\n\nYou must first call the 'confirm_operation' tool with this exact description:\n\"%s\"\n\nOnly after receiving confirmation will the operation be allowed.", errConfirmationRequired, operation, desc) //nolint:staticcheck // multi-line user-facing message; capitalization + punctuation are intentional
 }
 
-// isOperationConfirmed checks if there is a valid pending confirmation that matches the current operation.
-func (s *MCPMSSQLServer) isOperationConfirmed(operation string, tables []string) bool {
+// isOperationConfirmed checks if there is a valid pending confirmation that matches the current
+// operation on the same alias.
+func (s *MCPMSSQLServer) isOperationConfirmed(alias, operation string, tables []string) bool {
 	s.confirmMu.Lock()
 	defer s.confirmMu.Unlock()
 
-	if s.pendingConfirmation == nil {
+	if s.pendingConfirmation == nil || !s.pendingConfirmation.Confirmed {
 		return false
 	}
 
@@ -892,19 +1236,51 @@ func (s *MCPMSSQLServer) isOperationConfirmed(operation string, tables []string)
 		return false
 	}
 
-	// Simple matching: same operation and at least one overlapping table
-	if strings.EqualFold(s.pendingConfirmation.Operation, operation) {
-		for _, t1 := range s.pendingConfirmation.Tables {
-			for _, t2 := range tables {
-				if t1 == t2 {
-					// Consume the confirmation after use (one-time use)
-					s.pendingConfirmation = nil
-					return true
-				}
-			}
+	// The confirmation must cover the operation: same alias, same operation,
+	// and every table it touches among the confirmed ones.
+	p := s.pendingConfirmation
+	if !strings.EqualFold(p.Alias, alias) || !strings.EqualFold(p.Operation, operation) || !confirmedTablesCover(p.Tables, tables) {
+		return false
+	}
+
+	// Consume the confirmation after use (one-time use)
+	s.pendingConfirmation = nil
+	return true
+}
+
+// confirmedTablesCover reports whether every table of an operation is among
+// the confirmed tables. An operation without tables is only covered by a
+// confirmation without tables.
+func confirmedTablesCover(confirmed, tables []string) bool {
+	if len(tables) == 0 {
+		return len(confirmed) == 0
+	}
+	for _, t := range tables {
+		if !slices.ContainsFunc(confirmed, func(c string) bool { return strings.EqualFold(c, t) }) {
+			return false
 		}
 	}
+	return true
+}
 
+// mentionsKey reports whether desc contains key as a whole: the characters
+// around it are not letters, digits or underscores, so "session 5" is not
+// found in "kill session 55" nor "orders" in "orders_archive".
+func mentionsKey(desc, key string) bool {
+	isWord := func(r rune) bool { return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) }
+	for from := 0; key != ""; {
+		i := strings.Index(desc[from:], key)
+		if i < 0 {
+			return false
+		}
+		start, end := from+i, from+i+len(key)
+		before, _ := utf8.DecodeLastRuneInString(desc[:start])
+		after, _ := utf8.DecodeRuneInString(desc[end:])
+		if (start == 0 || !isWord(before)) && (end == len(desc) || !isWord(after)) {
+			return true
+		}
+		from = start + 1
+	}
 	return false
 }
 
@@ -933,27 +1309,56 @@ func parseWhitelistTables(env string) []string {
 // classic for different DBs) can set MSSQL_IGNORE_LOCAL_ENV=true in their .mcp.json
 // "env" block to completely disable .env loading for that instance. This provides
 // strong isolation even if a .env file was accidentally left next to the executable.
-func loadDotEnvIfPresent(secLogger *SecurityLogger) {
+//
+// The returned map holds the keys actually applied from the file so that
+// reloadConfig can later tell file-sourced values apart from host-passed ones.
+func loadDotEnvIfPresent(secLogger *SecurityLogger) map[string]string {
+	applied := make(map[string]string)
+
+	envPath := dotEnvPath()
+	if envPath == "" {
+		return applied
+	}
+
+	// #nosec G304 -- envPath is derived from os.Executable(), not user input
+	data, err := os.ReadFile(envPath)
+	if err != nil {
+		return applied // no .env next to exe is normal/OK
+	}
+
+	for key, val := range parseDotEnv(data) {
+		if os.Getenv(key) == "" {
+			_ = os.Setenv(key, val)
+			applied[key] = val
+		}
+	}
+	if len(applied) > 0 {
+		secLogger.Printf("Loaded %d variables from .env next to executable (keys only; values redacted)", len(applied))
+	}
+	return applied
+}
+
+// dotEnvPath returns the path of the .env file next to the executable, or ""
+// when local .env loading is disabled via MSSQL_IGNORE_LOCAL_ENV or the
+// executable location cannot be determined.
+func dotEnvPath() string {
 	// Nuclear option for isolation: completely ignore any .env next to the exe.
 	// Intended for classic servers configured purely via Claude Desktop .mcp.json env.
 	if v := strings.ToLower(strings.TrimSpace(os.Getenv("MSSQL_IGNORE_LOCAL_ENV"))); v == "true" || v == "1" || v == "yes" {
-		return
+		return ""
 	}
 
 	exePath, err := os.Executable()
 	if err != nil {
-		return // cannot determine location, skip .env (rely on passed env)
-	}
-	exeDir := filepath.Dir(exePath)
-	envPath := filepath.Join(exeDir, ".env")
-
-	// #nosec G304 -- envPath is derived from os.Executable(), not user input
-	data, err := os.ReadFile(envPath)
-	if err != nil {
-		return // no .env next to exe is normal/OK
+		return "" // cannot determine location, skip .env (rely on passed env)
 	}
+	return filepath.Join(filepath.Dir(exePath), ".env")
+}
 
-	loaded := 0
+// parseDotEnv parses KEY=VALUE lines from a .env file. Blank lines and lines
+// starting with '#' or '//' are ignored; surrounding quotes are stripped.
+func parseDotEnv(data []byte) map[string]string {
+	values := make(map[string]string)
 	for _, line := range strings.Split(string(data), "\n") {
 		line = strings.TrimSpace(line)
 		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
@@ -963,19 +1368,16 @@ func loadDotEnvIfPresent(secLogger *SecurityLogger) {
 			key := strings.TrimSpace(line[:idx])
 			val := strings.TrimSpace(line[idx+1:])
 			// Remove surrounding quotes if present
-			if (strings.HasPrefix(val, `"`) && strings.HasSuffix(val, `"`)) ||
-				(strings.HasPrefix(val, `'`) && strings.HasSuffix(val, `'`)) {
+			if len(val) >= 2 && ((strings.HasPrefix(val, `"`) && strings.HasSuffix(val, `"`)) ||
+				(strings.HasPrefix(val, `'`) && strings.HasSuffix(val, `'`))) {
 				val = val[1 : len(val)-1]
 			}
-			if key != "" && os.Getenv(key) == "" {
-				_ = os.Setenv(key, val)
-				loaded++
+			if key != "" {
+				values[key] = val
 			}
 		}
 	}
-	if loaded > 0 {
-		secLogger.Printf("Loaded %d variables from .env next to executable (keys only; values redacted)", loaded)
-	}
+	return values
 }
 
 // hasDynamicAliases returns true if any MSSQL_DYNAMIC_* variables are present in env.
@@ -1033,18 +1435,34 @@ func isDynamicMode() bool {
 // and builds a map of DynamicAlias with their individual security posture.
 // This enables the secure "one application - multiple related databases" pattern.
 func loadDynamicAliases(secLogger *SecurityLogger) map[string]DynamicAlias {
+	return loadDynamicAliasesFromEnv(environMap(), secLogger)
+}
+
+// startupDynamicAliases loads the aliases at startup and validates them like
+// reloadConfig does. An invalid configuration is rejected as a whole: the
+// server starts without aliases, and a corrected .env is picked up by the next
+// reload.
+func startupDynamicAliases(env map[string]string, devMode bool, secLogger *SecurityLogger) map[string]DynamicAlias {
+	aliases := loadDynamicAliasesFromEnv(env, secLogger)
+	if err := validateDynamicAliases(aliases, devMode); err != nil {
+		secLogger.Printf("ERROR: dynamic alias configuration rejected, no aliases loaded: %v", err)
+		return make(map[string]DynamicAlias)
+	}
+	return aliases
+}
+
+// loadDynamicAliasesFromEnv is loadDynamicAliases over an explicit environment
+// snapshot, so reloadConfig can build and validate a candidate configuration
+// before touching the process environment.
+func loadDynamicAliasesFromEnv(env map[string]string, secLogger *SecurityLogger) map[string]DynamicAlias {
 	aliases := make(map[string]DynamicAlias)
 	prefix := "MSSQL_DYNAMIC_"
 
 	// Collect all relevant env vars
 	envVars := make(map[string]string)
-	for _, env := range os.Environ() {
-		if idx := strings.Index(env, "="); idx > 0 {
-			key := env[:idx]
-			val := env[idx+1:]
-			if strings.HasPrefix(key, prefix) {
-				envVars[key] = val
-			}
+	for key, val := range env {
+		if strings.HasPrefix(key, prefix) {
+			envVars[key] = val
 		}
 	}
 
@@ -1115,6 +1533,24 @@ func loadDynamicAliases(secLogger *SecurityLogger) map[string]DynamicAlias {
 		}
 		// If not set and ReadOnly=false → whitelist remains empty = no modifications allowed (very safe)
 
+		// Per-alias procedure whitelist; falls back to MSSQL_WHITELIST_PROCEDURES.
+		if wl := envVars[prefix+alias+"_WHITELIST_PROCEDURES"]; strings.TrimSpace(wl) != "" {
+			a.WhitelistProcs = strings.TrimSpace(wl)
+		}
+
+		// KILL is never available unless the alias opts in explicitly.
+		a.AllowKill = strings.ToLower(strings.TrimSpace(envVars[prefix+alias+"_ALLOW_KILL"])) == "true"
+
+		// DML guards are opt-in; an invalid limit is reported and ignored.
+		if v := strings.TrimSpace(envVars[prefix+alias+"_MAX_AFFECTED_ROWS"]); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				a.MaxAffectedRows = n
+			} else {
+				secLogger.Printf("Ignoring invalid %s_MAX_AFFECTED_ROWS for alias '%s': must be a positive integer", prefix+alias, alias)
+			}
+		}
+		a.RequireWhere = strings.ToLower(strings.TrimSpace(envVars[prefix+alias+"_REQUIRE_WHERE"])) == "true"
+
 		aliases[alias] = a
 
 		// Security logging (never log credentials)
@@ -1291,7 +1727,12 @@ func (s *MCPMSSQLServer) extractOperation(query string) string {
 // validateTablePermissions validates that all tables in a modify operation are whitelisted
 func (s *MCPMSSQLServer) validateTablePermissions(query string) error {
 	// Use effective config (respects active dynamic alias posture)
-	effective := s.getEffectiveConfig()
+	return s.validateTablePermissionsFor(s.activeTarget(), query)
+}
+
+// validateTablePermissionsFor applies the whitelist rules of a specific target.
+func (s *MCPMSSQLServer) validateTablePermissionsFor(target *queryTarget, query string) error {
+	effective := target.config
 	if !effective.readOnly {
 		return nil // Whitelist mode disabled for current context, allow all operations
 	}
@@ -1317,17 +1758,6 @@ func (s *MCPMSSQLServer) validateTablePermissions(query string) error {
 	// Extract ALL tables referenced in the query
 	tablesInQuery := s.extractAllTablesFromQuery(query)
 
-	// === CONFIRMATION REQUIREMENT FOR WRITABLE DYNAMIC ALIASES ===
-	// We only enforce explicit confirmation when using dynamic mode with a writable alias.
-	// This protects the high-risk "multiple databases" scenario without breaking classic single-connection usage.
-	s.dynamicMu.RLock()
-	hasActiveWritableAlias := s.activeAlias != "" && !effective.readOnly
-	s.dynamicMu.RUnlock()
-
-	if hasActiveWritableAlias && !s.isOperationConfirmed(operation, tablesInQuery) {
-		return s.requireConfirmationForModification(operation, tablesInQuery)
-	}
-
 	s.secLogger.Printf("Permission check - Operation: %s, Tables found: %v, Whitelist: %v",
 		operation, tablesInQuery, whitelist)
 
@@ -1373,51 +1803,59 @@ func (s *MCPMSSQLServer) validateTablePermissions(query string) error {
 // maxQueryRows limits the number of rows returned by any query to prevent token overflow.
 const maxQueryRows = 500
 
-// executeSecureQuery runs a validated, prepared query and returns up to maxQueryRows rows.
-// If the result is truncated, the last element contains a "_truncated" warning key.
-func (s *MCPMSSQLServer) executeSecureQuery(ctx context.Context, query string, args ...interface{}) ([]map[string]interface{}, error) {
-	db := s.getDB()
+// openSecureRowsOn validates query against the target's policy, prepares it
+// and returns the open rows. Tools that stream results instead of collecting
+// them (compare) use it directly. The caller must call the returned func.
+func (s *MCPMSSQLServer) openSecureRowsOn(ctx context.Context, target *queryTarget, query string, args ...interface{}) (*sql.Rows, func(), error) {
+	db := target.db
 	if db == nil {
-		return nil, fmt.Errorf("database not connected")
+		return nil, nil, fmt.Errorf("database not connected")
 	}
 
-	if err := s.validateBasicInput(query); err != nil {
-		return nil, err
-	}
-
-	// Validate read-only restrictions
-	if err := s.validateReadOnlyQuery(query); err != nil {
-		s.secLogger.Printf("Read-only violation blocked: %s", err)
-		return nil, err
+	if err := s.enforcePolicy(policyRequest{target: target, query: query}); err != nil {
+		return nil, nil, err
 	}
 
-	// Validate granular table permissions (whitelist)
-	if err := s.validateTablePermissions(query); err != nil {
-		s.secLogger.Printf("Permission violation blocked: %s", err)
-		return nil, err
+	prepare := db.PrepareContext
+	if target.tx != nil {
+		prepare = target.tx.sqlTx.PrepareContext
+		target.tx.record(s.statementOperation(query), query, len(args))
 	}
-
-	stmt, err := db.PrepareContext(ctx, query)
+	stmt, err := prepare(ctx, query)
 	if err != nil {
 		if s.devMode {
 			s.secLogger.Printf("Failed to prepare statement: %v", err)
-			return nil, fmt.Errorf("query preparation failed: %v", err)
+			return nil, nil, fmt.Errorf("query preparation failed: %v", err)
 		}
 		s.secLogger.Printf("Failed to prepare statement: query preparation error")
-		return nil, fmt.Errorf("query preparation failed: check SQL syntax, table/column names, and permissions. Use explore tool to verify table exists")
+		return nil, nil, fmt.Errorf("query preparation failed: check SQL syntax, table/column names, and permissions. Use explore tool to verify table exists")
 	}
-	defer stmt.Close()
 
 	rows, err := stmt.QueryContext(ctx, args...)
 	if err != nil {
-		if s.devMode {
-			s.secLogger.Printf("Failed to execute query: %v", err)
-			return nil, fmt.Errorf("query execution failed: %v", err)
-		}
-		s.secLogger.Printf("Failed to execute query: execution error")
-		return nil, fmt.Errorf("query execution failed: the query syntax is valid but execution was rejected by the server. Check permissions and data constraints")
+		_ = stmt.Close()
+		return nil, nil, s.queryFailed(err)
+	}
+	return rows, func() {
+		_ = rows.Close()
+		_ = stmt.Close()
+	}, nil
+}
+
+// executeSecureQuery runs a validated, prepared query and returns up to maxQueryRows rows.
+// If the result is truncated, the last element contains a "_truncated" warning key.
+func (s *MCPMSSQLServer) executeSecureQuery(ctx context.Context, query string, args ...interface{}) ([]map[string]interface{}, error) {
+	return s.executeSecureQueryOn(ctx, s.activeTarget(), query, args...)
+}
+
+// executeSecureQueryOn is executeSecureQuery against an explicit target, so
+// the pool and the security posture always come from the same alias.
+func (s *MCPMSSQLServer) executeSecureQueryOn(ctx context.Context, target *queryTarget, query string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, closeRows, err := s.openSecureRowsOn(ctx, target, query, args...)
+	if err != nil {
+		return nil, err
 	}
-	defer rows.Close()
+	defer closeRows()
 
 	columns, err := rows.Columns()
 	if err != nil {
@@ -1589,7 +2027,10 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 
 			if activeAlias != "" {
 				// Dynamic mode - show the active alias and its specific posture
-				if alias, ok := s.dynamicAliases[activeAlias]; ok {
+				s.dynamicMu.RLock()
+				alias, ok := s.dynamicAliases[activeAlias]
+				s.dynamicMu.RUnlock()
+				if ok {
 					effective := s.getEffectiveConfig()
 					fmt.Fprintf(&info, "Active Dynamic Alias: %s\n", activeAlias)
 					fmt.Fprintf(&info, "  Server/Database: %s / %s\n", alias.Server, alias.Database)
@@ -1622,8 +2063,9 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 			}
 
 			// Show read-only status and whitelist (cached config)
-			whitelist := s.getWhitelistedTables()
-			if s.config.readOnly {
+			globalCfg := s.getGlobalConfig()
+			whitelist := globalCfg.whitelistTables
+			if globalCfg.readOnly {
 				if len(whitelist) > 0 {
 					info.WriteString("Access Mode: READ-ONLY with whitelist exceptions\n")
 					info.WriteString("Whitelisted Tables: " + strings.Join(whitelist, ", ") + "\n")
@@ -1643,6 +2085,8 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 			}
 		}
 
+		info.WriteString(s.formatConnStateHistory(10))
+
 		return &MCPResponse{
 			JSONRPC: "2.0",
 			ID:      id,
@@ -1657,7 +2101,8 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 		}
 
 	case "query_database":
-		if s.getDB() == nil {
+		target, err := s.resolveTarget(params.Arguments)
+		if err != nil {
 			return &MCPResponse{
 				JSONRPC: "2.0",
 				ID:      id,
@@ -1665,13 +2110,14 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 					Content: []ContentItem{
 						{
 							Type: "text",
-							Text: "Error: Database not connected. Call the get_database_info tool to see current configuration, diagnose the problem, and get specific troubleshooting steps.",
+							Text: fmt.Sprintf("Error: %v", err),
 						},
 					},
 					IsError: true,
 				},
 			}
 		}
+		target.tool = params.Name
 
 		query, ok := params.Arguments["query"].(string)
 		if !ok {
@@ -1690,10 +2136,31 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 			}
 		}
 
+		// Parameter values are bound by the driver; the policy pipeline
+		// only ever sees the parameterized text.
+		queryArgs, err := parseQueryParams(params.Arguments["params"])
+		if err != nil {
+			return &MCPResponse{
+				JSONRPC: "2.0",
+				ID:      id,
+				Result: CallToolResult{
+					Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Error: %v", err)}},
+					IsError: true,
+				},
+			}
+		}
+
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
-		results, err := s.executeSecureQuery(ctx, query)
+		var results []map[string]interface{}
+		prefix := "Query executed successfully"
+		if tx := s.transactionFor(target); tx != nil {
+			results, err = s.runInTransaction(ctx, tx, target, query, queryArgs)
+			prefix = fmt.Sprintf("Query executed in transaction %d (not committed yet)", tx.id)
+		} else {
+			results, err = s.executeCapturedQueryOn(ctx, target, query, queryArgs...)
+		}
 		if err != nil {
 			return &MCPResponse{
 				JSONRPC: "2.0",
@@ -1735,14 +2202,15 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 				Content: []ContentItem{
 					{
 						Type: "text",
-						Text: fmt.Sprintf("Query executed successfully. Results:\n%s", string(resultBytes)),
+						Text: fmt.Sprintf("%s. Results:\n%s", prefix, string(resultBytes)),
 					},
 				},
 			},
 		}
 
 	case "explore":
-		if s.getDB() == nil {
+		target, err := s.resolveTarget(params.Arguments)
+		if err != nil {
 			return &MCPResponse{
 				JSONRPC: "2.0",
 				ID:      id,
@@ -1750,13 +2218,14 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 					Content: []ContentItem{
 						{
 							Type: "text",
-							Text: "Error: Database not connected. Call the get_database_info tool to see current configuration, diagnose the problem, and get specific troubleshooting steps.",
+							Text: fmt.Sprintf("Error: %v", err),
 						},
 					},
 					IsError: true,
 				},
 			}
 		}
+		target.tool = params.Name
 
 		exploreType := "tables"
 		if t, ok := params.Arguments["type"].(string); ok && t != "" {
@@ -1767,7 +2236,6 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 		defer cancel()
 
 		var results []map[string]interface{}
-		var err error
 		var label string
 
 		switch exploreType {
@@ -1783,7 +2251,7 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 				WHERE database_id > 4
 				ORDER BY name
 			`
-			results, err = s.executeSecureQuery(ctx, query)
+			results, err = s.executeSecureQueryOn(ctx, target, query)
 
 		case "procedures":
 			label = "Stored procedures found"
@@ -1800,7 +2268,7 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 					WHERE SCHEMA_NAME(p.schema_id) = @p1 AND p.name LIKE @p2
 					ORDER BY schema_name, procedure_name
 				`
-				results, err = s.executeSecureQuery(ctx, query, schemaFilter, "%"+filterVal+"%")
+				results, err = s.executeSecureQueryOn(ctx, target, query, schemaFilter, "%"+filterVal+"%")
 			} else if schemaFilter != "" {
 				query := `
 					SELECT
@@ -1812,7 +2280,7 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 					WHERE SCHEMA_NAME(p.schema_id) = @p1
 					ORDER BY schema_name, procedure_name
 				`
-				results, err = s.executeSecureQuery(ctx, query, schemaFilter)
+				results, err = s.executeSecureQueryOn(ctx, target, query, schemaFilter)
 			} else if filterVal != "" {
 				query := `
 					SELECT
@@ -1824,7 +2292,7 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 					WHERE p.name LIKE @p1
 					ORDER BY schema_name, procedure_name
 				`
-				results, err = s.executeSecureQuery(ctx, query, "%"+filterVal+"%")
+				results, err = s.executeSecureQueryOn(ctx, target, query, "%"+filterVal+"%")
 			} else {
 				query := `
 					SELECT
@@ -1835,7 +2303,7 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 					FROM sys.procedures p
 					ORDER BY schema_name, procedure_name
 				`
-				results, err = s.executeSecureQuery(ctx, query)
+				results, err = s.executeSecureQueryOn(ctx, target, query)
 			}
 
 		case "search":
@@ -1867,7 +2335,7 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 					WHERE m.definition LIKE @p1
 					ORDER BY o.type_desc, o.name
 				`
-				results, err = s.executeSecureQuery(ctx, query, likePattern)
+				results, err = s.executeSecureQueryOn(ctx, target, query, likePattern)
 			} else {
 				label = fmt.Sprintf("Objects matching '%s' in name", pattern)
 				query := `
@@ -1882,7 +2350,7 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 					  AND o.type IN ('U','V','P','FN','IF','TF')
 					ORDER BY o.type_desc, o.name
 				`
-				results, err = s.executeSecureQuery(ctx, query, likePattern)
+				results, err = s.executeSecureQueryOn(ctx, target, query, likePattern)
 			}
 
 		case "views":
@@ -1890,39 +2358,18 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 			viewFilter, _ := params.Arguments["filter"].(string)
 			if viewFilter != "" {
 				query := "SELECT v.TABLE_SCHEMA AS schema_name, v.TABLE_NAME AS view_name, v.CHECK_OPTION AS check_option, v.IS_UPDATABLE AS is_updatable, LEFT(v.VIEW_DEFINITION, 300) AS definition_preview FROM INFORMATION_SCHEMA.VIEWS v WHERE v.TABLE_NAME LIKE @p1 ORDER BY v.TABLE_SCHEMA, v.TABLE_NAME"
-				results, err = s.executeSecureQuery(ctx, query, "%"+viewFilter+"%")
+				results, err = s.executeSecureQueryOn(ctx, target, query, "%"+viewFilter+"%")
 			} else {
 				query := "SELECT v.TABLE_SCHEMA AS schema_name, v.TABLE_NAME AS view_name, v.CHECK_OPTION AS check_option, v.IS_UPDATABLE AS is_updatable, LEFT(v.VIEW_DEFINITION, 300) AS definition_preview FROM INFORMATION_SCHEMA.VIEWS v ORDER BY v.TABLE_SCHEMA, v.TABLE_NAME"
-				results, err = s.executeSecureQuery(ctx, query)
+				results, err = s.executeSecureQueryOn(ctx, target, query)
 			}
 
 		default: // "tables"
-			label = "Tables and views found"
-			if filterVal, ok := params.Arguments["filter"].(string); ok && filterVal != "" {
-				filterPattern := "%" + filterVal + "%"
-				query := `
-					SELECT
-						TABLE_SCHEMA as schema_name,
-						TABLE_NAME as table_name,
-						TABLE_TYPE as table_type
-					FROM INFORMATION_SCHEMA.TABLES
-					WHERE TABLE_TYPE IN ('BASE TABLE', 'VIEW')
-					  AND TABLE_NAME LIKE @p1
-					ORDER BY TABLE_SCHEMA, TABLE_NAME
-				`
-				results, err = s.executeSecureQuery(ctx, query, filterPattern)
-			} else {
-				query := `
-					SELECT
-						TABLE_SCHEMA as schema_name,
-						TABLE_NAME as table_name,
-						TABLE_TYPE as table_type
-					FROM INFORMATION_SCHEMA.TABLES
-					WHERE TABLE_TYPE IN ('BASE TABLE', 'VIEW')
-					ORDER BY TABLE_SCHEMA, TABLE_NAME
-				`
-				results, err = s.executeSecureQuery(ctx, query)
-			}
+			filterVal, _ := params.Arguments["filter"].(string)
+			sortBy, _ := params.Arguments["sort_by"].(string)
+			var note string
+			results, note, err = s.exploreTables(ctx, target, filterVal, sortBy)
+			label = fmt.Sprintf("Tables and views found (%s)", note)
 		}
 
 		if err != nil {
@@ -1972,7 +2419,11 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 		}
 
 	case "execute_procedure":
-		if s.getDB() == nil {
+		return s.handleExecuteProcedure(id, params.Arguments)
+
+	case "inspect":
+		target, err := s.resolveTarget(params.Arguments)
+		if err != nil {
 			return &MCPResponse{
 				JSONRPC: "2.0",
 				ID:      id,
@@ -1980,224 +2431,23 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 					Content: []ContentItem{
 						{
 							Type: "text",
-							Text: "Error: Database not connected. Call the get_database_info tool to see current configuration, diagnose the problem, and get specific troubleshooting steps.",
+							Text: fmt.Sprintf("Error: %v", err),
 						},
 					},
 					IsError: true,
 				},
 			}
 		}
+		target.tool = params.Name
 
-		procName, ok := params.Arguments["procedure_name"].(string)
-		if !ok || procName == "" {
+		tableName, ok := params.Arguments["table_name"].(string)
+		if !ok || tableName == "" {
 			return &MCPResponse{
 				JSONRPC: "2.0",
 				ID:      id,
 				Result: CallToolResult{
 					Content: []ContentItem{
-						{
-							Type: "text",
-							Text: "Error: Missing or invalid 'procedure_name' parameter",
-						},
-					},
-					IsError: true,
-				},
-			}
-		}
-
-		// Check whitelist (cached at startup)
-		whitelistEnv := s.config.whitelistProcs
-		if whitelistEnv == "" {
-			return &MCPResponse{
-				JSONRPC: "2.0",
-				ID:      id,
-				Result: CallToolResult{
-					Content: []ContentItem{
-						{
-							Type: "text",
-							Text: "Error: No stored procedures are whitelisted. Set MSSQL_WHITELIST_PROCEDURES environment variable.",
-						},
-					},
-					IsError: true,
-				},
-			}
-		}
-
-		allowedProcs := strings.Split(whitelistEnv, ",")
-		procAllowed := false
-		procNameLower := strings.ToLower(strings.TrimSpace(procName))
-		for _, allowed := range allowedProcs {
-			if strings.ToLower(strings.TrimSpace(allowed)) == procNameLower {
-				procAllowed = true
-				break
-			}
-		}
-
-		if !procAllowed {
-			return &MCPResponse{
-				JSONRPC: "2.0",
-				ID:      id,
-				Result: CallToolResult{
-					Content: []ContentItem{
-						{
-							Type: "text",
-							Text: fmt.Sprintf("Error: Stored procedure '%s' is not in the whitelist. Allowed: %s", procName, whitelistEnv),
-						},
-					},
-					IsError: true,
-				},
-			}
-		}
-
-		// Validate procedure name contains only safe characters
-		if err := s.validateProcedureName(procName); err != nil {
-			s.secLogger.Printf("Rejected unsafe procedure name: %s", procName)
-			return &MCPResponse{
-				JSONRPC: "2.0",
-				ID:      id,
-				Result: CallToolResult{
-					Content: []ContentItem{
-						{
-							Type: "text",
-							Text: fmt.Sprintf("Error: %v", err),
-						},
-					},
-					IsError: true,
-				},
-			}
-		}
-
-		// Parse parameters if provided
-		var procParams map[string]interface{}
-		if paramsJSON, ok := params.Arguments["parameters"].(string); ok && paramsJSON != "" {
-			if err := json.Unmarshal([]byte(paramsJSON), &procParams); err != nil {
-				return &MCPResponse{
-					JSONRPC: "2.0",
-					ID:      id,
-					Result: CallToolResult{
-						Content: []ContentItem{
-							{
-								Type: "text",
-								Text: fmt.Sprintf("Error: Invalid JSON in parameters: %v", err),
-							},
-						},
-						IsError: true,
-					},
-				}
-			}
-		}
-
-		// Build EXEC statement with parameters
-		var queryBuilder strings.Builder
-		queryBuilder.WriteString("EXEC ")
-		queryBuilder.WriteString(procName)
-
-		var args []interface{}
-		if len(procParams) > 0 {
-			queryBuilder.WriteString(" ")
-			paramStrings := make([]string, 0, len(procParams))
-			i := 1
-			for paramName, paramValue := range procParams {
-				// Parameter NAMES are interpolated into the EXEC text (only the
-				// values are bound as @pN placeholders), so an unvalidated name
-				// is a SQL injection vector. Reject anything that is not a plain
-				// T-SQL identifier (optionally '@'-prefixed).
-				normalizedName := strings.TrimPrefix(paramName, "@")
-				if !validParamNamePattern.MatchString(paramName) {
-					s.secLogger.Printf("Rejected unsafe stored-procedure parameter name: %q", paramName)
-					return &MCPResponse{
-						JSONRPC: "2.0",
-						ID:      id,
-						Result: CallToolResult{
-							Content: []ContentItem{
-								{Type: "text", Text: fmt.Sprintf("Error: invalid parameter name '%s' (only letters, digits and underscore are allowed, optionally prefixed with '@')", paramName)},
-							},
-							IsError: true,
-						},
-					}
-				}
-				paramStrings = append(paramStrings, fmt.Sprintf("@%s = @p%d", normalizedName, i))
-				args = append(args, paramValue)
-				i++
-			}
-			queryBuilder.WriteString(strings.Join(paramStrings, ", "))
-		}
-
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-
-		results, err := s.executeSecureQuery(ctx, queryBuilder.String(), args...)
-		if err != nil {
-			return &MCPResponse{
-				JSONRPC: "2.0",
-				ID:      id,
-				Result: CallToolResult{
-					Content: []ContentItem{
-						{
-							Type: "text",
-							Text: fmt.Sprintf("Error executing procedure '%s': %v", procName, err),
-						},
-					},
-					IsError: true,
-				},
-			}
-		}
-
-		resultBytes, err := json.MarshalIndent(results, "", "  ")
-		if err != nil {
-			return &MCPResponse{
-				JSONRPC: "2.0",
-				ID:      id,
-				Result: CallToolResult{
-					Content: []ContentItem{
-						{
-							Type: "text",
-							Text: fmt.Sprintf("Error formatting results: %v", err),
-						},
-					},
-					IsError: true,
-				},
-			}
-		}
-
-		return &MCPResponse{
-			JSONRPC: "2.0",
-			ID:      id,
-			Result: CallToolResult{
-				Content: []ContentItem{
-					{
-						Type: "text",
-						Text: fmt.Sprintf("Procedure '%s' executed successfully:\n%s", procName, string(resultBytes)),
-					},
-				},
-			},
-		}
-
-	case "inspect":
-		if s.getDB() == nil {
-			return &MCPResponse{
-				JSONRPC: "2.0",
-				ID:      id,
-				Result: CallToolResult{
-					Content: []ContentItem{
-						{
-							Type: "text",
-							Text: "Error: Database not connected. Call the get_database_info tool to see current configuration, diagnose the problem, and get specific troubleshooting steps.",
-						},
-					},
-					IsError: true,
-				},
-			}
-		}
-
-		tableName, ok := params.Arguments["table_name"].(string)
-		if !ok || tableName == "" {
-			return &MCPResponse{
-				JSONRPC: "2.0",
-				ID:      id,
-				Result: CallToolResult{
-					Content: []ContentItem{
-						{Type: "text", Text: "Error: Missing or invalid 'table_name' parameter"},
+						{Type: "text", Text: "Error: Missing or invalid 'table_name' parameter"},
 					},
 					IsError: true,
 				},
@@ -2274,19 +2524,19 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 		`
 
 		if detail == "all" {
-			colResults, err := s.executeSecureQuery(ctx, columnsQuery, schemaName, tableName)
+			colResults, err := s.executeSecureQueryOn(ctx, target, columnsQuery, schemaName, tableName)
 			if err != nil {
 				return &MCPResponse{JSONRPC: "2.0", ID: id, Result: CallToolResult{
 					Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Error getting columns: %v", err)}}, IsError: true,
 				}}
 			}
-			idxResults, err := s.executeSecureQuery(ctx, indexesQuery, tableName, schemaName)
+			idxResults, err := s.executeSecureQueryOn(ctx, target, indexesQuery, tableName, schemaName)
 			if err != nil {
 				return &MCPResponse{JSONRPC: "2.0", ID: id, Result: CallToolResult{
 					Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Error getting indexes: %v", err)}}, IsError: true,
 				}}
 			}
-			fkResults, err := s.executeSecureQuery(ctx, fkQuery, tableName, schemaName)
+			fkResults, err := s.executeSecureQueryOn(ctx, target, fkQuery, tableName, schemaName)
 			if err != nil {
 				return &MCPResponse{JSONRPC: "2.0", ID: id, Result: CallToolResult{
 					Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Error getting foreign keys: %v", err)}}, IsError: true,
@@ -2305,12 +2555,14 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 				  AND (sed.referenced_schema_name = @p2 OR sed.referenced_schema_name IS NULL)
 				ORDER BY o.type_desc, referencing_schema, referencing_object
 			`
-			depsResults, _ := s.executeSecureQuery(ctx, depsAllQuery, tableName, schemaName) // #nosec G104 - dependencies query is optional, errors handled gracefully
+			depsResults, _ := s.executeSecureQueryOn(ctx, target, depsAllQuery, tableName, schemaName)             // #nosec G104 - dependencies query is optional, errors handled gracefully
+			storageResults, _ := s.executeSecureQueryOn(ctx, target, partitionStorageQuery, schemaName, tableName) // #nosec G104 - storage needs VIEW DATABASE STATE, errors handled gracefully
 			combined := map[string]interface{}{
 				"columns":      colResults,
 				"indexes":      idxResults,
 				"foreign_keys": fkResults,
 				"dependencies": depsResults,
+				"storage":      storageResults,
 			}
 			resultBytes, err := json.MarshalIndent(combined, "", "  ")
 			if err != nil {
@@ -2333,16 +2585,43 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 		}
 
 		var results []map[string]interface{}
-		var err error
 		var label string
 
 		switch detail {
 		case "indexes":
 			label = fmt.Sprintf("Indexes for '%s.%s'", schemaName, tableName)
-			results, err = s.executeSecureQuery(ctx, indexesQuery, tableName, schemaName)
+			results, err = s.executeSecureQueryOn(ctx, target, indexesQuery, tableName, schemaName)
+		case "health":
+			report, err := s.indexHealthOn(ctx, target, schemaName, tableName)
+			if err != nil {
+				return &MCPResponse{JSONRPC: "2.0", ID: id, Result: CallToolResult{
+					Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Error in inspect: %v", err)}}, IsError: true,
+				}}
+			}
+			resultBytes, err := json.MarshalIndent(report, "", "  ")
+			if err != nil {
+				return &MCPResponse{JSONRPC: "2.0", ID: id, Result: CallToolResult{
+					Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Error formatting results: %v", err)}}, IsError: true,
+				}}
+			}
+			return &MCPResponse{
+				JSONRPC: "2.0",
+				ID:      id,
+				Result: CallToolResult{
+					Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Index and statistics health for '%s.%s' (%s):\n%s", schemaName, tableName, report.Summary, string(resultBytes))}},
+				},
+			}
+		case "storage":
+			label = fmt.Sprintf("Storage per index and partition for '%s.%s' (row counts are approximate)", schemaName, tableName)
+			results, err = s.executeSecureQueryOn(ctx, target, partitionStorageQuery, schemaName, tableName)
+			if err == nil && len(results) == 0 {
+				return &MCPResponse{JSONRPC: "2.0", ID: id, Result: CallToolResult{
+					Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Table '%s.%s' not found", schemaName, tableName)}}, IsError: true,
+				}}
+			}
 		case "foreign_keys":
 			label = fmt.Sprintf("Foreign keys for '%s.%s'", schemaName, tableName)
-			results, err = s.executeSecureQuery(ctx, fkQuery, tableName, schemaName)
+			results, err = s.executeSecureQueryOn(ctx, target, fkQuery, tableName, schemaName)
 		case "dependencies":
 			label = fmt.Sprintf("Objects that depend on '%s.%s'", schemaName, tableName)
 			depsQuery := `
@@ -2358,10 +2637,10 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 				  AND (sed.referenced_schema_name = @p2 OR sed.referenced_schema_name IS NULL)
 				ORDER BY o.type_desc, referencing_schema, referencing_object
 			`
-			results, err = s.executeSecureQuery(ctx, depsQuery, tableName, schemaName)
+			results, err = s.executeSecureQueryOn(ctx, target, depsQuery, tableName, schemaName)
 		default: // "columns"
 			label = fmt.Sprintf("Table structure for '%s'", tableName)
-			results, err = s.executeSecureQuery(ctx, columnsQuery, schemaName, tableName)
+			results, err = s.executeSecureQueryOn(ctx, target, columnsQuery, schemaName, tableName)
 			if err == nil && len(results) == 0 {
 				return &MCPResponse{
 					JSONRPC: "2.0",
@@ -2423,16 +2702,18 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 		}
 
 	case "explain_query":
-		if s.getDB() == nil {
+		target, err := s.resolveTarget(params.Arguments)
+		if err != nil {
 			return &MCPResponse{
 				JSONRPC: "2.0",
 				ID:      id,
 				Result: CallToolResult{
-					Content: []ContentItem{{Type: "text", Text: "Error: Database not connected. Call the get_database_info tool to see current configuration, diagnose the problem, and get specific troubleshooting steps."}},
+					Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Error: %v", err)}},
 					IsError: true,
 				},
 			}
 		}
+		target.tool = params.Name
 
 		query, ok := params.Arguments["query"].(string)
 		if !ok || strings.TrimSpace(query) == "" {
@@ -2446,23 +2727,46 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 			}
 		}
 
-		// Only allow SELECT queries for safety (always enforced, regardless of MSSQL_READ_ONLY)
-		if op := s.extractOperation(query); op != "SELECT" {
+		mode, _ := params.Arguments["mode"].(string)
+		mode = strings.ToLower(strings.TrimSpace(mode))
+		policy := policySelectOnly
+		switch mode {
+		case "", planModeText, planModeEstimated:
+		case planModeActual:
+			policy = policyStrictRead
+		default:
+			return &MCPResponse{
+				JSONRPC: "2.0",
+				ID:      id,
+				Result: CallToolResult{
+					Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Error: invalid mode '%s' (use text, estimated or actual)", mode)}},
+					IsError: true,
+				},
+			}
+		}
+
+		// Only a single SELECT, and the same read-only and whitelist policy
+		// as query_database (always enforced, regardless of MSSQL_READ_ONLY)
+		if err := s.enforcePolicy(policyRequest{target: target, query: query, mode: policy}); err != nil {
 			return &MCPResponse{
 				JSONRPC: "2.0",
 				ID:      id,
 				Result: CallToolResult{
-					Content: []ContentItem{{Type: "text", Text: "Error: explain_query only accepts SELECT queries, got: " + op}},
+					Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Error: %v", err)}},
 					IsError: true,
 				},
 			}
 		}
+		if mode == planModeEstimated || mode == planModeActual {
+			includeXML, _ := params.Arguments["include_xml"].(bool)
+			return s.handleExplainPlan(id, target, query, mode, includeXML)
+		}
 
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
 		// Use a dedicated connection so SET SHOWPLAN_TEXT applies only to this query
-		conn, err := s.getDB().Conn(ctx)
+		conn, err := target.db.Conn(ctx)
 		if err != nil {
 			connErrMsg := "Error acquiring connection"
 			if s.devMode {
@@ -2539,6 +2843,42 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 			},
 		}
 
+	case "schema_diff":
+		return s.handleSchemaDiff(id, params.Arguments)
+
+	case "export_query":
+		return s.handleExportQuery(id, params.Arguments)
+
+	case "import_data":
+		return s.handleImportData(id, params.Arguments)
+
+	case "profile":
+		return s.handleProfile(id, params.Arguments)
+
+	case "find_value":
+		return s.handleFindValue(id, params.Arguments)
+
+	case "join_path":
+		return s.handleJoinPath(id, params.Arguments)
+
+	case "diagram":
+		return s.handleDiagram(id, params.Arguments)
+
+	case "compare":
+		return s.handleCompare(id, params.Arguments)
+
+	case "performance":
+		return s.handlePerformance(id, params.Arguments)
+
+	case "activity":
+		return s.handleActivity(id, params.Arguments)
+
+	case "begin_transaction", "commit", "rollback":
+		return s.handleTransactionTool(id, params.Name, params.Arguments)
+
+	case "undo_operation":
+		return s.handleUndoOperation(id, params.Arguments)
+
 	// === Dynamic multi-connection tools (only reachable when s.isDynamic) ===
 	// When !s.isDynamic these cases are unreachable because the tools are not
 	// advertised in tools/list, but we keep cheap runtime guards for safety.
@@ -2577,6 +2917,15 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 				if a.ConnectionString != "" {
 					override = " | ConnectionString: (custom override set)"
 				}
+				if a.AllowKill {
+					override += " | kill_session allowed"
+				}
+				if a.MaxAffectedRows > 0 {
+					override += fmt.Sprintf(" | max %d affected rows", a.MaxAffectedRows)
+				}
+				if a.RequireWhere {
+					override += " | WHERE required"
+				}
 				fmt.Fprintf(&sb, "- %s → %s/%s (%s%s%s)\n", alias, a.Server, a.Database, ro, wl, override)
 			}
 		}
@@ -2671,7 +3020,12 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 		s.dynamicMu.Lock()
 		defer s.dynamicMu.Unlock()
 
-		if s.activeAlias == "" {
+		closedAlias := s.activeAlias
+		if a, ok := params.Arguments["alias"].(string); ok && strings.TrimSpace(a) != "" {
+			closedAlias = strings.ToUpper(strings.TrimSpace(a))
+		}
+
+		if closedAlias == "" {
 			return &MCPResponse{
 				JSONRPC: "2.0",
 				ID:      id,
@@ -2681,12 +3035,30 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 			}
 		}
 
-		closedAlias := s.activeAlias
-
 		// Close the connection
-		if conn, ok := s.connections[s.activeAlias]; ok && conn != nil {
+		conn, open := s.connections[closedAlias]
+		if open && conn != nil {
+			s.rollbackTransactionOn("its connection was closed by dynamic_disconnect", closedAlias)
 			_ = conn.Close()
-			delete(s.connections, s.activeAlias)
+		}
+		delete(s.connections, closedAlias)
+		if open {
+			// Drop the alias's proc_* tools now rather than at the next poll.
+			s.kickProcedureTools()
+		}
+
+		if closedAlias != s.activeAlias {
+			msg := fmt.Sprintf("Closed connection pool of alias '%s'. Active connection unchanged.", closedAlias)
+			if !open {
+				msg = fmt.Sprintf("Alias '%s' has no open connection pool.", closedAlias)
+			}
+			return &MCPResponse{
+				JSONRPC: "2.0",
+				ID:      id,
+				Result: CallToolResult{
+					Content: []ContentItem{{Type: "text", Text: msg}},
+				},
+			}
 		}
 
 		s.dbMu.Lock()
@@ -2723,12 +3095,21 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 		if len(s.dynamicAliases) == 0 {
 			sb.WriteString("(none)\n")
 		} else {
-			for alias, a := range s.dynamicAliases {
-				active := ""
+			names := make([]string, 0, len(s.dynamicAliases))
+			for alias := range s.dynamicAliases {
+				names = append(names, alias)
+			}
+			sort.Strings(names)
+			for _, alias := range names {
+				a := s.dynamicAliases[alias]
+				marker := ""
+				if _, open := s.connections[alias]; open {
+					marker = "  [pool open]"
+				}
 				if alias == s.activeAlias {
-					active = "  ← ACTIVE"
+					marker += "  ← ACTIVE"
 				}
-				fmt.Fprintf(&sb, "- %s (%s/%s)%s\n", alias, a.Server, a.Database, active)
+				fmt.Fprintf(&sb, "- %s (%s/%s)%s\n", alias, a.Server, a.Database, marker)
 			}
 		}
 
@@ -2808,20 +3189,22 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 		pendingDesc := strings.ToLower(s.pendingConfirmation.Description)
 		userDesc := strings.ToLower(description)
 
-		mentionsOperation := strings.Contains(userDesc, strings.ToLower(s.pendingConfirmation.Operation))
+		mentionsOperation := mentionsKey(userDesc, strings.ToLower(s.pendingConfirmation.Operation))
 		mentionsTable := false
 		for _, t := range s.pendingConfirmation.Tables {
-			if t != "" && strings.Contains(userDesc, strings.ToLower(t)) {
+			if t != "" && mentionsKey(userDesc, strings.ToLower(t)) {
 				mentionsTable = true
 				break
 			}
 		}
 		// A near-verbatim echo of the generated description is also acceptable.
-		echoesPending := pendingDesc != "" && strings.Contains(userDesc, pendingDesc)
+		echoesPending := pendingDesc != "" && mentionsKey(userDesc, pendingDesc)
 
 		if (mentionsOperation && mentionsTable) || echoesPending {
 
-			s.pendingConfirmation = nil // consume it
+			// Consumed by the next matching operation (isOperationConfirmed).
+			s.pendingConfirmation.Confirmed = true
+			s.pendingConfirmation.ExpiresAt = time.Now().Add(90 * time.Second)
 			return &MCPResponse{
 				JSONRPC: "2.0",
 				ID:      id,
@@ -2841,6 +3224,12 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 		}
 
 	default:
+		if procName, ok := s.procedureForTool(params.Name); ok {
+			return s.handleExecuteProcedure(id, map[string]interface{}{
+				"procedure_name": procName,
+				"parameters":     params.Arguments,
+			})
+		}
 		return &MCPResponse{
 			JSONRPC: "2.0",
 			ID:      id,
@@ -2852,122 +3241,362 @@ func (s *MCPMSSQLServer) handleToolCall(id interface{}, params CallToolParams) *
 	}
 }
 
-func (s *MCPMSSQLServer) handleRequest(req MCPRequest) *MCPResponse {
-	defer func() {
-		if r := recover(); r != nil {
-			s.secLogger.Printf("Recovered panic in handleRequest for method %s: %v (request dropped, server stays alive)", req.Method, r)
-		}
-	}()
-	switch req.Method {
-	case "initialize":
-		dbStatus := "disconnected"
-		if s.getDB() != nil {
-			dbStatus = "connected"
-		}
-
-		// Extract client's protocolVersion and echo it back (spec MUST requirement)
-		clientVersion := "2025-11-25" // default to latest spec version
-		if req.Params != nil {
-			if paramBytes, err := json.Marshal(req.Params); err == nil {
-				var initParams InitializeParams
-				if err := json.Unmarshal(paramBytes, &initParams); err == nil && initParams.ProtocolVersion != "" {
-					clientVersion = initParams.ProtocolVersion
-				}
-			}
-		}
-
-		return &MCPResponse{
-			JSONRPC: "2.0",
-			ID:      req.ID,
-			Result: InitializeResult{
-				ProtocolVersion: clientVersion,
-				Capabilities: Capabilities{
-					Tools: ToolsCapability{
-						ListChanged: false,
+// listTools returns the tool surface advertised in tools/list. It is also used
+// by reloadConfig to detect when the surface changed and clients must be sent
+// notifications/tools/list_changed.
+func (s *MCPMSSQLServer) listTools() []Tool {
+	tools := []Tool{
+		{
+			Name:        "query_database",
+			Title:       "Query Database",
+			Description: "Execute a secure SQL query against the MSSQL database. Pass values through 'params' instead of inlining literals: the plan is reused and no quoting is needed.",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"query": {
+						Type:        "string",
+						Description: "SQL query to execute (uses prepared statements for security)",
+					},
+					"params": {
+						Types:       []string{"array", "object", "string"},
+						Description: "Query parameters (optional). An array binds @p1..@pN in order: [42, \"2026-01-31\"]. An object binds named parameters: {\"customer\": 42} for @customer. A value may carry an explicit SQL type: {\"value\": \"19.90\", \"type\": \"decimal(10,2)\"}; supported types include nvarchar(n), varchar(n), int, bigint, bit, decimal(p,s), float, date, datetime, datetime2, datetimeoffset, time, uniqueidentifier and varbinary (0x hex). Untyped strings are sent as nvarchar, whole numbers as bigint. The array or object may also be passed as a JSON string.",
 					},
-					Logging: map[string]interface{}{},
 				},
-				ServerInfo: ServerInfo{
-					Name:    fmt.Sprintf("mcp-go-mssql (%s)", dbStatus),
-					Title:   "MSSQL Database Connector",
-					Version: "1.0.0",
+				Required: []string{"query"},
+			},
+			Annotations: &ToolAnnotations{
+				ReadOnlyHint:    boolPtr(false),
+				DestructiveHint: boolPtr(false),
+				IdempotentHint:  boolPtr(false),
+				OpenWorldHint:   boolPtr(false),
+			},
+		},
+		{
+			Name:        "get_database_info",
+			Title:       "Get Database Info",
+			Description: "Get database connection status and basic information",
+			InputSchema: InputSchema{
+				Type:       "object",
+				Properties: map[string]Property{},
+				Required:   []string{},
+			},
+			Annotations: &ToolAnnotations{
+				ReadOnlyHint:    boolPtr(true),
+				DestructiveHint: boolPtr(false),
+				IdempotentHint:  boolPtr(true),
+				OpenWorldHint:   boolPtr(false),
+			},
+		},
+		{
+			Name:        "explore",
+			Title:       "Explore Database",
+			Description: "Explore database objects. type=tables (default) lists tables/views with approximate row counts, reserved/data/index sizes, compression and partitioning (sort_by=size or rows to find the largest), type=views lists views with metadata (check_option, is_updatable, definition preview), type=databases lists all databases, type=procedures lists stored procedures, type=search searches objects by name or source definition (requires pattern).",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"type": {
+						Type:        "string",
+						Description: "What to explore: 'tables' (default), 'views', 'databases', 'procedures', 'search'",
+					},
+					"filter": {
+						Type:        "string",
+						Description: "Name filter for tables/procedures (LIKE match, e.g. 'Pedido')",
+					},
+					"sort_by": {
+						Type:        "string",
+						Description: "Order of type=tables: 'name' (default), 'size' (reserved space, largest first) or 'rows' (most rows first)",
+					},
+					"schema": {
+						Type:        "string",
+						Description: "Schema filter for procedures (optional)",
+					},
+					"pattern": {
+						Type:        "string",
+						Description: "Search pattern. Required when type=search.",
+					},
+					"search_in": {
+						Type:        "string",
+						Description: "Where to search: 'name' (default) or 'definition' (inside procedure/view source code)",
+					},
 				},
-				Instructions: "This server provides secure access to a Microsoft SQL Server database. Use get_database_info to check connection status, explore/inspect for schema, query_database / execute_procedure for operations (subject to read-only + whitelist policy). When configured for dynamic multi-DB mode (MSSQL_DYNAMIC_* variables + no classic MSSQL_SERVER), the dynamic_available / dynamic_connect / dynamic_list / confirm_operation tools become available. All modifications are governed by the active security posture.",
+				Required: []string{},
 			},
-		}
-
-	case "ping":
-		// MCP spec MUST: respond promptly to ping with empty result
-		return &MCPResponse{
-			JSONRPC: "2.0",
-			ID:      req.ID,
-			Result:  map[string]interface{}{},
-		}
+			Annotations: &ToolAnnotations{
+				ReadOnlyHint:    boolPtr(true),
+				DestructiveHint: boolPtr(false),
+				IdempotentHint:  boolPtr(true),
+				OpenWorldHint:   boolPtr(false),
+			},
+		},
+		{
+			Name:        "inspect",
+			Title:       "Inspect Table",
+			Description: "Inspect a table's structure. detail=columns (default) returns column info, detail=indexes returns indexes, detail=foreign_keys returns FK relationships, detail=dependencies returns objects (views, procedures, functions) that reference this table, detail=storage returns row counts, sizes, compression, filegroup and boundaries per index and partition, detail=all returns everything in one call. detail=health reports index fragmentation (LIMITED scan), index usage, unused and duplicate/overlapping indexes and stale statistics, each finding with a recommended action and its DDL (never executed by the server).",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"table_name": {
+						Type:        "string",
+						Description: "Name of the table (can include schema: 'dbo.TableName')",
+					},
+					"schema": {
+						Type:        "string",
+						Description: "Schema name (optional, defaults to 'dbo')",
+					},
+					"detail": {
+						Type:        "string",
+						Description: "What to retrieve: 'columns' (default), 'indexes', 'foreign_keys', 'dependencies', 'storage', 'all', 'health'",
+					},
+				},
+				Required: []string{"table_name"},
+			},
+			Annotations: &ToolAnnotations{
+				ReadOnlyHint:    boolPtr(true),
+				DestructiveHint: boolPtr(false),
+				IdempotentHint:  boolPtr(true),
+				OpenWorldHint:   boolPtr(false),
+			},
+		},
+		{
+			Name:        "execute_procedure",
+			Title:       "Execute Procedure",
+			Description: "Execute a whitelisted stored procedure (requires MSSQL_WHITELIST_PROCEDURES env var). Arguments are checked against the procedure signature and converted to the declared parameter types. Returns every result set labelled separately, OUTPUT parameter values, the RETURN status and PRINT/RAISERROR messages.",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"procedure_name": {
+						Type:        "string",
+						Description: "Name of the stored procedure to execute",
+					},
+					"parameters": {
+						Type:        "string",
+						Description: "JSON object with parameter names and values (optional)",
+					},
+					"output_parameters": {
+						Type:        "string",
+						Description: "JSON object mapping OUTPUT parameter names to SQL types, e.g. {\"total\": \"int\", \"message\": \"nvarchar\"} (optional). OUTPUT parameters are otherwise typed from sys.parameters. A name also present in parameters is passed as INPUT/OUTPUT.",
+					},
+					"describe": {
+						Type:        "boolean",
+						Description: "Return the procedure's parameters (from sys.parameters) and a generated JSON input schema instead of executing it (optional)",
+					},
+				},
+				Required: []string{"procedure_name"},
+			},
+			Annotations: &ToolAnnotations{
+				ReadOnlyHint:    boolPtr(false),
+				DestructiveHint: boolPtr(true),
+				IdempotentHint:  boolPtr(false),
+				OpenWorldHint:   boolPtr(false),
+			},
+		},
+		{
+			Name:        "explain_query",
+			Title:       "Explain Query",
+			Description: "Show the execution plan for a SQL query. Useful for performance analysis and query optimization. Only SELECT queries are accepted. mode=estimated (or actual) returns a parsed operator tree with cost %, estimated/actual rows, warnings (implicit conversions, spills, key lookups, missing indexes, row misestimates) and the most expensive operators.",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"query": {
+						Type:        "string",
+						Description: "SELECT query to analyze (must be a read-only query)",
+					},
+					"mode": {
+						Type:        "string",
+						Description: "text (default): SHOWPLAN_TEXT lines, query not executed. estimated: SHOWPLAN_XML parsed into a tree, query not executed. actual: STATISTICS XML with actual rows; the query IS executed (strict read-only queries only, rows are discarded)",
+					},
+					"include_xml": {
+						Type:        "boolean",
+						Description: "Also return the raw showplan XML (estimated/actual modes only, optional)",
+					},
+				},
+				Required: []string{"query"},
+			},
+			Annotations: &ToolAnnotations{
+				ReadOnlyHint:    boolPtr(true),
+				DestructiveHint: boolPtr(false),
+				IdempotentHint:  boolPtr(true),
+				OpenWorldHint:   boolPtr(false),
+			},
+		},
+		{
+			Name:        "schema_diff",
+			Title:       "Schema Diff",
+			Description: "Compare tables, columns (type, nullability, default), indexes, foreign keys, views and procedure definitions between two dynamic aliases, or between a connection and a saved JSON snapshot. Reports added, removed and changed objects relative to the left side. Use save_snapshot to store the current schema for later drift checks.",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"left_alias": {
+						Type:        "string",
+						Description: "Alias of the left side (dynamic mode; default: the active connection)",
+					},
+					"right_alias": {
+						Type:        "string",
+						Description: "Alias to compare against (dynamic mode). Use either right_alias or snapshot.",
+					},
+					"snapshot": {
+						Type:        "string",
+						Description: "Saved snapshot file name in the snapshot directory (MSSQL_SNAPSHOT_DIR) to compare against",
+					},
+					"save_snapshot": {
+						Type:        "string",
+						Description: "Save the left side's schema under this file name in the snapshot directory",
+					},
+					"schema": {
+						Type:        "string",
+						Description: "Only compare objects in this schema (optional)",
+					},
+				},
+				Required: []string{},
+			},
+			Annotations: &ToolAnnotations{
+				ReadOnlyHint:    boolPtr(false), // save_snapshot writes a local file
+				DestructiveHint: boolPtr(false),
+				IdempotentHint:  boolPtr(true),
+				OpenWorldHint:   boolPtr(false),
+			},
+		},
+	}
 
-	case "logging/setLevel":
-		// MCP spec SHOULD: respect the minimum log level set by client
-		if req.Params != nil {
-			if paramBytes, err := json.Marshal(req.Params); err == nil {
-				var levelParams struct {
-					Level string `json:"level"`
-				}
-				if err := json.Unmarshal(paramBytes, &levelParams); err == nil {
-					mcpLevel := strings.ToLower(levelParams.Level)
-					var slogLevel slog.Level
-					switch mcpLevel {
-					case "debug":
-						slogLevel = slog.LevelDebug
-					case "info", "notice":
-						slogLevel = slog.LevelInfo
-					case "warning":
-						slogLevel = slog.LevelWarn
-					case "error", "critical", "alert", "emergency":
-						slogLevel = slog.LevelError
-					default:
-						slogLevel = slog.LevelInfo
-					}
-					s.secLogger.levelVar.Set(slogLevel)
+	// export_query writes files to the server's disk: only advertised once
+	// an export directory is configured.
+	if s.getGlobalConfig().exportEnabled {
+		tools = append(tools, exportTool())
+	}
+	tools = append(tools, profileTool(), findValueTool(), joinPathTool(), diagramTool())
+
+	// The performance and activity tools read server-wide DMVs: they are
+	// only advertised when the capability is switched on.
+	if s.getGlobalConfig().performanceInsights {
+		tools = append(tools, s.activityTool(s.killAllowedAnywhere()), Tool{
+			Name:        "performance",
+			Title:       "Performance Insights",
+			Description: "Read-only performance insights from Query Store and DMVs. mode=top_queries (default) lists the most expensive queries by CPU, duration or reads; mode=regressed lists queries slower recently than in their Query Store history (flagging plan changes); mode=waits samples wait statistics and reports the deltas; mode=blocking shows current blocking chains; mode=missing_indexes lists the optimizer's missing-index suggestions with DDL to review (never executed). Modes that need VIEW SERVER STATE report what is missing instead of failing.",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"mode": {
+						Type:        "string",
+						Description: "'top_queries' (default), 'regressed', 'waits', 'blocking' or 'missing_indexes'",
+					},
+					"order_by": {
+						Type:        "string",
+						Description: "top_queries: 'cpu' (default), 'duration' or 'reads'",
+					},
+					"source": {
+						Type:        "string",
+						Description: "top_queries: 'auto' (default: Query Store when enabled, else the plan cache), 'query_store' or 'dmv'",
+					},
+					"top": {
+						Type:        "integer",
+						Description: "Maximum rows returned (default 10, max 50)",
+					},
+					"hours": {
+						Type:        "integer",
+						Description: "Query Store window in hours for top_queries and the recent window of regressed (default 24)",
+					},
+					"baseline_hours": {
+						Type:        "integer",
+						Description: "regressed: baseline window in hours before the recent window (default 168)",
+					},
+					"sample_seconds": {
+						Type:        "integer",
+						Description: "waits: sampling interval in seconds (default 5, max 30)",
+					},
+				},
+				Required: []string{},
+			},
+			Annotations: &ToolAnnotations{
+				ReadOnlyHint:    boolPtr(true),
+				DestructiveHint: boolPtr(false),
+				IdempotentHint:  boolPtr(false),
+				OpenWorldHint:   boolPtr(false),
+			},
+		})
+	}
+
+	// Dynamic tools (and confirm_operation) are ONLY included in the tool list
+	// for servers that started in dynamic mode. Classic servers (the common case
+	// when using .mcp.json "env" with plain MSSQL_SERVER etc.) will never see
+	// dynamic_available, dynamic_connect, etc. This eliminates the "AI always
+	// tries dynamic connections" problem reported by users running multiple
+	// isolated server instances.
+	if s.isDynamic {
+		// Read tools can target any alias per call without switching the
+		// active connection; the alias's own security posture applies.
+		for i := range tools {
+			switch tools[i].Name {
+			case "query_database", "explore", "inspect", "explain_query", "export_query", "profile", "find_value", "join_path", "diagram", "performance", "activity":
+				tools[i].InputSchema.Properties["alias"] = Property{
+					Type:        "string",
+					Description: "Dynamic alias to run against (optional, case-insensitive). Defaults to the active connection; does not change it.",
 				}
 			}
 		}
-		return &MCPResponse{
-			JSONRPC: "2.0",
-			ID:      req.ID,
-			Result:  map[string]interface{}{},
-		}
 
-	case "notifications/cancelled":
-		// MCP spec: cancellation notification — no response needed
-		// Future: could cancel in-flight query contexts
-		return nil
-
-	case "tools/list":
-		tools := []Tool{
-			{
-				Name:        "query_database",
-				Title:       "Query Database",
-				Description: "Execute a secure SQL query against the MSSQL database",
+		tools = append(tools,
+			Tool{
+				Name:        "dynamic_available",
+				Title:       "List Dynamic Connections",
+				Description: "List all preconfigured dynamic database connections (aliases defined via MSSQL_DYNAMIC_<ALIAS>_* environment variables). Does not expose credentials. Use dynamic_connect to activate one.",
+				InputSchema: InputSchema{
+					Type:       "object",
+					Properties: map[string]Property{},
+					Required:   []string{},
+				},
+				Annotations: &ToolAnnotations{
+					ReadOnlyHint:    boolPtr(true),
+					DestructiveHint: boolPtr(false),
+					IdempotentHint:  boolPtr(true),
+					OpenWorldHint:   boolPtr(false),
+				},
+			},
+			Tool{
+				Name:        "dynamic_connect",
+				Title:       "Connect to Dynamic Alias",
+				Description: "Switch the active database connection to one of the preconfigured dynamic aliases (e.g. 'CRM', 'IDENTITY', 'GDP'). Pools of previously used aliases stay open, so switching back is cheap; read tools can also target an alias directly with their 'alias' argument. The security posture (read-only vs full access) is determined by the alias configuration or global safe defaults. After connecting, use get_database_info to verify the active alias and its effective permissions.",
 				InputSchema: InputSchema{
 					Type: "object",
 					Properties: map[string]Property{
-						"query": {
+						"alias": {
 							Type:        "string",
-							Description: "SQL query to execute (uses prepared statements for security)",
+							Description: "The alias name of the preconfigured connection (case-insensitive, e.g. 'CRM' or 'crm')",
 						},
 					},
-					Required: []string{"query"},
+					Required: []string{"alias"},
 				},
 				Annotations: &ToolAnnotations{
 					ReadOnlyHint:    boolPtr(false),
-					DestructiveHint: boolPtr(false),
+					DestructiveHint: boolPtr(false), // depends on the *alias* posture, not global
 					IdempotentHint:  boolPtr(false),
 					OpenWorldHint:   boolPtr(false),
 				},
 			},
-			{
-				Name:        "get_database_info",
-				Title:       "Get Database Info",
-				Description: "Get database connection status and basic information",
+			Tool{
+				Name:        "dynamic_disconnect",
+				Title:       "Disconnect Dynamic Connection",
+				Description: "Close the pool of a dynamic alias (default: the active one). Closing the active alias returns to disconnected state. Does not affect other aliases.",
+				InputSchema: InputSchema{
+					Type: "object",
+					Properties: map[string]Property{
+						"alias": {
+							Type:        "string",
+							Description: "Alias whose pool should be closed (optional, defaults to the active alias)",
+						},
+					},
+					Required: []string{},
+				},
+				Annotations: &ToolAnnotations{
+					ReadOnlyHint:    boolPtr(true),
+					DestructiveHint: boolPtr(false),
+					IdempotentHint:  boolPtr(true),
+					OpenWorldHint:   boolPtr(false),
+				},
+			},
+			Tool{
+				Name:        "dynamic_list",
+				Title:       "List Active Dynamic Connections",
+				Description: "Show currently loaded dynamic aliases, which ones have an open connection pool, and which one (if any) is the active connection for calls without an 'alias' argument.",
 				InputSchema: InputSchema{
 					Type:       "object",
 					Properties: map[string]Property{},
@@ -2980,64 +3609,47 @@ func (s *MCPMSSQLServer) handleRequest(req MCPRequest) *MCPResponse {
 					OpenWorldHint:   boolPtr(false),
 				},
 			},
-			{
-				Name:        "explore",
-				Title:       "Explore Database",
-				Description: "Explore database objects. type=tables (default) lists tables/views, type=views lists views with metadata (check_option, is_updatable, definition preview), type=databases lists all databases, type=procedures lists stored procedures, type=search searches objects by name or source definition (requires pattern).",
+			Tool{
+				Name:        "compare",
+				Title:       "Compare Data Across Aliases",
+				Description: "Run the same table or SELECT on two dynamic aliases (e.g. prod vs staging) and diff the rows by key in the server. Returns counts plus samples of rows only on the left, only on the right, and changed rows with the differing columns. Each side is read under its own alias's read policy.",
 				InputSchema: InputSchema{
 					Type: "object",
 					Properties: map[string]Property{
-						"type": {
+						"left_alias": {
 							Type:        "string",
-							Description: "What to explore: 'tables' (default), 'views', 'databases', 'procedures', 'search'",
+							Description: "Alias of the left side (e.g. 'APP_MAIN')",
 						},
-						"filter": {
+						"right_alias": {
 							Type:        "string",
-							Description: "Name filter for tables/procedures (LIKE match, e.g. 'Pedido')",
+							Description: "Alias of the right side (e.g. 'APP_ARCHIVE')",
 						},
-						"schema": {
+						"table": {
 							Type:        "string",
-							Description: "Schema filter for procedures (optional)",
+							Description: "Table to compare ('table' or 'schema.table'). Use either table or query.",
 						},
-						"pattern": {
+						"schema": {
 							Type:        "string",
-							Description: "Search pattern. Required when type=search.",
+							Description: "Schema of 'table' (optional, default 'dbo')",
 						},
-						"search_in": {
+						"query": {
 							Type:        "string",
-							Description: "Where to search: 'name' (default) or 'definition' (inside procedure/view source code)",
+							Description: "SELECT run on both sides. Use either table or query.",
 						},
-					},
-					Required: []string{},
-				},
-				Annotations: &ToolAnnotations{
-					ReadOnlyHint:    boolPtr(true),
-					DestructiveHint: boolPtr(false),
-					IdempotentHint:  boolPtr(true),
-					OpenWorldHint:   boolPtr(false),
-				},
-			},
-			{
-				Name:        "inspect",
-				Title:       "Inspect Table",
-				Description: "Inspect a table's structure. detail=columns (default) returns column info, detail=indexes returns indexes, detail=foreign_keys returns FK relationships, detail=dependencies returns objects (views, procedures, functions) that reference this table, detail=all returns everything in one call.",
-				InputSchema: InputSchema{
-					Type: "object",
-					Properties: map[string]Property{
-						"table_name": {
+						"key_columns": {
 							Type:        "string",
-							Description: "Name of the table (can include schema: 'dbo.TableName')",
+							Description: "Key column(s) identifying a row on both sides, comma-separated (e.g. 'id' or 'order_id,line_no')",
 						},
-						"schema": {
-							Type:        "string",
-							Description: "Schema name (optional, defaults to 'dbo')",
+						"sample_size": {
+							Type:        "integer",
+							Description: "Rows to return per sample category (default 10, max 100)",
 						},
-						"detail": {
-							Type:        "string",
-							Description: "What to retrieve: 'columns' (default), 'indexes', 'foreign_keys', 'dependencies', 'all'",
+						"max_rows": {
+							Type:        "integer",
+							Description: "Maximum rows read from each side (default 100000)",
 						},
 					},
-					Required: []string{"table_name"},
+					Required: []string{"left_alias", "right_alias", "key_columns"},
 				},
 				Annotations: &ToolAnnotations{
 					ReadOnlyHint:    boolPtr(true),
@@ -3046,23 +3658,19 @@ func (s *MCPMSSQLServer) handleRequest(req MCPRequest) *MCPResponse {
 					OpenWorldHint:   boolPtr(false),
 				},
 			},
-			{
-				Name:        "execute_procedure",
-				Title:       "Execute Procedure",
-				Description: "Execute a whitelisted stored procedure (requires MSSQL_WHITELIST_PROCEDURES env var)",
+			Tool{
+				Name:        "confirm_operation",
+				Title:       "Confirm Dangerous Operation",
+				Description: "Explicitly confirm a potentially destructive operation (INSERT/UPDATE/DELETE/DROP/etc.) on a writable dynamic alias. This is a required security step. You must call this tool with a clear description of what you intend to do before the actual modification query will be allowed.",
 				InputSchema: InputSchema{
 					Type: "object",
 					Properties: map[string]Property{
-						"procedure_name": {
-							Type:        "string",
-							Description: "Name of the stored procedure to execute",
-						},
-						"parameters": {
+						"description": {
 							Type:        "string",
-							Description: "JSON object with parameter names and values (optional)",
+							Description: "Clear description of the operation you want to perform (e.g. 'DELETE all rows from temp_ai where created < 2025-01-01')",
 						},
 					},
-					Required: []string{"procedure_name"},
+					Required: []string{"description"},
 				},
 				Annotations: &ToolAnnotations{
 					ReadOnlyHint:    boolPtr(false),
@@ -3071,134 +3679,113 @@ func (s *MCPMSSQLServer) handleRequest(req MCPRequest) *MCPResponse {
 					OpenWorldHint:   boolPtr(false),
 				},
 			},
-			{
-				Name:        "explain_query",
-				Title:       "Explain Query",
-				Description: "Show the estimated execution plan for a SQL query without executing it. Useful for performance analysis and query optimization. Only SELECT queries are accepted.",
-				InputSchema: InputSchema{
-					Type: "object",
-					Properties: map[string]Property{
-						"query": {
-							Type:        "string",
-							Description: "SELECT query to analyze (must be a read-only query)",
-						},
+		)
+		if s.writableAliasAnywhere() {
+			tools = append(tools, transactionTools()...)
+			tools = append(tools, importDataTool())
+			if s.getGlobalConfig().undoJournal {
+				tools = append(tools, undoTool())
+			}
+		}
+	}
+
+	return append(tools, s.procedureTools()...)
+}
+
+func (s *MCPMSSQLServer) handleRequest(req MCPRequest) *MCPResponse {
+	defer func() {
+		if r := recover(); r != nil {
+			s.secLogger.Printf("Recovered panic in handleRequest for method %s: %v (request dropped, server stays alive)", req.Method, r)
+		}
+	}()
+	switch req.Method {
+	case "initialize":
+		dbStatus := "disconnected"
+		if s.getDB() != nil {
+			dbStatus = "connected"
+		}
+
+		// Extract client's protocolVersion and echo it back (spec MUST requirement)
+		clientVersion := "2025-11-25" // default to latest spec version
+		if req.Params != nil {
+			if paramBytes, err := json.Marshal(req.Params); err == nil {
+				var initParams InitializeParams
+				if err := json.Unmarshal(paramBytes, &initParams); err == nil && initParams.ProtocolVersion != "" {
+					clientVersion = initParams.ProtocolVersion
+				}
+			}
+		}
+
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      req.ID,
+			Result: InitializeResult{
+				ProtocolVersion: clientVersion,
+				Capabilities: Capabilities{
+					Tools: ToolsCapability{
+						ListChanged: true, // sent after a config reload changes the tool surface
 					},
-					Required: []string{"query"},
+					Logging: map[string]interface{}{},
 				},
-				Annotations: &ToolAnnotations{
-					ReadOnlyHint:    boolPtr(true),
-					DestructiveHint: boolPtr(false),
-					IdempotentHint:  boolPtr(true),
-					OpenWorldHint:   boolPtr(false),
+				ServerInfo: ServerInfo{
+					Name:    fmt.Sprintf("mcp-go-mssql (%s)", dbStatus),
+					Title:   "MSSQL Database Connector",
+					Version: "1.0.0",
 				},
+				Instructions: "This server provides secure access to a Microsoft SQL Server database. Use get_database_info to check connection status, explore/inspect for schema, query_database / execute_procedure for operations (subject to read-only + whitelist policy). When configured for dynamic multi-DB mode (MSSQL_DYNAMIC_* variables + no classic MSSQL_SERVER), the dynamic_available / dynamic_connect / dynamic_list / confirm_operation tools become available. All modifications are governed by the active security posture.",
 			},
 		}
 
-		// Dynamic tools (and confirm_operation) are ONLY included in the tool list
-		// for servers that started in dynamic mode. Classic servers (the common case
-		// when using .mcp.json "env" with plain MSSQL_SERVER etc.) will never see
-		// dynamic_available, dynamic_connect, etc. This eliminates the "AI always
-		// tries dynamic connections" problem reported by users running multiple
-		// isolated server instances.
-		if s.isDynamic {
-			tools = append(tools,
-				Tool{
-					Name:        "dynamic_available",
-					Title:       "List Dynamic Connections",
-					Description: "List all preconfigured dynamic database connections (aliases defined via MSSQL_DYNAMIC_<ALIAS>_* environment variables). Does not expose credentials. Use dynamic_connect to activate one.",
-					InputSchema: InputSchema{
-						Type:       "object",
-						Properties: map[string]Property{},
-						Required:   []string{},
-					},
-					Annotations: &ToolAnnotations{
-						ReadOnlyHint:    boolPtr(true),
-						DestructiveHint: boolPtr(false),
-						IdempotentHint:  boolPtr(true),
-						OpenWorldHint:   boolPtr(false),
-					},
-				},
-				Tool{
-					Name:        "dynamic_connect",
-					Title:       "Connect to Dynamic Alias",
-					Description: "Switch the active database connection to one of the preconfigured dynamic aliases (e.g. 'CRM', 'IDENTITY', 'GDP'). The security posture (read-only vs full access) is determined by the alias configuration or global safe defaults. After connecting, use get_database_info to verify the active alias and its effective permissions.",
-					InputSchema: InputSchema{
-						Type: "object",
-						Properties: map[string]Property{
-							"alias": {
-								Type:        "string",
-								Description: "The alias name of the preconfigured connection (case-insensitive, e.g. 'CRM' or 'crm')",
-							},
-						},
-						Required: []string{"alias"},
-					},
-					Annotations: &ToolAnnotations{
-						ReadOnlyHint:    boolPtr(false),
-						DestructiveHint: boolPtr(false), // depends on the *alias* posture, not global
-						IdempotentHint:  boolPtr(false),
-						OpenWorldHint:   boolPtr(false),
-					},
-				},
-				Tool{
-					Name:        "dynamic_disconnect",
-					Title:       "Disconnect Dynamic Connection",
-					Description: "Close the currently active dynamic connection and return to disconnected state. Does not affect other aliases.",
-					InputSchema: InputSchema{
-						Type:       "object",
-						Properties: map[string]Property{},
-						Required:   []string{},
-					},
-					Annotations: &ToolAnnotations{
-						ReadOnlyHint:    boolPtr(true),
-						DestructiveHint: boolPtr(false),
-						IdempotentHint:  boolPtr(true),
-						OpenWorldHint:   boolPtr(false),
-					},
-				},
-				Tool{
-					Name:        "dynamic_list",
-					Title:       "List Active Dynamic Connections",
-					Description: "Show currently loaded dynamic aliases and which one (if any) is the active connection for subsequent query_database calls.",
-					InputSchema: InputSchema{
-						Type:       "object",
-						Properties: map[string]Property{},
-						Required:   []string{},
-					},
-					Annotations: &ToolAnnotations{
-						ReadOnlyHint:    boolPtr(true),
-						DestructiveHint: boolPtr(false),
-						IdempotentHint:  boolPtr(true),
-						OpenWorldHint:   boolPtr(false),
-					},
-				},
-				Tool{
-					Name:        "confirm_operation",
-					Title:       "Confirm Dangerous Operation",
-					Description: "Explicitly confirm a potentially destructive operation (INSERT/UPDATE/DELETE/DROP/etc.) on a writable dynamic alias. This is a required security step. You must call this tool with a clear description of what you intend to do before the actual modification query will be allowed.",
-					InputSchema: InputSchema{
-						Type: "object",
-						Properties: map[string]Property{
-							"description": {
-								Type:        "string",
-								Description: "Clear description of the operation you want to perform (e.g. 'DELETE all rows from temp_ai where created < 2025-01-01')",
-							},
-						},
-						Required: []string{"description"},
-					},
-					Annotations: &ToolAnnotations{
-						ReadOnlyHint:    boolPtr(false),
-						DestructiveHint: boolPtr(true),
-						IdempotentHint:  boolPtr(false),
-						OpenWorldHint:   boolPtr(false),
-					},
-				},
-			)
+	case "ping":
+		// MCP spec MUST: respond promptly to ping with empty result
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      req.ID,
+			Result:  map[string]interface{}{},
 		}
 
+	case "logging/setLevel":
+		// MCP spec SHOULD: respect the minimum log level set by client
+		if req.Params != nil {
+			if paramBytes, err := json.Marshal(req.Params); err == nil {
+				var levelParams struct {
+					Level string `json:"level"`
+				}
+				if err := json.Unmarshal(paramBytes, &levelParams); err == nil {
+					mcpLevel := strings.ToLower(levelParams.Level)
+					var slogLevel slog.Level
+					switch mcpLevel {
+					case "debug":
+						slogLevel = slog.LevelDebug
+					case "info", "notice":
+						slogLevel = slog.LevelInfo
+					case "warning":
+						slogLevel = slog.LevelWarn
+					case "error", "critical", "alert", "emergency":
+						slogLevel = slog.LevelError
+					default:
+						slogLevel = slog.LevelInfo
+					}
+					s.secLogger.levelVar.Set(slogLevel)
+				}
+			}
+		}
 		return &MCPResponse{
 			JSONRPC: "2.0",
 			ID:      req.ID,
-			Result:  ToolsListResult{Tools: tools},
+			Result:  map[string]interface{}{},
+		}
+
+	case "notifications/cancelled":
+		// MCP spec: cancellation notification — no response needed
+		// Future: could cancel in-flight query contexts
+		return nil
+
+	case "tools/list":
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      req.ID,
+			Result:  ToolsListResult{Tools: s.listTools()},
 		}
 
 	case "tools/call":
@@ -3248,6 +3835,42 @@ func (s *MCPMSSQLServer) handleRequest(req MCPRequest) *MCPResponse {
 	}
 }
 
+// buildServerConfig derives the global serverConfig from an environment lookup
+// function, applying the dynamic-mode safety guard. It is shared by main() and
+// reloadConfig so both paths enforce exactly the same policy.
+func buildServerConfig(getenv func(string) string, dynamicMode bool, secLogger *SecurityLogger) serverConfig {
+	cfg := serverConfig{
+		readOnly:            strings.ToLower(getenv("MSSQL_READ_ONLY")) == "true",
+		whitelistTables:     parseWhitelistTables(getenv("MSSQL_WHITELIST_TABLES")),
+		whitelistProcs:      getenv("MSSQL_WHITELIST_PROCEDURES"),
+		procedureTools:      strings.ToLower(getenv("MSSQL_PROCEDURE_TOOLS")) == "true",
+		performanceInsights: strings.ToLower(getenv("MSSQL_PERFORMANCE_INSIGHTS")) == "true",
+		undoJournal:         strings.ToLower(getenv("MSSQL_UNDO_JOURNAL")) == "true",
+		exportEnabled:       strings.TrimSpace(getenv("MSSQL_EXPORT_DIR")) != "",
+	}
+	if _, err := parseProcWhitelist(cfg.whitelistProcs); err != nil {
+		secLogger.Printf("WARNING: MSSQL_WHITELIST_PROCEDURES ignored: %v", err)
+		cfg.whitelistProcs = ""
+	}
+
+	// === SECURITY GUARD: Dynamic mode + global READ_ONLY=false is fatal ===
+	// This combination (as reported in production incidents) allows an AI (or
+	// prompt-injected tool call) to dynamic_connect to any preconfigured internal
+	// corporate database and execute arbitrary DML/DDL because enforcement is global.
+	if dynamicMode && !cfg.readOnly {
+		secLogger.Printf("*** FATAL SECURITY MISCONFIGURATION DETECTED ***")
+		secLogger.Printf("DYNAMIC multi-connection mode is active (MSSQL_DYNAMIC_MODE or MSSQL_DYNAMIC_* vars present)")
+		secLogger.Printf("BUT top-level MSSQL_READ_ONLY is false or unset (full access mode).")
+		secLogger.Printf("This would expose ALL dynamic databases (including production ones) to unrestricted write operations via query_database after dynamic_connect.")
+		secLogger.Printf("SAFETY ACTION: Forcing READ-ONLY mode globally for this session. Dynamic aliases will inherit safe defaults.")
+		secLogger.Printf("Recommendation: Remove MSSQL_READ_ONLY=false from the .env used with dynamic connections. Per-alias control (MSSQL_DYNAMIC_<ALIAS>_READ_ONLY) will be supported in a future secure implementation.")
+		cfg.readOnly = true
+		cfg.whitelistTables = nil // start strict; per-alias can relax later
+	}
+
+	return cfg
+}
+
 func main() {
 	// Initialize security logger
 	secLogger := NewSecurityLogger()
@@ -3255,50 +3878,32 @@ func main() {
 
 	// Load .env from executable directory (supports documented dynamic mode workflow)
 	// Host-passed environment variables always take precedence.
-	loadDotEnvIfPresent(secLogger)
+	envFileValues := loadDotEnvIfPresent(secLogger)
 
 	// Determine mode once. We use this both to decide whether to load dynamic aliases
 	// and to expose the correct tool surface to the AI.
 	dynamicMode := isDynamicMode()
 
+	// Check for developer mode
+	devMode := strings.ToLower(os.Getenv("DEVELOPER_MODE")) == "true"
+	if devMode {
+		secLogger.Printf("DEVELOPER MODE ENABLED - Detailed errors will be shown")
+	}
+
 	// Only load dynamic aliases when operating in dynamic mode.
 	// This prevents stray MSSQL_DYNAMIC_* variables (inherited from Claude Desktop's
 	// parent environment, previous shell sessions, or leftover .env files in other folders)
 	// from polluting classic single-connection server instances.
 	var dynamicAliases map[string]DynamicAlias
 	if dynamicMode {
-		dynamicAliases = loadDynamicAliases(secLogger)
+		dynamicAliases = startupDynamicAliases(environMap(), devMode, secLogger)
 	} else {
 		dynamicAliases = make(map[string]DynamicAlias)
 	}
 
-	// Check for developer mode
-	devMode := strings.ToLower(os.Getenv("DEVELOPER_MODE")) == "true"
-	if devMode {
-		secLogger.Printf("DEVELOPER MODE ENABLED - Detailed errors will be shown")
-	}
-
-	// Cache configuration once at startup (avoid os.Getenv on every request)
-	cfg := serverConfig{
-		readOnly:        strings.ToLower(os.Getenv("MSSQL_READ_ONLY")) == "true",
-		whitelistTables: parseWhitelistTables(os.Getenv("MSSQL_WHITELIST_TABLES")),
-		whitelistProcs:  os.Getenv("MSSQL_WHITELIST_PROCEDURES"),
-	}
-
-	// === SECURITY GUARD: Dynamic mode + global READ_ONLY=false is fatal ===
-	// This combination (as reported in production incidents) allows an AI (or
-	// prompt-injected tool call) to dynamic_connect to any preconfigured internal
-	// corporate database and execute arbitrary DML/DDL because enforcement is global.
-	if isDynamicMode() && !cfg.readOnly {
-		secLogger.Printf("*** FATAL SECURITY MISCONFIGURATION DETECTED ***")
-		secLogger.Printf("DYNAMIC multi-connection mode is active (MSSQL_DYNAMIC_MODE or MSSQL_DYNAMIC_* vars present)")
-		secLogger.Printf("BUT top-level MSSQL_READ_ONLY is false or unset (full access mode).")
-		secLogger.Printf("This would expose ALL dynamic databases (including production ones) to unrestricted write operations via query_database after dynamic_connect.")
-		secLogger.Printf("SAFETY ACTION: Forcing READ-ONLY mode globally for this session. Dynamic aliases will inherit safe defaults.")
-		secLogger.Printf("Recommendation: Remove MSSQL_READ_ONLY=false from the .env used with dynamic connections. Per-alias control (MSSQL_DYNAMIC_<ALIAS>_READ_ONLY) will be supported in a future secure implementation.")
-		cfg.readOnly = true
-		cfg.whitelistTables = nil // start strict; per-alias can relax later
-	}
+	// Cache configuration once at startup (avoid os.Getenv on every request).
+	// reloadConfig rebuilds it the same way when the .env file changes.
+	cfg := buildServerConfig(os.Getenv, dynamicMode, secLogger)
 
 	// Create MCP server without database initially
 	server := &MCPMSSQLServer{
@@ -3308,6 +3913,11 @@ func main() {
 		config:         cfg,
 		isDynamic:      dynamicMode,
 		dynamicAliases: dynamicAliases,
+		envFilePath:    dotEnvPath(),
+		envFileValues:  envFileValues,
+		procToolsKick:  make(chan struct{}, 1),
+		supervisorKick: make(chan struct{}, 1),
+		out:            os.Stdout,
 	}
 	// Initialize rate limiter: 60 tool calls per minute
 	server.rateLimiter.maxTokens = 60
@@ -3394,7 +4004,7 @@ func main() {
 		}
 
 		// Log security settings (using cached config)
-		if server.config.readOnly {
+		if server.getGlobalConfig().readOnly {
 			secLogger.Printf("READ-ONLY MODE ENABLED - Only SELECT queries allowed")
 		} else {
 			secLogger.Printf("Full access mode enabled")
@@ -3403,101 +4013,24 @@ func main() {
 		if customConnStr == "" && serverHost == "" {
 			if isDynamicMode() {
 				secLogger.Printf("Dynamic multi-connection mode enabled - no default connection configured (use dynamic_connect after startup)")
+				// Still health-check the alias pools opened by dynamic_connect.
+				server.superviseConnections(connCtx, false)
 			} else {
 				secLogger.Printf("No MSSQL_SERVER or MSSQL_CONNECTION_STRING environment variable - database features disabled")
 			}
 			return
 		}
 
-		// Build secure connection string
-		connStr, err := buildSecureConnectionString()
-		if err != nil {
-			if devMode {
-				secLogger.Printf("Failed to build connection string: %v", err)
-			} else {
-				secLogger.Printf("Failed to build connection string: configuration error")
-			}
-			return
-		}
-
-		// Connect to MSSQL
-		secLogger.Printf("Attempting to connect to MSSQL server...")
-		db, err := sql.Open("sqlserver", connStr)
-		if err != nil {
-			if devMode {
-				secLogger.Printf("sql.Open failed: %v", err)
-			} else {
-				secLogger.Printf("Failed to connect: connection error")
-			}
-			return
-		}
-		secLogger.Printf("sql.Open successful, testing connection...")
-
-		// Configure optimized connection pool
-		db.SetMaxOpenConns(10)                  // More concurrent connections
-		db.SetMaxIdleConns(5)                   // More idle connections for reuse
-		db.SetConnMaxLifetime(30 * time.Minute) // Shorter lifetime for fresher connections
-		db.SetConnMaxIdleTime(5 * time.Minute)  // Quick cleanup of unused connections
-
-		// Test connection with longer timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-
-		secLogger.Printf("Testing database connection with ping...")
-		if err := db.PingContext(ctx); err != nil {
-			secLogger.LogConnectionAttempt(false)
-			if devMode {
-				secLogger.Printf("Database ping failed: %v", err)
-				trustCert := "false"
-				if strings.ToLower(os.Getenv("DEVELOPER_MODE")) == "true" {
-					trustCert = "true"
-				}
-
-				auth := strings.ToLower(os.Getenv("MSSQL_AUTH"))
-				if auth == "" {
-					auth = "sql"
-				}
-
-				if customConnStr := os.Getenv("MSSQL_CONNECTION_STRING"); customConnStr != "" {
-					secLogger.Printf("Using custom connection string format")
-				} else if auth == "integrated" || auth == "windows" {
-					secLogger.Printf("Using Windows Integrated Authentication (SSPI)")
-					secLogger.Printf("Troubleshooting tips for integrated auth:")
-					secLogger.Printf("  1. Ensure your Windows user has permission in SQL Server")
-					secLogger.Printf("  2. Check if SQL Server is configured to allow Windows Authentication")
-					secLogger.Printf("  3. Try using server='.' or server='localhost' or server='(local)'")
-					secLogger.Printf("  4. Verify TCP/IP or Named Pipes are enabled in SQL Server Configuration Manager")
-
-					// Get current Windows user
-					if u, err := osuser.Current(); err == nil {
-						secLogger.Printf("  Running as Windows user: %s\\%s", u.Username, u.Name)
-					}
-				} else {
-					encrypt := "true"
-					if strings.ToLower(os.Getenv("DEVELOPER_MODE")) == "true" {
-						if envEncrypt := os.Getenv("MSSQL_ENCRYPT"); envEncrypt != "" {
-							encrypt = strings.ToLower(envEncrypt)
-						} else {
-							encrypt = "false"
-						}
-					}
-					secLogger.Printf("Connection string format: server=SERVER;port=PORT;database=DB;user id=USER;password=***;encrypt=%s;trustservercertificate=%s;connection timeout=30;command timeout=30", encrypt, trustCert)
-				}
-			} else {
-				secLogger.Printf("Failed to ping database: connection test failed")
-			}
-			if cerr := db.Close(); cerr != nil {
-				secLogger.Printf("Error closing DB after failed ping: %v", cerr)
-			}
-			return
-		}
+		// Connect, then keep the pool healthy (retry with backoff, periodic
+		// health checks, transparent reopen). See health.go.
+		server.superviseConnections(connCtx, true)
+	}()
 
-		secLogger.LogConnectionAttempt(true)
-		secLogger.Printf("Database connection established successfully")
+	// Hot reload: poll the .env file and listen for SIGHUP (see reload.go)
+	server.startConfigReload(connCtx, &connWg)
 
-		// Update server with working database connection
-		server.setDB(db)
-	}()
+	// Per-procedure tools (MSSQL_PROCEDURE_TOOLS, see procedure_tools.go)
+	server.startProcedureTools(connCtx, &connWg)
 
 	// Start MCP protocol handler
 	scanner := bufio.NewScanner(os.Stdin)
@@ -3522,9 +4055,7 @@ func main() {
 					Message: "Parse error",
 				},
 			}
-			if respBytes, err := json.Marshal(parseErrResp); err == nil {
-				fmt.Println(string(respBytes))
-			}
+			_ = server.writeMessage(parseErrResp)
 			continue
 		}
 
@@ -3538,9 +4069,7 @@ func main() {
 					Message: "Invalid Request: missing or incorrect jsonrpc version, must be \"2.0\"",
 				},
 			}
-			if respBytes, err := json.Marshal(invalidResp); err == nil {
-				fmt.Println(string(respBytes))
-			}
+			_ = server.writeMessage(invalidResp)
 			continue
 		}
 
@@ -3551,13 +4080,9 @@ func main() {
 
 		// Only send response if one is needed (not for notifications)
 		if response != nil {
-			responseBytes, err := json.Marshal(response)
-			if err != nil {
-				secLogger.Printf("Failed to marshal response: %v", err)
-				continue
+			if err := server.writeMessage(response); err != nil {
+				secLogger.Printf("Failed to write response: %v", err)
 			}
-
-			fmt.Println(string(responseBytes))
 		}
 	}
 	// Note: we intentionally do not recover here; a panic in the main loop is fatal
@@ -3567,7 +4092,8 @@ func main() {
 		secLogger.Printf("Scanner error: %v", err)
 	}
 
-	// Clean shutdown: cancel connection goroutine and wait for it
+	// Clean shutdown: cancel background goroutines and wait for them
 	connCancel()
 	connWg.Wait()
+	server.rollbackTransactionOn("server shutting down")
 }
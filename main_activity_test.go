@@ -0,0 +1,152 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestActivityQueriesPassReadOnlyPolicy(t *testing.T) {
+	s := newTestMCPServer()
+	for name, q := range map[string]string{"activity": activityQuery, "kill target": killTargetQuery} {
+		if err := s.validateReadOnlyQueryFor(serverConfig{readOnly: true}, q); err != nil {
+			t.Errorf("%s query refused by the read-only policy: %v", name, err)
+		}
+	}
+}
+
+func TestRenderBlockingTree(t *testing.T) {
+	tree := blockingTree([]blockingSession{
+		{SessionID: 55, Status: "sleeping", OpenTransactions: 1, Login: "app", Host: "web1", Statement: "UPDATE dbo.Orders SET Status = 2"},
+		{SessionID: 60, BlockedBy: 55, WaitType: "LCK_M_S", WaitMs: 1200},
+		{SessionID: 61, BlockedBy: 60, WaitType: "LCK_M_S", WaitMs: 800},
+		{SessionID: 62, BlockedBy: 55, WaitType: "LCK_M_X", WaitMs: 300},
+	})
+	want := "session 55, sleeping, 1 open tran, app@web1: UPDATE dbo.Orders SET Status = 2\n" +
+		"├─ session 60, waiting LCK_M_S 1200ms\n" +
+		"│  └─ session 61, waiting LCK_M_S 800ms\n" +
+		"└─ session 62, waiting LCK_M_X 300ms\n"
+	if got := renderBlockingTree(tree); got != want {
+		t.Errorf("renderBlockingTree =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestKillPolicy(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	kill := func(alias string) error {
+		target, err := s.resolveTarget(map[string]interface{}{"alias": alias})
+		if err != nil {
+			t.Fatal(err)
+		}
+		target.tool = "activity"
+		return s.enforcePolicy(policyRequest{target: target, query: "KILL 57", mode: policyKill, session: 57})
+	}
+
+	if err := kill("RW"); err == nil || !strings.Contains(err.Error(), "ALLOW_KILL") {
+		t.Errorf("kill without ALLOW_KILL should be refused, got %v", err)
+	}
+
+	ro := s.dynamicAliases["RO"]
+	ro.AllowKill = true
+	s.dynamicAliases["RO"] = ro
+	// ALLOW_KILL is independent of READ_ONLY but always needs a confirmation.
+	err := kill("RO")
+	if !errors.Is(err, errConfirmationRequired) {
+		t.Fatalf("kill should require confirmation, got %v", err)
+	}
+	result := s.handleToolCall(1, CallToolParams{Name: "confirm_operation", Arguments: map[string]interface{}{"description": "KILL session 57"}}).Result.(CallToolResult)
+	if result.IsError {
+		t.Fatalf("confirm_operation: %s", result.Content[0].Text)
+	}
+	if err := kill("RO"); err != nil {
+		t.Errorf("confirmed kill should pass the policy, got %v", err)
+	}
+	if err := kill("RO"); !errors.Is(err, errConfirmationRequired) {
+		t.Errorf("a confirmation is consumed by one kill, got %v", err)
+	}
+
+	// The session id is matched as a whole: "session 5" is not confirmed by
+	// a description naming session 55.
+	target, err := s.resolveTarget(map[string]interface{}{"alias": "RO"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.enforcePolicy(policyRequest{target: target, query: "KILL 5", mode: policyKill, session: 5}); !errors.Is(err, errConfirmationRequired) {
+		t.Fatalf("kill should require confirmation, got %v", err)
+	}
+	result = s.handleToolCall(1, CallToolParams{Name: "confirm_operation", Arguments: map[string]interface{}{"description": "KILL session 55"}}).Result.(CallToolResult)
+	if !result.IsError {
+		t.Error("a confirmation naming session 55 must not confirm killing session 5")
+	}
+	result = s.handleToolCall(1, CallToolParams{Name: "confirm_operation", Arguments: map[string]interface{}{"description": "KILL session 5"}}).Result.(CallToolResult)
+	if result.IsError {
+		t.Errorf("confirm_operation: %s", result.Content[0].Text)
+	}
+}
+
+func TestKillRefusedInClassicMode(t *testing.T) {
+	s := newTestMCPServer()
+	s.config = serverConfig{performanceInsights: true}
+	db, err := sql.Open("sqlserver", "server=127.0.0.1;database=x")
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+	s.db = db
+	result := s.handleActivity(1, map[string]interface{}{"action": "kill_session", "session_id": float64(57)}).Result.(CallToolResult)
+	if !result.IsError || !strings.Contains(result.Content[0].Text, "not enabled") {
+		t.Errorf("kill_session must be refused on classic connections, got %+v", result)
+	}
+}
+
+func TestAliasAllowKillFromEnv(t *testing.T) {
+	env := map[string]string{
+		"MSSQL_DYNAMIC_OPS_SERVER":     "ops.local",
+		"MSSQL_DYNAMIC_OPS_DATABASE":   "Ops",
+		"MSSQL_DYNAMIC_OPS_ALLOW_KILL": "true",
+		"MSSQL_DYNAMIC_CRM_SERVER":     "crm.local",
+		"MSSQL_DYNAMIC_CRM_DATABASE":   "CRM",
+	}
+	s := newTestMCPServer()
+	s.isDynamic = true
+	s.dynamicAliases = loadDynamicAliasesFromEnv(env, s.secLogger)
+	if !s.getEffectiveConfigFor("OPS").allowKill {
+		t.Error("OPS should allow kill_session")
+	}
+	if s.getEffectiveConfigFor("CRM").allowKill {
+		t.Error("kill_session must be off by default")
+	}
+}
+
+func TestActivityToolListing(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	activity := func() *Tool {
+		for _, tool := range s.listTools() {
+			if tool.Name == "activity" {
+				return &tool
+			}
+		}
+		return nil
+	}
+
+	if activity() != nil {
+		t.Error("activity must not be listed unless MSSQL_PERFORMANCE_INSIGHTS=true")
+	}
+	s.config.performanceInsights = true
+	tool := activity()
+	if tool == nil {
+		t.Fatal("activity should be listed once enabled")
+	}
+	if _, ok := tool.InputSchema.Properties["session_id"]; ok || !*tool.Annotations.ReadOnlyHint {
+		t.Error("kill_session must not be advertised when no alias allows it")
+	}
+
+	rw := s.dynamicAliases["RW"]
+	rw.AllowKill = true
+	s.dynamicAliases["RW"] = rw
+	tool = activity()
+	if _, ok := tool.InputSchema.Properties["session_id"]; !ok || !*tool.Annotations.DestructiveHint {
+		t.Error("kill_session should be advertised, and the tool marked destructive, when an alias allows it")
+	}
+}
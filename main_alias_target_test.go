@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// newAliasTargetTestServer returns a dynamic-mode server with a writable
+// alias (RW) and a read-only alias (RO). Both have a pool pre-seeded with
+// sql.Open placeholders, which never dial, so no SQL Server is needed.
+func newAliasTargetTestServer(t *testing.T) *MCPMSSQLServer {
+	t.Helper()
+	s := newTestMCPServer()
+	s.isDynamic = true
+	s.config = serverConfig{readOnly: true}
+	s.dynamicAliases = map[string]DynamicAlias{
+		"RW": {Server: "rw.local", Database: "RW", ReadOnly: false, WhitelistTables: []string{"temp_ai"}},
+		"RO": {Server: "ro.local", Database: "RO", ReadOnly: true},
+	}
+	s.connections = map[string]*sql.DB{}
+	for name := range s.dynamicAliases {
+		db, err := sql.Open("sqlserver", "server=127.0.0.1;database=x")
+		if err != nil {
+			t.Fatalf("sql.Open: %v", err)
+		}
+		t.Cleanup(func() { _ = db.Close() })
+		s.connections[name] = db
+	}
+	return s
+}
+
+func TestResolveTargetUsesAliasPoolAndPosture(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	if err := s.connectToDynamicAlias("rw"); err != nil {
+		t.Fatalf("connectToDynamicAlias: %v", err)
+	}
+
+	target, err := s.resolveTarget(map[string]interface{}{"alias": " ro "})
+	if err != nil {
+		t.Fatalf("resolveTarget: %v", err)
+	}
+	if target.alias != "RO" || target.db != s.connections["RO"] {
+		t.Errorf("expected RO pool, got alias %q", target.alias)
+	}
+	if !target.config.readOnly {
+		t.Error("per-call alias must apply that alias's read-only posture")
+	}
+	if s.activeAlias != "RW" || s.getDB() != s.connections["RW"] {
+		t.Error("per-call alias must not change the active connection")
+	}
+
+	target, err = s.resolveTarget(map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("resolveTarget without alias: %v", err)
+	}
+	if target.alias != "RW" || target.config.readOnly {
+		t.Errorf("default target should be the active writable alias, got %q (readOnly=%v)", target.alias, target.config.readOnly)
+	}
+}
+
+func TestResolveTargetErrors(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	if _, err := s.resolveTarget(map[string]interface{}{"alias": "MISSING"}); err == nil {
+		t.Error("expected error for unknown alias")
+	}
+	if _, err := s.resolveTarget(map[string]interface{}{}); err == nil {
+		t.Error("expected error when no alias is given and nothing is active")
+	}
+
+	classic := newTestMCPServer()
+	if _, err := classic.resolveTarget(map[string]interface{}{"alias": "RW"}); err == nil ||
+		!strings.Contains(err.Error(), "dynamic") {
+		t.Errorf("expected dynamic-mode error in classic mode, got %v", err)
+	}
+}
+
+func TestExecuteSecureQueryOnAppliesTargetPosture(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	if err := s.connectToDynamicAlias("RW"); err != nil {
+		t.Fatalf("connectToDynamicAlias: %v", err)
+	}
+
+	// The active alias is writable, but the explicit target is read-only:
+	// the write must be refused before anything reaches the pool.
+	target, err := s.resolveTarget(map[string]interface{}{"alias": "RO"})
+	if err != nil {
+		t.Fatalf("resolveTarget: %v", err)
+	}
+	_, err = s.executeSecureQueryOn(context.Background(), target, "DELETE FROM temp_ai WHERE id = 1")
+	if err == nil || !strings.Contains(strings.ToLower(err.Error()), "read-only") {
+		t.Errorf("expected read-only violation on RO alias, got %v", err)
+	}
+}
+
+func TestConnectToDynamicAliasKeepsOtherPoolsOpen(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	if err := s.connectToDynamicAlias("RW"); err != nil {
+		t.Fatalf("connectToDynamicAlias RW: %v", err)
+	}
+	if err := s.connectToDynamicAlias("RO"); err != nil {
+		t.Fatalf("connectToDynamicAlias RO: %v", err)
+	}
+	if _, ok := s.connections["RW"]; !ok {
+		t.Error("switching the active alias must keep the previous pool open")
+	}
+	if s.activeAlias != "RO" || s.getDB() != s.connections["RO"] {
+		t.Error("RO should be the active connection")
+	}
+}
+
+func TestDynamicDisconnectNamedAlias(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	if err := s.connectToDynamicAlias("RW"); err != nil {
+		t.Fatalf("connectToDynamicAlias: %v", err)
+	}
+
+	resp := s.handleToolCall("t", CallToolParams{Name: "dynamic_disconnect", Arguments: map[string]interface{}{"alias": "ro"}})
+	if result := resp.Result.(CallToolResult); result.IsError {
+		t.Fatalf("dynamic_disconnect returned error: %v", result.Content)
+	}
+	if _, ok := s.connections["RO"]; ok {
+		t.Error("named alias pool should be closed")
+	}
+	if s.activeAlias != "RW" || s.getDB() == nil {
+		t.Error("closing a non-active alias must not touch the active connection")
+	}
+}
+
+func TestAliasArgumentAdvertisedOnlyInDynamicMode(t *testing.T) {
+	hasAlias := func(s *MCPMSSQLServer, name string) bool {
+		for _, tool := range s.listTools() {
+			if tool.Name == name {
+				_, ok := tool.InputSchema.Properties["alias"]
+				return ok
+			}
+		}
+		t.Fatalf("tool %s not listed", name)
+		return false
+	}
+
+	dynamic := newAliasTargetTestServer(t)
+	classic := newTestMCPServer()
+	for _, name := range []string{"query_database", "explore", "inspect", "explain_query"} {
+		if !hasAlias(dynamic, name) {
+			t.Errorf("%s should accept 'alias' in dynamic mode", name)
+		}
+		if hasAlias(classic, name) {
+			t.Errorf("%s should not advertise 'alias' in classic mode", name)
+		}
+	}
+}
+
+// TestConfirmationIsBoundToAlias checks that a confirmation obtained on one
+// alias does not authorise the same statement on another alias.
+func TestConfirmationIsBoundToAlias(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	s.dynamicAliases["RW2"] = DynamicAlias{Server: "rw2.local", Database: "RW2", WhitelistTables: []string{"temp_ai"}}
+	s.connections["RW2"] = s.connections["RW"]
+	const query = "DELETE FROM temp_ai WHERE id = 1"
+	check := func(alias string) error {
+		t.Helper()
+		target, err := s.resolveTarget(map[string]interface{}{"alias": alias})
+		if err != nil {
+			t.Fatalf("resolveTarget(%s): %v", alias, err)
+		}
+		return s.confirmationPolicy(target, query)
+	}
+	confirm := func() {
+		t.Helper()
+		resp := s.handleToolCall(1, CallToolParams{
+			Name:      "confirm_operation",
+			Arguments: map[string]interface{}{"description": s.pendingConfirmation.Description},
+		})
+		if r := resp.Result.(CallToolResult); r.IsError {
+			t.Fatalf("confirm_operation: %+v", r)
+		}
+	}
+
+	if err := check("RW"); !errors.Is(err, errConfirmationRequired) {
+		t.Fatalf("expected a confirmation request, got %v", err)
+	}
+	if !strings.Contains(s.pendingConfirmation.Description, "(alias RW)") {
+		t.Errorf("the description must name the alias: %q", s.pendingConfirmation.Description)
+	}
+	confirm()
+	if err := check("RW2"); !errors.Is(err, errConfirmationRequired) {
+		t.Errorf("a confirmation on RW must not authorise RW2, got %v", err)
+	}
+
+	if err := check("RW"); !errors.Is(err, errConfirmationRequired) {
+		t.Fatalf("expected a confirmation request, got %v", err)
+	}
+	confirm()
+	if err := check("RW"); err != nil {
+		t.Errorf("the confirmed alias should run the statement, got %v", err)
+	}
+
+	_ = s.requireConfirmationForModification("RW", "KILL", []string{"session 57"})
+	confirm()
+	if s.isOperationConfirmed("RW2", "KILL", []string{"session 57"}) {
+		t.Error("KILL confirmed on RW must not kill the session on RW2")
+	}
+	if !s.isOperationConfirmed("RW", "KILL", []string{"session 57"}) {
+		t.Error("KILL confirmed on RW should stay usable on RW")
+	}
+}
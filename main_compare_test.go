@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRowDifferCountsAndSamples(t *testing.T) {
+	d := newRowDiffer([]string{"id"}, 10)
+	if err := d.setLeftColumns([]string{"id", "name", "legacy"}); err != nil {
+		t.Fatalf("setLeftColumns: %v", err)
+	}
+	d.addLeft([]interface{}{int64(1), "alice", "x"})
+	d.addLeft([]interface{}{int64(2), "bob", "x"})
+	d.addLeft([]interface{}{int64(3), nil, "x"})
+	d.addLeft([]interface{}{int64(3), "dup", "x"})
+
+	if err := d.setRightColumns([]string{"ID", "Name", "added"}); err != nil {
+		t.Fatalf("setRightColumns: %v", err)
+	}
+	d.addRight([]interface{}{int32(1), []byte("alice"), "y"}) // same values, different driver types
+	d.addRight([]interface{}{int64(2), "robert", "y"})
+	d.addRight([]interface{}{int64(3), "carol", "y"}) // NULL -> value is a change
+	d.addRight([]interface{}{int64(4), "dave", "y"})
+
+	r := d.finish()
+	if r.LeftRows != 4 || r.RightRows != 4 {
+		t.Errorf("row counts = %d/%d, want 4/4", r.LeftRows, r.RightRows)
+	}
+	if r.Identical != 1 || r.Changed != 2 || r.OnlyLeft != 0 || r.OnlyRight != 1 {
+		t.Errorf("identical=%d changed=%d onlyLeft=%d onlyRight=%d, want 1/2/0/1", r.Identical, r.Changed, r.OnlyLeft, r.OnlyRight)
+	}
+	if r.DuplicateKeysLeft != 1 {
+		t.Errorf("duplicate left keys = %d, want 1", r.DuplicateKeysLeft)
+	}
+	if len(r.ColumnsOnlyLeft) != 1 || r.ColumnsOnlyLeft[0] != "legacy" || len(r.ColumnsOnlyRight) != 1 || r.ColumnsOnlyRight[0] != "added" {
+		t.Errorf("column differences = %v / %v", r.ColumnsOnlyLeft, r.ColumnsOnlyRight)
+	}
+	if len(r.SampleChanged) != 2 || len(r.SampleChanged[0].Changes) != 1 || r.SampleChanged[0].Changes[0].Column != "name" {
+		t.Errorf("unexpected changed samples: %+v", r.SampleChanged)
+	}
+	if len(r.SampleOnlyRight) != 1 || r.SampleOnlyRight[0]["Name"] != "dave" {
+		t.Errorf("unexpected only-right sample: %+v", r.SampleOnlyRight)
+	}
+}
+
+func TestRowDifferOnlyLeftAndSampleLimit(t *testing.T) {
+	d := newRowDiffer([]string{"a", "b"}, 2)
+	if err := d.setLeftColumns([]string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		d.addLeft([]interface{}{int64(i), "k"})
+	}
+	if err := d.setRightColumns([]string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	d.addRight([]interface{}{int64(0), "k"})
+	r := d.finish()
+	if r.OnlyLeft != 4 || len(r.SampleOnlyLeft) != 2 {
+		t.Errorf("onlyLeft=%d samples=%d, want 4/2", r.OnlyLeft, len(r.SampleOnlyLeft))
+	}
+	if r.SampleOnlyLeft[0]["a"] != int64(1) {
+		t.Errorf("only-left samples should keep left order, got %v", r.SampleOnlyLeft[0])
+	}
+}
+
+func TestRowDifferMissingKeyColumn(t *testing.T) {
+	d := newRowDiffer([]string{"id"}, 10)
+	if err := d.setLeftColumns([]string{"code", "name"}); err == nil {
+		t.Error("expected error when key column is missing")
+	}
+}
+
+func TestCompareSource(t *testing.T) {
+	s := newTestMCPServer()
+	tests := []struct {
+		name    string
+		args    map[string]interface{}
+		want    string
+		wantErr bool
+	}{
+		{"table default schema", map[string]interface{}{"table": "Orders"}, "SELECT * FROM [dbo].[Orders]", false},
+		{"qualified table", map[string]interface{}{"table": "[sales].[Orders]"}, "SELECT * FROM [sales].[Orders]", false},
+		{"schema argument", map[string]interface{}{"table": "Orders", "schema": "hist"}, "SELECT * FROM [hist].[Orders]", false},
+		{"query", map[string]interface{}{"query": "SELECT id FROM t"}, "SELECT id FROM t", false},
+		{"injection in table", map[string]interface{}{"table": "Orders]; DROP TABLE x--"}, "", true},
+		{"three-part name", map[string]interface{}{"table": "db.dbo.Orders"}, "", true},
+		{"both", map[string]interface{}{"query": "SELECT 1", "table": "t"}, "", true},
+		{"neither", map[string]interface{}{}, "", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := s.compareSource(tc.args)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCompareToolArgumentErrors(t *testing.T) {
+	classic := newTestMCPServer()
+	resp := classic.handleToolCall("c", CallToolParams{Name: "compare", Arguments: map[string]interface{}{}})
+	if result := resp.Result.(CallToolResult); !result.IsError || !strings.Contains(result.Content[0].Text, "dynamic") {
+		t.Errorf("compare must be refused in classic mode, got %+v", result)
+	}
+
+	s := newAliasTargetTestServer(t)
+	cases := []map[string]interface{}{
+		{"right_alias": "RO", "key_columns": "id", "table": "t"},
+		{"left_alias": "RW", "right_alias": "RO", "table": "t"},
+		{"left_alias": "RW", "right_alias": "RO", "key_columns": "id;drop", "table": "t"},
+		{"left_alias": "RW", "right_alias": "MISSING", "key_columns": "id", "table": "t"},
+	}
+	for _, args := range cases {
+		resp := s.handleToolCall("c", CallToolParams{Name: "compare", Arguments: args})
+		if result := resp.Result.(CallToolResult); !result.IsError {
+			t.Errorf("expected error for args %v", args)
+		}
+	}
+}
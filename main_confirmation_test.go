@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// confirmPending answers the pending confirmation request by echoing its
+// description, as a client would.
+func confirmPending(t *testing.T, s *MCPMSSQLServer) {
+	t.Helper()
+	if s.pendingConfirmation == nil {
+		t.Fatal("no pending confirmation")
+	}
+	resp := s.handleToolCall(1, CallToolParams{
+		Name:      "confirm_operation",
+		Arguments: map[string]interface{}{"description": s.pendingConfirmation.Description},
+	})
+	if r := resp.Result.(CallToolResult); r.IsError {
+		t.Fatalf("confirm_operation rejected: %s", r.Content[0].Text)
+	}
+}
+
+func TestWritableAliasDMLRequiresConfirmation(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	rw := &queryTarget{alias: "RW", config: serverConfig{readOnly: false}}
+	req := policyRequest{target: rw, query: "DELETE FROM temp_ai WHERE id = 1"}
+
+	stage, err := s.evaluatePolicy(req)
+	if !errors.Is(err, errConfirmationRequired) || stage != "confirm" {
+		t.Fatalf("DML on a writable alias should require confirmation, got %q %v", stage, err)
+	}
+	confirmPending(t, s)
+	if _, err := s.evaluatePolicy(req); err != nil {
+		t.Errorf("confirmed DML should be allowed: %v", err)
+	}
+	if _, err := s.evaluatePolicy(req); !errors.Is(err, errConfirmationRequired) {
+		t.Errorf("a confirmation must only authorise one statement, got %v", err)
+	}
+	if _, err := s.evaluatePolicy(policyRequest{target: rw, query: "SELECT * FROM temp_ai"}); err != nil {
+		t.Errorf("reads need no confirmation: %v", err)
+	}
+}
+
+// TestConfirmationCoversEveryStatement checks that a read leading the batch
+// does not hide the modifications after it.
+func TestConfirmationCoversEveryStatement(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	rw := &queryTarget{alias: "RW", config: serverConfig{readOnly: false}}
+	for query, operation := range map[string]string{
+		"SELECT 1; DELETE FROM temp_ai":                         "DELETE",
+		"SELECT 1 DELETE FROM temp_ai":                          "DELETE",
+		"SET XACT_ABORT ON UPDATE temp_ai SET note = 'x'":       "SET+UPDATE",
+		"SET NOCOUNT ON; SELECT * FROM temp_ai":                 "SET",
+		"EXEC('DELETE FROM temp_ai')":                           "EXEC",
+		"EXEC sp_executesql N'DELETE FROM temp_ai'":             "EXEC",
+		"(DELETE FROM temp_ai)":                                 "OTHER",
+		"SELECT * FROM temp_ai; INSERT INTO temp_ai VALUES (1)": "INSERT",
+	} {
+		err := s.confirmationPolicy(rw, query)
+		if !errors.Is(err, errConfirmationRequired) {
+			t.Errorf("%q should require confirmation, got %v", query, err)
+			continue
+		}
+		if got := s.pendingConfirmation.Operation; got != operation {
+			t.Errorf("%q: confirmation asked for %q, want %q", query, got, operation)
+		}
+	}
+	for _, query := range []string{
+		"SELECT * FROM temp_ai",
+		"SELECT 1 UNION ALL SELECT 2;",
+		"WITH c AS (SELECT 1 AS a) SELECT a FROM c",
+	} {
+		if err := s.confirmationPolicy(rw, query); err != nil {
+			t.Errorf("%q only reads: %v", query, err)
+		}
+	}
+}
+
+func TestConfirmationNotRequiredOutsideWritableAliases(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	query := "DELETE FROM temp_ai WHERE id = 1"
+	for name, target := range map[string]*queryTarget{
+		"classic full access":  {config: serverConfig{readOnly: false}},
+		"read-only whitelist":  {alias: "RO", config: serverConfig{readOnly: true, whitelistTables: []string{"temp_ai"}}},
+		"explicit transaction": {alias: "RW", config: serverConfig{readOnly: false}, tx: &pinnedTx{alias: "RW"}},
+	} {
+		if err := s.confirmationPolicy(target, query); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if s.pendingConfirmation != nil {
+		t.Error("no confirmation should have been requested")
+	}
+}
+
+func TestConfirmationMustCoverEveryTable(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	rw := &queryTarget{alias: "RW", config: serverConfig{readOnly: false}}
+	wider := "DELETE FROM temp_ai WHERE id IN (SELECT id FROM users)"
+	if got := s.extractAllTablesFromQuery(wider); len(got) != 2 {
+		t.Fatalf("expected two tables in %q, got %v", wider, got)
+	}
+
+	if err := s.confirmationPolicy(rw, "DELETE FROM temp_ai WHERE id = 1"); !errors.Is(err, errConfirmationRequired) {
+		t.Fatalf("expected a confirmation request, got %v", err)
+	}
+	confirmPending(t, s)
+	if err := s.confirmationPolicy(rw, wider); !errors.Is(err, errConfirmationRequired) {
+		t.Errorf("a DELETE confirmed on temp_ai must not authorise one touching users too, got %v", err)
+	}
+
+	// The request for the wider statement names both tables and, once
+	// confirmed, covers it.
+	confirmPending(t, s)
+	if err := s.confirmationPolicy(rw, wider); err != nil {
+		t.Errorf("a confirmation naming both tables should cover the statement: %v", err)
+	}
+}
+
+func TestConfirmedTablesCover(t *testing.T) {
+	cases := []struct {
+		confirmed, tables []string
+		want              bool
+	}{
+		{[]string{"a"}, []string{"a"}, true},
+		{[]string{"a", "b"}, []string{"B"}, true},
+		{[]string{"a"}, []string{"a", "b"}, false},
+		{[]string{"a"}, nil, false},
+		{nil, nil, true},
+		{nil, []string{"a"}, false},
+	}
+	for _, c := range cases {
+		if got := confirmedTablesCover(c.confirmed, c.tables); got != c.want {
+			t.Errorf("confirmedTablesCover(%v, %v) = %v, want %v", c.confirmed, c.tables, got, c.want)
+		}
+	}
+}
+
+func TestMentionsKeyMatchesWholeKeys(t *testing.T) {
+	cases := []struct {
+		desc, key string
+		want      bool
+	}{
+		{"kill session 5", "session 5", true},
+		{"kill session 55", "session 5", false},
+		{"delete on dbo.orders (alias rw)", "dbo.orders", true},
+		{"delete on dbo.orders_archive", "dbo.orders", false},
+		{"delete from orders, then orders_archive", "orders", true},
+		{"undeleted rows", "delete", false},
+		{"anything", "", false},
+	}
+	for _, tc := range cases {
+		if got := mentionsKey(tc.desc, tc.key); got != tc.want {
+			t.Errorf("mentionsKey(%q, %q) = %v, want %v", tc.desc, tc.key, got, tc.want)
+		}
+	}
+}
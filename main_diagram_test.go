@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// diagramTestSnapshot models:
+//
+//	Customers <- Orders -> Addresses (nullable BillAddressID)
+//	Orders <- OrderLines -> Products, Orders <- Invoices (one per order)
+//	Employees -> Employees (ManagerID), sales.Orders -> dbo.Customers
+func diagramTestSnapshot() *schemaSnapshot {
+	snap := &schemaSnapshot{Tables: map[string]*tableSchema{}}
+	table := func(key string, columns ...string) *tableSchema {
+		t := &tableSchema{Columns: map[string]columnSchema{}, Indexes: map[string]indexSchema{}, ForeignKeys: map[string]foreignKeySchema{}}
+		for i, c := range columns {
+			nullable := strings.HasSuffix(c, "?")
+			t.Columns[strings.TrimSuffix(c, "?")] = columnSchema{DataType: "int", Nullable: nullable, Position: i + 1}
+		}
+		t.Indexes["PK_"+key] = indexSchema{Type: "CLUSTERED", Unique: true, PrimaryKey: true, Columns: strings.TrimSuffix(columns[0], "?")}
+		snap.Tables[key] = t
+		return t
+	}
+	fk := func(t *tableSchema, name, cols, parent, parentCols string) {
+		t.ForeignKeys[name] = foreignKeySchema{Columns: cols, ReferencedTable: parent, ReferencedColumns: parentCols}
+	}
+	table("dbo.Customers", "CustomerID", "Name?").Columns["Name"] = columnSchema{DataType: "nvarchar(100)", Nullable: true, Position: 2}
+	table("dbo.Addresses", "AddressID")
+	table("dbo.Products", "ProductID")
+	orders := table("dbo.Orders", "OrderID", "CustomerID", "ShipAddressID", "BillAddressID?")
+	fk(orders, "FK_Orders_Customers", "CustomerID", "dbo.Customers", "CustomerID")
+	fk(orders, "FK_Orders_Ship", "ShipAddressID", "dbo.Addresses", "AddressID")
+	fk(orders, "FK_Orders_Bill", "BillAddressID", "dbo.Addresses", "AddressID")
+	lines := table("dbo.OrderLines", "OrderID", "LineNo", "ProductID")
+	lines.Indexes["PK_dbo.OrderLines"] = indexSchema{Unique: true, PrimaryKey: true, Columns: "OrderID, LineNo"}
+	fk(lines, "FK_Lines_Orders", "OrderID", "dbo.Orders", "OrderID")
+	fk(lines, "FK_Lines_Products", "ProductID", "dbo.Products", "ProductID")
+	invoices := table("dbo.Invoices", "InvoiceID", "OrderID")
+	invoices.Indexes["UX_Invoices_Order"] = indexSchema{Unique: true, Columns: "OrderID DESC"}
+	fk(invoices, "FK_Invoices_Orders", "OrderID", "dbo.Orders", "OrderID")
+	employees := table("dbo.Employees", "EmployeeID", "ManagerID?")
+	fk(employees, "FK_Employees_Manager", "ManagerID", "dbo.Employees", "EmployeeID")
+	fk(table("sales.Orders", "OrderID", "CustomerID"), "FK_SalesOrders_Customers", "CustomerID", "dbo.Customers", "CustomerID")
+	return snap
+}
+
+func TestDiagramSelection(t *testing.T) {
+	snap := diagramTestSnapshot()
+	cases := []struct {
+		schema string
+		tables []string
+		center string
+		hops   int
+		want   string
+	}{
+		{schema: "SALES", want: "sales.Orders"},
+		{tables: []string{"orderlines", "dbo.Products"}, want: "dbo.OrderLines,dbo.Products"},
+		{center: "Products", hops: 1, want: "dbo.OrderLines,dbo.Products"},
+		{center: "Products", hops: 2, want: "dbo.OrderLines,dbo.Orders,dbo.Products"},
+		{center: "Customers", hops: 1, want: "dbo.Customers,dbo.Orders,sales.Orders"},
+		{center: "Employees", hops: 3, want: "dbo.Employees"},
+		{center: "Addresses", hops: 0, want: "dbo.Addresses"},
+	}
+	for _, c := range cases {
+		keys, err := selectDiagramTables(snap, c.schema, c.tables, c.center, c.hops)
+		if err != nil {
+			t.Errorf("%+v: %v", c, err)
+			continue
+		}
+		if got := strings.Join(keys, ","); got != c.want {
+			t.Errorf("%+v: got %s", c, got)
+		}
+	}
+
+	for _, c := range []struct {
+		tables []string
+		want   string
+	}{
+		{[]string{"Orders"}, ""},
+		{[]string{"Nope"}, "Nope not found"},
+		{[]string{"sales.Customers"}, "sales.Customers not found"},
+	} {
+		keys, err := selectDiagramTables(snap, "", c.tables, "", 0)
+		if c.want == "" {
+			if err != nil || len(keys) != 1 || keys[0] != "dbo.Orders" {
+				t.Errorf("an unqualified name prefers dbo: %v %v", keys, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%v: got %v, want %q", c.tables, err, c.want)
+		}
+	}
+	delete(snap.Tables, "dbo.Orders")
+	if _, err := selectDiagramTables(snap, "", []string{"Orders"}, "", 0); err != nil {
+		t.Errorf("a name unique across schemas resolves: %v", err)
+	}
+	snap.Tables["audit.Orders"] = &tableSchema{}
+	if _, err := selectDiagramTables(snap, "", []string{"Orders"}, "", 0); err == nil || !strings.Contains(err.Error(), "several schemas") {
+		t.Errorf("ambiguous names should be refused, got %v", err)
+	}
+
+	big := &schemaSnapshot{Tables: map[string]*tableSchema{}}
+	for i := range maxDiagramTables + 1 {
+		big.Tables[fmt.Sprintf("dbo.T%02d", i)] = &tableSchema{}
+	}
+	if _, err := selectDiagramTables(big, "dbo", nil, "", 0); err == nil || !strings.Contains(err.Error(), "at most") {
+		t.Errorf("oversized selections should be refused, got %v", err)
+	}
+}
+
+func TestDiagramCardinality(t *testing.T) {
+	snap := diagramTestSnapshot()
+	keys, _ := selectDiagramTables(snap, "dbo", nil, "", 0)
+	d := buildDiagram(snap, keys, "all")
+	marks := map[string]string{}
+	for _, r := range d.relations {
+		marks[r.name] = r.parentMark() + "--" + r.childMark()
+	}
+	for name, want := range map[string]string{
+		"FK_Orders_Customers":  "||--o{",
+		"FK_Orders_Bill":       "|o--o{",
+		"FK_Invoices_Orders":   "||--o|",
+		"FK_Lines_Orders":      "||--o{",
+		"FK_Employees_Manager": "|o--o{",
+	} {
+		if marks[name] != want {
+			t.Errorf("%s: got %q, want %q", name, marks[name], want)
+		}
+	}
+	if len(d.relations) != 7 || d.outside != 0 {
+		t.Errorf("relations = %d, outside = %d", len(d.relations), d.outside)
+	}
+
+	d = buildDiagram(snap, []string{"dbo.OrderLines", "dbo.Orders"}, "keys")
+	if len(d.relations) != 1 || d.outside != 4 {
+		t.Errorf("foreign keys leaving the selection are counted: relations = %d, outside = %d", len(d.relations), d.outside)
+	}
+	lines := d.tables[0]
+	if lines.id != "OrderLines" || len(lines.columns) != 3 || !lines.columns[1].pk || lines.columns[1].fk || !lines.columns[2].fk {
+		t.Errorf("OrderLines = %+v", lines)
+	}
+	orders := d.tables[1]
+	if len(orders.columns) != 4 || orders.hidden != 0 {
+		t.Errorf("Orders has only key columns: %+v", orders)
+	}
+}
+
+func TestDiagramIdentifiers(t *testing.T) {
+	snap := diagramTestSnapshot()
+	snap.Tables["dbo.Order Notes"] = &tableSchema{Columns: map[string]columnSchema{"Note Text": {DataType: "nvarchar(max)", Position: 1}}}
+	d := buildDiagram(snap, []string{"dbo.Order Notes", "dbo.Orders", "sales.Orders"}, "none")
+	var ids []string
+	for _, tb := range d.tables {
+		ids = append(ids, tb.id)
+		if len(tb.columns) != 0 || tb.hidden == 0 {
+			t.Errorf("columns=none draws no columns: %+v", tb)
+		}
+	}
+	if got := strings.Join(ids, ","); got != "dbo_Order_Notes,dbo_Orders,sales_Orders" {
+		t.Errorf("ids across schemas = %s", got)
+	}
+	d = buildDiagram(snap, []string{"dbo.Order Notes"}, "all")
+	if got := renderMermaid(d); !strings.Contains(got, `nvarchar Note_Text "nvarchar(max) Note Text"`) {
+		t.Errorf("mermaid should keep the real type and name in the comment:\n%s", got)
+	}
+
+	wide := &schemaSnapshot{Tables: map[string]*tableSchema{"dbo.Wide": {Columns: map[string]columnSchema{}}}}
+	for i := range maxDiagramColumns + 5 {
+		wide.Tables["dbo.Wide"].Columns[fmt.Sprintf("c%02d", i)] = columnSchema{DataType: "int", Position: i + 1}
+	}
+	d = buildDiagram(wide, []string{"dbo.Wide"}, "all")
+	if len(d.tables[0].columns) != maxDiagramColumns || d.tables[0].hidden != 5 || d.tables[0].columns[0].name != "c00" {
+		t.Errorf("wide tables are capped in column order: %d drawn, %d hidden", len(d.tables[0].columns), d.tables[0].hidden)
+	}
+}
+
+func TestDiagramRenderers(t *testing.T) {
+	snap := diagramTestSnapshot()
+	d := buildDiagram(snap, []string{"dbo.Addresses", "dbo.Customers", "dbo.Orders"}, "all")
+
+	mermaid := renderMermaid(d)
+	for _, want := range []string{
+		"erDiagram\n",
+		"    Orders {\n        int OrderID PK\n        int CustomerID FK\n",
+		`        int BillAddressID FK "NULL"`,
+		`        nvarchar Name "nvarchar(100) NULL"`,
+		`    Customers ||--o{ Orders : "FK_Orders_Customers"`,
+		`    Addresses |o--o{ Orders : "FK_Orders_Bill"`,
+	} {
+		if !strings.Contains(mermaid, want) {
+			t.Errorf("mermaid should contain %q:\n%s", want, mermaid)
+		}
+	}
+
+	plantuml := renderPlantUML(d)
+	for _, want := range []string{
+		"@startuml\n",
+		"entity \"dbo.Orders\" as Orders {\n  * OrderID : int <<PK>>\n  --\n  * CustomerID : int <<FK>>\n",
+		"  BillAddressID : int <<FK>>\n",
+		"Addresses |o--o{ Orders : FK_Orders_Bill\n",
+		"@enduml\n",
+	} {
+		if !strings.Contains(plantuml, want) {
+			t.Errorf("plantuml should contain %q:\n%s", want, plantuml)
+		}
+	}
+
+	dot := renderDOT(d)
+	for _, want := range []string{
+		"digraph ER {",
+		`<td port="c1" align="left">PK <u>OrderID : int</u> *</td>`,
+		`"dbo.Orders":c2 -> "dbo.Customers":c1 [arrowtail=crowodot, arrowhead=teetee, label="FK_Orders_Customers"];`,
+		`"dbo.Orders":c4 -> "dbo.Addresses":c1 [arrowtail=crowodot, arrowhead=teeodot, label="FK_Orders_Bill"];`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("dot should contain %q:\n%s", want, dot)
+		}
+	}
+}
+
+func TestDiagramSVG(t *testing.T) {
+	snap := diagramTestSnapshot()
+	snap.Tables["dbo.Customers"].Columns["Name"] = columnSchema{DataType: "nvarchar(100)", Nullable: true, Position: 2}
+	snap.Tables["dbo.R&D <Notes>"] = &tableSchema{Columns: map[string]columnSchema{"x": {DataType: "int", Position: 1}}}
+	keys, _ := selectDiagramTables(snap, "dbo", nil, "", 0)
+	d := buildDiagram(snap, keys, "all")
+	svg := renderSVG(d)
+
+	dec := xml.NewDecoder(strings.NewReader(svg))
+	paths, texts := 0, 0
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("svg is not well-formed: %v\n%s", err, svg)
+		}
+		if el, ok := tok.(xml.StartElement); ok {
+			switch el.Name.Local {
+			case "path":
+				paths++
+			case "text":
+				texts++
+			}
+		}
+	}
+	// 3 marker shapes plus one line per relationship.
+	if paths != 3+len(d.relations) {
+		t.Errorf("paths = %d, want %d", paths, 3+len(d.relations))
+	}
+	if !strings.Contains(svg, "R&amp;D &lt;Notes&gt;") || !strings.Contains(svg, "<title>FK_Employees_Manager</title>") {
+		t.Error("svg should escape names and title each relationship")
+	}
+
+	level := diagramLevels(d)
+	byKey := map[string]*diagramTable{}
+	for _, tb := range d.tables {
+		byKey[tb.key] = tb
+	}
+	if level[byKey["dbo.Customers"]] != 0 || level[byKey["dbo.Orders"]] != 1 || level[byKey["dbo.OrderLines"]] != 2 || level[byKey["dbo.Employees"]] != 0 {
+		t.Errorf("referenced tables go left of the tables that reference them: %v", level)
+	}
+}
+
+func TestDiagramContent(t *testing.T) {
+	data, _ := json.Marshal([]ContentItem{{Type: "text"}, {Type: "image", Data: "PHN2Zy8+", MimeType: "image/svg+xml"}})
+	if want := `[{"type":"text","text":""},{"type":"image","data":"PHN2Zy8+","mimeType":"image/svg+xml"}]`; string(data) != want {
+		t.Errorf("got  %s\nwant %s", data, want)
+	}
+
+	s := newAliasTargetTestServer(t)
+	cases := []struct {
+		args map[string]interface{}
+		want string
+	}{
+		{map[string]interface{}{"alias": "RO"}, "exactly one of"},
+		{map[string]interface{}{"alias": "RO", "schema": "dbo", "table": "Orders"}, "exactly one of"},
+		{map[string]interface{}{"alias": "RO", "schema": "dbo", "format": "png"}, "invalid format"},
+		{map[string]interface{}{"alias": "RO", "schema": "dbo", "columns": "some"}, "invalid columns"},
+		{map[string]interface{}{"alias": "RO", "schema": "dbo", "hops": float64(2)}, "only applies"},
+		{map[string]interface{}{"alias": "RO", "schema": "x;DROP"}, "invalid schema"},
+	}
+	for _, c := range cases {
+		result := s.handleDiagram(1, c.args).Result.(CallToolResult)
+		if !result.IsError || !strings.Contains(result.Content[0].Text, c.want) {
+			t.Errorf("%v: got %+v, want an error about %q", c.args, result, c.want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func dmlGuardTestServer(t *testing.T, maxRows int, requireWhere bool) (*MCPMSSQLServer, *queryTarget) {
+	t.Helper()
+	s := newAliasTargetTestServer(t)
+	rw := s.dynamicAliases["RW"]
+	rw.MaxAffectedRows = maxRows
+	rw.RequireWhere = requireWhere
+	s.dynamicAliases["RW"] = rw
+	target, err := s.resolveTarget(map[string]interface{}{"alias": "RW"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target.tool = "query_database"
+	return s, target
+}
+
+func TestRequireWherePolicy(t *testing.T) {
+	s, target := dmlGuardTestServer(t, 0, true)
+	for _, q := range []string{
+		"DELETE FROM temp_ai",
+		"UPDATE temp_ai SET note = 'WHERE' -- WHERE id = 1",
+		"UPDATE temp_ai SET total = (SELECT MAX(x) FROM temp_ai WHERE id = 2)",
+		"DELETE FROM temp_ai WHERE id IN (1); DELETE FROM temp_ai",
+		"TRUNCATE TABLE temp_ai",
+		// The guards fail closed on statements they cannot see through.
+		"SET NOCOUNT ON DELETE FROM temp_ai",
+		"EXEC('DELETE FROM temp_ai')",
+		"EXEC sp_executesql N'DELETE FROM temp_ai'",
+		"(DELETE FROM temp_ai)",
+		"DELETE FROM temp_ai WHERE id = 1 DELETE FROM temp_ai",
+		"DECLARE @n int = 1 UPDATE temp_ai SET note = 'x'",
+		"UPDATE temp_ai SET note = 'x' WHERE id = 1 EXEC('DELETE FROM temp_ai')",
+		// MERGE's ON/USING cannot be checked for a row filter.
+		"MERGE temp_ai t USING temp_ai s ON 1 = 1 WHEN MATCHED THEN DELETE;",
+		"MERGE temp_ai t USING src s ON t.id = s.id WHEN MATCHED THEN UPDATE SET note = s.note WHEN NOT MATCHED THEN INSERT (id) VALUES (s.id);",
+	} {
+		err := s.enforcePolicy(policyRequest{target: target, query: q})
+		if err == nil || errors.Is(err, errConfirmationRequired) {
+			t.Errorf("%q should be refused by REQUIRE_WHERE, got %v", q, err)
+		}
+	}
+	for _, q := range []string{
+		"DELETE FROM temp_ai WHERE id = 1",
+		"update temp_ai set note = 'x' where id in (select id from temp_ai where note is null)",
+		"INSERT INTO temp_ai (id) VALUES (1)",
+		"INSERT INTO temp_ai (id) SELECT id FROM temp_ai UNION ALL SELECT 1",
+		"WITH old AS (SELECT id FROM temp_ai) DELETE FROM temp_ai WHERE id IN (SELECT id FROM old)",
+	} {
+		// Past the guard the writable alias asks for the usual confirmation.
+		if err := s.enforcePolicy(policyRequest{target: target, query: q}); !errors.Is(err, errConfirmationRequired) {
+			t.Errorf("%q should pass REQUIRE_WHERE, got %v", q, err)
+		}
+	}
+	if err := s.enforcePolicy(policyRequest{target: target, query: "SELECT * FROM temp_ai"}); err != nil {
+		t.Errorf("SELECT is not guarded: %v", err)
+	}
+}
+
+func TestMaxAffectedRowsGuard(t *testing.T) {
+	s, target := dmlGuardTestServer(t, 100, false)
+	for _, q := range []string{
+		"TRUNCATE TABLE temp_ai",
+		"UPDATE temp_ai SET a = 1; UPDATE temp_ai SET b = 2",
+		"SET NOCOUNT ON DELETE FROM temp_ai",
+		"EXEC('DELETE FROM temp_ai')",
+		"EXEC sp_executesql N'DELETE FROM temp_ai'",
+		"(DELETE FROM temp_ai)",
+	} {
+		err := s.enforcePolicy(policyRequest{target: target, query: q})
+		if err == nil || !strings.Contains(err.Error(), "MAX_AFFECTED_ROWS") {
+			t.Errorf("%q should be refused under MAX_AFFECTED_ROWS, got %v", q, err)
+		}
+	}
+	if err := s.enforcePolicy(policyRequest{target: target, query: "DELETE FROM temp_ai"}); !errors.Is(err, errConfirmationRequired) {
+		t.Errorf("DELETE without WHERE is only counted, got %v", err)
+	}
+	for _, q := range []string{"DELETE FROM temp_ai", "merge temp_ai t using s on t.id = s.id when matched then delete;", "INSERT INTO temp_ai (id) VALUES (1)"} {
+		if !s.maxAffectedRowsApplies(target, q) {
+			t.Errorf("%q should run under the limit", q)
+		}
+	}
+	if s.maxAffectedRowsApplies(target, "SELECT * FROM temp_ai") {
+		t.Error("SELECT is not counted")
+	}
+	if err := tooManyRows(target, 250); !strings.Contains(err.Error(), "250 rows") || !strings.Contains(err.Error(), "rolled back") {
+		t.Errorf("the error should report the rows that would have been touched: %v", err)
+	}
+
+	_, plain := dmlGuardTestServer(t, 0, false)
+	if s.maxAffectedRowsApplies(plain, "DELETE FROM temp_ai") {
+		t.Error("the limit is off by default")
+	}
+}
+
+func TestDMLGuardsFromEnv(t *testing.T) {
+	env := map[string]string{
+		"MSSQL_DYNAMIC_APP_SERVER":            "app.local",
+		"MSSQL_DYNAMIC_APP_DATABASE":          "App",
+		"MSSQL_DYNAMIC_APP_MAX_AFFECTED_ROWS": "500",
+		"MSSQL_DYNAMIC_APP_REQUIRE_WHERE":     "TRUE",
+		"MSSQL_DYNAMIC_BAD_SERVER":            "bad.local",
+		"MSSQL_DYNAMIC_BAD_DATABASE":          "Bad",
+		"MSSQL_DYNAMIC_BAD_MAX_AFFECTED_ROWS": "-3",
+	}
+	s := newTestMCPServer()
+	s.isDynamic = true
+	s.dynamicAliases = loadDynamicAliasesFromEnv(env, s.secLogger)
+	if cfg := s.getEffectiveConfigFor("APP"); cfg.maxAffectedRows != 500 || !cfg.requireWhere {
+		t.Errorf("APP guards = %d, %v", cfg.maxAffectedRows, cfg.requireWhere)
+	}
+	if cfg := s.getEffectiveConfigFor("BAD"); cfg.maxAffectedRows != 0 || cfg.requireWhere {
+		t.Errorf("invalid or unset guards should stay off, got %d, %v", cfg.maxAffectedRows, cfg.requireWhere)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func exportTestRows() ([]exportColumn, [][]interface{}) {
+	cols := exportColumns(
+		[]string{"id", "name", "", "amount", "paid", "due", "id"},
+		[]string{"INT", "NVARCHAR", "VARCHAR", "DECIMAL", "BIT", "DATE", "BIGINT"})
+	return cols, [][]interface{}{
+		{int64(1), "Ñandú, \"quoted\" <b>", "x", []byte("12.50"), true, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), int64(9007199254740993)},
+		{nil, nil, nil, nil, nil, nil, nil},
+	}
+}
+
+func writeExport(t *testing.T, format string) []byte {
+	t.Helper()
+	cols, rows := exportTestRows()
+	var buf bytes.Buffer
+	w, err := newExportWriter(format, &buf, cols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range rows {
+		if err := w.writeRow(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExportColumnsAreUnique(t *testing.T) {
+	cols, _ := exportTestRows()
+	var names []string
+	for _, c := range cols {
+		names = append(names, c.Name)
+	}
+	if got := strings.Join(names, ","); got != "id,name,column_3,amount,paid,due,id_2" {
+		t.Errorf("column names = %s", got)
+	}
+	if cols[3].Type != "decimal" {
+		t.Errorf("types should be lower case, got %q", cols[3].Type)
+	}
+}
+
+func TestExportCSVAndJSONL(t *testing.T) {
+	want := "id,name,column_3,amount,paid,due,id_2\n" +
+		"1,\"Ñandú, \"\"quoted\"\" <b>\",x,12.50,true,2026-03-01,9007199254740993\n" +
+		",,,,,,\n"
+	if got := string(writeExport(t, "csv")); got != want {
+		t.Errorf("csv:\n%s\nwant:\n%s", got, want)
+	}
+
+	want = `{"id":1,"name":"Ñandú, \"quoted\" <b>","column_3":"x","amount":"12.50","paid":true,"due":"2026-03-01","id_2":9007199254740993}` + "\n" +
+		`{"id":null,"name":null,"column_3":null,"amount":null,"paid":null,"due":null,"id_2":null}` + "\n"
+	if got := string(writeExport(t, "jsonl")); got != want {
+		t.Errorf("jsonl:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestExportXLSX(t *testing.T) {
+	data := writeExport(t, "xlsx")
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("not a zip file: %v", err)
+	}
+	parts := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(rc)
+		_ = rc.Close()
+		parts[f.Name] = string(body)
+
+		dec := xml.NewDecoder(bytes.NewReader(body))
+		for {
+			if _, err := dec.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s is not well-formed XML: %v", f.Name, err)
+			}
+		}
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml"} {
+		if _, ok := parts[name]; !ok {
+			t.Errorf("missing part %s", name)
+		}
+	}
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	for _, want := range []string{
+		`<c r="A1" t="inlineStr" s="3"><is><t xml:space="preserve">id</t></is></c>`,
+		`<c r="A2"><v>1</v></c>`,
+		`<t xml:space="preserve">Ñandú, &#34;quoted&#34; &lt;b&gt;</t>`,
+		`<c r="D2"><v>12.50</v></c>`,
+		`<c r="E2" t="b"><v>1</v></c>`,
+		`<c r="F2" s="1"><v>46082</v></c>`,
+		// 16 significant digits do not fit in an Excel number.
+		`<c r="G2" t="inlineStr"><is><t xml:space="preserve">9007199254740993</t></is></c>`,
+		`<row r="3"></row>`,
+	} {
+		if !strings.Contains(sheet, want) {
+			t.Errorf("sheet should contain %s:\n%s", want, sheet)
+		}
+	}
+	if xlsxColumnName(0) != "A" || xlsxColumnName(25) != "Z" || xlsxColumnName(26) != "AA" || xlsxColumnName(16383) != "XFD" {
+		t.Error("wrong column letters")
+	}
+}
+
+func TestExportParquetLayout(t *testing.T) {
+	data := writeExport(t, "parquet")
+	if !bytes.HasPrefix(data, []byte("PAR1")) || !bytes.HasSuffix(data, []byte("PAR1")) {
+		t.Fatal("missing Parquet magic")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := data[len(data)-8-footerLen : len(data)-8]
+	for _, name := range []string{"schema", "amount", "id_2", parquetCreatedBy} {
+		if !bytes.Contains(footer, []byte(name)) {
+			t.Errorf("footer should contain %q", name)
+		}
+	}
+	// The first column chunk starts right after the magic with a data page
+	// header: type DATA_PAGE (field 1, i32 0).
+	if data[4] != 0x15 || data[5] != 0x00 {
+		t.Errorf("unexpected page header start % x", data[4:8])
+	}
+
+	// One defined value then one null: two RLE runs of length 1.
+	if got := rleLevels([]bool{true, false}); !bytes.Equal(got, []byte{4, 0, 0, 0, 2, 1, 2, 0}) {
+		t.Errorf("definition levels = % x", got)
+	}
+	if got := packBools([]bool{true, false, true, true, false, false, false, false, true}); !bytes.Equal(got, []byte{0x0d, 0x01}) {
+		t.Errorf("packed booleans = % x", got)
+	}
+
+	var tw thriftWriter
+	tw.i32(1, 1)
+	tw.i64(20, -1)
+	tw.beginStruct(21)
+	tw.boolean(1, true)
+	tw.endStruct()
+	tw.stop()
+	want := []byte{0x15, 0x02, 0x06, 0x28, 0x01, 0x1c, 0x11, 0x00, 0x00}
+	if !bytes.Equal(tw.buf.Bytes(), want) {
+		t.Errorf("thrift compact = % x, want % x", tw.buf.Bytes(), want)
+	}
+}
+
+func TestExportSinkLimit(t *testing.T) {
+	var buf bytes.Buffer
+	cols, rows := exportTestRows()
+	sink := &exportSink{w: &buf, hash: sha256.New(), limit: 64}
+	w, err := newExportWriter("jsonl", sink, cols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.writeRow(rows[0])
+	if err == nil {
+		err = w.close()
+	}
+	if !errors.Is(err, errExportTooLarge) {
+		t.Errorf("writes past the limit should fail, got %v", err)
+	}
+	if sink.n > sink.limit {
+		t.Errorf("%d bytes written past the %d limit", sink.n, sink.limit)
+	}
+}
+
+func TestExportQueryRefusals(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("MSSQL_EXPORT_DIR", dir)
+	if err := os.WriteFile(filepath.Join(dir, "taken.csv"), []byte("x"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := newAliasTargetTestServer(t)
+	export := func(query, format, name string) error {
+		target, err := s.resolveTarget(map[string]interface{}{"alias": "RW"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		target.tool = "export_query"
+		_, err = s.exportQuery(t.Context(), target, query, nil, format, name, false)
+		return err
+	}
+	cases := []struct{ query, format, name, want string }{
+		{"SELECT 1", "pdf", "", "invalid format"},
+		{"SELECT 1", "csv", "../outside.csv", "invalid file name"},
+		{"SELECT 1", "csv", "taken", "already exists"},
+		{"SELECT 1", "parquet", "a.csv", "does not match format"},
+		{"DELETE FROM temp_ai", "csv", "", "read-only mode"},
+		{"SELECT 1; SELECT 2", "csv", "", "multiple SQL statements"},
+	}
+	for _, c := range cases {
+		if err := export(c.query, c.format, c.name); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%q as %s to %q: got %v, want %q", c.query, c.format, c.name, err, c.want)
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("refused exports must not leave files behind: %v", entries)
+	}
+}
+
+func TestExportQueryNeedsExportDir(t *testing.T) {
+	listed := func(s *MCPMSSQLServer) bool {
+		for _, tool := range s.listTools() {
+			if tool.Name == "export_query" {
+				return true
+			}
+		}
+		return false
+	}
+	s := newTestMCPServer()
+	if listed(s) {
+		t.Error("export_query must not be listed without MSSQL_EXPORT_DIR")
+	}
+	resp := s.handleToolCall(1, CallToolParams{Name: "export_query", Arguments: map[string]interface{}{"query": "SELECT 1"}})
+	if result := resp.Result.(CallToolResult); !result.IsError || !strings.Contains(result.Content[0].Text, "MSSQL_EXPORT_DIR") {
+		t.Errorf("export_query must be refused without MSSQL_EXPORT_DIR, got %+v", result)
+	}
+
+	env := map[string]string{"MSSQL_READ_ONLY": "true", "MSSQL_EXPORT_DIR": t.TempDir()}
+	s.config = buildServerConfig(func(k string) string { return env[k] }, false, s.secLogger)
+	if !listed(s) {
+		t.Error("export_query should be listed once MSSQL_EXPORT_DIR is set")
+	}
+}
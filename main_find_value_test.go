@@ -0,0 +1,161 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/shopspring/decimal"
+)
+
+func findValueTestColumns() []findValueColumn {
+	col := func(schema, table, name, typ string, length, key int) findValueColumn {
+		return findValueColumn{schema: schema, table: table, name: name, typ: queryParamType{base: typ, length: length, precision: 10, scale: 2}, keyOrdinal: key}
+	}
+	return []findValueColumn{
+		col("dbo", "Customers", "Code", "varchar", 10, 0),
+		col("dbo", "Customers", "Id", "int", 4, 1),
+		col("dbo", "Customers", "Name", "nvarchar", 100, 0),
+		col("dbo", "OrderLines", "OrderId", "int", 4, 1),
+		col("dbo", "OrderLines", "Line", "int", 4, 2),
+		col("dbo", "OrderLines", "Amount", "decimal", 9, 0),
+		col("sales", "Customers", "Ref", "uniqueidentifier", 16, 0),
+	}
+}
+
+func TestFindValueTables(t *testing.T) {
+	cols := findValueTestColumns()
+	tables, err := findValueTables(cols, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) != 3 {
+		t.Fatalf("expected 3 tables, got %d", len(tables))
+	}
+	if got := strings.Join(tables[1].key, ","); got != "OrderId,Line" {
+		t.Errorf("key columns should follow key order, got %s", got)
+	}
+	if len(tables[2].key) != 0 {
+		t.Errorf("sales.Customers has no primary key, got %v", tables[2].key)
+	}
+
+	tables, _ = findValueTables(cols, "", " customers ")
+	if len(tables) != 2 || tables[0].name() != "dbo.Customers" || tables[1].name() != "sales.Customers" {
+		t.Errorf("a table without schema should match it in every schema, got %d tables", len(tables))
+	}
+	tables, _ = findValueTables(cols, "SALES", "")
+	if len(tables) != 1 || tables[0].name() != "sales.Customers" {
+		t.Errorf("schemas filter: got %d tables", len(tables))
+	}
+	tables, _ = findValueTables(cols, "", "dbo.orderlines")
+	if len(tables) != 1 || len(tables[0].columns) != 3 {
+		t.Errorf("qualified table filter: got %+v", tables)
+	}
+	if _, err := findValueTables(cols, "", "Customers,Nope"); err == nil || !strings.Contains(err.Error(), "nope") {
+		t.Errorf("unknown tables should be refused, got %v", err)
+	}
+}
+
+func TestFindValuePredicate(t *testing.T) {
+	cols := findValueTestColumns()
+	code, id, name, amount, ref := cols[0], cols[1], cols[2], cols[5], cols[6]
+
+	cond, param, ok := findValuePredicate(code, "ABC123", "exact")
+	if !ok || cond != "[Code] = @p1" || param != mssql.VarChar("ABC123") {
+		t.Errorf("varchar exact: %q %#v %v", cond, param, ok)
+	}
+	if _, _, ok := findValuePredicate(code, "ABC123456789", "exact"); ok {
+		t.Error("a value longer than the column cannot match")
+	}
+	cond, param, ok = findValuePredicate(code, "50%_off[1]", "contains")
+	if !ok || cond != `[Code] LIKE @p1 ESCAPE '\'` || param != mssql.VarChar(`%50\%\_off\[1]%`) {
+		t.Errorf("contains should escape wildcards: %q %#v", cond, param)
+	}
+	if _, param, _ = findValuePredicate(code, "Ñandú", "prefix"); param != "Ñandú%" {
+		t.Errorf("non-ASCII text should be sent as nvarchar, got %#v", param)
+	}
+	if cond, param, ok = findValuePredicate(name, "A%z", "like"); !ok || cond != "[Name] LIKE @p1" || param != "A%z" {
+		t.Errorf("like pattern: %q %#v", cond, param)
+	}
+
+	if _, param, ok = findValuePredicate(id, "42", "exact"); !ok || param != int32(42) {
+		t.Errorf("int exact: %#v %v", param, ok)
+	}
+	for _, v := range []string{"ABC", "4.5", "99999999999", "1e3"} {
+		if _, _, ok := findValuePredicate(id, v, "exact"); ok {
+			t.Errorf("%q should not be searched in an int column", v)
+		}
+	}
+	if _, _, ok := findValuePredicate(id, "42", "contains"); ok {
+		t.Error("numeric columns are only searched with match=exact")
+	}
+	if _, param, ok = findValuePredicate(amount, "12.5", "exact"); !ok || param.(decimal.Decimal).String() != "12.5" {
+		t.Errorf("decimal exact: %#v %v", param, ok)
+	}
+	if _, _, ok := findValuePredicate(amount, "12.555", "exact"); ok {
+		t.Error("a value with more decimals than the column's scale cannot match")
+	}
+	if _, _, ok := findValuePredicate(ref, "6F9619FF-8B86-D011-B42D-00C04FC964FF", "exact"); !ok {
+		t.Error("GUID columns should be searched for GUID values")
+	}
+	if _, _, ok := findValuePredicate(ref, "ABC123", "exact"); ok {
+		t.Error("GUID columns should not be searched for other values")
+	}
+}
+
+func TestFindValueQuery(t *testing.T) {
+	tables, _ := findValueTables(findValueTestColumns(), "", "")
+	got := findValueQuery(tables[1], tables[1].columns[2], "[Amount] = @p1", false)
+	if want := "SELECT TOP (3) [OrderId], [Line] FROM [dbo].[OrderLines] WHERE [Amount] = @p1"; got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+	got = findValueQuery(tables[2], tables[2].columns[0], "[Ref] = @p1", false)
+	if want := "SELECT TOP (3) [Ref] FROM [sales].[Customers] WHERE [Ref] = @p1"; got != want {
+		t.Errorf("without a primary key the value itself is returned:\ngot  %s\nwant %s", got, want)
+	}
+}
+
+func TestFindValueBounds(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	target, err := s.resolveTarget(map[string]interface{}{"alias": "RO"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cols := findValueTestColumns()
+	cols[2].masked = true
+	tables, _ := findValueTables(cols, "", "")
+	// A used-up budget stops the search before any query is sent.
+	report := s.findValueOn(t.Context(), target, tables, "ABC", false, findValueOptions{match: "exact", maxMatches: 5, maxColumns: 1, rate: 10})
+	if report.ColumnsEligible != 1 || report.ColumnsSearched != 0 || report.Complete {
+		t.Errorf("report = %+v", report)
+	}
+	notes := strings.Join(report.Notes, "\n")
+	for _, want := range []string{"1 masked column", "time budget"} {
+		if !strings.Contains(notes, want) {
+			t.Errorf("notes should mention %q: %s", want, notes)
+		}
+	}
+
+	report = s.findValueOn(t.Context(), target, tables, "42", true, findValueOptions{match: "exact", maxMatches: 5, maxColumns: 2, rate: 10})
+	if report.ColumnsEligible != 6 || !strings.Contains(strings.Join(report.Notes, "\n"), "first 2 of 6") {
+		t.Errorf("max_columns: %+v", report)
+	}
+}
+
+func TestFindValueRefusals(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	cases := []struct {
+		args map[string]interface{}
+		want string
+	}{
+		{map[string]interface{}{"alias": "RO"}, "'value'"},
+		{map[string]interface{}{"alias": "RO", "value": "x", "match": "regex"}, "invalid match"},
+		{map[string]interface{}{"alias": "RO", "value": "%%", "match": "like"}, "only of wildcards"},
+	}
+	for _, c := range cases {
+		result := s.handleFindValue(1, c.args).Result.(CallToolResult)
+		if !result.IsError || !strings.Contains(result.Content[0].Text, c.want) {
+			t.Errorf("%v: got %+v, want an error about %q", c.args, result, c.want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNextBackoff(t *testing.T) {
+	max := 8 * time.Second
+	cases := []struct{ cur, want time.Duration }{
+		{0, minReconnectBackoff},
+		{time.Second, 2 * time.Second},
+		{4 * time.Second, 8 * time.Second},
+		{8 * time.Second, 8 * time.Second},
+	}
+	for _, tc := range cases {
+		if got := nextBackoff(tc.cur, max); got != tc.want {
+			t.Errorf("nextBackoff(%s) = %s, want %s", tc.cur, got, tc.want)
+		}
+	}
+}
+
+func TestRecordConnStateCollapsesAndBounds(t *testing.T) {
+	s := newTestMCPServer()
+
+	s.recordConnState(classicTarget, connStateUp, nil)
+	s.recordConnState(classicTarget, connStateUp, nil)
+	s.recordConnState(classicTarget, connStateDown, errString("password=hunter2 refused"))
+	s.recordConnState(classicTarget, connStateDown, nil)
+	s.recordConnState(classicTarget, connStateReconnected, nil)
+	s.recordConnState(classicTarget, connStateUp, nil) // first ping after reopen
+
+	history := s.connStateHistory()
+	if len(history) != 3 {
+		t.Fatalf("expected 3 transitions (up, down, reconnected), got %d: %+v", len(history), history)
+	}
+	if strings.Contains(history[1].Detail, "hunter2") {
+		t.Errorf("connection history must not keep credentials, got %q", history[1].Detail)
+	}
+
+	for i := 0; i < maxConnHistory*2; i++ {
+		s.recordConnState("ALIAS", connStateReconnected, nil)
+	}
+	if n := len(s.connStateHistory()); n != maxConnHistory {
+		t.Errorf("history should be bounded to %d, got %d", maxConnHistory, n)
+	}
+}
+
+func TestFormatConnStateHistory(t *testing.T) {
+	s := newTestMCPServer()
+	if out := s.formatConnStateHistory(10); out != "" {
+		t.Errorf("empty history should render nothing, got %q", out)
+	}
+	s.recordConnState(classicTarget, connStateDown, nil)
+	s.recordConnState("CRM", connStateUp, nil)
+
+	out := s.formatConnStateHistory(10)
+	for _, want := range []string{"Connection Health", "(classic): down", "CRM: up", "CRM -> up"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("health report missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestConnectClassicWithoutConfigFails(t *testing.T) {
+	t.Setenv("MSSQL_CONNECTION_STRING", "")
+	t.Setenv("MSSQL_SERVER", "")
+	s := newTestMCPServer()
+	if err := s.connectClassic(context.Background()); err == nil {
+		t.Fatal("expected connectClassic to fail without MSSQL_SERVER")
+	}
+	if s.getDB() != nil {
+		t.Error("failed connect must not install a pool")
+	}
+}
+
+func TestCheckPoolsMarksUnreachableAliasDown(t *testing.T) {
+	s := newTestMCPServer()
+	s.dynamicAliases = map[string]DynamicAlias{
+		"DEAD": {Alias: "DEAD", ConnectionString: "server=127.0.0.1;port=1;database=x;connection timeout=1"},
+	}
+	db, err := sql.Open("sqlserver", "server=127.0.0.1;port=1;database=x;connection timeout=1")
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	s.connections = map[string]*sql.DB{"DEAD": db}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	if s.checkPools(ctx, false) {
+		t.Fatal("expected unreachable pool to be reported unhealthy")
+	}
+	if s.connStates["DEAD"] != connStateDown {
+		t.Errorf("expected DEAD to be recorded as down, got %q", s.connStates["DEAD"])
+	}
+	if s.connections["DEAD"] != db {
+		t.Error("failed reopen must keep the existing pool in place")
+	}
+}
+
+func TestGetDatabaseInfoReportsConnectionHealth(t *testing.T) {
+	s := newTestMCPServer()
+	s.recordConnState(classicTarget, connStateDown, nil)
+	resp := s.handleToolCall(1, CallToolParams{Name: "get_database_info", Arguments: map[string]interface{}{}})
+	result, ok := resp.Result.(CallToolResult)
+	if !ok || len(result.Content) == 0 {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if !strings.Contains(result.Content[0].Text, "Connection Health") {
+		t.Errorf("get_database_info should include the connection state history:\n%s", result.Content[0].Text)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestSplitQualifiedName(t *testing.T) {
+	for name, want := range map[string]string{
+		"orders":              "[dbo].[orders]",
+		"sales.orders":        "[sales].[orders]",
+		"[sales].[orders]":    "[sales].[orders]",
+		" sales . orders ":    "[sales].[orders]",
+		"Ventas.Pedidos_2026": "[Ventas].[Pedidos_2026]",
+	} {
+		schema, table, err := splitQualifiedName(name, "dbo")
+		if err != nil || qualifiedTable(schema, table) != want {
+			t.Errorf("splitQualifiedName(%q) = %s, %v; want %s", name, qualifiedTable(schema, table), err, want)
+		}
+	}
+	for _, bad := range []string{"", "a.b.c", "orders; DROP TABLE x", "[dbo].[a]]b]", "1orders"} {
+		if _, _, err := splitQualifiedName(bad, "dbo"); err == nil {
+			t.Errorf("splitQualifiedName(%q) should fail", bad)
+		}
+	}
+}
+
+func TestToolArgHelpers(t *testing.T) {
+	if got := stringListArg("a, b,,c "); len(got) != 3 || got[2] != "c" {
+		t.Errorf("comma-separated list = %q", got)
+	}
+	if got := stringListArg([]interface{}{"a", " ", 3, "b"}); len(got) != 2 || got[1] != "b" {
+		t.Errorf("array list = %q", got)
+	}
+	args := map[string]interface{}{"n": float64(500), "neg": float64(-1)}
+	if intArg(args, "n", 10, 100) != 100 || intArg(args, "neg", 10, 100) != 10 || intArg(args, "missing", 10, 100) != 10 {
+		t.Error("intArg should default and clamp")
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// importTestTable is dbo.temp_ai as importColumnsQuery would describe it.
+func importTestTable() []importColumn {
+	col := func(name, dataType string, maxLength, precision, scale int, nullable, hasDefault, identity bool) importColumn {
+		c := newImportColumn(name, dataType, maxLength, precision, scale)
+		c.nullable, c.hasDefault, c.identity = nullable, hasDefault, identity
+		return c
+	}
+	return []importColumn{
+		col("row_id", "int", 0, 10, 0, false, false, true),
+		col("Code", "varchar", 3, 0, 0, false, false, false),
+		col("name", "nvarchar", 20, 0, 0, true, false, false),
+		col("amount", "decimal", 0, 5, 2, true, false, false),
+		col("big", "bigint", 0, 19, 0, true, false, false),
+		col("active", "bit", 0, 0, 0, false, true, false),
+		col("since", "date", 0, 0, 0, true, false, false),
+		col("ref", "uniqueidentifier", 0, 0, 0, true, false, false),
+	}
+}
+
+func writeImportFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMapImportColumns(t *testing.T) {
+	table := importTestTable()
+	cols, defaulted, err := mapImportColumns([]string{"code", "NAME", "big"}, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cols[0].Name != "Code" || cols[0].Type != "varchar(3)" || cols[2].Type != "bigint" {
+		t.Errorf("columns = %+v", cols)
+	}
+	if got := strings.Join(defaulted, ","); got != "row_id,amount,active,since,ref" {
+		t.Errorf("defaulted columns = %s", got)
+	}
+
+	for _, c := range []struct {
+		header []string
+		want   string
+	}{
+		{[]string{"code", "color"}, "no column color"},
+		{[]string{"code", "row_id"}, "identity column"},
+		{[]string{"code", "Code"}, "appears twice"},
+		{[]string{"name"}, "no column Code, which cannot be NULL"},
+		{[]string{"code", ""}, "has no name"},
+	} {
+		if _, _, err := mapImportColumns(c.header, table); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%v: got %v, want %q", c.header, err, c.want)
+		}
+	}
+}
+
+func TestImportValue(t *testing.T) {
+	byName := map[string]importColumn{}
+	for _, c := range importTestTable() {
+		byName[c.Name] = c
+	}
+	valid := []struct {
+		col  string
+		in   interface{}
+		want interface{}
+	}{
+		{"big", "9007199254740993", int64(9007199254740993)},
+		{"row_id", "42", int64(42)},
+		{"amount", "123.45", "123.45"},
+		{"active", "1", true},
+		{"active", true, true},
+		{"Code", "ES", "ES"},
+		{"name", nil, nil},
+		{"since", "2026-03-01", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range valid {
+		got, err := importValue(byName[c.col], c.in)
+		if err != nil || got != c.want {
+			t.Errorf("%s %v = %#v, %v; want %#v", c.col, c.in, got, err, c.want)
+		}
+	}
+	ref, err := importValue(byName["ref"], "6F9619FF-8B86-D011-B42D-00C04FC964FF")
+	if b, ok := ref.([]byte); err != nil || !ok || len(b) != 16 || b[0] != 0xFF {
+		t.Errorf("GUIDs are sent as their 16 bytes in SQL Server order, got % x, %v", ref, err)
+	}
+
+	latin1, utf8Col := byName["Code"], byName["Code"]
+	latin1.codePage, utf8Col.codePage = 1252, 65001
+	if got, err := importValue(latin1, "Añ€"); err != nil || got != "A\xf1\x80" {
+		t.Errorf("varchar text is sent in the column's code page, got %q, %v", got, err)
+	}
+	if _, err := importValue(latin1, "Ωμ"); err == nil {
+		t.Error("characters outside code page 1252 should be refused")
+	}
+	if _, err := importValue(byName["Code"], "Añ"); err == nil || !strings.Contains(err.Error(), "code page 0") {
+		t.Errorf("non-ASCII text for other code pages should be refused, got %v", err)
+	}
+	if _, err := importValue(utf8Col, "Añb"); err == nil || !strings.Contains(err.Error(), "4 bytes") {
+		t.Errorf("UTF-8 columns are measured in bytes, got %v", err)
+	}
+
+	for _, c := range []struct {
+		col string
+		in  interface{}
+	}{
+		{"Code", "SPAIN"},
+		{"Code", nil},
+		{"amount", "1234.5"},
+		{"amount", "1.234"},
+		{"big", "1.5"},
+		{"active", "maybe"},
+		{"since", "01/03/2026"},
+		{"ref", "not-a-guid"},
+	} {
+		if _, err := importValue(byName[c.col], c.in); err == nil {
+			t.Errorf("%s %v should be refused", c.col, c.in)
+		}
+	}
+}
+
+func TestScanImportCSV(t *testing.T) {
+	path := writeImportFile(t, "countries.csv", "\ufeffcode;name;amount;since\n"+
+		"ES;España;10.50;2026-01-01\n"+
+		"FR;\"France; la\";;\n"+
+		"TOOLONG;x;1;2026-01-01\n"+
+		"DE;Deutschland\n"+
+		";nobody;1;2026-01-01\n")
+	var lines []int
+	var got [][]interface{}
+	plan, err := scanImport(t.Context(), path, "csv", ';', importTestTable(), func(line int, values []interface{}) error {
+		lines = append(lines, line)
+		got = append(got, values)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Rows != 5 || plan.ValidRows != 2 || plan.InvalidRows != 3 {
+		t.Errorf("rows = %d, valid = %d, invalid = %d", plan.Rows, plan.ValidRows, plan.InvalidRows)
+	}
+	if len(lines) != 2 || lines[0] != 2 || lines[1] != 3 {
+		t.Errorf("valid lines = %v", lines)
+	}
+	if got[1][1] != "France; la" || got[1][2] != nil || got[1][3] != nil {
+		t.Errorf("quoted delimiters and empty fields (NULL): %#v", got[1])
+	}
+	want := []string{
+		"line 4: column Code: value longer than varchar(3)",
+		"line 5: 2 fields, the header has 4",
+		"line 6: column Code: NULL is not allowed",
+	}
+	if strings.Join(plan.Errors, "\n") != strings.Join(want, "\n") {
+		t.Errorf("errors:\n%s\nwant:\n%s", strings.Join(plan.Errors, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestScanImportJSONL(t *testing.T) {
+	path := writeImportFile(t, "countries.jsonl", `{"code": "ES", "big": 9007199254740993, "name": "España"}`+"\n"+
+		"\n"+
+		`{"code": "FR"}`+"\n"+
+		`{"code": "IT", "color": "green"}`+"\n"+
+		`{"code": "PT", "name": {"pt": "Portugal"}}`+"\n"+
+		`not json`)
+	var got [][]interface{}
+	plan, err := scanImport(t.Context(), path, "jsonl", ',', importTestTable(), func(line int, values []interface{}) error {
+		got = append(got, values)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Rows != 5 || plan.ValidRows != 2 {
+		t.Fatalf("rows = %d, valid = %d: %v", plan.Rows, plan.ValidRows, plan.Errors)
+	}
+	if cols := plan.Columns; cols[0].Name != "Code" || cols[1].Name != "big" || cols[2].Name != "name" {
+		t.Errorf("the first object's keys are the columns, got %+v", cols)
+	}
+	if got[0][1] != int64(9007199254740993) || got[1][1] != nil || got[1][2] != nil {
+		t.Errorf("values = %#v", got)
+	}
+	for i, want := range []string{"line 4: key \"color\"", "line 5: key \"name\": nested", "line 6: expected a JSON object"} {
+		if i >= len(plan.Errors) || !strings.HasPrefix(plan.Errors[i], want) {
+			t.Errorf("errors = %q, want %q at %d", plan.Errors, want, i)
+		}
+	}
+
+	for content, want := range map[string]string{
+		"":                     "the file is empty",
+		"[1, 2]\n":             "expected a JSON object",
+		`{"row_id": 1}` + "\n": "identity column",
+	} {
+		path := writeImportFile(t, "bad.jsonl", content)
+		if _, err := scanImport(t.Context(), path, "jsonl", ',', importTestTable(), nil); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got %v, want %q", content, err, want)
+		}
+	}
+}
+
+func TestImportErrorsAreCapped(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("code\n")
+	for i := 0; i < maxImportErrors+5; i++ {
+		buf.WriteString("TOOLONG\n")
+	}
+	path := writeImportFile(t, "bad.csv", buf.String())
+	plan, err := scanImport(t.Context(), path, "csv", ',', importTestTable(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.InvalidRows != maxImportErrors+5 || len(plan.Errors) != maxImportErrors+1 || plan.Errors[maxImportErrors] != "... and 5 more" {
+		t.Errorf("invalid = %d, errors = %d, last = %q", plan.InvalidRows, len(plan.Errors), plan.Errors[len(plan.Errors)-1])
+	}
+}
+
+func TestImportFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("MSSQL_IMPORT_DIR", dir)
+	t.Setenv("MSSQL_IMPORT_MAX_BYTES", "10")
+	for name, content := range map[string]string{"small.csv": "code\nES\n", "big.jsonl": strings.Repeat("x", 11), "data.txt": "code\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if path, format, err := importFile("small.csv", ""); err != nil || format != "csv" || path != filepath.Join(dir, "small.csv") {
+		t.Errorf("small.csv = %s, %s, %v", path, format, err)
+	}
+	if _, format, err := importFile("data.txt", "csv"); err != nil || format != "csv" {
+		t.Errorf("an explicit format overrides the extension: %s, %v", format, err)
+	}
+	for name, want := range map[string]string{
+		"../small.csv": "invalid file name",
+		"missing.csv":  "not found",
+		"data.txt":     "cannot tell the format",
+		"big.jsonl":    "MSSQL_IMPORT_MAX_BYTES",
+	} {
+		if _, _, err := importFile(name, ""); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", name, err, want)
+		}
+	}
+}
+
+func TestImportPolicy(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	rw := s.dynamicAliases["RW"]
+	rw.MaxAffectedRows = 1000
+	s.dynamicAliases["RW"] = rw
+	roWhitelisted := s.dynamicAliases["RO"]
+	roWhitelisted.WhitelistTables = []string{"temp_ai"}
+	s.dynamicAliases["RO"] = roWhitelisted
+	importInto := func(alias, table string, rows int) error {
+		target, err := s.resolveTarget(map[string]interface{}{"alias": alias})
+		if err != nil {
+			t.Fatal(err)
+		}
+		target.tool = "import_data"
+		return s.enforcePolicy(policyRequest{target: target, query: "IMPORT", mode: policyImport, table: table, rows: rows})
+	}
+	for _, c := range []struct {
+		alias, table string
+		rows         int
+		want         string
+	}{
+		{"RO", "dbo.temp_ai", 10, "writable dynamic alias"},
+		{"RW", "dbo.customers", 10, "not whitelisted"},
+		{"RW", "dbo.temp_ai", 1001, "MAX_AFFECTED_ROWS"},
+	} {
+		err := importInto(c.alias, c.table, c.rows)
+		if err == nil || errors.Is(err, errConfirmationRequired) || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%d rows into %s on %s: got %v, want %q", c.rows, c.table, c.alias, err, c.want)
+		}
+	}
+
+	if err := importInto("RW", "dbo.temp_ai", 250); !errors.Is(err, errConfirmationRequired) || !strings.Contains(err.Error(), "250 rows into dbo.temp_ai") {
+		t.Fatalf("the import should ask to confirm the table and row count, got %v", err)
+	}
+	result := s.handleToolCall(1, CallToolParams{Name: "confirm_operation", Arguments: map[string]interface{}{"description": "IMPORT 250 rows into dbo.temp_ai"}}).Result.(CallToolResult)
+	if result.IsError {
+		t.Fatalf("confirm_operation: %s", result.Content[0].Text)
+	}
+	if err := importInto("RW", "dbo.temp_ai", 251); !errors.Is(err, errConfirmationRequired) {
+		t.Errorf("a different row count needs its own confirmation, got %v", err)
+	}
+	if err := importInto("RW", "dbo.temp_ai", 250); !errors.Is(err, errConfirmationRequired) {
+		t.Fatalf("the import should ask again, got %v", err)
+	}
+	result = s.handleToolCall(1, CallToolParams{Name: "confirm_operation", Arguments: map[string]interface{}{"description": "IMPORT 250 rows into dbo.temp_ai"}}).Result.(CallToolResult)
+	if result.IsError {
+		t.Fatalf("confirm_operation: %s", result.Content[0].Text)
+	}
+	if err := importInto("RW", "dbo.temp_ai", 250); err != nil {
+		t.Errorf("confirmed import should pass, got %v", err)
+	}
+}
+
+func TestImportDataListingAndRefusals(t *testing.T) {
+	listed := func(s *MCPMSSQLServer) bool {
+		for _, tool := range s.listTools() {
+			if tool.Name == "import_data" {
+				return true
+			}
+		}
+		return false
+	}
+	s := newAliasTargetTestServer(t)
+	if !listed(s) {
+		t.Error("import_data should be listed when an alias is writable")
+	}
+	if listed(newTestMCPServer()) {
+		t.Error("import_data should not be listed in classic mode")
+	}
+
+	call := func(args map[string]interface{}) string {
+		result := s.handleToolCall(1, CallToolParams{Name: "import_data", Arguments: args}).Result.(CallToolResult)
+		if !result.IsError {
+			t.Errorf("%v should be refused", args)
+		}
+		return result.Content[0].Text
+	}
+	if got := call(map[string]interface{}{"alias": "RO", "file_name": "x.csv", "table": "temp_ai"}); !strings.Contains(got, "writable dynamic alias") {
+		t.Errorf("read-only alias: %s", got)
+	}
+	if got := call(map[string]interface{}{"alias": "RW", "file_name": "x.csv"}); !strings.Contains(got, "'table' are required") {
+		t.Errorf("missing table: %s", got)
+	}
+	t.Setenv("MSSQL_IMPORT_DIR", t.TempDir())
+	if got := call(map[string]interface{}{"alias": "RW", "file_name": "x.csv", "table": "temp_ai"}); !strings.Contains(got, "not found") {
+		t.Errorf("missing file: %s", got)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIndexHealthQueriesPassReadOnlyPolicy(t *testing.T) {
+	s := newTestMCPServer()
+	queries := map[string]string{
+		"object":        indexObjectQuery,
+		"definitions":   indexDefinitionQuery,
+		"fragmentation": indexFragmentationQuery,
+		"usage":         indexUsageQuery,
+		"uptime":        serverUptimeQuery,
+		"statistics":    statisticsQuery,
+	}
+	for name, q := range queries {
+		if err := s.validateReadOnlyQueryFor(serverConfig{readOnly: true}, q); err != nil {
+			t.Errorf("%s query refused by the read-only policy: %v", name, err)
+		}
+	}
+}
+
+func TestParseIndexKeyColumns(t *testing.T) {
+	got := parseIndexKeyColumns("[CustomerId], [Order Date] DESC, [odd]]name]")
+	want := []indexKeyColumn{{Name: "CustomerId"}, {Name: "Order Date", Descending: true}, {Name: "odd]name"}}
+	if len(got) != len(want) {
+		t.Fatalf("parseIndexKeyColumns = %+v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("key %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if parseColumnList("") != nil {
+		t.Error("empty list should give no columns")
+	}
+}
+
+func pct(v float64) *float64 { return &v }
+
+func TestFragmentationFindings(t *testing.T) {
+	indexes := []indexHealth{
+		{Name: "IX_Small", Type: "NONCLUSTERED", Pages: 10, FragmentationPct: pct(90)},
+		{Name: "IX_Mild", Type: "NONCLUSTERED", Pages: 5000, FragmentationPct: pct(12)},
+		{Name: "PK_Orders", Type: "CLUSTERED", PrimaryKey: true, Pages: 20000, FragmentationPct: pct(45)},
+		{Name: "IX_Clean", Type: "NONCLUSTERED", Pages: 5000, FragmentationPct: pct(2)},
+	}
+	got := fragmentationFindings("dbo", "Orders", indexes)
+	if len(got) != 2 {
+		t.Fatalf("findings = %+v", got)
+	}
+	if got[0].Object != "IX_Mild" || got[0].DDL != "ALTER INDEX [IX_Mild] ON [dbo].[Orders] REORGANIZE;" {
+		t.Errorf("first finding = %+v", got[0])
+	}
+	if got[1].Object != "PK_Orders" || got[1].Action != "REBUILD" {
+		t.Errorf("second finding = %+v", got[1])
+	}
+}
+
+func TestUnusedIndexFindings(t *testing.T) {
+	indexes := []indexHealth{
+		{Name: "IX_Read", Type: "NONCLUSTERED", Usage: &indexUsage{Seeks: 3}},
+		{Name: "IX_WriteOnly", Type: "NONCLUSTERED", Usage: &indexUsage{Updates: 900}},
+		{Name: "IX_Untouched", Type: "NONCLUSTERED"},
+		{Name: "UQ_Email", Type: "NONCLUSTERED", Unique: true},
+		{Name: "PK_Orders", Type: "CLUSTERED", PrimaryKey: true},
+	}
+	got := unusedIndexFindings("dbo", "Orders", indexes)
+	if len(got) != 2 || got[0].Object != "IX_WriteOnly" || got[1].Object != "IX_Untouched" {
+		t.Fatalf("findings = %+v", got)
+	}
+	if !strings.Contains(got[0].Detail, "900 writes") || got[0].DDL != "DROP INDEX [IX_WriteOnly] ON [dbo].[Orders];" {
+		t.Errorf("write-only finding = %+v", got[0])
+	}
+}
+
+func TestDuplicateIndexFindings(t *testing.T) {
+	keys := func(names ...string) []indexKeyColumn {
+		var k []indexKeyColumn
+		for _, n := range names {
+			k = append(k, indexKeyColumn{Name: n})
+		}
+		return k
+	}
+	indexes := []indexHealth{
+		{id: 1, Name: "PK_Orders", Type: "CLUSTERED", PrimaryKey: true, KeyColumns: keys("OrderId")},
+		{id: 2, Name: "IX_Cust", Type: "NONCLUSTERED", KeyColumns: keys("CustomerId")},
+		{id: 3, Name: "IX_Cust_Date", Type: "NONCLUSTERED", KeyColumns: keys("CustomerId", "OrderDate"), IncludedColumns: []string{"Total"}},
+		{id: 4, Name: "IX_Cust_Date_2", Type: "NONCLUSTERED", KeyColumns: keys("CustomerId", "OrderDate"), IncludedColumns: []string{"Total"}},
+		// Same keys but a different direction: not a duplicate.
+		{id: 5, Name: "IX_Date_Desc", Type: "NONCLUSTERED", KeyColumns: []indexKeyColumn{{Name: "OrderDate", Descending: true}}},
+		{id: 6, Name: "IX_Date", Type: "NONCLUSTERED", KeyColumns: keys("OrderDate")},
+		// Covered by the clustered index.
+		{id: 7, Name: "IX_OrderId", Type: "NONCLUSTERED", KeyColumns: keys("OrderId"), IncludedColumns: []string{"Total"}},
+		// Filtered: serves different queries.
+		{id: 8, Name: "IX_Cust_Open", Type: "NONCLUSTERED", KeyColumns: keys("CustomerId"), Filter: "([Status]=(0))"},
+	}
+	got := duplicateIndexFindings("dbo", "Orders", indexes)
+	byName := map[string]indexFinding{}
+	for _, f := range got {
+		byName[f.Object] = f
+	}
+	if len(got) != 3 {
+		t.Fatalf("findings = %+v", got)
+	}
+	if f := byName["IX_Cust"]; f.Kind != "overlapping" || !strings.Contains(f.Action, "IX_Cust_Date") {
+		t.Errorf("IX_Cust = %+v", f)
+	}
+	if f := byName["IX_Cust_Date_2"]; f.Kind != "duplicate" || f.DDL != "DROP INDEX [IX_Cust_Date_2] ON [dbo].[Orders];" {
+		t.Errorf("of two duplicates the newer one should be reported: %+v", got)
+	}
+	if f := byName["IX_OrderId"]; f.Kind != "duplicate" || !strings.Contains(f.Action, "PK_Orders") {
+		t.Errorf("IX_OrderId = %+v", f)
+	}
+}
+
+func TestStaleStatisticsFindings(t *testing.T) {
+	stats := []statsHealth{
+		{Name: "PK_Orders", Rows: 1000000, Modifications: 40000, LastUpdated: "2026-01-01 00:00:00", lastUpdated: true},
+		{Name: "IX_Cust", Rows: 1000000, Modifications: 100, LastUpdated: "2026-01-01 00:00:00", lastUpdated: true},
+		{Name: "_WA_Sys_Status", NoRecompute: true},
+	}
+	got := staleStatisticsFindings("dbo", "Orders", stats)
+	if len(got) != 2 {
+		t.Fatalf("findings = %+v", got)
+	}
+	if got[0].Object != "PK_Orders" || got[0].DDL != "UPDATE STATISTICS [dbo].[Orders] [PK_Orders];" {
+		t.Errorf("first finding = %+v", got[0])
+	}
+	if got[1].Object != "_WA_Sys_Status" || !strings.Contains(got[1].Detail, "never computed") || !strings.Contains(got[1].Detail, "NORECOMPUTE") {
+		t.Errorf("second finding = %+v", got[1])
+	}
+	if len(staleStatisticsFindings("dbo", "Empty", []statsHealth{{Name: "PK_Empty"}})) != 0 {
+		t.Error("statistics of an empty table are not stale")
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// joinTestGraph is a small order and enrolment model:
+//
+//	Customers <- Orders -> Addresses (twice: ship and bill)
+//	Orders <- OrderLines -> Products, OrderLines <- OrderLineNotes (two columns)
+//	Students <- Enrollments -> Courses
+//	Shipments.order_id has no foreign key.
+func joinTestGraph() *joinGraph {
+	var cols []findValueColumn
+	table := func(schema, name string, columns ...string) {
+		for _, c := range columns {
+			key := 0
+			if strings.HasPrefix(c, "*") {
+				c = strings.TrimPrefix(c, "*")
+				key = 1
+				for _, prev := range cols {
+					if prev.schema == schema && prev.table == name && prev.keyOrdinal > 0 {
+						key++
+					}
+				}
+			}
+			cols = append(cols, findValueColumn{schema: schema, table: name, name: c, typ: queryParamType{base: "int"}, keyOrdinal: key})
+		}
+	}
+	table("audit", "Logs", "*id")
+	table("dbo", "Addresses", "*id", "street")
+	table("dbo", "Courses", "*CourseID")
+	table("dbo", "Customers", "*CustomerID", "Name")
+	table("dbo", "Enrollments", "*StudentID", "*CourseID")
+	table("dbo", "OrderLineNotes", "*NoteID", "OrderID", "LineNo")
+	table("dbo", "OrderLines", "*OrderID", "*LineNo", "ProductID")
+	table("dbo", "Orders", "*OrderID", "CustomerID", "ShipAddressID", "BillAddressID")
+	table("dbo", "Products", "*ProductID")
+	table("dbo", "Shipments", "*ShipmentID", "order_id")
+	table("dbo", "Students", "*StudentID")
+	table("sales", "Logs", "*id")
+	tables, _ := findValueTables(cols, "", "")
+	g := newJoinGraph(tables)
+	fk := func(name, child, parent string, childCols, parentCols []string) {
+		g.add(&joinEdge{name: name, child: child, parent: parent, childCols: childCols, parentCols: parentCols})
+	}
+	fk("FK_Orders_Customers", "dbo.orders", "dbo.customers", []string{"CustomerID"}, []string{"CustomerID"})
+	fk("FK_Orders_Ship", "dbo.orders", "dbo.addresses", []string{"ShipAddressID"}, []string{"id"})
+	fk("FK_Orders_Bill", "dbo.orders", "dbo.addresses", []string{"BillAddressID"}, []string{"id"})
+	fk("FK_Lines_Orders", "dbo.orderlines", "dbo.orders", []string{"OrderID"}, []string{"OrderID"})
+	fk("FK_Lines_Products", "dbo.orderlines", "dbo.products", []string{"ProductID"}, []string{"ProductID"})
+	fk("FK_Notes_Lines", "dbo.orderlinenotes", "dbo.orderlines", []string{"OrderID", "LineNo"}, []string{"OrderID", "LineNo"})
+	fk("FK_Enrollments_Students", "dbo.enrollments", "dbo.students", []string{"StudentID"}, []string{"StudentID"})
+	fk("FK_Enrollments_Courses", "dbo.enrollments", "dbo.courses", []string{"CourseID"}, []string{"CourseID"})
+	return g
+}
+
+func TestJoinPathShortest(t *testing.T) {
+	report, err := joinPaths(joinTestGraph(), []string{"Customers", "dbo.Products"}, 4, 3, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Paths) != 1 || report.ForeignKeys != 8 {
+		t.Fatalf("expected one path over 8 foreign keys, got %+v", report)
+	}
+	p := report.Paths[0]
+	want := "FROM [dbo].[Customers] AS c\n" +
+		"JOIN [dbo].[Orders] AS o ON o.[CustomerID] = c.[CustomerID]\n" +
+		"JOIN [dbo].[OrderLines] AS ol ON ol.[OrderID] = o.[OrderID]\n" +
+		"JOIN [dbo].[Products] AS p ON p.[ProductID] = ol.[ProductID]"
+	if p.SQL != want {
+		t.Errorf("sql:\n%s\nwant:\n%s", p.SQL, want)
+	}
+	var cardinalities []string
+	for _, s := range p.Steps {
+		cardinalities = append(cardinalities, s.Cardinality)
+	}
+	if got := strings.Join(cardinalities, ","); got != "one-to-many,one-to-many,many-to-one" {
+		t.Errorf("cardinalities = %s", got)
+	}
+	if p.Hops != 3 || p.Steps[1].Constraint != "FK_Lines_Orders" {
+		t.Errorf("path = %+v", p)
+	}
+	if len(p.Bridges) != 1 || p.Bridges[0] != "dbo.OrderLines" {
+		t.Errorf("OrderLines links orders to products: bridges = %v", p.Bridges)
+	}
+}
+
+func TestJoinPathAlternativesAndCompositeKeys(t *testing.T) {
+	g := joinTestGraph()
+	report, _ := joinPaths(g, []string{"Orders", "Addresses"}, 4, 3, false)
+	if len(report.Paths) != 2 || report.Paths[0].Steps[0].Constraint != "FK_Orders_Ship" || report.Paths[1].Steps[0].Constraint != "FK_Orders_Bill" {
+		t.Errorf("both foreign keys to Addresses are alternatives: %+v", report.Paths)
+	}
+	if on := report.Paths[1].Steps[0].On; on != "a.[id] = o.[BillAddressID]" {
+		t.Errorf("on = %s", on)
+	}
+
+	report, _ = joinPaths(g, []string{"OrderLineNotes", "OrderLines"}, 4, 3, false)
+	if on := report.Paths[0].Steps[0].On; on != "ol.[OrderID] = oln.[OrderID] AND ol.[LineNo] = oln.[LineNo]" {
+		t.Errorf("multi-column keys should join on every column, got %s", on)
+	}
+
+	report, _ = joinPaths(g, []string{"Students", "Courses"}, 4, 3, false)
+	if len(report.Paths) != 1 || len(report.Paths[0].Bridges) != 1 || report.Paths[0].Bridges[0] != "dbo.Enrollments" {
+		t.Errorf("Enrollments is a many-to-many bridge: %+v", report.Paths)
+	}
+
+	report, _ = joinPaths(g, []string{"Customers", "Products"}, 2, 3, false)
+	if len(report.Paths) != 0 || len(report.Notes) == 0 || !strings.Contains(report.Notes[0], "within 2 hops") {
+		t.Errorf("max_hops should bound the path: %+v", report)
+	}
+}
+
+func TestJoinPathSeveralTables(t *testing.T) {
+	report, err := joinPaths(joinTestGraph(), []string{"Customers", "Products", "Addresses"}, 4, 3, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Paths) != 1 {
+		t.Fatalf("expected one plan, got %+v", report)
+	}
+	p := report.Paths[0]
+	if got := strings.Join(p.Tables, ","); got != "dbo.Customers,dbo.Orders,dbo.Addresses,dbo.OrderLines,dbo.Products" {
+		t.Errorf("join order = %s", got)
+	}
+	if p.Hops != 4 {
+		t.Errorf("hops = %d", p.Hops)
+	}
+
+	report, _ = joinPaths(joinTestGraph(), []string{"Customers", "Students"}, 4, 3, false)
+	if len(report.Paths) != 0 || !strings.Contains(strings.Join(report.Notes, "\n"), "include_inferred") {
+		t.Errorf("unrelated tables: %+v", report)
+	}
+}
+
+func TestJoinPathInferred(t *testing.T) {
+	g := joinTestGraph()
+	report, _ := joinPaths(g, []string{"Shipments", "Customers"}, 4, 3, false)
+	if len(report.Paths) != 0 {
+		t.Fatalf("no declared foreign key reaches Shipments: %+v", report.Paths)
+	}
+	report, _ = joinPaths(g, []string{"Shipments", "Customers"}, 4, 3, true)
+	if len(report.Paths) != 1 || report.Inferred != 1 {
+		t.Fatalf("expected one path over one inferred relationship: %+v", report)
+	}
+	p := report.Paths[0]
+	if !p.Inferred || !p.Steps[0].Inferred || p.Steps[1].Inferred || p.Steps[0].Constraint != "" {
+		t.Errorf("only the first join is inferred: %+v", p.Steps)
+	}
+	if !strings.Contains(p.SQL, "JOIN [dbo].[Orders] AS o ON o.[OrderID] = s.[order_id] -- inferred") {
+		t.Errorf("sql should mark the inferred join:\n%s", p.SQL)
+	}
+	if !strings.Contains(strings.Join(report.Notes, "\n"), "naming conventions") {
+		t.Errorf("notes should warn about inferred joins: %v", report.Notes)
+	}
+}
+
+func TestJoinPathTableNames(t *testing.T) {
+	g := joinTestGraph()
+	cases := []struct {
+		tables []string
+		want   string
+	}{
+		{[]string{"Orders"}, "at least two"},
+		{[]string{"Orders", "dbo.Orders"}, "two different"},
+		{[]string{"Orders", "Nope"}, "Nope not found"},
+		{[]string{"Orders", "Logs"}, "several schemas"},
+		{[]string{"Orders", "sales.Nope"}, "sales.Nope not found"},
+	}
+	for _, c := range cases {
+		if _, err := joinPaths(g, c.tables, 4, 3, false); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%v: got %v, want %q", c.tables, err, c.want)
+		}
+	}
+	if _, err := joinPaths(g, []string{"Orders", "audit.Logs"}, 4, 3, false); err != nil {
+		t.Errorf("qualified names resolve ambiguity: %v", err)
+	}
+}
+
+func TestJoinAlias(t *testing.T) {
+	for in, want := range map[string]string{
+		"OrderLines":   "ol",
+		"order_lines":  "ol",
+		"Customers":    "c",
+		"ORDERS":       "o",
+		"OrderNumbers": "on",
+		"2020Sales":    "t2s",
+	} {
+		if got := joinAlias(in); got != want {
+			t.Errorf("joinAlias(%q) = %q, want %q", in, got, want)
+		}
+	}
+	g := joinTestGraph()
+	g.tables["dbo.ordernumbers"] = &findValueTable{schema: "dbo", table: "OrderNumbers"}
+	p := g.renderJoin("dbo.ordernumbers", []*joinEdge{{child: "dbo.orders", parent: "dbo.ordernumbers", childCols: []string{"OrderID"}, parentCols: []string{"OrderID"}}}, nil)
+	if !strings.HasPrefix(p.SQL, "FROM [dbo].[OrderNumbers] AS on2\n") {
+		t.Errorf("aliases must not be keywords:\n%s", p.SQL)
+	}
+}
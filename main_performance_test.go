@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// The performance queries run through the policy pipeline like any other
+// statement: they must pass the strict read-only rules.
+func TestPerformanceQueriesPassReadOnlyPolicy(t *testing.T) {
+	s := newTestMCPServer()
+	queries := map[string]string{
+		"capabilities":    perfCapabilitiesQuery,
+		"query store":     perfQueryStoreStateQuery,
+		"regression":      perfRegressionQuery,
+		"query text":      fmt.Sprintf(perfQueryTextQuery, "@p1, @p2"),
+		"wait stats":      perfWaitStatsQuery,
+		"blocking":        perfBlockingQuery,
+		"missing indexes": perfMissingIndexQuery,
+	}
+	for order, column := range perfOrderColumns {
+		queries["query store top "+order] = fmt.Sprintf(perfQueryStoreTopQuery, column)
+		queries["plan cache top "+order] = fmt.Sprintf(perfPlanCacheTopQuery, column)
+	}
+	for name, q := range queries {
+		if err := s.validateReadOnlyQueryFor(serverConfig{readOnly: true}, q); err != nil {
+			t.Errorf("%s query refused by the read-only policy: %v", name, err)
+		}
+	}
+}
+
+func TestFindRegressions(t *testing.T) {
+	windows := []qsPlanWindow{
+		// Query 1: plan 10 averaged 10ms, the new plan 11 averages 50ms.
+		{queryID: 1, planID: 10, executions: 100, durationMs: 1000},
+		{queryID: 1, planID: 11, recent: true, executions: 20, durationMs: 1000},
+		// Query 2: same plan, 2x slower but with few executions.
+		{queryID: 2, planID: 20, executions: 10, durationMs: 100},
+		{queryID: 2, planID: 20, recent: true, executions: 2, durationMs: 40},
+		// Query 3: stable.
+		{queryID: 3, planID: 30, executions: 10, durationMs: 100},
+		{queryID: 3, planID: 30, recent: true, executions: 10, durationMs: 110},
+		// Query 4: no baseline.
+		{queryID: 4, planID: 40, recent: true, executions: 5, durationMs: 5000},
+	}
+	got := findRegressions(windows, 10)
+	if len(got) != 2 {
+		t.Fatalf("regressions = %+v", got)
+	}
+	first := got[0]
+	if first.QueryID != 1 || first.Ratio != 5 || !first.PlanChanged || first.ExtraMs != 800 {
+		t.Errorf("first regression = %+v", first)
+	}
+	if got[1].QueryID != 2 || got[1].PlanChanged {
+		t.Errorf("second regression = %+v", got[1])
+	}
+	if len(findRegressions(windows, 1)) != 1 {
+		t.Error("top must limit the result")
+	}
+}
+
+func TestDeltaWaits(t *testing.T) {
+	before := map[string]waitSample{
+		"PAGEIOLATCH_SH":   {tasks: 10, waitMs: 1000, signalMs: 10},
+		"LCK_M_X":          {tasks: 1, waitMs: 500},
+		"LAZYWRITER_SLEEP": {tasks: 5, waitMs: 100},
+		"CXPACKET":         {tasks: 100, waitMs: 9000},
+	}
+	after := map[string]waitSample{
+		"PAGEIOLATCH_SH":   {tasks: 40, waitMs: 4000, signalMs: 40},
+		"LCK_M_X":          {tasks: 2, waitMs: 1500},
+		"LAZYWRITER_SLEEP": {tasks: 50, waitMs: 90000},
+		"CXPACKET":         {tasks: 1, waitMs: 10}, // cleared in between
+		"WRITELOG":         {tasks: 3, waitMs: 0},
+	}
+	got := deltaWaits(before, after, 10)
+	if len(got) != 2 {
+		t.Fatalf("waits = %+v", got)
+	}
+	if got[0].WaitType != "PAGEIOLATCH_SH" || got[0].WaitMs != 3000 || got[0].Tasks != 30 || got[0].Percent != 75 {
+		t.Errorf("first wait = %+v", got[0])
+	}
+	if got[1].WaitType != "LCK_M_X" || got[1].Percent != 25 {
+		t.Errorf("second wait = %+v", got[1])
+	}
+}
+
+func TestBlockingTree(t *testing.T) {
+	sessions := []blockingSession{
+		{SessionID: 60, BlockedBy: 55, WaitType: "LCK_M_S"},
+		{SessionID: 55, Status: "sleeping", OpenTransactions: 1}, // idle lead blocker
+		{SessionID: 61, BlockedBy: 60},
+		{SessionID: 62, BlockedBy: 55},
+		{SessionID: 70, BlockedBy: 99}, // blocker not listed
+		// Cycle: neither is a lead blocker.
+		{SessionID: 80, BlockedBy: 81},
+		{SessionID: 81, BlockedBy: 80},
+	}
+	tree := blockingTree(sessions)
+	if len(tree) != 3 {
+		t.Fatalf("roots = %d, want 3", len(tree))
+	}
+	lead := tree[0]
+	if lead.SessionID != 55 || len(lead.Blocking) != 2 {
+		t.Fatalf("lead blocker = %+v", lead)
+	}
+	if lead.Blocking[0].SessionID != 60 || len(lead.Blocking[0].Blocking) != 1 || lead.Blocking[0].Blocking[0].SessionID != 61 {
+		t.Errorf("chain under 55 = %+v", lead.Blocking[0])
+	}
+	cycle := tree[1]
+	if cycle.SessionID != 80 || len(cycle.Blocking) != 1 || cycle.Blocking[0].SessionID != 81 || len(cycle.Blocking[0].Blocking) != 0 {
+		t.Errorf("cycle must be broken once: %+v", cycle)
+	}
+	if tree[2].SessionID != 70 {
+		t.Errorf("session with an unlisted blocker should be a root: %+v", tree[2])
+	}
+}
+
+func TestSplitIndexColumns(t *testing.T) {
+	got := splitIndexColumns("[CustomerId], [Order Date]")
+	if len(got) != 2 || got[0] != "CustomerId" || got[1] != "Order Date" {
+		t.Errorf("splitIndexColumns = %q", got)
+	}
+	if splitIndexColumns("") != nil {
+		t.Error("empty list should give no columns")
+	}
+}
+
+func TestPerformanceToolGated(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	if err := s.connectToDynamicAlias("RO"); err != nil {
+		t.Fatal(err)
+	}
+	listed := func() bool {
+		for _, tool := range s.listTools() {
+			if tool.Name == "performance" {
+				return true
+			}
+		}
+		return false
+	}
+
+	if listed() {
+		t.Error("performance must not be listed unless MSSQL_PERFORMANCE_INSIGHTS=true")
+	}
+	result := s.handleToolCall(1, CallToolParams{Name: "performance"}).Result.(CallToolResult)
+	if !result.IsError || !strings.Contains(result.Content[0].Text, "MSSQL_PERFORMANCE_INSIGHTS") {
+		t.Errorf("disabled tool should explain how to enable it, got %+v", result)
+	}
+
+	s.config.performanceInsights = true
+	if !listed() {
+		t.Error("performance should be listed once enabled")
+	}
+	result = s.handleToolCall(1, CallToolParams{Name: "performance", Arguments: map[string]interface{}{"mode": "fastest"}}).Result.(CallToolResult)
+	if !result.IsError || !strings.Contains(result.Content[0].Text, "invalid mode") {
+		t.Errorf("expected invalid mode error, got %+v", result)
+	}
+}
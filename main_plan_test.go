@@ -0,0 +1,158 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// samplePlanXML is a trimmed STATISTICS XML plan: a nested loops join of an
+// index seek and a key lookup, with a plan-level implicit conversion warning,
+// a missing index suggestion, a spilling sort and a badly estimated seek.
+const samplePlanXML = `<?xml version="1.0" encoding="utf-16"?>
+<ShowPlanXML xmlns="http://schemas.microsoft.com/sqlserver/2004/07/showplan" Version="1.564" Build="16.0.1000.6">
+ <BatchSequence><Batch><Statements>
+  <StmtSimple StatementText="SELECT * FROM dbo.Orders WHERE CustomerCode = @c ORDER BY OrderDate" StatementId="1" StatementSubTreeCost="1.0" StatementEstRows="10" StatementType="SELECT">
+   <QueryPlan DegreeOfParallelism="1">
+    <Warnings>
+     <PlanAffectingConvert ConvertIssue="Seek Plan" Expression="CONVERT_IMPLICIT(nvarchar(20),[o].[CustomerCode],0)=[@c]" />
+    </Warnings>
+    <MissingIndexes>
+     <MissingIndexGroup Impact="87.5">
+      <MissingIndex Database="[Shop]" Schema="[dbo]" Table="[Orders]">
+       <ColumnGroup Usage="EQUALITY"><Column Name="[CustomerCode]" ColumnId="3" /></ColumnGroup>
+       <ColumnGroup Usage="INCLUDE"><Column Name="[OrderDate]" ColumnId="4" /><Column Name="[Total]" ColumnId="5" /></ColumnGroup>
+      </MissingIndex>
+     </MissingIndexGroup>
+    </MissingIndexes>
+    <RelOp NodeId="0" PhysicalOp="Sort" LogicalOp="Sort" EstimateRows="10" EstimatedTotalSubtreeCost="1.0">
+     <Warnings><SpillToTempDb SpillLevel="1" SpilledThreadCount="1" /></Warnings>
+     <RunTimeInformation><RunTimeCountersPerThread Thread="0" ActualRows="5000" ActualExecutions="1" /></RunTimeInformation>
+     <Sort Distinct="0">
+      <RelOp NodeId="1" PhysicalOp="Nested Loops" LogicalOp="Inner Join" EstimateRows="10" EstimatedTotalSubtreeCost="0.6">
+       <RunTimeInformation><RunTimeCountersPerThread Thread="0" ActualRows="5000" ActualExecutions="1" /></RunTimeInformation>
+       <NestedLoops Optimized="0">
+        <RelOp NodeId="2" PhysicalOp="Index Seek" LogicalOp="Index Seek" EstimateRows="10" EstimatedTotalSubtreeCost="0.1">
+         <RunTimeInformation><RunTimeCountersPerThread Thread="0" ActualRows="5000" ActualExecutions="1" /></RunTimeInformation>
+         <IndexScan Ordered="1">
+          <Object Database="[Shop]" Schema="[dbo]" Table="[Orders]" Index="[IX_Orders_Code]" />
+          <SeekPredicates><SeekPredicateNew><SeekKeys><Prefix ScanType="EQ"><RangeExpressions>
+           <ScalarOperator ScalarString="CONVERT_IMPLICIT(nvarchar(20),[Shop].[dbo].[Orders].[CustomerCode],0)" />
+          </RangeExpressions></Prefix></SeekKeys></SeekPredicateNew></SeekPredicates>
+         </IndexScan>
+        </RelOp>
+        <RelOp NodeId="3" PhysicalOp="Clustered Index Seek" LogicalOp="Clustered Index Seek" EstimateRows="1" EstimatedTotalSubtreeCost="0.3">
+         <RunTimeInformation><RunTimeCountersPerThread Thread="0" ActualRows="5000" ActualExecutions="5000" /></RunTimeInformation>
+         <IndexScan Lookup="1" Ordered="1">
+          <Object Database="[Shop]" Schema="[dbo]" Table="[Orders]" Index="[PK_Orders]" />
+         </IndexScan>
+        </RelOp>
+       </NestedLoops>
+      </RelOp>
+     </Sort>
+    </RelOp>
+   </QueryPlan>
+  </StmtSimple>
+ </Statements></Batch></BatchSequence>
+</ShowPlanXML>`
+
+func TestParseShowplanXML(t *testing.T) {
+	stmts, err := parseShowplanXML(samplePlanXML)
+	if err != nil {
+		t.Fatalf("parseShowplanXML: %v", err)
+	}
+	if len(stmts) != 1 {
+		t.Fatalf("expected 1 statement, got %d", len(stmts))
+	}
+	st := stmts[0]
+
+	if len(st.Warnings) != 1 || !strings.Contains(st.Warnings[0], "implicit conversion affects seek plan") {
+		t.Errorf("plan warnings = %v", st.Warnings)
+	}
+	if len(st.MissingIndexes) != 1 {
+		t.Fatalf("missing indexes = %+v", st.MissingIndexes)
+	}
+	mi := st.MissingIndexes[0]
+	if mi.Table != "dbo.Orders" || mi.Impact != 87.5 || len(mi.Equality) != 1 || len(mi.Include) != 2 {
+		t.Errorf("missing index = %+v", mi)
+	}
+	if want := "CREATE NONCLUSTERED INDEX [IX_Orders_CustomerCode] ON [dbo].[Orders] ([CustomerCode]) INCLUDE ([OrderDate], [Total])"; mi.Suggestion != want {
+		t.Errorf("suggestion = %q, want %q", mi.Suggestion, want)
+	}
+
+	root := st.Tree
+	if root == nil || root.PhysicalOp != "Sort" || len(root.Children) != 1 {
+		t.Fatalf("unexpected root: %+v", root)
+	}
+	if root.ActualRows == nil || *root.ActualRows != 5000 {
+		t.Errorf("root actual rows = %v", root.ActualRows)
+	}
+	if !containsSubstring(root.Warnings, "spill to tempdb") || !containsSubstring(root.Warnings, "row estimate off") {
+		t.Errorf("root warnings = %v", root.Warnings)
+	}
+	// Own cost: Sort 1.0-0.6, Loops 0.6-0.4, seek 0.1, lookup 0.3.
+	if root.CostPercent != 40 {
+		t.Errorf("sort cost%% = %v, want 40", root.CostPercent)
+	}
+
+	loops := root.Children[0]
+	if len(loops.Children) != 2 {
+		t.Fatalf("nested loops children = %d", len(loops.Children))
+	}
+	seek, lookup := loops.Children[0], loops.Children[1]
+	if seek.Object != "dbo.Orders (IX_Orders_Code)" {
+		t.Errorf("seek object = %q", seek.Object)
+	}
+	if !containsSubstring(seek.Warnings, "CONVERT_IMPLICIT") {
+		t.Errorf("seek should flag the implicit conversion: %v", seek.Warnings)
+	}
+	if !containsSubstring(lookup.Warnings, "key lookup") {
+		t.Errorf("lookup warnings = %v", lookup.Warnings)
+	}
+	// 5000 executions x 1 estimated row = 5000: not a misestimate.
+	if containsSubstring(lookup.Warnings, "row estimate off") {
+		t.Errorf("per-execution estimates must be scaled by executions: %v", lookup.Warnings)
+	}
+
+	if len(st.TopOperators) != 4 {
+		t.Fatalf("top operators = %+v", st.TopOperators)
+	}
+	if st.TopOperators[0].NodeID != 0 || st.TopOperators[1].NodeID != 3 {
+		t.Errorf("ranking = %+v", st.TopOperators)
+	}
+}
+
+func TestParseShowplanXMLRejectsGarbage(t *testing.T) {
+	if _, err := parseShowplanXML("<ShowPlanXML><BatchSequence>"); err == nil {
+		t.Error("truncated XML must be rejected")
+	}
+}
+
+func TestExplainQueryModeValidation(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	if err := s.connectToDynamicAlias("RO"); err != nil {
+		t.Fatal(err)
+	}
+	call := func(args map[string]interface{}) CallToolResult {
+		return s.handleToolCall(1, CallToolParams{Name: "explain_query", Arguments: args}).Result.(CallToolResult)
+	}
+
+	if r := call(map[string]interface{}{"query": "SELECT 1", "mode": "verbose"}); !r.IsError || !strings.Contains(r.Content[0].Text, "invalid mode") {
+		t.Errorf("expected invalid mode error, got %+v", r)
+	}
+	// Actual plans execute the query: anything that could write is refused
+	// before reaching the server.
+	for _, q := range []string{"SELECT * INTO #copy FROM t", "SELECT 1; DELETE FROM t"} {
+		if r := call(map[string]interface{}{"query": q, "mode": "actual"}); !r.IsError || !strings.Contains(r.Content[0].Text, "read-only") {
+			t.Errorf("actual plan of %q should be refused, got %+v", q, r)
+		}
+	}
+}
+
+func containsSubstring(list []string, sub string) bool {
+	for _, s := range list {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
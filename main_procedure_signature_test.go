@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golang-sql/civil"
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/shopspring/decimal"
+)
+
+func TestProcParamDefaults(t *testing.T) {
+	tests := []struct {
+		name       string
+		definition string
+		want       map[string]bool
+	}{
+		{
+			name: "plain list",
+			definition: `CREATE PROCEDURE dbo.usp_Orders
+				@CustomerId int,
+				@Status nvarchar(20) = N'open, pending', -- comment with = sign
+				@From datetime2(3) = NULL,
+				@Total money OUTPUT
+			AS
+			BEGIN
+				SELECT @Total = 0 WHERE @CustomerId = 1
+			END`,
+			want: map[string]bool{"customerid": false, "status": true, "from": true, "total": false},
+		},
+		{
+			name:       "parenthesised list",
+			definition: "CREATE PROC p (@a decimal(18, 2) = 1.5, @b int /* = 3 */) WITH RECOMPILE AS SELECT 1",
+			want:       map[string]bool{"a": true, "b": false},
+		},
+		{
+			name:       "AS before type",
+			definition: "CREATE PROCEDURE p @a AS int = 5, @b AS varchar(10) AS SELECT @a",
+			want:       map[string]bool{"a": true, "b": false},
+		},
+		{
+			name:       "no parameters",
+			definition: "CREATE PROCEDURE p AS SELECT @@VERSION",
+			want:       map[string]bool{},
+		},
+	}
+	for _, tc := range tests {
+		if got := procParamDefaults(tc.definition); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParamTypeName(t *testing.T) {
+	tests := []struct {
+		typeName, baseType       string
+		maxLen, precision, scale int64
+		want                     string
+	}{
+		{"nvarchar", "nvarchar", 100, 0, 0, "nvarchar(50)"},
+		{"nvarchar", "nvarchar", -1, 0, 0, "nvarchar(max)"},
+		{"varchar", "varchar", 30, 0, 0, "varchar(30)"},
+		{"decimal", "decimal", 9, 18, 2, "decimal(18,2)"},
+		{"datetime2", "datetime2", 8, 27, 7, "datetime2(7)"},
+		{"int", "int", 4, 10, 0, "int"},
+		{"Phone", "varchar", 20, 0, 0, "Phone"},
+	}
+	for _, tc := range tests {
+		if got := paramTypeName(tc.typeName, tc.baseType, tc.maxLen, tc.precision, tc.scale); got != tc.want {
+			t.Errorf("paramTypeName(%s, %d) = %q, want %q", tc.typeName, tc.maxLen, got, tc.want)
+		}
+	}
+}
+
+func TestCoerceProcValue(t *testing.T) {
+	tests := []struct {
+		baseType string
+		in       interface{}
+		want     interface{}
+	}{
+		{"int", float64(42), int32(42)},
+		{"int", "42", int32(42)},
+		{"bigint", float64(1 << 40), int64(1 << 40)},
+		{"bigint", "9007199254740993", int64(9007199254740993)},
+		{"bigint", "-9223372036854775808", int64(math.MinInt64)},
+		{"bigint", float64(1<<53 - 1), int64(1<<53 - 1)},
+		{"smallint", float64(-3), int16(-3)},
+		{"tinyint", float64(255), uint8(255)},
+		{"bit", float64(1), true},
+		{"bit", "false", false},
+		{"float", "2.5", float64(2.5)},
+		{"varchar", "abc", mssql.VarChar("abc")},
+		{"nvarchar", "ünï", "ünï"},
+		{"date", "2026-01-31", civil.Date{Year: 2026, Month: 1, Day: 31}},
+		{"datetime2", "2026-01-31 13:45:00", civil.DateTime{Date: civil.Date{Year: 2026, Month: 1, Day: 31}, Time: civil.Time{Hour: 13, Minute: 45}}},
+		{"time", "13:45:00", civil.Time{Hour: 13, Minute: 45}},
+		{"uniqueidentifier", "{6F9619FF-8B86-D011-B42D-00C04FC964FF}", mssql.UniqueIdentifier{0x6F, 0x96, 0x19, 0xFF, 0x8B, 0x86, 0xD0, 0x11, 0xB4, 0x2D, 0x00, 0xC0, 0x4F, 0xC9, 0x64, 0xFF}},
+		{"int", nil, nil},
+	}
+	for _, tc := range tests {
+		got, err := coerceProcValue(procParam{Name: "p", BaseType: tc.baseType}, tc.in)
+		if err != nil {
+			t.Errorf("coerce %s %v: %v", tc.baseType, tc.in, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("coerce %s %v = %#v, want %#v", tc.baseType, tc.in, got, tc.want)
+		}
+	}
+
+	// Decimal types are bound as exact decimals, never through float64
+	// text.
+	for _, tc := range []struct {
+		baseType string
+		in       interface{}
+		want     string
+	}{
+		{"decimal", float64(12.25), "12.25"},
+		{"decimal", "123456789012345678.99", "123456789012345678.99"},
+		{"numeric", "-0.000000000000000000000000000001", "-0.000000000000000000000000000001"},
+		{"money", "922337203685477.5807", "922337203685477.5807"},
+		{"smallmoney", float64(-214748.3648), "-214748.3648"},
+	} {
+		got, err := coerceProcValue(procParam{BaseType: tc.baseType}, tc.in)
+		if d, ok := got.(decimal.Decimal); err != nil || !ok || d.String() != tc.want {
+			t.Errorf("coerce %s %v = %#v, %v; want decimal %s", tc.baseType, tc.in, got, err, tc.want)
+		}
+	}
+
+	got, err := coerceProcValue(procParam{BaseType: "varbinary"}, "0x0A1B")
+	if err != nil || !bytes.Equal(got.([]byte), []byte{0x0a, 0x1b}) {
+		t.Errorf("varbinary: got %v, %v", got, err)
+	}
+
+	for _, bad := range []struct {
+		baseType string
+		in       interface{}
+	}{
+		{"int", float64(1.5)},
+		{"int", float64(1 << 40)},
+		{"bigint", float64(1 << 53)},
+		{"bigint", float64(1 << 63)},
+		{"bigint", "9223372036854775808"},
+		{"bigint", "12.5"},
+		{"tinyint", float64(-1)},
+		{"bit", float64(2)},
+		{"date", "31/01/2026"},
+		{"uniqueidentifier", "not-a-guid"},
+		{"varbinary", "0AZZ"},
+		{"decimal", "abc"},
+		{"decimal", "NaN"},
+		{"decimal", float64(12345678901234567)},
+		{"money", "1000000000000000"},
+		{"smallmoney", "214748.36471"},
+		{"float", "NaN"},
+		{"float", "-Inf"},
+		{"real", "+Infinity"},
+	} {
+		if _, err := coerceProcValue(procParam{BaseType: bad.baseType}, bad.in); err == nil {
+			t.Errorf("coerce %s %v should fail", bad.baseType, bad.in)
+		}
+	}
+}
+
+func testProcSignature() *procSignature {
+	return &procSignature{Procedure: "dbo.usp_Orders", Params: []procParam{
+		{Name: "CustomerId", Type: "int", BaseType: "int"},
+		{Name: "Status", Type: "nvarchar(20)", BaseType: "nvarchar", HasDefault: true},
+		{Name: "Since", Type: "date", BaseType: "date"},
+		{Name: "Total", Type: "money", BaseType: "money", IsOutput: true},
+	}}
+}
+
+func TestProcSignatureBind(t *testing.T) {
+	sig := testProcSignature()
+
+	typed, outs, err := sig.bind(map[string]interface{}{"@customerid": "7", "Since": "2026-01-01"}, nil)
+	if err != nil {
+		t.Fatalf("bind: %v", err)
+	}
+	if typed["CustomerId"] != int32(7) {
+		t.Errorf("CustomerId = %#v, want int32(7)", typed["CustomerId"])
+	}
+	if _, ok := typed["Since"].(civil.Date); !ok {
+		t.Errorf("Since = %#v, want civil.Date", typed["Since"])
+	}
+	if len(outs) != 1 || outs[0].name != "Total" {
+		t.Fatalf("OUTPUT parameters not declared from metadata: %+v", outs)
+	}
+
+	_, _, err = sig.bind(map[string]interface{}{"Status": "open"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "@CustomerId (int)") || !strings.Contains(err.Error(), "@Since (date)") {
+		t.Errorf("expected every missing parameter to be reported, got %v", err)
+	}
+
+	if _, _, err := sig.bind(map[string]interface{}{"CustomerId": 1, "Since": "2026-01-01", "Bogus": 1}, nil); err == nil || !strings.Contains(err.Error(), "@Bogus") {
+		t.Errorf("expected unknown parameter error, got %v", err)
+	}
+	if _, _, err := sig.bind(map[string]interface{}{"CustomerId": "x", "Since": "2026-01-01"}, nil); err == nil || !strings.Contains(err.Error(), "@CustomerId (int)") {
+		t.Errorf("expected conversion error naming the parameter, got %v", err)
+	}
+	if _, _, err := sig.bind(map[string]interface{}{"CustomerId": 1, "Since": "2026-01-01"}, map[string]interface{}{"Status": "int"}); err == nil {
+		t.Error("output_parameters naming an input-only parameter must be rejected")
+	}
+}
+
+func TestProcSignatureInputSchema(t *testing.T) {
+	schema := testProcSignature().inputSchema()
+	if !reflect.DeepEqual(schema.Required, []string{"CustomerId", "Since"}) {
+		t.Errorf("required = %v", schema.Required)
+	}
+	if schema.Properties["CustomerId"].Type != "integer" || schema.Properties["Status"].Type != "string" {
+		t.Errorf("unexpected property types: %+v", schema.Properties)
+	}
+	if _, ok := schema.Properties["Total"]; ok {
+		t.Error("OUTPUT parameters must not be part of the input schema")
+	}
+}
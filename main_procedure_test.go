@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutputDestTypes(t *testing.T) {
+	tests := []struct {
+		sqlType string
+		initial interface{}
+		want    interface{}
+	}{
+		{"int", nil, nil},
+		{"int", float64(41), int64(41)},
+		{"bigint", float64(1 << 40), int64(1 << 40)},
+		{"smallint", float64(7), int64(7)},
+		{"tinyint", float64(3), int64(3)},
+		{"bit", true, true},
+		{"float", float64(1.5), float64(1.5)},
+		{"nvarchar(200)", "hello", "hello"},
+		{"decimal(18,2)", float64(12.5), "12.5"},
+		{"decimal(38,2)", "123456789012345678901234.56", "123456789012345678901234.56"},
+		{"bigint", "9007199254740993", int64(9007199254740993)},
+		{"money", float64(1e6), "1000000"},
+		{"uniqueidentifier", "6f9619ff-8b86-d011-b42d-00c04fc964ff", "6F9619FF-8B86-D011-B42D-00C04FC964FF"},
+		{"uniqueidentifier", nil, nil},
+		{"", nil, nil},
+	}
+	for _, tc := range tests {
+		dest, err := outputDest(tc.sqlType, tc.initial)
+		if err != nil {
+			t.Errorf("outputDest(%q): %v", tc.sqlType, err)
+			continue
+		}
+		if got := outputValue(dest); got != tc.want {
+			t.Errorf("outputDest(%q, %v) -> %#v, want %#v", tc.sqlType, tc.initial, got, tc.want)
+		}
+	}
+
+	dest, err := outputDest("datetime2", "2026-01-02T03:04:05Z")
+	if err != nil {
+		t.Fatalf("datetime2: %v", err)
+	}
+	if got, ok := outputValue(dest).(time.Time); !ok || got.Year() != 2026 {
+		t.Errorf("datetime2 initial value not kept, got %v", outputValue(dest))
+	}
+
+	for _, bad := range []struct {
+		sqlType string
+		initial interface{}
+	}{
+		{"geography", nil},
+		{"int", "not a number"},
+		{"bit", float64(1)},
+		{"date", "yesterday"},
+		// Initial values follow the input argument rules: no wrapping or
+		// truncation.
+		{"tinyint", float64(300)},
+		{"int", float64(1.9)},
+		{"smallint", float64(-40000)},
+		{"int", float64(1 << 31)},
+		{"bigint", float64(1 << 60)},
+		{"money", "1000000000000000"},
+		{"uniqueidentifier", "nope"},
+	} {
+		if _, err := outputDest(bad.sqlType, bad.initial); err == nil {
+			t.Errorf("outputDest(%q, %v) should fail", bad.sqlType, bad.initial)
+		}
+	}
+}
+
+func TestParseOutputParams(t *testing.T) {
+	inputs := map[string]interface{}{"@Counter": float64(5), "filter": "x"}
+	outs, err := parseOutputParams(map[string]interface{}{"counter": "int", "@message": "nvarchar"}, inputs)
+	if err != nil {
+		t.Fatalf("parseOutputParams: %v", err)
+	}
+	if len(outs) != 2 || outs[0].name != "message" || outs[1].name != "counter" {
+		t.Fatalf("unexpected outputs: %+v", outs)
+	}
+	if got := outputValue(outs[1].dest); got != int64(5) {
+		t.Errorf("INPUT/OUTPUT parameter should start with the input value, got %v", got)
+	}
+	if _, ok := outs[0].dest.(*sql.NullString); !ok {
+		t.Errorf("nvarchar OUTPUT should use a NullString destination, got %T", outs[0].dest)
+	}
+
+	if _, err := parseOutputParams(map[string]interface{}{"x; DROP TABLE t--": "int"}, nil); err == nil {
+		t.Error("unsafe OUTPUT parameter name must be rejected")
+	}
+}
+
+func TestProcValidationText(t *testing.T) {
+	outs := []procOutputParam{{name: "total"}}
+	got := procValidationText("dbo.usp_Report", map[string]interface{}{"@total": float64(1), "b": 2, "a": 1}, outs)
+	want := "EXEC dbo.usp_Report @total = @p1 OUTPUT, @a = @p2, @b = @p3"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := procValidationText("usp_Ping", nil, nil); got != "EXEC usp_Ping" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestJSONObjectArg(t *testing.T) {
+	if m, err := jsonObjectArg(`{"a": 1}`); err != nil || m["a"] != float64(1) {
+		t.Errorf("string form: %v, %v", m, err)
+	}
+	if m, err := jsonObjectArg(map[string]interface{}{"a": "b"}); err != nil || m["a"] != "b" {
+		t.Errorf("object form: %v, %v", m, err)
+	}
+	if m, err := jsonObjectArg(""); err != nil || m != nil {
+		t.Errorf("empty string: %v, %v", m, err)
+	}
+	if _, err := jsonObjectArg("[1,2]"); err == nil {
+		t.Error("JSON array must be rejected")
+	}
+	if _, err := jsonObjectArg(float64(3)); err == nil {
+		t.Error("non-object must be rejected")
+	}
+}
+
+func TestExecuteProcedureOnAppliesReadOnlyPolicy(t *testing.T) {
+	s := newTestMCPServer()
+	db, err := sql.Open("sqlserver", "server=127.0.0.1;database=x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	target := &queryTarget{db: db, config: serverConfig{readOnly: true}}
+
+	// Strict read-only without a table whitelist refuses EXEC before the
+	// call reaches the server, exactly as the previous EXEC-batch path did.
+	_, err = s.executeProcedureOn(context.Background(), target, "dbo.usp_Report", nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "read-only") {
+		t.Errorf("expected read-only violation, got %v", err)
+	}
+}
+
+func TestExecuteProcedureInvalidOutputParameters(t *testing.T) {
+	s := newTestMCPServer()
+	db, err := sql.Open("sqlserver", "server=127.0.0.1;database=x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s.db = db
+	s.config = serverConfig{whitelistProcs: "usp_report"}
+
+	for _, out := range []string{`not json`, `{"total": "geometry"}`} {
+		resp := s.handleToolCall("p", CallToolParams{Name: "execute_procedure", Arguments: map[string]interface{}{
+			"procedure_name":    "usp_report",
+			"output_parameters": out,
+		}})
+		if result := resp.Result.(CallToolResult); !result.IsError {
+			t.Errorf("expected error for output_parameters %q", out)
+		}
+	}
+}
+
+// An unqualified procedure name is looked up and called as the whitelist
+// matched it (dbo), not through the login's default schema.
+func TestExecuteProcedureCallsMatchedSchema(t *testing.T) {
+	s := newTestMCPServer()
+	db, err := sql.Open("sqlserver", "server=127.0.0.1;port=1;database=x;connection timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s.db = db
+	s.config = serverConfig{whitelistProcs: "dbo.usp_Get*"}
+
+	resp := s.handleToolCall("p", CallToolParams{Name: "execute_procedure", Arguments: map[string]interface{}{
+		"procedure_name": "usp_GetX",
+	}})
+	result := resp.Result.(CallToolResult)
+	if !result.IsError || !strings.Contains(result.Content[0].Text, "'[dbo].[usp_GetX]'") {
+		t.Errorf("expected the signature lookup of [dbo].[usp_GetX], got %+v", result)
+	}
+}
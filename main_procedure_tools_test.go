@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProcToolName(t *testing.T) {
+	tests := map[string]string{
+		"usp_GetOrder":         "proc_usp_GetOrder",
+		"dbo.usp_CloseTicket":  "proc_dbo_usp_CloseTicket",
+		" reporting.usp_Daily": "proc_reporting_usp_Daily",
+	}
+	for in, want := range tests {
+		if got := procToolName(in); got != want {
+			t.Errorf("procToolName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestProcedureToolFromSignature(t *testing.T) {
+	sig := testProcSignature()
+
+	tool := procedureTool("dbo.usp_Orders", "Lists a customer's orders.", sig, procWrite)
+	if tool.Name != "proc_dbo_usp_Orders" {
+		t.Errorf("name = %q", tool.Name)
+	}
+	if !strings.HasPrefix(tool.Description, "Lists a customer's orders.") {
+		t.Errorf("MS_Description not used: %q", tool.Description)
+	}
+	if !reflect.DeepEqual(tool.InputSchema, sig.inputSchema()) {
+		t.Errorf("input schema not derived from the signature: %+v", tool.InputSchema)
+	}
+
+	if *tool.Annotations.ReadOnlyHint || !*tool.Annotations.DestructiveHint {
+		t.Error("write procedure must be annotated as destructive")
+	}
+
+	if tool := procedureTool("dbo.usp_Orders", "  ", sig, procWrite); !strings.Contains(tool.Description, "dbo.usp_Orders") {
+		t.Errorf("fallback description should name the procedure: %q", tool.Description)
+	}
+	if tool := procedureTool("reporting.usp_Daily", "", sig, procRead); !*tool.Annotations.ReadOnlyHint {
+		t.Error("read procedure must be annotated read-only")
+	}
+}
+
+func TestProcedureToolsListedAndRouted(t *testing.T) {
+	s := newTestMCPServer()
+	s.procTools = []procToolEntry{{
+		procedure: "dbo.usp_Orders",
+		tool:      procedureTool("dbo.usp_Orders", "", testProcSignature(), procUnclassified),
+	}}
+
+	found := false
+	for _, tool := range s.listTools() {
+		if tool.Name == "proc_dbo_usp_Orders" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("per-procedure tool missing from tools/list")
+	}
+
+	// Not connected: the call must reach execute_procedure (and fail
+	// there), not be rejected as an unknown tool.
+	resp := s.handleToolCall("1", CallToolParams{Name: "proc_dbo_usp_Orders", Arguments: map[string]interface{}{"CustomerId": 1}})
+	if resp.Error != nil {
+		t.Fatalf("per-procedure tool not routed: %v", resp.Error.Message)
+	}
+	if result := resp.Result.(CallToolResult); !result.IsError || !strings.Contains(result.Content[0].Text, "not connected") {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	resp = s.handleToolCall("2", CallToolParams{Name: "proc_unknown"})
+	if resp.Error == nil {
+		t.Error("unknown per-procedure tool must be rejected")
+	}
+}
+
+func TestRefreshProcedureToolsNotifiesWhenDisabled(t *testing.T) {
+	s := newTestMCPServer()
+	var buf bytes.Buffer
+	s.out = &buf
+	s.procTools = []procToolEntry{{
+		procedure: "dbo.usp_Orders",
+		tool:      procedureTool("dbo.usp_Orders", "", testProcSignature(), procUnclassified),
+	}}
+
+	// MSSQL_PROCEDURE_TOOLS is off: the tools are withdrawn and clients told.
+	s.refreshProcedureTools(context.Background())
+	if len(s.procedureTools()) != 0 {
+		t.Error("procedure tools should be removed when the feature is off")
+	}
+	if !strings.Contains(buf.String(), "notifications/tools/list_changed") {
+		t.Errorf("expected list_changed notification, got %q", buf.String())
+	}
+
+	buf.Reset()
+	s.refreshProcedureTools(context.Background())
+	if buf.Len() != 0 {
+		t.Errorf("no notification expected when nothing changed, got %q", buf.String())
+	}
+}
+
+func TestDynamicDisconnectRefreshesProcedureTools(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	s.procToolsKick = make(chan struct{}, 1)
+	if err := s.connectToDynamicAlias("RW"); err != nil {
+		t.Fatalf("connectToDynamicAlias: %v", err)
+	}
+	select {
+	case <-s.procToolsKick: // the connect's own refresh
+	default:
+	}
+
+	s.handleToolCall("t", CallToolParams{Name: "dynamic_disconnect", Arguments: map[string]interface{}{"alias": "ro"}})
+	select {
+	case <-s.procToolsKick:
+	default:
+		t.Error("closing an alias pool should refresh the procedure tools")
+	}
+
+	s.handleToolCall("t", CallToolParams{Name: "dynamic_disconnect", Arguments: map[string]interface{}{"alias": "ro"}})
+	select {
+	case <-s.procToolsKick:
+		t.Error("an alias without a pool has no tools to drop")
+	default:
+	}
+}
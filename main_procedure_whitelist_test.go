@@ -0,0 +1,124 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatchProcWhitelist(t *testing.T) {
+	const wl = "usp_GetOrder, reporting.*:read, dbo.usp_Close*:write, sales.usp_Report:read, sales.usp_Rep*"
+	tests := []struct {
+		proc    string
+		allowed bool
+		kind    procKind
+	}{
+		{"usp_GetOrder", true, procUnclassified},
+		{"dbo.usp_getorder", true, procUnclassified},
+		{"[dbo].[usp_GetOrder]", true, procUnclassified},
+		{"hr.usp_GetOrder", false, procUnclassified},
+		{"reporting.usp_Daily", true, procRead},
+		{"Reporting.anything", true, procRead},
+		{"usp_CloseTicket", true, procWrite},
+		{"dbo.usp_Closed", true, procWrite},
+		{"dbo.usp_Open", false, procUnclassified},
+		// Two entries match: the more restrictive classification wins.
+		{"sales.usp_Report", true, procUnclassified},
+		{"a.b.c", false, procUnclassified},
+	}
+	for _, tc := range tests {
+		allowed, kind, _, _ := matchProcWhitelist(wl, tc.proc)
+		if allowed != tc.allowed || kind != tc.kind {
+			t.Errorf("matchProcWhitelist(%q) = %v, %v; want %v, %v", tc.proc, allowed, kind, tc.allowed, tc.kind)
+		}
+	}
+
+	// The matched procedure is what runs: an unqualified name is dbo's, not
+	// the one in the login's default schema.
+	for proc, want := range map[string]string{
+		"usp_GetOrder":        "[dbo].[usp_GetOrder]",
+		"[Reporting].[Daily]": "[Reporting].[Daily]",
+	} {
+		_, _, schema, name := matchProcWhitelist(wl, proc)
+		if got := qualifiedTable(schema, name); got != want {
+			t.Errorf("matchProcWhitelist(%q) runs %s, want %s", proc, got, want)
+		}
+	}
+
+	for _, bad := range []string{"dbo.usp_x:delete", "a.b.c", "dbo.usp;DROP", "dbo.usp x"} {
+		if _, err := parseProcWhitelist(bad); err == nil {
+			t.Errorf("parseProcWhitelist(%q) should fail", bad)
+		}
+		if allowed, _, _, _ := matchProcWhitelist(bad+",usp_ok", "usp_ok"); allowed {
+			t.Errorf("an invalid whitelist (%q) must not allow anything", bad)
+		}
+	}
+}
+
+func TestPerAliasProcedureWhitelist(t *testing.T) {
+	env := map[string]string{
+		"MSSQL_DYNAMIC_CRM_SERVER":               "crm.local",
+		"MSSQL_DYNAMIC_CRM_DATABASE":             "CRM",
+		"MSSQL_DYNAMIC_CRM_WHITELIST_PROCEDURES": "crm.usp_*:read",
+		"MSSQL_DYNAMIC_HR_SERVER":                "hr.local",
+		"MSSQL_DYNAMIC_HR_DATABASE":              "HR",
+	}
+	s := newTestMCPServer()
+	s.isDynamic = true
+	s.config = serverConfig{readOnly: true, whitelistProcs: "dbo.usp_Global"}
+	s.dynamicAliases = loadDynamicAliasesFromEnv(env, s.secLogger)
+	if err := validateDynamicAliases(s.dynamicAliases, true); err != nil {
+		t.Fatalf("validateDynamicAliases: %v", err)
+	}
+
+	if got := s.getEffectiveConfigFor("CRM").whitelistProcs; got != "crm.usp_*:read" {
+		t.Errorf("CRM whitelist = %q, want its own list", got)
+	}
+	if got := s.getEffectiveConfigFor("HR").whitelistProcs; got != "dbo.usp_Global" {
+		t.Errorf("HR whitelist = %q, want the global fallback", got)
+	}
+
+	env["MSSQL_DYNAMIC_CRM_WHITELIST_PROCEDURES"] = "crm.usp_*:maybe"
+	if err := validateDynamicAliases(loadDynamicAliasesFromEnv(env, s.secLogger), true); err == nil {
+		t.Error("an invalid per-alias procedure whitelist must be rejected")
+	}
+	if aliases := startupDynamicAliases(env, true, s.secLogger); len(aliases) != 0 {
+		t.Errorf("startup must reject it too, got %v", aliases)
+	}
+}
+
+func TestValidateProcedureCallClassification(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	exec := "EXEC dbo.usp_x"
+
+	ro := &queryTarget{alias: "RO", config: serverConfig{readOnly: true, whitelistProcs: "reporting.*:read,dbo.usp_Close*:write,dbo.usp_Legacy"}}
+	if err := s.validateProcedureCall(ro, "reporting.usp_Daily", "EXEC reporting.usp_Daily"); err != nil {
+		t.Errorf("read procedure on a read-only alias: %v", err)
+	}
+	if err := s.validateProcedureCall(ro, "dbo.usp_CloseTicket", exec); err == nil || !strings.Contains(err.Error(), "classified as write") {
+		t.Errorf("write procedure on a read-only alias should be refused, got %v", err)
+	}
+	if err := s.validateProcedureCall(ro, "dbo.usp_Legacy", exec); err == nil || !strings.Contains(err.Error(), "read-only") {
+		t.Errorf("unclassified procedure keeps the strict read-only EXEC ban, got %v", err)
+	}
+
+	rw := &queryTarget{alias: "RW", config: serverConfig{readOnly: false, whitelistProcs: "reporting.*:read,dbo.usp_Close*:write"}}
+	if err := s.validateProcedureCall(rw, "reporting.usp_Daily", "EXEC reporting.usp_Daily"); err != nil {
+		t.Errorf("read procedure on a writable alias needs no confirmation: %v", err)
+	}
+	err := s.validateProcedureCall(rw, "dbo.usp_CloseTicket", exec)
+	if err == nil || !strings.Contains(err.Error(), "CONFIRMATION REQUIRED") {
+		t.Fatalf("write procedure on a writable alias should require confirmation, got %v", err)
+	}
+	resp := s.handleToolCall(1, CallToolParams{Name: "confirm_operation", Arguments: map[string]interface{}{
+		"description": "EXEC dbo.usp_closeticket to close the ticket",
+	}})
+	if result := resp.Result.(CallToolResult); result.IsError {
+		t.Fatalf("confirm_operation rejected: %s", result.Content[0].Text)
+	}
+	if err := s.validateProcedureCall(rw, "dbo.usp_CloseTicket", exec); err != nil {
+		t.Errorf("confirmed write procedure should run: %v", err)
+	}
+	if err := s.validateProcedureCall(rw, "dbo.usp_CloseTicket", exec); err == nil {
+		t.Error("a confirmation must only authorise one call")
+	}
+}
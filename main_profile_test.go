@@ -0,0 +1,158 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func profileTestColumns() []profileColumn {
+	var cols []profileColumn
+	for _, c := range []struct {
+		name, typ string
+		masked    bool
+	}{
+		{"id", "int", false},
+		{"email", "nvarchar", false},
+		{"ssn", "varchar", true},
+		{"photo", "varbinary", false},
+		{"doc", "xml", false},
+		{"active", "bit", false},
+	} {
+		cols = append(cols, profileColumn{name: c.name, sqlType: c.typ, kind: profileKindOf(c.typ), masked: c.masked})
+	}
+	return cols
+}
+
+func TestProfileAggregateQuery(t *testing.T) {
+	cols := profileTestColumns()
+	q := profileAggregateQuery(cols, profileOptions{source: "[dbo].[people]", patterns: true})
+	for _, want := range []string{
+		"COUNT_BIG(DISTINCT [id])",
+		"MIN([id])",
+		"MIN(LEN([email]))",
+		"AVG(CAST(DATALENGTH([photo]) AS float))",
+		"TRY_CONVERT(uniqueidentifier, [email])",
+		"[email] LIKE '%_@_%._%'",
+		"COUNT_BIG(CASE WHEN [doc] IS NOT NULL THEN 1 END)",
+		"COUNT_BIG(DISTINCT [active])",
+		"FROM [dbo].[people]",
+	} {
+		if !strings.Contains(q, want) {
+			t.Errorf("query should contain %s:\n%s", want, q)
+		}
+	}
+	for _, unwanted := range []string{"DISTINCT [ssn]", "MIN([ssn])", "LEN([ssn])", "DISTINCT [doc]", "DISTINCT [photo]", "MIN([active])", "%!"} {
+		if strings.Contains(q, unwanted) {
+			t.Errorf("query should not contain %s:\n%s", unwanted, q)
+		}
+	}
+
+	q = profileAggregateQuery(cols, profileOptions{source: "[dbo].[people]", approx: true})
+	if !strings.Contains(q, "APPROX_COUNT_DISTINCT([id])") || strings.Contains(q, "COUNT_BIG(DISTINCT") {
+		t.Errorf("approx query should use APPROX_COUNT_DISTINCT only:\n%s", q)
+	}
+	if strings.Contains(q, "TRY_CONVERT") {
+		t.Errorf("patterns need TRY_CONVERT:\n%s", q)
+	}
+}
+
+func TestReadProfileAggregates(t *testing.T) {
+	cols := profileTestColumns()
+	opt := profileOptions{patterns: true}
+	values := []interface{}{
+		int64(10),
+		// id: non-null, distinct, min, max
+		int64(10), int64(10), int64(1), int64(10),
+		// email: non-null, distinct, min, max, length min/max/avg, patterns
+		int64(8), int64(7), "a@x.io", strings.Repeat("z", 150), int64(6), int64(150), 20.25,
+		int64(6), int64(0), int64(0), int64(0),
+		// ssn (masked): non-null only
+		int64(9),
+		// photo: non-null, length min/max/avg
+		int64(0), nil, nil, nil,
+		// doc: non-null
+		int64(5),
+		// active: non-null, distinct
+		int64(10), int64(2),
+	}
+	rows, reports := readProfileAggregates(cols, opt, values)
+	if rows != 10 || len(reports) != len(cols) {
+		t.Fatalf("rows = %d, reports = %d", rows, len(reports))
+	}
+	email := reports[1]
+	if email.Nulls != 2 || email.NullPct != 20 || *email.Distinct != 7 || email.Min != "a@x.io" {
+		t.Errorf("email = %+v", email)
+	}
+	if max := email.Max.(string); len([]rune(max)) != profileValueLimit+1 || !strings.HasSuffix(max, "…") {
+		t.Errorf("long values should be shortened, got %d characters", len([]rune(max)))
+	}
+	if email.Length == nil || email.Length.Max != 150 || email.Length.Avg != 20.3 {
+		t.Errorf("email length = %+v", email.Length)
+	}
+	if len(email.Patterns) != 1 || email.Patterns["email"] != 75 {
+		t.Errorf("email patterns = %v", email.Patterns)
+	}
+	if ssn := reports[2]; !ssn.Masked || ssn.Distinct != nil || ssn.Min != nil || ssn.Nulls != 1 {
+		t.Errorf("masked column should only report nulls: %+v", ssn)
+	}
+	if photo := reports[3]; photo.Length != nil || photo.NullPct != 100 {
+		t.Errorf("all-NULL column = %+v", photo)
+	}
+	if active := reports[5]; *active.Distinct != 2 || active.Min != nil {
+		t.Errorf("active = %+v", active)
+	}
+}
+
+func TestProfileSamplePercent(t *testing.T) {
+	cases := []struct {
+		rows int64
+		want float64
+	}{
+		{-1, 0},
+		{500000, 0},
+		{profileSampleThreshold, 0},
+		{2000000, 5},
+		{3000000, 3.3},
+		{700000000, 0.014},
+	}
+	for _, c := range cases {
+		if got := profileSamplePercent(c.rows); got != c.want {
+			t.Errorf("profileSamplePercent(%d) = %v, want %v", c.rows, got, c.want)
+		}
+	}
+}
+
+func TestSelectProfileColumns(t *testing.T) {
+	all := profileTestColumns()
+	cols, err := selectProfileColumns(all, " Active, [EMAIL] ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cols) != 2 || cols[0].name != "email" || cols[1].name != "active" {
+		t.Errorf("columns should keep table order: %+v", cols)
+	}
+	if cols, _ := selectProfileColumns(all, ""); len(cols) != len(all) {
+		t.Errorf("no list should mean every column, got %d", len(cols))
+	}
+	if _, err := selectProfileColumns(all, "id,nope"); err == nil || !strings.Contains(err.Error(), "nope") {
+		t.Errorf("unknown columns should be refused, got %v", err)
+	}
+}
+
+func TestProfileRefusals(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	cases := []struct {
+		args map[string]interface{}
+		want string
+	}{
+		{map[string]interface{}{"alias": "RW"}, "table_name"},
+		{map[string]interface{}{"alias": "NOPE", "table_name": "t"}, "NOPE"},
+	}
+	for _, c := range cases {
+		resp := s.handleProfile(1, c.args)
+		result := resp.Result.(CallToolResult)
+		if !result.IsError || !strings.Contains(result.Content[0].Text, c.want) {
+			t.Errorf("%v: got %+v, want an error about %q", c.args, result, c.want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/golang-sql/civil"
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/shopspring/decimal"
+)
+
+func TestParseQueryParamsPositional(t *testing.T) {
+	args, err := parseQueryParams([]interface{}{
+		float64(42),
+		1.5,
+		"O'Brien",
+		true,
+		nil,
+		map[string]interface{}{"value": "2026-01-31 13:45:00", "type": "datetime2"},
+		map[string]interface{}{"value": "19.90", "type": "decimal(10,2)"},
+		map[string]interface{}{"value": "{6F9619FF-8B86-D011-B42D-00C04FC964FF}", "type": "uniqueidentifier"},
+		map[string]interface{}{"value": "abc", "type": "varchar(10)"},
+	})
+	if err != nil {
+		t.Fatalf("parseQueryParams: %v", err)
+	}
+	if len(args) != 9 {
+		t.Fatalf("args = %#v", args)
+	}
+	if args[0] != int64(42) || args[1] != 1.5 || args[2] != "O'Brien" || args[3] != true || args[4] != nil {
+		t.Errorf("inferred values = %#v", args[:5])
+	}
+	if dt, ok := args[5].(civil.DateTime); !ok || dt.Date.Day != 31 || dt.Time.Hour != 13 {
+		t.Errorf("datetime2 = %#v", args[5])
+	}
+	if d, ok := args[6].(decimal.Decimal); !ok || d.String() != "19.9" {
+		t.Errorf("decimal = %#v", args[6])
+	}
+	if _, ok := args[7].(mssql.UniqueIdentifier); !ok {
+		t.Errorf("uniqueidentifier = %#v", args[7])
+	}
+	if args[8] != mssql.VarChar("abc") {
+		t.Errorf("varchar = %#v", args[8])
+	}
+}
+
+func TestParseQueryParamsNamed(t *testing.T) {
+	args, err := parseQueryParams(`{"@customer": 7, "since": {"value": "2026-01-01", "type": "date"}}`)
+	if err != nil {
+		t.Fatalf("parseQueryParams: %v", err)
+	}
+	named := map[string]interface{}{}
+	for _, a := range args {
+		n, ok := a.(sql.NamedArg)
+		if !ok {
+			t.Fatalf("expected named arguments, got %#v", a)
+		}
+		named[n.Name] = n.Value
+	}
+	if named["customer"] != int64(7) {
+		t.Errorf("customer = %#v", named["customer"])
+	}
+	if d, ok := named["since"].(civil.Date); !ok || d.Year != 2026 {
+		t.Errorf("since = %#v", named["since"])
+	}
+}
+
+func TestParseQueryParamsErrors(t *testing.T) {
+	cases := map[string]interface{}{
+		"unsupported type":  []interface{}{map[string]interface{}{"value": 1, "type": "sql_variant"}},
+		"bad int":           []interface{}{map[string]interface{}{"value": "x", "type": "int"}},
+		"too long":          []interface{}{map[string]interface{}{"value": "abcdef", "type": "nvarchar(5)"}},
+		"decimal overflow":  []interface{}{map[string]interface{}{"value": "1234.5", "type": "decimal(5,2)"}},
+		"decimal too fine":  []interface{}{map[string]interface{}{"value": "1.234", "type": "decimal(5,2)"}},
+		"bad guid":          []interface{}{map[string]interface{}{"value": "nope", "type": "uniqueidentifier"}},
+		"nested value":      []interface{}{[]interface{}{1}},
+		"unknown key":       []interface{}{map[string]interface{}{"val": 1}},
+		"bad name":          map[string]interface{}{"1st": 1},
+		"injection in name": map[string]interface{}{"x; DROP TABLE t": 1},
+		"scalar":            float64(3),
+		"bad json":          "[1,",
+	}
+	for name, v := range cases {
+		if _, err := parseQueryParams(v); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if args, err := parseQueryParams(`[{"value": "9007199254740993", "type": "bigint"}]`); err != nil || args[0] != int64(9007199254740993) {
+		t.Errorf("bigint strings keep every digit: %v %v", args, err)
+	}
+	if _, err := parseQueryParams(`[{"value": 9007199254740993, "type": "bigint"}]`); err == nil || !strings.Contains(err.Error(), "as a string") {
+		t.Errorf("bigint numbers beyond 2^53 should be refused, got %v", err)
+	}
+	if args, err := parseQueryParams([]interface{}{map[string]interface{}{"value": "123.45", "type": "decimal(5,2)"}}); err != nil || args[0].(decimal.Decimal).String() != "123.45" {
+		t.Errorf("decimal(5,2) should hold 123.45: %v %v", args, err)
+	}
+	if args, err := parseQueryParams(`[{"value": "12345678901234567890123456.789", "type": "decimal(38,3)"}]`); err != nil || args[0].(decimal.Decimal).String() != "12345678901234567890123456.789" {
+		t.Errorf("decimal strings keep every digit: %v %v", args, err)
+	}
+	if _, err := parseQueryParams(`[{"value": 1234567890123456.7, "type": "decimal(38,3)"}]`); err == nil || !strings.Contains(err.Error(), "as a string") {
+		t.Errorf("decimal numbers beyond float64 precision should be refused, got %v", err)
+	}
+	if _, err := parseQueryParams(`[{"value": "NaN", "type": "decimal(10,2)"}]`); err == nil {
+		t.Error("NaN is not a decimal")
+	}
+}
+
+// The policy pipeline sees the parameterized text: a write stays a write
+// whatever the values, and values never reach the SQL text.
+func TestQueryDatabaseParamsPolicy(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	if err := s.connectToDynamicAlias("RO"); err != nil {
+		t.Fatal(err)
+	}
+	call := func(args map[string]interface{}) CallToolResult {
+		return s.handleToolCall(1, CallToolParams{Name: "query_database", Arguments: args}).Result.(CallToolResult)
+	}
+
+	result := call(map[string]interface{}{"query": "DELETE FROM users WHERE id = @p1", "params": []interface{}{float64(1)}})
+	if !result.IsError || !strings.Contains(result.Content[0].Text, "read-only") {
+		t.Errorf("parameterized DELETE must be refused on a read-only alias, got %+v", result)
+	}
+	result = call(map[string]interface{}{"query": "SELECT * FROM users WHERE id = @p1", "params": []interface{}{map[string]interface{}{"value": 1, "type": "money(4)"}}})
+	if !result.IsError || !strings.Contains(result.Content[0].Text, "params: @p1") {
+		t.Errorf("invalid params must be reported before running the query, got %+v", result)
+	}
+}
+
+// Schema-validating clients must be able to send params as a JSON array or
+// object, not only as a string.
+func TestQueryParamsSchemaAcceptsArraysAndObjects(t *testing.T) {
+	s := newTestMCPServer()
+	s.config.exportEnabled = true
+	for _, tool := range s.listTools() {
+		if tool.Name != "query_database" && tool.Name != "export_query" {
+			continue
+		}
+		data, err := json.Marshal(tool.InputSchema.Properties["params"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		var prop map[string]interface{}
+		if err := json.Unmarshal(data, &prop); err != nil {
+			t.Fatal(err)
+		}
+		types, _ := prop["type"].([]interface{})
+		if len(types) != 3 || types[0] != "array" || types[1] != "object" || types[2] != "string" {
+			t.Errorf("%s params type = %v", tool.Name, prop["type"])
+		}
+		var back Property
+		if err := json.Unmarshal(data, &back); err != nil || len(back.Types) != 3 || back.Description == "" {
+			t.Errorf("%s params does not round-trip: %+v %v", tool.Name, back, err)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newReloadTestServer returns a dynamic-mode server whose .env lives in a temp
+// dir. The returned path can be rewritten by the test before reloadConfig.
+func newReloadTestServer(t *testing.T) (*MCPMSSQLServer, string) {
+	t.Helper()
+	s := newTestMCPServer()
+	s.isDynamic = true
+	s.envFilePath = filepath.Join(t.TempDir(), ".env")
+	s.envFileValues = map[string]string{}
+	s.dynamicAliases = map[string]DynamicAlias{}
+	s.connections = map[string]*sql.DB{}
+	return s, s.envFilePath
+}
+
+// writeEnvFile writes content to path and registers cleanup for every key it
+// sets so reloadConfig's os.Setenv calls do not leak into other tests.
+func writeEnvFile(t *testing.T, path, content string) {
+	t.Helper()
+	for key := range parseDotEnv([]byte(content)) {
+		t.Setenv(key, os.Getenv(key))
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write .env: %v", err)
+	}
+}
+
+func TestParseDotEnv(t *testing.T) {
+	got := parseDotEnv([]byte("# comment\n// also comment\n\nA=1\nB = \"two\"\nC='three'\nD=\"\nnoequals\n=novalue\n"))
+	want := map[string]string{"A": "1", "B": "two", "C": "three", "D": `"`}
+	if len(got) != len(want) {
+		t.Fatalf("parseDotEnv returned %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("parseDotEnv[%s] = %q, want %q", k, got[k], v)
+		}
+	}
+}
+
+func TestReloadConfigAppliesPolicyAndAliases(t *testing.T) {
+	s, envPath := newReloadTestServer(t)
+	writeEnvFile(t, envPath, strings.Join([]string{
+		"MSSQL_READ_ONLY=true",
+		"MSSQL_DYNAMIC_CRM_SERVER=crm.local",
+		"MSSQL_DYNAMIC_CRM_DATABASE=CRM",
+		"MSSQL_DYNAMIC_CRM_READ_ONLY=false",
+		"MSSQL_DYNAMIC_CRM_WHITELIST_TABLES=temp_ai",
+	}, "\n"))
+
+	if err := s.reloadConfig("test"); err != nil {
+		t.Fatalf("reloadConfig: %v", err)
+	}
+	alias, ok := s.dynamicAliases["CRM"]
+	if !ok {
+		t.Fatalf("alias CRM not loaded, got %v", s.dynamicAliases)
+	}
+	if alias.ReadOnly || len(alias.WhitelistTables) != 1 {
+		t.Errorf("unexpected CRM posture: %+v", alias)
+	}
+	if os.Getenv("MSSQL_DYNAMIC_CRM_SERVER") != "crm.local" {
+		t.Error("reloaded .env value was not applied to the process environment")
+	}
+
+	// Tighten the whitelist: the active alias must see it immediately.
+	s.activeAlias = "CRM"
+	writeEnvFile(t, envPath, strings.Join([]string{
+		"MSSQL_READ_ONLY=true",
+		"MSSQL_DYNAMIC_CRM_SERVER=crm.local",
+		"MSSQL_DYNAMIC_CRM_DATABASE=CRM",
+	}, "\n"))
+	if err := s.reloadConfig("test"); err != nil {
+		t.Fatalf("reloadConfig: %v", err)
+	}
+	if eff := s.getEffectiveConfig(); !eff.readOnly || len(eff.whitelistTables) != 0 {
+		t.Errorf("tightened policy not applied to active alias: %+v", eff)
+	}
+	if s.activeAlias != "CRM" {
+		t.Error("policy-only change must not disconnect the active alias")
+	}
+	if os.Getenv("MSSQL_DYNAMIC_CRM_WHITELIST_TABLES") != "" {
+		t.Error("key removed from .env should be unset from the process environment")
+	}
+}
+
+func TestReloadConfigClosesRemovedAndChangedAliases(t *testing.T) {
+	s, envPath := newReloadTestServer(t)
+	writeEnvFile(t, envPath, strings.Join([]string{
+		"MSSQL_READ_ONLY=true",
+		"MSSQL_DYNAMIC_KEEP_SERVER=a", "MSSQL_DYNAMIC_KEEP_DATABASE=A",
+		"MSSQL_DYNAMIC_GONE_SERVER=b", "MSSQL_DYNAMIC_GONE_DATABASE=B",
+		"MSSQL_DYNAMIC_MOVED_SERVER=c", "MSSQL_DYNAMIC_MOVED_DATABASE=C",
+	}, "\n"))
+	if err := s.reloadConfig("test"); err != nil {
+		t.Fatalf("reloadConfig: %v", err)
+	}
+
+	// sql.Open does not dial, so these handles are safe placeholders.
+	for _, name := range []string{"KEEP", "GONE", "MOVED"} {
+		db, err := sql.Open("sqlserver", "server=127.0.0.1;database=x")
+		if err != nil {
+			t.Fatalf("sql.Open: %v", err)
+		}
+		s.connections[name] = db
+	}
+	s.activeAlias = "MOVED"
+	s.db = s.connections["MOVED"]
+
+	writeEnvFile(t, envPath, strings.Join([]string{
+		"MSSQL_READ_ONLY=true",
+		"MSSQL_DYNAMIC_KEEP_SERVER=a", "MSSQL_DYNAMIC_KEEP_DATABASE=A",
+		"MSSQL_DYNAMIC_MOVED_SERVER=c2", "MSSQL_DYNAMIC_MOVED_DATABASE=C",
+	}, "\n"))
+	if err := s.reloadConfig("test"); err != nil {
+		t.Fatalf("reloadConfig: %v", err)
+	}
+
+	if _, ok := s.connections["KEEP"]; !ok {
+		t.Error("unchanged alias connection should be kept")
+	}
+	if _, ok := s.connections["GONE"]; ok {
+		t.Error("removed alias connection should be closed")
+	}
+	if _, ok := s.connections["MOVED"]; ok {
+		t.Error("alias with changed server should have its connection closed")
+	}
+	if s.activeAlias != "" || s.getDB() != nil {
+		t.Error("active alias whose connection was closed should be cleared")
+	}
+}
+
+func TestReloadConfigRejectsInvalidCandidate(t *testing.T) {
+	s, envPath := newReloadTestServer(t)
+	writeEnvFile(t, envPath, "MSSQL_READ_ONLY=true\nMSSQL_DYNAMIC_CRM_SERVER=crm\nMSSQL_DYNAMIC_CRM_DATABASE=CRM\n")
+	if err := s.reloadConfig("test"); err != nil {
+		t.Fatalf("reloadConfig: %v", err)
+	}
+
+	// Password carrying a field separator must be rejected as a whole.
+	writeEnvFile(t, envPath, "MSSQL_READ_ONLY=true\nMSSQL_DYNAMIC_CRM_SERVER=other\nMSSQL_DYNAMIC_CRM_DATABASE=CRM\nMSSQL_DYNAMIC_CRM_PASSWORD=x;encrypt=false\n")
+	if err := s.reloadConfig("test"); err == nil {
+		t.Fatal("expected invalid candidate configuration to be rejected")
+	}
+	if got := s.dynamicAliases["CRM"].Server; got != "crm" {
+		t.Errorf("rejected reload must keep previous aliases, got server %q", got)
+	}
+	if got := os.Getenv("MSSQL_DYNAMIC_CRM_SERVER"); got != "crm" {
+		t.Errorf("rejected reload must not touch the environment, got %q", got)
+	}
+}
+
+func TestReloadConfigHostEnvWins(t *testing.T) {
+	s, envPath := newReloadTestServer(t)
+	t.Setenv("MSSQL_WHITELIST_TABLES", "host_table")
+	t.Setenv("MSSQL_READ_ONLY", "true")
+	writeEnvFile(t, envPath, "MSSQL_WHITELIST_TABLES=file_table\nMSSQL_WHITELIST_PROCEDURES=usp_a\n")
+
+	if err := s.reloadConfig("test"); err != nil {
+		t.Fatalf("reloadConfig: %v", err)
+	}
+	cfg := s.getGlobalConfig()
+	if len(cfg.whitelistTables) != 1 || cfg.whitelistTables[0] != "host_table" {
+		t.Errorf("host-passed whitelist should win over .env, got %v", cfg.whitelistTables)
+	}
+	if cfg.whitelistProcs != "usp_a" {
+		t.Errorf(".env value should fill unset keys, got %q", cfg.whitelistProcs)
+	}
+}
+
+func TestReloadConfigClearsPendingConfirmation(t *testing.T) {
+	s, envPath := newReloadTestServer(t)
+	writeEnvFile(t, envPath, "MSSQL_READ_ONLY=true\n")
+	s.pendingConfirmation = &PendingConfirmation{Operation: "DELETE", Tables: []string{"t"}}
+	if err := s.reloadConfig("test"); err != nil {
+		t.Fatalf("reloadConfig: %v", err)
+	}
+	if s.pendingConfirmation != nil {
+		t.Error("pending confirmation issued under the old policy must be discarded")
+	}
+}
+
+func TestSendNotificationWritesJSONRPCLine(t *testing.T) {
+	s := newTestMCPServer()
+	var buf bytes.Buffer
+	s.out = &buf
+	s.sendNotification("notifications/tools/list_changed", nil)
+
+	line := strings.TrimSpace(buf.String())
+	var msg map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &msg); err != nil {
+		t.Fatalf("notification is not valid JSON: %v (%q)", err, line)
+	}
+	if msg["method"] != "notifications/tools/list_changed" || msg["jsonrpc"] != "2.0" {
+		t.Errorf("unexpected notification: %v", msg)
+	}
+	if _, hasID := msg["id"]; hasID {
+		t.Error("notifications must not carry an id")
+	}
+}
+
+func TestStartupRejectsAliasesLikeReload(t *testing.T) {
+	env := map[string]string{
+		"MSSQL_DYNAMIC_CRM_SERVER":   "crm",
+		"MSSQL_DYNAMIC_CRM_DATABASE": "CRM",
+		"MSSQL_DYNAMIC_BAD_DATABASE": "Bad", // no SERVER
+	}
+	if aliases := startupDynamicAliases(env, true, NewSecurityLogger()); len(aliases) != 0 {
+		t.Errorf("an invalid alias must reject the startup configuration, got %v", aliases)
+	}
+
+	s, envPath := newReloadTestServer(t)
+	writeEnvFile(t, envPath, "MSSQL_READ_ONLY=true\nMSSQL_DYNAMIC_CRM_SERVER=crm\nMSSQL_DYNAMIC_CRM_DATABASE=CRM\nMSSQL_DYNAMIC_BAD_DATABASE=Bad\n")
+	if err := s.reloadConfig("test"); err == nil {
+		t.Error("reload must reject the configuration startup rejects")
+	}
+
+	delete(env, "MSSQL_DYNAMIC_BAD_DATABASE")
+	if aliases := startupDynamicAliases(env, true, NewSecurityLogger()); len(aliases) != 1 {
+		t.Errorf("a valid configuration loads at startup, got %v", aliases)
+	}
+}
+
+// A classic settings change must not leave the server without a pool until
+// the next health check: the reload wakes the supervisor to reopen it.
+func TestReloadConfigClassicChangeWakesSupervisor(t *testing.T) {
+	s, envPath := newReloadTestServer(t)
+	s.isDynamic = false
+	s.supervisorKick = make(chan struct{}, 1)
+	db, err := sql.Open("sqlserver", "server=127.0.0.1;port=1;database=x")
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	s.db = db
+
+	t.Setenv("MSSQL_DATABASE", "")
+	writeEnvFile(t, envPath, "MSSQL_DATABASE=reloaded\n")
+	if err := s.reloadConfig("test"); err != nil {
+		t.Fatalf("reloadConfig: %v", err)
+	}
+	if s.getDB() != nil {
+		t.Error("the stale classic pool must be closed")
+	}
+	select {
+	case <-s.supervisorKick:
+	default:
+		t.Error("reload should wake the connection supervisor")
+	}
+}
+
+func TestReloadConfigNotifiesToolListChanges(t *testing.T) {
+	s, envPath := newReloadTestServer(t)
+	var buf bytes.Buffer
+	s.out = &buf
+
+	writeEnvFile(t, envPath, "MSSQL_READ_ONLY=true\n")
+	if err := s.reloadConfig("test"); err != nil {
+		t.Fatalf("reloadConfig: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("an unchanged tool surface must not notify, got %q", buf.String())
+	}
+
+	// The performance and activity tools are only listed when enabled.
+	writeEnvFile(t, envPath, "MSSQL_READ_ONLY=true\nMSSQL_PERFORMANCE_INSIGHTS=true\n")
+	if err := s.reloadConfig("test"); err != nil {
+		t.Fatalf("reloadConfig: %v", err)
+	}
+	if !strings.Contains(buf.String(), "notifications/tools/list_changed") {
+		t.Errorf("expected list_changed after the tool surface changed, got %q", buf.String())
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testSnapshot() *schemaSnapshot {
+	return &schemaSnapshot{
+		FormatVersion: schemaSnapshotFormatVersion,
+		Source:        "PROD",
+		Tables: map[string]*tableSchema{
+			"dbo.Orders": {
+				Columns: map[string]columnSchema{
+					"id":     {DataType: "int", Position: 1},
+					"amount": {DataType: "decimal(18,2)", Nullable: true, Position: 2},
+					"legacy": {DataType: "varchar(10)", Nullable: true, Position: 3},
+				},
+				Indexes: map[string]indexSchema{
+					"PK_Orders": {Type: "CLUSTERED", Unique: true, PrimaryKey: true, Columns: "id"},
+				},
+				ForeignKeys: map[string]foreignKeySchema{
+					"FK_Orders_Customers": {Columns: "customer_id", ReferencedTable: "dbo.Customers", ReferencedColumns: "id", OnDelete: "NO_ACTION", OnUpdate: "NO_ACTION"},
+				},
+			},
+			"dbo.Old": {Columns: map[string]columnSchema{"id": {DataType: "int"}}},
+		},
+		Views:      map[string]string{"dbo.vOrders": "CREATE VIEW vOrders AS\r\nSELECT id FROM Orders  \r\n"},
+		Procedures: map[string]string{"dbo.usp_Report": "CREATE PROCEDURE usp_Report AS\nSELECT 1"},
+	}
+}
+
+func findChange(changes []schemaChange, objectType, name string) *schemaChange {
+	for i := range changes {
+		if changes[i].ObjectType == objectType && changes[i].Name == name {
+			return &changes[i]
+		}
+	}
+	return nil
+}
+
+func TestDiffSchemas(t *testing.T) {
+	left := testSnapshot()
+	right := testSnapshot()
+	delete(right.Tables, "dbo.Old")
+	right.Tables["dbo.New"] = &tableSchema{Columns: map[string]columnSchema{"id": {DataType: "int"}}}
+	orders := right.Tables["dbo.Orders"]
+	orders.Columns = map[string]columnSchema{
+		"id":     {DataType: "bigint", Position: 1},
+		"amount": {DataType: "decimal(18,2)", Nullable: false, Default: "((0))", Position: 2},
+	}
+	orders.Indexes = map[string]indexSchema{
+		"PK_Orders":     {Type: "CLUSTERED", Unique: true, PrimaryKey: true, Columns: "id"},
+		"IX_Orders_Amt": {Type: "NONCLUSTERED", Columns: "amount DESC"},
+	}
+	orders.ForeignKeys = map[string]foreignKeySchema{
+		"FK_Orders_Customers": {Columns: "customer_id", ReferencedTable: "dbo.Customers", ReferencedColumns: "id", OnDelete: "CASCADE", OnUpdate: "NO_ACTION"},
+	}
+	// Only line endings and trailing blanks differ: not a change.
+	right.Views["dbo.vOrders"] = "CREATE VIEW vOrders AS\nSELECT id FROM Orders\n"
+	right.Procedures["dbo.usp_Report"] = "CREATE PROCEDURE usp_Report AS\nSELECT 2"
+
+	changes := diffSchemas(left, right)
+	want := []struct{ objectType, name, change string }{
+		{"table", "dbo.Old", "removed"},
+		{"table", "dbo.New", "added"},
+		{"column", "dbo.Orders.legacy", "removed"},
+		{"column", "dbo.Orders.id", "changed"},
+		{"column", "dbo.Orders.amount", "changed"},
+		{"index", "dbo.Orders.IX_Orders_Amt", "added"},
+		{"foreign_key", "dbo.Orders.FK_Orders_Customers", "changed"},
+		{"procedure", "dbo.usp_Report", "changed"},
+	}
+	for _, w := range want {
+		c := findChange(changes, w.objectType, w.name)
+		if c == nil || c.Change != w.change {
+			t.Errorf("expected %s %s %s, got %+v", w.objectType, w.name, w.change, c)
+		}
+	}
+	if len(changes) != len(want) {
+		t.Errorf("got %d changes, want %d: %+v", len(changes), len(want), changes)
+	}
+	if c := findChange(changes, "column", "dbo.Orders.amount"); c != nil && len(c.Details) != 2 {
+		t.Errorf("amount should report nullable and default changes, got %v", c.Details)
+	}
+	if c := findChange(changes, "procedure", "dbo.usp_Report"); c != nil && !strings.Contains(c.Details[0], "line 2") {
+		t.Errorf("procedure change should point at line 2, got %v", c.Details)
+	}
+	if changes[0].ObjectType != "table" {
+		t.Errorf("table-level changes should be listed first, got %+v", changes[0])
+	}
+}
+
+func TestFormatColumnType(t *testing.T) {
+	n := func(v int64) sql.NullInt64 { return sql.NullInt64{Int64: v, Valid: true} }
+	none := sql.NullInt64{}
+	tests := []struct {
+		got, want string
+	}{
+		{formatColumnType("nvarchar", n(50), none, none, none), "nvarchar(50)"},
+		{formatColumnType("varbinary", n(-1), none, none, none), "varbinary(max)"},
+		{formatColumnType("decimal", none, n(18), n(2), none), "decimal(18,2)"},
+		{formatColumnType("datetime2", none, none, none, n(7)), "datetime2(7)"},
+		{formatColumnType("int", none, n(10), n(0), none), "int"},
+	}
+	for _, tc := range tests {
+		if tc.got != tc.want {
+			t.Errorf("got %q, want %q", tc.got, tc.want)
+		}
+	}
+}
+
+func TestSandboxFile(t *testing.T) {
+	dir := t.TempDir()
+	got, err := sandboxFile(dir, "prod-2026", ".json")
+	if err != nil || got != filepath.Join(dir, "prod-2026.json") {
+		t.Errorf("sandboxFile = %q, %v", got, err)
+	}
+	for _, bad := range []string{"../etc/passwd", "a/b", `a\b`, "..", ".hidden", "C:x", ""} {
+		if _, err := sandboxFile(dir, bad, ".json"); err == nil {
+			t.Errorf("sandboxFile accepted %q", bad)
+		}
+	}
+}
+
+func TestSchemaSnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "prod.json")
+	if err := saveSchemaSnapshot(path, testSnapshot()); err != nil {
+		t.Fatalf("saveSchemaSnapshot: %v", err)
+	}
+	loaded, err := loadSchemaSnapshot(path)
+	if err != nil {
+		t.Fatalf("loadSchemaSnapshot: %v", err)
+	}
+	if changes := diffSchemas(testSnapshot(), loaded); len(changes) != 0 {
+		t.Errorf("round-tripped snapshot should not differ, got %+v", changes)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"format_version": 99}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadSchemaSnapshot(path); err == nil {
+		t.Error("expected error for unsupported snapshot version")
+	}
+}
+
+func TestSchemaDiffArgumentErrors(t *testing.T) {
+	s := newTestMCPServer()
+	t.Setenv("MSSQL_SNAPSHOT_DIR", t.TempDir())
+	cases := []map[string]interface{}{
+		{},
+		{"right_alias": "STAGING", "snapshot": "prod"},
+		{"snapshot": "prod", "schema": "dbo; DROP"},
+		{"right_alias": "STAGING"}, // classic server without a connection
+	}
+	for _, args := range cases {
+		resp := s.handleToolCall("d", CallToolParams{Name: "schema_diff", Arguments: args})
+		if result := resp.Result.(CallToolResult); !result.IsError {
+			t.Errorf("expected error for args %v", args)
+		}
+	}
+}
@@ -1,6 +1,11 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -221,5 +226,196 @@ func TestStackedQueryAllowedInFullAccess(t *testing.T) {
 	}
 }
 
+// policyBypassAttempts are statements every SQL-accepting tool must refuse
+// on a read-only alias before anything reaches the server.
+var policyBypassAttempts = []string{
+	"DELETE FROM temp_ai WHERE id = 1",
+	"SELECT 1; DELETE FROM prod_users",
+	"SELECT 1 /* sneaky */; DROP TABLE prod_users",
+	"WITH x AS (SELECT 1 AS a) DELETE FROM prod_users",
+	"EXEC xp_cmdshell 'dir'",
+	"SET NOCOUNT ON DELETE FROM prod_users",
+	"EXEC('DELETE FROM prod_users')",
+	"SELECT * FROM OPENROWSET(BULK 'c:\\x', SINGLE_CLOB) AS f",
+}
+
+// TestPolicyBypassPerTool runs the bypass attempts through each tool that
+// accepts SQL. The alias pools never dial, so a statement that got past the
+// policy would fail with a connection error instead of a policy refusal.
+func TestPolicyBypassPerTool(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	if err := s.connectToDynamicAlias("RO"); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MSSQL_EXPORT_DIR", t.TempDir())
+	s.config.exportEnabled = true
+
+	tools := []struct {
+		name string
+		args map[string]interface{}
+	}{
+		{"query_database", nil},
+		{"explain_query", nil},
+		{"explain_query", map[string]interface{}{"mode": "estimated"}},
+		{"explain_query", map[string]interface{}{"mode": "actual"}},
+		// compare only reads: the strict read policy applies on the
+		// writable alias too.
+		{"compare", map[string]interface{}{"left_alias": "RW", "right_alias": "RO", "key_columns": "id"}},
+		{"export_query", nil},
+		{"export_query", map[string]interface{}{"alias": "RW"}},
+	}
+	for _, tool := range tools {
+		for _, q := range policyBypassAttempts {
+			args := map[string]interface{}{"query": q}
+			for k, v := range tool.args {
+				args[k] = v
+			}
+			result := s.handleToolCall(1, CallToolParams{Name: tool.name, Arguments: args}).Result.(CallToolResult)
+			text := ""
+			if len(result.Content) > 0 {
+				text = result.Content[0].Text
+			}
+			if !result.IsError || !(strings.Contains(text, "read-only") || strings.Contains(text, "only accepts")) {
+				t.Errorf("%s %v: %q should be refused by the policy, got %q", tool.name, tool.args, q, text)
+			}
+		}
+	}
+
+	// Tools that build their SQL from arguments: injection attempts are
+	// refused outright, and whatever they do send is logged as a policy
+	// decision under the tool's name before the pool is dialled.
+	var buf bytes.Buffer
+	s.secLogger = &SecurityLogger{logger: slog.New(slog.NewJSONHandler(&buf, nil))}
+	s.config.performanceInsights = true
+	importDir := t.TempDir()
+	t.Setenv("MSSQL_IMPORT_DIR", importDir)
+	if err := os.WriteFile(filepath.Join(importDir, "rows.csv"), []byte("id\n1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	built := []struct {
+		name   string
+		args   map[string]interface{}
+		refuse string // expected refusal; "" when the SQL is only checked by the pipeline
+	}{
+		{"profile", map[string]interface{}{"table_name": "temp_ai; DELETE FROM prod_users"}, "invalid table name"},
+		{"profile", map[string]interface{}{"table_name": "temp_ai", "columns": "id]; DELETE FROM prod_users--"}, ""},
+		{"find_value", map[string]interface{}{"value": "x'; DELETE FROM prod_users--", "tables": "prod_users; DELETE FROM prod_users"}, ""},
+		{"import_data", map[string]interface{}{"file_name": "rows.csv", "table": "temp_ai", "alias": "RO", "apply": true}, "writable dynamic alias"},
+		{"import_data", map[string]interface{}{"file_name": "rows.csv", "table": "temp_ai; DELETE FROM prod_users", "alias": "RW", "apply": true}, "invalid table name"},
+		{"activity", map[string]interface{}{"action": "kill_session", "session_id": float64(77), "alias": "RW"}, "not enabled"},
+		{"activity", map[string]interface{}{"action": "list"}, ""},
+	}
+	for _, c := range built {
+		buf.Reset()
+		result := s.handleToolCall(1, CallToolParams{Name: c.name, Arguments: c.args}).Result.(CallToolResult)
+		text := ""
+		if len(result.Content) > 0 {
+			text = result.Content[0].Text
+		}
+		if c.refuse != "" {
+			if !result.IsError || !strings.Contains(text, c.refuse) {
+				t.Errorf("%s %v should be refused with %q, got %q", c.name, c.args, c.refuse, text)
+			}
+			continue
+		}
+		checked := false
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var entry map[string]interface{}
+			if json.Unmarshal([]byte(line), &entry) == nil && entry["msg"] == "policy decision" && entry["tool"] == c.name {
+				checked = true
+			}
+		}
+		if !checked {
+			t.Errorf("%s %v sent SQL without a policy decision (log: %s)", c.name, c.args, buf.String())
+		}
+	}
+
+	// execute_procedure: the EXEC text built from the call goes through
+	// the same pipeline after the whitelist classification.
+	ro := &queryTarget{alias: "RO", tool: "execute_procedure", config: serverConfig{readOnly: true, whitelistProcs: "dbo.usp_Legacy,dbo.usp_Write:write"}}
+	for _, proc := range []string{"dbo.usp_Legacy", "dbo.usp_Write"} {
+		if err := s.validateProcedureCall(ro, proc, "EXEC "+proc); err == nil || !strings.Contains(err.Error(), "read-only") {
+			t.Errorf("execute_procedure %s on a read-only alias should be refused, got %v", proc, err)
+		}
+	}
+}
+
+// TestExplainQueryPolicyOnWritableAlias checks explain_query stays
+// SELECT-only where query_database would accept the statement.
+func TestExplainQueryPolicyOnWritableAlias(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	rw, err := s.resolveTarget(map[string]interface{}{"alias": "RW"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw.tool = "explain_query"
+	for _, q := range []string{"SELECT 1; DELETE FROM temp_ai", "EXEC dbo.usp_anything", "DECLARE @x int"} {
+		if err := s.enforcePolicy(policyRequest{target: rw, query: q, mode: policySelectOnly}); err == nil || !strings.Contains(err.Error(), "explain_query only accepts") {
+			t.Errorf("explain_query should refuse %q on a writable alias, got %v", q, err)
+		}
+	}
+	if err := s.enforcePolicy(policyRequest{target: rw, query: "WITH c AS (SELECT 1 AS a) SELECT a FROM c", mode: policySelectOnly}); err != nil {
+		t.Errorf("a CTE SELECT should be explainable: %v", err)
+	}
+}
+
+// TestPolicyDecisionsLoggedUniformly checks that every tool's decisions are
+// logged with the same structured fields, without the statement text.
+func TestPolicyDecisionsLoggedUniformly(t *testing.T) {
+	var buf bytes.Buffer
+	s := newAliasTargetTestServer(t)
+	s.secLogger = &SecurityLogger{logger: slog.New(slog.NewJSONHandler(&buf, nil))}
+	if err := s.connectToDynamicAlias("RO"); err != nil {
+		t.Fatal(err)
+	}
+	const secret = "DELETE FROM prod_users WHERE note = 'secret-value'"
+	for _, name := range []string{"query_database", "explain_query"} {
+		s.handleToolCall(1, CallToolParams{Name: name, Arguments: map[string]interface{}{"query": secret}})
+	}
+	rw := &queryTarget{alias: "RW", tool: "execute_procedure", config: serverConfig{whitelistProcs: "dbo.usp_Write:write"}}
+	_ = s.validateProcedureCall(rw, "dbo.usp_Write", "EXEC dbo.usp_Write")
+	// Operations are logged as a known keyword or OTHER, never as the
+	// statement's first word.
+	ro := &queryTarget{alias: "RO", config: serverConfig{readOnly: true}}
+	ro.tool = "compare"
+	_ = s.enforcePolicy(policyRequest{target: ro, query: "EXEC('DELETE FROM prod_users WHERE note = ''secret-value''')", mode: policyStrictRead})
+	ro.tool = "export_query"
+	_ = s.enforcePolicy(policyRequest{target: ro, query: "(DELETE FROM prod_users WHERE note = 'secret-value')", mode: policyStrictRead})
+	// Background catalog reads are not logged unless refused.
+	internal := &queryTarget{alias: "RO", config: serverConfig{readOnly: true}}
+	if err := s.enforcePolicy(policyRequest{target: internal, query: procToolCatalogQuery}); err != nil {
+		t.Fatalf("catalog query: %v", err)
+	}
+	if strings.Contains(buf.String(), `"tool":"internal"`) {
+		t.Errorf("allowed internal statements must not be logged: %s", buf.String())
+	}
+	_ = s.enforcePolicy(policyRequest{target: internal, query: "DELETE FROM prod_users"})
+
+	decisions := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil || entry["msg"] != "policy decision" {
+			continue
+		}
+		if strings.Contains(line, "secret-value") || entry["reason"] != nil {
+			t.Errorf("policy log must not contain the statement text: %s", line)
+		}
+		decisions[entry["tool"].(string)] = entry["decision"].(string) + "/" + entry["operation"].(string)
+	}
+	want := map[string]string{
+		"query_database":    "deny/DELETE",
+		"explain_query":     "deny/DELETE",
+		"execute_procedure": "confirm/EXEC dbo.usp_Write",
+		"compare":           "deny/EXEC",
+		"export_query":      "deny/OTHER",
+		"internal":          "deny/DELETE",
+	}
+	for tool, w := range want {
+		if decisions[tool] != w {
+			t.Errorf("%s decision = %q, want %q (log: %s)", tool, decisions[tool], w, buf.String())
+		}
+	}
+}
+
 // Ensure the test file's intent strings stay readable in failures.
 var _ = strings.TrimSpace
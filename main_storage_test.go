@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestStorageQueriesPassReadOnlyPolicy(t *testing.T) {
+	s := newTestMCPServer()
+	queries := map[string]string{
+		"partitions":     partitionStorageQuery,
+		"list":           fmt.Sprintf(tableListQuery, ""),
+		"list filtered":  fmt.Sprintf(tableListQuery, "\n\tAND t.TABLE_NAME LIKE @p1"),
+		"sizes filtered": fmt.Sprintf(tableStorageQuery, "\n\tAND t.TABLE_NAME LIKE @p1", tableSortOrders["name"]),
+	}
+	for sortBy, order := range tableSortOrders {
+		queries["sizes by "+sortBy] = fmt.Sprintf(tableStorageQuery, "", order)
+	}
+	for name, q := range queries {
+		if err := s.validateReadOnlyQueryFor(serverConfig{readOnly: true}, q); err != nil {
+			t.Errorf("%s query refused by the read-only policy: %v", name, err)
+		}
+	}
+}
+
+func TestExploreTablesRejectsUnknownSort(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	if err := s.connectToDynamicAlias("RO"); err != nil {
+		t.Fatal(err)
+	}
+	result := s.handleToolCall(1, CallToolParams{Name: "explore", Arguments: map[string]interface{}{"sort_by": "age"}}).Result.(CallToolResult)
+	if !result.IsError || !strings.Contains(result.Content[0].Text, "invalid sort_by") {
+		t.Errorf("expected invalid sort_by error, got %+v", result)
+	}
+}
@@ -313,11 +313,13 @@ func TestMCPToolsList(t *testing.T) {
 
 	// In the default test server (no MSSQL_DYNAMIC_* vars, no explicit DYNAMIC_MODE=true,
 	// and the test helper does not set classic MSSQL_* either), we are in classic mode.
-	// Therefore only the 6 core tools are exposed. This is the desired behavior:
+	// Therefore only the core tools are exposed. This is the desired behavior:
 	// classic servers (the majority of real .mcp.json usage) must not advertise
-	// dynamic_* tools so the AI does not get confused.
+	// dynamic_* tools so the AI does not get confused. export_query needs
+	// MSSQL_EXPORT_DIR.
 	expectedTools := []string{
 		"query_database", "get_database_info", "explore", "inspect", "execute_procedure", "explain_query",
+		"schema_diff", "profile", "find_value", "join_path", "diagram",
 	}
 	if len(toolsResult.Tools) != len(expectedTools) {
 		t.Errorf("Expected %d tools (classic mode), got %d. Tools: %+v",
@@ -1221,7 +1223,7 @@ func TestClassicServerDoesNotExposeDynamicTools(t *testing.T) {
 		t.Errorf("Classic server exposed %d dynamic tools (expected 0)", dynamicToolCount)
 	}
 
-	if len(toolsResult.Tools) != 6 {
-		t.Errorf("Expected exactly 6 core tools in classic mode, got %d", len(toolsResult.Tools))
+	if len(toolsResult.Tools) != 11 {
+		t.Errorf("Expected exactly 11 core tools in classic mode, got %d", len(toolsResult.Tools))
 	}
 }
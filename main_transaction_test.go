@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTransactionPolicyRefusesTransactionControl(t *testing.T) {
+	for _, q := range []string{
+		"COMMIT",
+		"commit transaction",
+		"ROLLBACK TRAN",
+		"BEGIN TRANSACTION; UPDATE temp_ai SET a = 1",
+		"SAVE TRAN before_update",
+		"SET IMPLICIT_TRANSACTIONS OFF",
+		"BEGIN DISTRIBUTED TRAN",
+		"UPDATE temp_ai SET a = 1 /* done */ COMMIT",
+		"EXEC('COMMIT')",
+		"EXECUTE ('COM' + 'MIT')",
+		"EXEC sp_executesql N'COMMIT'",
+		"UPDATE temp_ai SET a = 1; EXEC sp_executesql N'ROLLBACK'",
+		"EXEC dbo.usp_CommitAll",
+		"dbo.usp_CommitAll",
+		"DECLARE @s nvarchar(20) = N'COMMIT'; EXEC (@s)",
+	} {
+		if err := transactionPolicy(q); err == nil {
+			t.Errorf("%q should be refused inside a transaction", q)
+		}
+	}
+	if err := transactionPolicy("INSERT INTO temp_ai (note) VALUES ('committed later')"); err != nil {
+		t.Errorf("words containing a keyword should be allowed: %v", err)
+	}
+	if err := transactionPolicy("UPDATE temp_ai SET status = 'open' WHERE id = @p1"); err != nil {
+		t.Errorf("DML should be allowed: %v", err)
+	}
+	for _, q := range []string{
+		"UPDATE temp_ai SET note = 'please commit' WHERE id = 1",
+		"UPDATE temp_ai SET note = 'x' WHERE id = 1 -- rollback if wrong",
+		"SELECT [commit] FROM temp_ai /* BEGIN TRAN */",
+		"BEGIN TRY SELECT 1 END TRY BEGIN CATCH SELECT 2 END CATCH",
+		"WITH c AS (SELECT id FROM temp_ai) UPDATE temp_ai SET a = 1 WHERE id IN (SELECT id FROM c)",
+		"DECLARE @n int = 1; UPDATE temp_ai SET a = @n WHERE id = 1",
+	} {
+		if err := transactionPolicy(q); err != nil {
+			t.Errorf("%q: literals, comments and identifiers are not transaction control: %v", q, err)
+		}
+	}
+	if err := transactionPolicy("begin  tran"); err == nil || !strings.HasPrefix(err.Error(), "BEGIN TRAN is not allowed") {
+		t.Errorf("refusal should name the statement, got %v", err)
+	}
+}
+
+func TestStatementsInTransactionSkipPerStatementConfirmation(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	target, err := s.resolveTarget(map[string]interface{}{"alias": "RW"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target.tool = "query_database"
+	insert := policyRequest{target: target, query: "INSERT INTO temp_ai (id) VALUES (1)"}
+	if err := s.enforcePolicy(insert); !errors.Is(err, errConfirmationRequired) {
+		t.Fatalf("outside a transaction an INSERT needs confirmation, got %v", err)
+	}
+
+	target.tx = &pinnedTx{id: 1, alias: "RW"}
+	if err := s.enforcePolicy(insert); err != nil {
+		t.Errorf("inside a transaction the INSERT should pass, got %v", err)
+	}
+	if err := s.enforcePolicy(policyRequest{target: target, query: "COMMIT"}); err == nil || !strings.Contains(err.Error(), "commit or rollback tools") {
+		t.Errorf("COMMIT inside a transaction should be refused, got %v", err)
+	}
+}
+
+func TestCommitRequiresConfirmationWithSummary(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	tx := &pinnedTx{id: 3, alias: "RW"}
+	tx.record("INSERT", "INSERT INTO temp_ai (id) VALUES (@p1)", 1)
+	tx.record("UPDATE", "UPDATE temp_ai SET total = 10", 0)
+	target := &queryTarget{alias: "RW", config: s.getEffectiveConfigFor("RW"), tool: "commit", tx: tx}
+	commit := policyRequest{target: target, query: "COMMIT", mode: policyCommit}
+
+	err := s.enforcePolicy(commit)
+	if !errors.Is(err, errConfirmationRequired) {
+		t.Fatalf("commit should require confirmation, got %v", err)
+	}
+	for _, want := range []string{"transaction 3", "1. INSERT INTO temp_ai (id) VALUES (@p1) [1 params]", "2. UPDATE temp_ai SET total = 10"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("confirmation request should contain %q:\n%v", want, err)
+		}
+	}
+
+	result := s.handleToolCall(1, CallToolParams{Name: "confirm_operation", Arguments: map[string]interface{}{"description": "COMMIT transaction 3"}}).Result.(CallToolResult)
+	if result.IsError {
+		t.Fatalf("confirm_operation: %s", result.Content[0].Text)
+	}
+	if err := s.enforcePolicy(commit); err != nil {
+		t.Errorf("confirmed commit should pass, got %v", err)
+	}
+	if err := s.enforcePolicy(commit); !errors.Is(err, errConfirmationRequired) {
+		t.Errorf("a confirmation is consumed by one commit, got %v", err)
+	}
+}
+
+func TestBeginTransactionRefusals(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	ro, err := s.resolveTarget(map[string]interface{}{"alias": "RO"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.beginTransaction(ro, ""); err == nil || !strings.Contains(err.Error(), "read-only") {
+		t.Errorf("read-only alias should be refused, got %v", err)
+	}
+	rw, err := s.resolveTarget(map[string]interface{}{"alias": "RW"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.beginTransaction(rw, "chaos"); err == nil || !strings.Contains(err.Error(), "isolation_level") {
+		t.Errorf("unknown isolation level should be refused, got %v", err)
+	}
+	s.tx = &pinnedTx{id: 7, alias: "RW"}
+	if _, err := s.beginTransaction(rw, ""); err == nil || !strings.Contains(err.Error(), "already open") {
+		t.Errorf("a second transaction should be refused, got %v", err)
+	}
+	if s.transactionFor(ro) != nil || s.transactionFor(rw) != s.tx {
+		t.Error("only calls on the transaction's alias run inside it")
+	}
+}
+
+func TestTransactionToolsListing(t *testing.T) {
+	has := func(s *MCPMSSQLServer) bool {
+		for _, tool := range s.listTools() {
+			if tool.Name == "begin_transaction" {
+				return true
+			}
+		}
+		return false
+	}
+	s := newAliasTargetTestServer(t)
+	if !has(s) {
+		t.Error("transaction tools should be listed when an alias is writable")
+	}
+	delete(s.dynamicAliases, "RW")
+	if has(s) {
+		t.Error("transaction tools should not be listed without a writable alias")
+	}
+	if has(newTestMCPServer()) {
+		t.Error("transaction tools should not be listed in classic mode")
+	}
+
+	result := s.handleToolCall(1, CallToolParams{Name: "commit", Arguments: map[string]interface{}{}}).Result.(CallToolResult)
+	if !result.IsError || !strings.Contains(result.Content[0].Text, "no transaction is open") {
+		t.Errorf("commit without a transaction = %+v", result)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenizeSQLSkipsCommentsAndLiterals(t *testing.T) {
+	toks := tokenizeSQL("UPDATE [a]]b] /* WHERE */ SET x = N'FROM ''y''' -- WHERE\nWHERE (id IN (1, 2))")
+	var words []string
+	for _, tok := range toks {
+		words = append(words, tok.text)
+	}
+	want := "UPDATE|[a]]b]|SET|x|=|N'FROM ''y'''|WHERE|(|id|IN|(|1|,|2|)|)"
+	if got := strings.Join(words, "|"); got != want {
+		t.Fatalf("tokens = %s\nwant     %s", got, want)
+	}
+	if toks[9].depth != 1 || toks[11].depth != 2 || toks[15].depth != 0 {
+		t.Errorf("unexpected depths: %+v", toks)
+	}
+}
+
+func TestPlanUndoCapture(t *testing.T) {
+	cols := []undoColumn{{Name: "id", Key: 1}, {Name: "total"}}
+	cases := []struct {
+		query, table, want string
+	}{
+		{"DELETE FROM dbo.Orders WHERE id = 1", "dbo.Orders",
+			"DELETE FROM dbo.Orders  OUTPUT deleted.[id], deleted.[total] WHERE id = 1"},
+		{"delete [dbo].[Orders];", "[dbo].[Orders]",
+			"delete [dbo].[Orders] OUTPUT deleted.[id], deleted.[total] ;"},
+		{"DELETE TOP (10) Orders FROM Orders JOIN Customers c ON c.id = Orders.customer_id", "Orders",
+			"DELETE TOP (10) Orders  OUTPUT deleted.[id], deleted.[total] FROM Orders JOIN Customers c ON c.id = Orders.customer_id"},
+		{"UPDATE Orders SET total = (SELECT MAX(x) FROM t WHERE t.id = 2) WHERE id = 1", "Orders",
+			"UPDATE Orders SET total = (SELECT MAX(x) FROM t WHERE t.id = 2)  OUTPUT deleted.[id], deleted.[total], inserted.[id], inserted.[total] WHERE id = 1"},
+		{"UPDATE Orders WITH (ROWLOCK) SET total = 0", "Orders",
+			"UPDATE Orders WITH (ROWLOCK) SET total = 0 OUTPUT deleted.[id], deleted.[total], inserted.[id], inserted.[total] "},
+	}
+	for _, c := range cases {
+		capture, err := planUndoCapture(c.query)
+		if err != nil {
+			t.Errorf("%q: %v", c.query, err)
+			continue
+		}
+		if capture.table != c.table {
+			t.Errorf("%q: table = %q, want %q", c.query, capture.table, c.table)
+		}
+		if got := capture.rewrite(c.query, cols); got != c.want {
+			t.Errorf("%q:\n got %q\nwant %q", c.query, got, c.want)
+		}
+	}
+
+	for _, q := range []string{
+		"WITH x AS (SELECT 1 AS id) DELETE FROM Orders WHERE id IN (SELECT id FROM x)",
+		"DELETE FROM Orders OUTPUT deleted.id WHERE id = 1",
+		"DELETE FROM Orders; DELETE FROM Lines",
+		"INSERT INTO Orders (id) VALUES (1)",
+		"UPDATE Orders",
+	} {
+		if _, err := planUndoCapture(q); err == nil {
+			t.Errorf("%q should not be journaled", q)
+		}
+	}
+}
+
+func TestUndoValueRoundTrip(t *testing.T) {
+	ts := time.Date(2026, 3, 1, 13, 45, 0, 123456700, time.UTC)
+	cases := []struct {
+		col  undoColumn
+		in   interface{}
+		want interface{}
+	}{
+		{undoColumn{Type: "bigint"}, int64(9007199254740993), "9007199254740993"},
+		{undoColumn{Type: "int"}, int64(42), int64(42)},
+		{undoColumn{Type: "decimal"}, []byte("12.50"), "12.50"},
+		{undoColumn{Type: "varbinary"}, []byte{0x0a, 0xff}, "0x0AFF"},
+		{undoColumn{Type: "date"}, ts, "2026-03-01"},
+		{undoColumn{Type: "datetime2"}, ts, "2026-03-01T13:45:00.1234567"},
+		{undoColumn{Type: "nvarchar"}, "Ñandú", "Ñandú"},
+		{undoColumn{Type: "bit"}, true, true},
+		{undoColumn{Type: "nvarchar"}, nil, nil},
+	}
+	for _, c := range cases {
+		got := encodeUndoValue(c.col, c.in)
+		if got != c.want {
+			t.Errorf("encode %s %v = %#v, want %#v", c.col.Type, c.in, got, c.want)
+			continue
+		}
+		// Entries are decoded after a round trip through the journal.
+		var stored interface{}
+		data, _ := json.Marshal(got)
+		_ = json.Unmarshal(data, &stored)
+		if _, err := decodeUndoValue(c.col, stored); err != nil {
+			t.Errorf("decode %s %#v: %v", c.col.Type, got, err)
+		}
+	}
+	if v, _ := decodeUndoValue(undoColumn{Type: "bigint"}, "9007199254740993"); v != int64(9007199254740993) {
+		t.Errorf("bigint lost precision: %v", v)
+	}
+	if !sameUndoValue(int64(5), float64(5)) || sameUndoValue("5", float64(5)) {
+		t.Error("values should compare by their JSON form")
+	}
+}
+
+func undoTestEntry(op string) *undoEntry {
+	e := &undoEntry{
+		FormatVersion: undoEntryFormatVersion,
+		ID:            "20261018-101500-a1b2c3",
+		Alias:         "RW",
+		Table:         "[dbo].[temp_ai]",
+		Operation:     op,
+		Columns:       []undoColumn{{Name: "id", Type: "int", Identity: true, Key: 1}, {Name: "note", Type: "nvarchar"}},
+		Before:        [][]interface{}{{float64(1), "old"}},
+	}
+	if op == "UPDATE" {
+		e.After = [][]interface{}{{float64(1), "new"}}
+	}
+	return e
+}
+
+// Entries are re-quoted on load: the table journal is a row any writer of
+// the alias database can edit.
+func TestUndoEntryRequote(t *testing.T) {
+	e := undoTestEntry("DELETE")
+	e.Table = "dbo.temp_ai"
+	if err := e.requote("rw"); err != nil || e.Table != "[dbo].[temp_ai]" {
+		t.Errorf("requote = %q, %v", e.Table, err)
+	}
+	for name, edit := range map[string]func(*undoEntry){
+		"injected table":   func(e *undoEntry) { e.Table = "[dbo].[temp_ai] WITH (NOLOCK); DROP TABLE users --" },
+		"three-part table": func(e *undoEntry) { e.Table = "other.dbo.temp_ai" },
+		"empty table":      func(e *undoEntry) { e.Table = "" },
+		"empty column":     func(e *undoEntry) { e.Columns[1].Name = "" },
+		"duplicate column": func(e *undoEntry) { e.Columns[1].Name = "ID" },
+		"other alias":      func(e *undoEntry) { e.Alias = "PROD" },
+	} {
+		e := undoTestEntry("DELETE")
+		edit(e)
+		if err := e.requote("RW"); err == nil {
+			t.Errorf("%s: expected the entry to be refused", name)
+		}
+	}
+
+	e = undoTestEntry("DELETE")
+	e.Columns[1].Name = "note]) VALUES (1); DROP TABLE users --"
+	if err := e.requote("RW"); err != nil {
+		t.Fatal(err)
+	}
+	steps, err := buildUndoSteps(e)
+	if err != nil || !strings.Contains(steps[0].apply, "[note]]) VALUES (1); DROP TABLE users --]") {
+		t.Errorf("column names must stay quoted identifiers: %+v, %v", steps, err)
+	}
+}
+
+func TestBuildUndoSteps(t *testing.T) {
+	steps, err := buildUndoSteps(undoTestEntry("DELETE"))
+	if err != nil || len(steps) != 1 {
+		t.Fatalf("steps = %+v, err = %v", steps, err)
+	}
+	if steps[0].apply != "INSERT INTO [dbo].[temp_ai] ([id], [note]) VALUES (@p1, @p2)" || len(steps[0].args) != 2 {
+		t.Errorf("delete undo = %+v", steps[0])
+	}
+	if steps[0].check != "SELECT 1 FROM [dbo].[temp_ai] WITH (UPDLOCK, HOLDLOCK) WHERE [id] = @p1" || steps[0].key != "(id=1)" {
+		t.Errorf("delete check = %+v", steps[0])
+	}
+
+	steps, err = buildUndoSteps(undoTestEntry("UPDATE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The identity column is the key: it locates the row but is never set.
+	if steps[0].apply != "UPDATE [dbo].[temp_ai] SET [note] = @p1 WHERE [id] = @p2" {
+		t.Errorf("update undo = %q", steps[0].apply)
+	}
+	if steps[0].args[0] != "old" || steps[0].expect[1] != "new" {
+		t.Errorf("update undo should write the before-image where the after-image is: %+v", steps[0])
+	}
+
+	noKey := undoTestEntry("UPDATE")
+	noKey.Columns[0].Key = 0
+	if _, err := buildUndoSteps(noKey); err == nil {
+		t.Error("an UPDATE without key columns cannot be undone")
+	}
+}
+
+func TestUndoJournalFiles(t *testing.T) {
+	t.Setenv("MSSQL_UNDO_JOURNAL_TABLE", "")
+	t.Setenv("MSSQL_UNDO_JOURNAL_DIR", t.TempDir())
+	journal, err := undoJournalFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := undoTestEntry("DELETE")
+	if err := journal.save(t.Context(), nil, e); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	loaded, err := journal.load(t.Context(), nil, "", e.ID)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if loaded.Table != e.Table || len(loaded.Before) != 1 || loaded.UndoneAt != nil {
+		t.Errorf("loaded = %+v", loaded)
+	}
+	if err := journal.markUndone(t.Context(), nil, loaded, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := journal.load(t.Context(), nil, "", e.ID); again == nil || again.UndoneAt == nil {
+		t.Error("undone_at should be persisted")
+	}
+	if _, err := journal.load(t.Context(), nil, "", "../../etc/passwd"); err == nil {
+		t.Error("operation ids must not escape the journal directory")
+	}
+
+	t.Setenv("MSSQL_UNDO_JOURNAL_TABLE", "audit.undo_journal")
+	if journal, err = undoJournalFromEnv(); err != nil || journal.table != "[audit].[undo_journal]" {
+		t.Errorf("journal table = %+v, %v", journal, err)
+	}
+	t.Setenv("MSSQL_UNDO_JOURNAL_TABLE", "x; DROP TABLE y")
+	if _, err := undoJournalFromEnv(); err == nil {
+		t.Error("invalid journal table names should be refused")
+	}
+}
+
+func TestUndoPolicy(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	undo := func(alias string) error {
+		target, err := s.resolveTarget(map[string]interface{}{"alias": alias})
+		if err != nil {
+			t.Fatal(err)
+		}
+		target.tool = "undo_operation"
+		return s.enforcePolicy(policyRequest{target: target, query: "UNDO", mode: policyUndo, undoID: "20261018-101500-a1b2c3"})
+	}
+	if err := undo("RO"); err == nil || errors.Is(err, errConfirmationRequired) {
+		t.Errorf("undo on a read-only alias should be refused, got %v", err)
+	}
+	if err := undo("RW"); !errors.Is(err, errConfirmationRequired) {
+		t.Fatalf("undo should require confirmation, got %v", err)
+	}
+	result := s.handleToolCall(1, CallToolParams{Name: "confirm_operation", Arguments: map[string]interface{}{"description": "UNDO operation 20261018-101500-a1b2c3"}}).Result.(CallToolResult)
+	if result.IsError {
+		t.Fatalf("confirm_operation: %s", result.Content[0].Text)
+	}
+	if err := undo("RW"); err != nil {
+		t.Errorf("confirmed undo should pass, got %v", err)
+	}
+}
+
+func TestUndoJournalApplies(t *testing.T) {
+	s := newAliasTargetTestServer(t)
+	s.config.undoJournal = true
+	rw, _ := s.resolveTarget(map[string]interface{}{"alias": "RW"})
+	ro, _ := s.resolveTarget(map[string]interface{}{"alias": "RO"})
+	if !s.undoJournalApplies(rw, "DELETE FROM temp_ai WHERE id = 1") || !s.undoJournalApplies(rw, "update temp_ai set a = 1") {
+		t.Error("UPDATE and DELETE on writable aliases are journaled")
+	}
+	if s.undoJournalApplies(rw, "INSERT INTO temp_ai (id) VALUES (1)") || s.undoJournalApplies(ro, "DELETE FROM temp_ai") {
+		t.Error("only UPDATE/DELETE on writable aliases are journaled")
+	}
+
+	listed := func() bool {
+		for _, tool := range s.listTools() {
+			if tool.Name == "undo_operation" {
+				return true
+			}
+		}
+		return false
+	}
+	if !listed() {
+		t.Error("undo_operation should be listed with a writable alias")
+	}
+	s.config.undoJournal = false
+	rw, _ = s.resolveTarget(map[string]interface{}{"alias": "RW"})
+	if listed() || s.undoJournalApplies(rw, "DELETE FROM temp_ai") {
+		t.Error("the journal is off without MSSQL_UNDO_JOURNAL=true")
+	}
+
+	for value, want := range map[string]bool{"": false, "false": false, "true": true, "TRUE": true} {
+		env := map[string]string{"MSSQL_READ_ONLY": "true", "MSSQL_UNDO_JOURNAL": value}
+		if got := buildServerConfig(func(k string) string { return env[k] }, false, s.secLogger).undoJournal; got != want {
+			t.Errorf("MSSQL_UNDO_JOURNAL=%q: undoJournal = %v, want %v", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,868 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Modes of the performance tool.
+const (
+	perfModeTopQueries     = "top_queries"
+	perfModeRegressed      = "regressed"
+	perfModeWaits          = "waits"
+	perfModeBlocking       = "blocking"
+	perfModeMissingIndexes = "missing_indexes"
+)
+
+const (
+	defaultPerfTop        = 10
+	maxPerfTop            = 50
+	defaultPerfHours      = 24
+	maxPerfHours          = 24 * 30
+	defaultBaselineHours  = 24 * 7
+	defaultWaitSampleSecs = 5
+	maxWaitSampleSecs     = 30
+	// perfTextLimit truncates statement texts so a report stays readable.
+	perfTextLimit = 400
+	perfTimeout   = time.Minute
+	// regressionFactor is how much slower (average duration) a query must be
+	// in the recent window than in its baseline to be reported.
+	regressionFactor = 1.5
+	// engineEditionAzureSQLDatabase is SERVERPROPERTY('EngineEdition') on
+	// Azure SQL Database, where VIEW DATABASE STATE grants the DMVs.
+	engineEditionAzureSQLDatabase = 5
+)
+
+// perfOrderColumns maps the order_by argument to the column of the
+// top-queries queries; the value is never taken from the request.
+var perfOrderColumns = map[string]string{
+	"cpu":      "total_cpu_ms",
+	"duration": "total_duration_ms",
+	"reads":    "total_logical_reads",
+}
+
+// perfCapabilitiesQuery reads what the login may see.
+const perfCapabilitiesQuery = `SELECT CAST(SERVERPROPERTY('EngineEdition') AS int),
+	ISNULL(HAS_PERMS_BY_NAME(NULL, NULL, 'VIEW SERVER STATE'), 0),
+	ISNULL(HAS_PERMS_BY_NAME(DB_NAME(), 'DATABASE', 'VIEW DATABASE STATE'), 0)`
+
+const perfQueryStoreStateQuery = `SELECT actual_state_desc FROM sys.database_query_store_options`
+
+const perfQueryStoreTopQuery = `SELECT TOP (@p1)
+	q.query_id,
+	MAX(qt.query_sql_text) AS query_text,
+	SUM(rs.count_executions) AS executions,
+	CAST(SUM(rs.avg_cpu_time * rs.count_executions) / 1000.0 AS float) AS total_cpu_ms,
+	CAST(SUM(rs.avg_duration * rs.count_executions) / 1000.0 AS float) AS total_duration_ms,
+	CAST(SUM(rs.avg_logical_io_reads * rs.count_executions) AS float) AS total_logical_reads,
+	COUNT(DISTINCT p.plan_id) AS plan_count,
+	MAX(rs.last_execution_time) AS last_execution
+FROM sys.query_store_runtime_stats rs
+JOIN sys.query_store_runtime_stats_interval i ON i.runtime_stats_interval_id = rs.runtime_stats_interval_id
+JOIN sys.query_store_plan p ON p.plan_id = rs.plan_id
+JOIN sys.query_store_query q ON q.query_id = p.query_id
+JOIN sys.query_store_query_text qt ON qt.query_text_id = q.query_text_id
+WHERE i.end_time >= DATEADD(HOUR, -@p2, SYSUTCDATETIME())
+GROUP BY q.query_id
+ORDER BY %s DESC`
+
+// perfPlanCacheTopQuery reads sys.dm_exec_query_stats: statistics of the
+// plans still in cache, for the current database only.
+const perfPlanCacheTopQuery = `SELECT TOP (@p1)
+	CONVERT(varchar(20), qs.query_hash, 1) AS query_hash,
+	SUBSTRING(t.text, qs.statement_start_offset / 2 + 1,
+		(CASE qs.statement_end_offset WHEN -1 THEN DATALENGTH(t.text) ELSE qs.statement_end_offset END - qs.statement_start_offset) / 2 + 1) AS query_text,
+	qs.execution_count AS executions,
+	CAST(qs.total_worker_time / 1000.0 AS float) AS total_cpu_ms,
+	CAST(qs.total_elapsed_time / 1000.0 AS float) AS total_duration_ms,
+	CAST(qs.total_logical_reads AS float) AS total_logical_reads,
+	1 AS plan_count,
+	qs.last_execution_time AS last_execution
+FROM sys.dm_exec_query_stats qs
+CROSS APPLY (SELECT CONVERT(int, value) AS dbid FROM sys.dm_exec_plan_attributes(qs.plan_handle) WHERE attribute = N'dbid') pa
+OUTER APPLY sys.dm_exec_sql_text(qs.sql_handle) t
+WHERE pa.dbid = DB_ID()
+ORDER BY %s DESC`
+
+// perfRegressionQuery aggregates Query Store runtime stats per query, plan
+// and window (recent = 1 for the last @p1 hours, 0 for the baseline before).
+const perfRegressionQuery = `SELECT p.query_id, p.plan_id,
+	CASE WHEN i.start_time >= DATEADD(HOUR, -@p1, SYSUTCDATETIME()) THEN 1 ELSE 0 END AS recent,
+	SUM(rs.count_executions) AS executions,
+	CAST(SUM(rs.avg_duration * rs.count_executions) / 1000.0 AS float) AS total_duration_ms
+FROM sys.query_store_runtime_stats rs
+JOIN sys.query_store_runtime_stats_interval i ON i.runtime_stats_interval_id = rs.runtime_stats_interval_id
+JOIN sys.query_store_plan p ON p.plan_id = rs.plan_id
+WHERE i.start_time >= DATEADD(HOUR, -@p2, SYSUTCDATETIME())
+GROUP BY p.query_id, p.plan_id, CASE WHEN i.start_time >= DATEADD(HOUR, -@p1, SYSUTCDATETIME()) THEN 1 ELSE 0 END`
+
+const perfQueryTextQuery = `SELECT q.query_id, qt.query_sql_text
+FROM sys.query_store_query q
+JOIN sys.query_store_query_text qt ON qt.query_text_id = q.query_text_id
+WHERE q.query_id IN (%s)`
+
+const perfWaitStatsQuery = `SELECT wait_type, waiting_tasks_count, wait_time_ms, signal_wait_time_ms
+FROM sys.dm_os_wait_stats
+WHERE wait_time_ms > 0`
+
+// perfBlockingQuery lists the sessions involved in blocking: blocked
+// requests and their blockers, which may be idle sessions holding locks in
+// an open transaction (their last statement comes from the connection).
+const perfBlockingQuery = `SELECT s.session_id,
+	ISNULL(r.blocking_session_id, 0) AS blocking_session_id,
+	ISNULL(r.status, s.status) AS status,
+	ISNULL(r.command, '') AS command,
+	ISNULL(r.wait_type, '') AS wait_type,
+	CAST(ISNULL(r.wait_time, 0) AS bigint) AS wait_ms,
+	ISNULL(s.login_name, '') AS login_name,
+	ISNULL(s.host_name, '') AS host_name,
+	ISNULL(s.program_name, '') AS program_name,
+	ISNULL(DB_NAME(s.database_id), '') AS database_name,
+	s.open_transaction_count,
+	CASE WHEN r.sql_handle IS NULL THEN t.text
+		ELSE SUBSTRING(t.text, r.statement_start_offset / 2 + 1,
+			(CASE r.statement_end_offset WHEN -1 THEN DATALENGTH(t.text) ELSE r.statement_end_offset END - r.statement_start_offset) / 2 + 1)
+	END AS statement_text
+FROM sys.dm_exec_sessions s
+LEFT JOIN sys.dm_exec_requests r ON r.session_id = s.session_id
+LEFT JOIN sys.dm_exec_connections c ON c.session_id = s.session_id AND c.parent_connection_id IS NULL
+OUTER APPLY sys.dm_exec_sql_text(COALESCE(r.sql_handle, c.most_recent_sql_handle)) t
+WHERE s.session_id <> @@SPID
+	AND (s.session_id IN (SELECT session_id FROM sys.dm_exec_requests WHERE blocking_session_id <> 0)
+		OR s.session_id IN (SELECT blocking_session_id FROM sys.dm_exec_requests WHERE blocking_session_id <> 0))`
+
+const perfMissingIndexQuery = `SELECT TOP (@p1)
+	ISNULL(OBJECT_SCHEMA_NAME(d.object_id, d.database_id), '') AS schema_name,
+	ISNULL(OBJECT_NAME(d.object_id, d.database_id), '') AS table_name,
+	ISNULL(d.equality_columns, '') AS equality_columns,
+	ISNULL(d.inequality_columns, '') AS inequality_columns,
+	ISNULL(d.included_columns, '') AS included_columns,
+	gs.user_seeks, gs.user_scans,
+	CAST(gs.avg_user_impact AS float) AS avg_user_impact,
+	CAST(gs.avg_total_user_cost * gs.avg_user_impact * (gs.user_seeks + gs.user_scans) AS float) AS improvement
+FROM sys.dm_db_missing_index_details d
+JOIN sys.dm_db_missing_index_groups g ON g.index_handle = d.index_handle
+JOIN sys.dm_db_missing_index_group_stats gs ON gs.group_handle = g.index_group_handle
+WHERE d.database_id = DB_ID()
+ORDER BY improvement DESC`
+
+// benignWaits are idle and background waits that say nothing about the
+// workload; they are left out of the waits report.
+var benignWaits = map[string]bool{
+	"BROKER_EVENTHANDLER": true, "BROKER_RECEIVE_WAITFOR": true, "BROKER_TASK_STOP": true,
+	"BROKER_TO_FLUSH": true, "BROKER_TRANSMITTER": true, "CHECKPOINT_QUEUE": true,
+	"CHKPT": true, "CLR_AUTO_EVENT": true, "CLR_MANUAL_EVENT": true, "CLR_SEMAPHORE": true,
+	"DBMIRROR_DBM_EVENT": true, "DBMIRROR_EVENTS_QUEUE": true, "DBMIRROR_WORKER_QUEUE": true,
+	"DBMIRRORING_CMD": true, "DIRTY_PAGE_POLL": true, "DISPATCHER_QUEUE_SEMAPHORE": true,
+	"EXECSYNC": true, "FSAGENT": true, "FT_IFTS_SCHEDULER_IDLE_WAIT": true, "FT_IFTSHC_MUTEX": true,
+	"HADR_CLUSAPI_CALL": true, "HADR_FILESTREAM_IOMGR_IOCOMPLETION": true, "HADR_LOGCAPTURE_WAIT": true,
+	"HADR_NOTIFICATION_DEQUEUE": true, "HADR_TIMER_TASK": true, "HADR_WORK_QUEUE": true,
+	"KSOURCE_WAKEUP": true, "LAZYWRITER_SLEEP": true, "LOGMGR_QUEUE": true,
+	"MEMORY_ALLOCATION_EXT": true, "ONDEMAND_TASK_QUEUE": true,
+	"PARALLEL_REDO_DRAIN_WORKER": true, "PARALLEL_REDO_LOG_CACHE": true, "PARALLEL_REDO_TRAN_LIST": true,
+	"PARALLEL_REDO_WORKER_SYNC": true, "PARALLEL_REDO_WORKER_WAIT_WORK": true,
+	"PREEMPTIVE_XE_GETTARGETSTATE": true, "PWAIT_ALL_COMPONENTS_INITIALIZED": true,
+	"PWAIT_DIRECTLOGCONSUMER_GETNEXT": true, "QDS_PERSIST_TASK_MAIN_LOOP_SLEEP": true,
+	"QDS_ASYNC_QUEUE": true, "QDS_CLEANUP_STALE_QUERIES_TASK_MAIN_LOOP_SLEEP": true,
+	"QDS_SHUTDOWN_QUEUE": true, "REDO_THREAD_PENDING_WORK": true, "REQUEST_FOR_DEADLOCK_SEARCH": true,
+	"RESOURCE_QUEUE": true, "SERVER_IDLE_CHECK": true, "SLEEP_BPOOL_FLUSH": true, "SLEEP_DBSTARTUP": true,
+	"SLEEP_DCOMSTARTUP": true, "SLEEP_MASTERDBREADY": true, "SLEEP_MASTERMDREADY": true,
+	"SLEEP_MASTERUPGRADED": true, "SLEEP_MSDBSTARTUP": true, "SLEEP_SYSTEMTASK": true, "SLEEP_TASK": true,
+	"SLEEP_TEMPDBSTARTUP": true, "SNI_HTTP_ACCEPT": true, "SOS_WORK_DISPATCHER": true,
+	"SP_SERVER_DIAGNOSTICS_SLEEP": true, "SQLTRACE_BUFFER_FLUSH": true,
+	"SQLTRACE_INCREMENTAL_FLUSH_SLEEP": true, "SQLTRACE_WAIT_ENTRIES": true, "VDI_CLIENT_OTHER": true,
+	"WAIT_FOR_RESULTS": true, "WAITFOR": true, "WAITFOR_TASKSHUTDOWN": true, "WAIT_XTP_RECOVERY": true,
+	"WAIT_XTP_HOST_WAIT": true, "WAIT_XTP_OFFLINE_CKPT_NEW_LOG": true, "WAIT_XTP_CKPT_CLOSE": true,
+	"XE_DISPATCHER_JOIN": true, "XE_DISPATCHER_WAIT": true, "XE_TIMER_EVENT": true,
+}
+
+// perfCapabilities is what the login is allowed to read.
+type perfCapabilities struct {
+	// dmv: server-level DMVs (VIEW SERVER STATE, or VIEW DATABASE STATE on
+	// Azure SQL Database).
+	dmv bool
+	// databaseState: VIEW DATABASE STATE, needed by Query Store views.
+	databaseState bool
+	// queryStore is sys.database_query_store_options.actual_state_desc, or
+	// "" when unavailable (SQL Server before 2016, no permission).
+	queryStore string
+}
+
+func (c perfCapabilities) queryStoreReadable() bool {
+	return c.databaseState && (c.queryStore == "READ_ONLY" || c.queryStore == "READ_WRITE")
+}
+
+type perfQuery struct {
+	QueryID           int64      `json:"query_id,omitempty"`
+	QueryHash         string     `json:"query_hash,omitempty"`
+	Text              string     `json:"text"`
+	Executions        int64      `json:"executions"`
+	TotalCPUMs        float64    `json:"total_cpu_ms"`
+	AvgCPUMs          float64    `json:"avg_cpu_ms"`
+	TotalDurationMs   float64    `json:"total_duration_ms"`
+	AvgDurationMs     float64    `json:"avg_duration_ms"`
+	TotalLogicalReads float64    `json:"total_logical_reads"`
+	AvgLogicalReads   float64    `json:"avg_logical_reads"`
+	Plans             int64      `json:"plans"`
+	LastExecution     *time.Time `json:"last_execution,omitempty"`
+}
+
+// qsPlanWindow is one row of perfRegressionQuery.
+type qsPlanWindow struct {
+	queryID, planID int64
+	recent          bool
+	executions      int64
+	durationMs      float64
+}
+
+type perfRegression struct {
+	QueryID       int64   `json:"query_id"`
+	Text          string  `json:"text,omitempty"`
+	RecentAvgMs   float64 `json:"recent_avg_duration_ms"`
+	BaselineAvgMs float64 `json:"baseline_avg_duration_ms"`
+	Ratio         float64 `json:"ratio"`
+	RecentExecs   int64   `json:"recent_executions"`
+	ExtraMs       float64 `json:"extra_duration_ms"`
+	RecentPlans   []int64 `json:"recent_plans"`
+	BaselinePlans []int64 `json:"baseline_plans"`
+	PlanChanged   bool    `json:"plan_changed"`
+}
+
+type waitSample struct {
+	tasks, waitMs, signalMs int64
+}
+
+type perfWait struct {
+	WaitType string  `json:"wait_type"`
+	WaitMs   int64   `json:"wait_ms"`
+	SignalMs int64   `json:"signal_ms"`
+	Tasks    int64   `json:"waiting_tasks"`
+	Percent  float64 `json:"percent"`
+}
+
+// blockingSession is a session involved in blocking.
+type blockingSession struct {
+	SessionID        int    `json:"session_id"`
+	BlockedBy        int    `json:"blocked_by,omitempty"`
+	Status           string `json:"status,omitempty"`
+	Command          string `json:"command,omitempty"`
+	WaitType         string `json:"wait_type,omitempty"`
+	WaitMs           int64  `json:"wait_ms,omitempty"`
+	Login            string `json:"login,omitempty"`
+	Host             string `json:"host,omitempty"`
+	Program          string `json:"program,omitempty"`
+	Database         string `json:"database,omitempty"`
+	OpenTransactions int    `json:"open_transactions"`
+	Statement        string `json:"statement,omitempty"`
+}
+
+// blockingNode is a session with the sessions it blocks.
+type blockingNode struct {
+	blockingSession
+	Blocking []*blockingNode `json:"blocking,omitempty"`
+}
+
+type perfMissingIndex struct {
+	planMissingIndex
+	UserSeeks   int64   `json:"user_seeks"`
+	UserScans   int64   `json:"user_scans"`
+	Improvement float64 `json:"improvement_measure"`
+}
+
+// perfReport is the result of one performance call.
+type perfReport struct {
+	Mode           string             `json:"mode"`
+	Source         string             `json:"source,omitempty"`
+	Summary        string             `json:"summary"`
+	Notes          []string           `json:"notes,omitempty"`
+	Queries        []perfQuery        `json:"queries,omitempty"`
+	Regressions    []perfRegression   `json:"regressions,omitempty"`
+	Waits          []perfWait         `json:"waits,omitempty"`
+	Blocking       []*blockingNode    `json:"blocking,omitempty"`
+	MissingIndexes []perfMissingIndex `json:"missing_indexes,omitempty"`
+}
+
+// viewServerStateNote explains a mode that needs VIEW SERVER STATE.
+func viewServerStateNote(mode string) string {
+	return fmt.Sprintf("%s needs VIEW SERVER STATE, which this login does not have. A DBA can grant it with: GRANT VIEW SERVER STATE TO [login]", mode)
+}
+
+// truncateText shortens s to perfTextLimit characters, collapsing runs of
+// whitespace so multi-line statements stay compact.
+func truncateText(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > perfTextLimit {
+		return string(r[:perfTextLimit]) + "…"
+	}
+	return s
+}
+
+// round1 rounds to one decimal.
+func round1(v float64) float64 {
+	return float64(int64(v*10+0.5)) / 10
+}
+
+// scanQuery runs query on target under the policy pipeline and calls scan
+// for every row.
+func (s *MCPMSSQLServer) scanQuery(ctx context.Context, target *queryTarget, query string, args []interface{}, scan func(*sql.Rows) error) error {
+	rows, closeRows, err := s.openSecureRowsOn(ctx, target, query, args...)
+	if err != nil {
+		return err
+	}
+	defer closeRows()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// perfCapabilitiesOn probes what the login may read. A failed Query Store
+// probe only makes Query Store unavailable.
+func (s *MCPMSSQLServer) perfCapabilitiesOn(ctx context.Context, target *queryTarget) (perfCapabilities, error) {
+	var caps perfCapabilities
+	err := s.scanQuery(ctx, target, perfCapabilitiesQuery, nil, func(rows *sql.Rows) error {
+		var edition sql.NullInt64
+		var server, database int
+		if err := rows.Scan(&edition, &server, &database); err != nil {
+			return err
+		}
+		caps.databaseState = database == 1
+		caps.dmv = server == 1 || (edition.Int64 == engineEditionAzureSQLDatabase && caps.databaseState)
+		return nil
+	})
+	if err != nil {
+		return caps, err
+	}
+	_ = s.scanQuery(ctx, target, perfQueryStoreStateQuery, nil, func(rows *sql.Rows) error { // #nosec G104 - Query Store is optional
+		var state sql.NullString
+		if err := rows.Scan(&state); err != nil {
+			return err
+		}
+		caps.queryStore = strings.ToUpper(state.String)
+		return nil
+	})
+	return caps, nil
+}
+
+// perfTopQueries reports the most expensive queries, from Query Store when
+// it is readable (source "auto" or "query_store") and from the plan cache
+// otherwise.
+func (s *MCPMSSQLServer) perfTopQueries(ctx context.Context, target *queryTarget, caps perfCapabilities, args map[string]interface{}) (*perfReport, error) {
+	orderBy, _ := args["order_by"].(string)
+	orderBy = strings.ToLower(strings.TrimSpace(orderBy))
+	if orderBy == "" {
+		orderBy = "cpu"
+	}
+	column, ok := perfOrderColumns[orderBy]
+	if !ok {
+		return nil, fmt.Errorf("invalid order_by '%s' (use cpu, duration or reads)", orderBy)
+	}
+	source, _ := args["source"].(string)
+	source = strings.ToLower(strings.TrimSpace(source))
+	top := intArg(args, "top", defaultPerfTop, maxPerfTop)
+	hours := intArg(args, "hours", defaultPerfHours, maxPerfHours)
+
+	report := &perfReport{Mode: perfModeTopQueries}
+	useQueryStore := false
+	switch source {
+	case "", "auto":
+		useQueryStore = caps.queryStoreReadable()
+		if !useQueryStore && caps.queryStore != "" && caps.queryStore != "READ_ONLY" && caps.queryStore != "READ_WRITE" {
+			report.Notes = append(report.Notes, fmt.Sprintf("Query Store is %s on this database: using the plan cache, which only covers plans still cached.", caps.queryStore))
+		}
+	case "query_store":
+		if !caps.queryStoreReadable() {
+			report.Summary = "Query Store is not readable on this database"
+			report.Notes = append(report.Notes, "Query Store must be enabled (ALTER DATABASE ... SET QUERY_STORE = ON) and the login needs VIEW DATABASE STATE. Use source=dmv for the plan cache.")
+			return report, nil
+		}
+		useQueryStore = true
+	case "dmv":
+	default:
+		return nil, fmt.Errorf("invalid source '%s' (use auto, query_store or dmv)", source)
+	}
+	if !useQueryStore && !caps.dmv {
+		report.Summary = "No query statistics available"
+		report.Notes = append(report.Notes, viewServerStateNote("The plan cache (sys.dm_exec_query_stats)"))
+		if !caps.queryStoreReadable() {
+			report.Notes = append(report.Notes, "Query Store is not enabled or not readable either.")
+		}
+		return report, nil
+	}
+
+	query, queryArgs := fmt.Sprintf(perfPlanCacheTopQuery, column), []interface{}{top}
+	report.Source = "plan cache (sys.dm_exec_query_stats)"
+	report.Summary = fmt.Sprintf("Top %d queries by %s among plans still in cache", top, orderBy)
+	if useQueryStore {
+		query, queryArgs = fmt.Sprintf(perfQueryStoreTopQuery, column), []interface{}{top, hours}
+		report.Source = "Query Store"
+		report.Summary = fmt.Sprintf("Top %d queries by %s over the last %dh", top, orderBy, hours)
+	}
+
+	err := s.scanQuery(ctx, target, query, queryArgs, func(rows *sql.Rows) error {
+		var q perfQuery
+		var id sql.NullInt64
+		var hash, text sql.NullString
+		var last sql.NullTime
+		dest := []interface{}{&hash, &text, &q.Executions, &q.TotalCPUMs, &q.TotalDurationMs, &q.TotalLogicalReads, &q.Plans, &last}
+		if useQueryStore {
+			dest[0] = &id
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		q.QueryID, q.QueryHash, q.Text = id.Int64, hash.String, truncateText(text.String)
+		if last.Valid {
+			q.LastExecution = &last.Time
+		}
+		if q.Executions > 0 {
+			n := float64(q.Executions)
+			q.AvgCPUMs = round1(q.TotalCPUMs / n)
+			q.AvgDurationMs = round1(q.TotalDurationMs / n)
+			q.AvgLogicalReads = round1(q.TotalLogicalReads / n)
+		}
+		q.TotalCPUMs, q.TotalDurationMs = round1(q.TotalCPUMs), round1(q.TotalDurationMs)
+		report.Queries = append(report.Queries, q)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(report.Queries) == 0 {
+		report.Summary += ": no statistics recorded"
+	}
+	return report, nil
+}
+
+// findRegressions compares, per query, the average duration in the recent
+// window with the baseline window and returns the queries at least
+// regressionFactor slower, most extra time first.
+func findRegressions(windows []qsPlanWindow, top int) []perfRegression {
+	type acc struct {
+		recentExecs, baseExecs int64
+		recentMs, baseMs       float64
+		recentPlans, basePlans map[int64]bool
+	}
+	byQuery := map[int64]*acc{}
+	for _, w := range windows {
+		a := byQuery[w.queryID]
+		if a == nil {
+			a = &acc{recentPlans: map[int64]bool{}, basePlans: map[int64]bool{}}
+			byQuery[w.queryID] = a
+		}
+		if w.recent {
+			a.recentExecs += w.executions
+			a.recentMs += w.durationMs
+			a.recentPlans[w.planID] = true
+		} else {
+			a.baseExecs += w.executions
+			a.baseMs += w.durationMs
+			a.basePlans[w.planID] = true
+		}
+	}
+
+	sortedIDs := func(set map[int64]bool) []int64 {
+		ids := make([]int64, 0, len(set))
+		for id := range set {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		return ids
+	}
+
+	var out []perfRegression
+	for id, a := range byQuery {
+		if a.recentExecs == 0 || a.baseExecs == 0 || a.baseMs <= 0 {
+			continue
+		}
+		recentAvg, baseAvg := a.recentMs/float64(a.recentExecs), a.baseMs/float64(a.baseExecs)
+		if recentAvg < baseAvg*regressionFactor {
+			continue
+		}
+		r := perfRegression{
+			QueryID:       id,
+			RecentAvgMs:   round1(recentAvg),
+			BaselineAvgMs: round1(baseAvg),
+			Ratio:         round1(recentAvg / baseAvg),
+			RecentExecs:   a.recentExecs,
+			ExtraMs:       round1((recentAvg - baseAvg) * float64(a.recentExecs)),
+			RecentPlans:   sortedIDs(a.recentPlans),
+			BaselinePlans: sortedIDs(a.basePlans),
+		}
+		for p := range a.recentPlans {
+			if !a.basePlans[p] {
+				r.PlanChanged = true
+			}
+		}
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].ExtraMs != out[j].ExtraMs {
+			return out[i].ExtraMs > out[j].ExtraMs
+		}
+		return out[i].QueryID < out[j].QueryID
+	})
+	if len(out) > top {
+		out = out[:top]
+	}
+	return out
+}
+
+// perfRegressed reports queries whose recent duration regressed against
+// their Query Store history, flagging those that changed plan.
+func (s *MCPMSSQLServer) perfRegressed(ctx context.Context, target *queryTarget, caps perfCapabilities, args map[string]interface{}) (*perfReport, error) {
+	top := intArg(args, "top", defaultPerfTop, maxPerfTop)
+	hours := intArg(args, "hours", defaultPerfHours, maxPerfHours)
+	baseline := intArg(args, "baseline_hours", defaultBaselineHours, maxPerfHours)
+
+	report := &perfReport{Mode: perfModeRegressed, Source: "Query Store"}
+	if !caps.queryStoreReadable() {
+		report.Summary = "Regressions need Query Store history"
+		report.Notes = append(report.Notes, "Query Store must be enabled (ALTER DATABASE ... SET QUERY_STORE = ON) and the login needs VIEW DATABASE STATE.")
+		return report, nil
+	}
+
+	var windows []qsPlanWindow
+	err := s.scanQuery(ctx, target, perfRegressionQuery, []interface{}{hours, hours + baseline}, func(rows *sql.Rows) error {
+		var w qsPlanWindow
+		var recent int
+		if err := rows.Scan(&w.queryID, &w.planID, &recent, &w.executions, &w.durationMs); err != nil {
+			return err
+		}
+		w.recent = recent == 1
+		windows = append(windows, w)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	report.Regressions = findRegressions(windows, top)
+	report.Summary = fmt.Sprintf("%d quer(y/ies) at least %.1fx slower in the last %dh than in the previous %dh", len(report.Regressions), regressionFactor, hours, baseline)
+	if len(report.Regressions) == 0 {
+		return report, nil
+	}
+
+	placeholders := make([]string, len(report.Regressions))
+	ids := make([]interface{}, len(report.Regressions))
+	byID := map[int64]*perfRegression{}
+	for i := range report.Regressions {
+		placeholders[i] = fmt.Sprintf("@p%d", i+1)
+		ids[i] = report.Regressions[i].QueryID
+		byID[report.Regressions[i].QueryID] = &report.Regressions[i]
+	}
+	err = s.scanQuery(ctx, target, fmt.Sprintf(perfQueryTextQuery, strings.Join(placeholders, ", ")), ids, func(rows *sql.Rows) error {
+		var id int64
+		var text sql.NullString
+		if err := rows.Scan(&id, &text); err != nil {
+			return err
+		}
+		if r := byID[id]; r != nil {
+			r.Text = truncateText(text.String)
+		}
+		return nil
+	})
+	return report, err
+}
+
+// deltaWaits returns the non-benign waits accumulated between two
+// snapshots, largest first. Counters that went down (cleared in between)
+// are ignored.
+func deltaWaits(before, after map[string]waitSample, top int) []perfWait {
+	var out []perfWait
+	var total int64
+	for wt, a := range after {
+		if benignWaits[wt] {
+			continue
+		}
+		b := before[wt]
+		d := perfWait{WaitType: wt, WaitMs: a.waitMs - b.waitMs, SignalMs: a.signalMs - b.signalMs, Tasks: a.tasks - b.tasks}
+		if d.WaitMs <= 0 || d.SignalMs < 0 || d.Tasks < 0 {
+			continue
+		}
+		total += d.WaitMs
+		out = append(out, d)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].WaitMs != out[j].WaitMs {
+			return out[i].WaitMs > out[j].WaitMs
+		}
+		return out[i].WaitType < out[j].WaitType
+	})
+	for i := range out {
+		out[i].Percent = round1(float64(out[i].WaitMs) * 100 / float64(total))
+	}
+	if len(out) > top {
+		out = out[:top]
+	}
+	return out
+}
+
+func (s *MCPMSSQLServer) waitSnapshot(ctx context.Context, target *queryTarget) (map[string]waitSample, error) {
+	snap := map[string]waitSample{}
+	err := s.scanQuery(ctx, target, perfWaitStatsQuery, nil, func(rows *sql.Rows) error {
+		var wt string
+		var w waitSample
+		if err := rows.Scan(&wt, &w.tasks, &w.waitMs, &w.signalMs); err != nil {
+			return err
+		}
+		snap[wt] = w
+		return nil
+	})
+	return snap, err
+}
+
+// perfWaits samples sys.dm_os_wait_stats twice and reports the deltas, so
+// the result shows what the server waits on now rather than since startup.
+func (s *MCPMSSQLServer) perfWaits(ctx context.Context, target *queryTarget, caps perfCapabilities, args map[string]interface{}) (*perfReport, error) {
+	report := &perfReport{Mode: perfModeWaits, Source: "sys.dm_os_wait_stats"}
+	if !caps.dmv {
+		report.Summary = "Wait statistics unavailable"
+		report.Notes = append(report.Notes, viewServerStateNote("Wait statistics"))
+		return report, nil
+	}
+	top := intArg(args, "top", defaultPerfTop, maxPerfTop)
+	sample := intArg(args, "sample_seconds", defaultWaitSampleSecs, maxWaitSampleSecs)
+
+	before, err := s.waitSnapshot(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-time.After(time.Duration(sample) * time.Second):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	after, err := s.waitSnapshot(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	report.Waits = deltaWaits(before, after, top)
+	report.Summary = fmt.Sprintf("No significant waits during a %ds sample", sample)
+	if len(report.Waits) > 0 {
+		w := report.Waits[0]
+		report.Summary = fmt.Sprintf("Top waits during a %ds sample: %s accounts for %.1f%% of the wait time", sample, w.WaitType, w.Percent)
+	}
+	report.Notes = append(report.Notes, "Wait statistics are server-wide: they include every database on the instance.")
+	return report, nil
+}
+
+// blockingTree arranges sessions into blocking chains: the roots are lead
+// blockers (not blocked themselves), sorted by how many sessions wait
+// behind them. Sessions in a blocking cycle are reported as their own root.
+func blockingTree(sessions []blockingSession) []*blockingNode {
+	nodes := map[int]*blockingNode{}
+	ids := make([]int, 0, len(sessions))
+	for _, sess := range sessions {
+		if _, dup := nodes[sess.SessionID]; dup {
+			continue
+		}
+		nodes[sess.SessionID] = &blockingNode{blockingSession: sess}
+		ids = append(ids, sess.SessionID)
+	}
+	sort.Ints(ids)
+
+	children := map[int][]int{}
+	var roots []int
+	for _, id := range ids {
+		by := nodes[id].BlockedBy
+		if _, ok := nodes[by]; by != 0 && ok && by != id {
+			children[by] = append(children[by], id)
+		} else {
+			roots = append(roots, id)
+		}
+	}
+
+	visited := map[int]bool{}
+	var attach func(id int) *blockingNode
+	attach = func(id int) *blockingNode {
+		visited[id] = true
+		n := nodes[id]
+		for _, c := range children[id] {
+			if !visited[c] {
+				n.Blocking = append(n.Blocking, attach(c))
+			}
+		}
+		return n
+	}
+	var tree []*blockingNode
+	for _, id := range roots {
+		tree = append(tree, attach(id))
+	}
+	for _, id := range ids {
+		if !visited[id] {
+			tree = append(tree, attach(id))
+		}
+	}
+
+	var count func(n *blockingNode) int
+	count = func(n *blockingNode) int {
+		total := len(n.Blocking)
+		for _, c := range n.Blocking {
+			total += count(c)
+		}
+		return total
+	}
+	sort.SliceStable(tree, func(i, j int) bool { return count(tree[i]) > count(tree[j]) })
+	return tree
+}
+
+// blockingSessionsOn reads the sessions involved in blocking on target.
+func (s *MCPMSSQLServer) blockingSessionsOn(ctx context.Context, target *queryTarget) ([]blockingSession, error) {
+	var sessions []blockingSession
+	err := s.scanQuery(ctx, target, perfBlockingQuery, nil, func(rows *sql.Rows) error {
+		var b blockingSession
+		var stmt sql.NullString
+		if err := rows.Scan(&b.SessionID, &b.BlockedBy, &b.Status, &b.Command, &b.WaitType, &b.WaitMs,
+			&b.Login, &b.Host, &b.Program, &b.Database, &b.OpenTransactions, &stmt); err != nil {
+			return err
+		}
+		b.Statement = truncateText(stmt.String)
+		sessions = append(sessions, b)
+		return nil
+	})
+	return sessions, err
+}
+
+func (s *MCPMSSQLServer) perfBlocking(ctx context.Context, target *queryTarget, caps perfCapabilities) (*perfReport, error) {
+	report := &perfReport{Mode: perfModeBlocking, Source: "sys.dm_exec_requests"}
+	if !caps.dmv {
+		report.Summary = "Blocking information unavailable"
+		report.Notes = append(report.Notes, viewServerStateNote("Blocking chains"))
+		return report, nil
+	}
+	sessions, err := s.blockingSessionsOn(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	report.Blocking = blockingTree(sessions)
+	blocked := 0
+	for _, sess := range sessions {
+		if sess.BlockedBy != 0 {
+			blocked++
+		}
+	}
+	report.Summary = "No blocking right now"
+	if blocked > 0 {
+		report.Summary = fmt.Sprintf("%d blocked request(s) behind %d lead blocker(s)", blocked, len(report.Blocking))
+	}
+	return report, nil
+}
+
+// splitIndexColumns splits a missing-index column list ("[a], [b]").
+func splitIndexColumns(list string) []string {
+	var cols []string
+	for _, c := range strings.Split(list, "], [") {
+		if c = strings.Trim(strings.TrimSpace(c), "[]"); c != "" {
+			cols = append(cols, c)
+		}
+	}
+	return cols
+}
+
+func (s *MCPMSSQLServer) perfMissingIndexes(ctx context.Context, target *queryTarget, caps perfCapabilities, args map[string]interface{}) (*perfReport, error) {
+	report := &perfReport{Mode: perfModeMissingIndexes, Source: "sys.dm_db_missing_index_details"}
+	if !caps.dmv {
+		report.Summary = "Missing-index statistics unavailable"
+		report.Notes = append(report.Notes, viewServerStateNote("Missing-index statistics"))
+		return report, nil
+	}
+	top := intArg(args, "top", defaultPerfTop, maxPerfTop)
+	err := s.scanQuery(ctx, target, perfMissingIndexQuery, []interface{}{top}, func(rows *sql.Rows) error {
+		var m perfMissingIndex
+		var schema, table, eq, ineq, inc string
+		if err := rows.Scan(&schema, &table, &eq, &ineq, &inc, &m.UserSeeks, &m.UserScans, &m.Impact, &m.Improvement); err != nil {
+			return err
+		}
+		m.Table = schema + "." + table
+		m.Equality, m.Inequality, m.Include = splitIndexColumns(eq), splitIndexColumns(ineq), splitIndexColumns(inc)
+		m.Impact, m.Improvement = round1(m.Impact), round1(m.Improvement)
+		m.Suggestion = missingIndexDDL(m.planMissingIndex)
+		report.MissingIndexes = append(report.MissingIndexes, m)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	report.Summary = fmt.Sprintf("%d missing-index suggestion(s) recorded since the last restart", len(report.MissingIndexes))
+	report.Notes = append(report.Notes, "Suggestions come from the optimizer and overlap each other: review them before creating anything. They are never applied automatically.")
+	return report, nil
+}
+
+// handlePerformance implements the performance tool.
+func (s *MCPMSSQLServer) handlePerformance(id interface{}, args map[string]interface{}) *MCPResponse {
+	errorResponse := func(msg string) *MCPResponse {
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result: CallToolResult{
+				Content: []ContentItem{{Type: "text", Text: msg}},
+				IsError: true,
+			},
+		}
+	}
+
+	target, err := s.resolveTarget(args)
+	if err != nil {
+		return errorResponse(fmt.Sprintf("Error: %v", err))
+	}
+	if !target.config.performanceInsights {
+		return errorResponse("Error: the performance tool is disabled. Set MSSQL_PERFORMANCE_INSIGHTS=true to enable it.")
+	}
+	target.tool = "performance"
+
+	mode, _ := args["mode"].(string)
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	if mode == "" {
+		mode = perfModeTopQueries
+	}
+	switch mode {
+	case perfModeTopQueries, perfModeRegressed, perfModeWaits, perfModeBlocking, perfModeMissingIndexes:
+	default:
+		return errorResponse(fmt.Sprintf("Error: invalid mode '%s' (use top_queries, regressed, waits, blocking or missing_indexes)", mode))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), perfTimeout)
+	defer cancel()
+
+	caps, err := s.perfCapabilitiesOn(ctx, target)
+	if err != nil {
+		return errorResponse(fmt.Sprintf("Performance Error: %v", err))
+	}
+
+	var report *perfReport
+	switch mode {
+	case perfModeTopQueries:
+		report, err = s.perfTopQueries(ctx, target, caps, args)
+	case perfModeRegressed:
+		report, err = s.perfRegressed(ctx, target, caps, args)
+	case perfModeWaits:
+		report, err = s.perfWaits(ctx, target, caps, args)
+	case perfModeBlocking:
+		report, err = s.perfBlocking(ctx, target, caps)
+	case perfModeMissingIndexes:
+		report, err = s.perfMissingIndexes(ctx, target, caps, args)
+	}
+	if err != nil {
+		return errorResponse(fmt.Sprintf("Performance Error: %v", err))
+	}
+
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return errorResponse(fmt.Sprintf("Error formatting results: %v", err))
+	}
+	return &MCPResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Result: CallToolResult{
+			Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Performance (%s):\n%s", mode, string(out))}},
+		},
+	}
+}
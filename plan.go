@@ -0,0 +1,571 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Execution plan analysis for explain_query: SHOWPLAN_XML (estimated) and
+// STATISTICS XML (actual) plans are parsed into a tree of operators with
+// their cost share, row counts and warnings, plus a ranked list of the most
+// expensive operators.
+
+const (
+	planModeText      = "text"
+	planModeEstimated = "estimated"
+	planModeActual    = "actual"
+
+	// statisticsXMLColumn is the column name of the extra result set that
+	// SET STATISTICS XML ON appends after the query's own results.
+	statisticsXMLColumn = "Microsoft SQL Server 2005 XML Showplan"
+
+	planTopOperators = 5
+	// misestimateFactor flags operators whose actual rows differ from the
+	// estimate by at least this factor (and by planMisestimateMinRows rows).
+	misestimateFactor      = 10
+	planMisestimateMinRows = 100
+)
+
+// selectIntoPattern detects SELECT ... INTO, which creates a table and so
+// must not run for an actual plan.
+var selectIntoPattern = regexp.MustCompile(`(?i)\bINTO\b`)
+
+// planNode is one operator (RelOp) of an execution plan.
+type planNode struct {
+	NodeID           int         `json:"node_id"`
+	PhysicalOp       string      `json:"physical_op"`
+	LogicalOp        string      `json:"logical_op,omitempty"`
+	Object           string      `json:"object,omitempty"`
+	EstimatedRows    float64     `json:"estimated_rows"`
+	ActualRows       *float64    `json:"actual_rows,omitempty"`
+	ActualExecutions *float64    `json:"actual_executions,omitempty"`
+	CostPercent      float64     `json:"cost_percent"`
+	Warnings         []string    `json:"warnings,omitempty"`
+	Children         []*planNode `json:"children,omitempty"`
+
+	subtreeCost float64
+	lookup      bool
+}
+
+// planMissingIndex is an index suggested by the optimizer.
+type planMissingIndex struct {
+	Table      string   `json:"table"`
+	Impact     float64  `json:"impact_percent"`
+	Equality   []string `json:"equality_columns,omitempty"`
+	Inequality []string `json:"inequality_columns,omitempty"`
+	Include    []string `json:"include_columns,omitempty"`
+	// Suggestion is for the user to review; it is never executed.
+	Suggestion string `json:"suggested_ddl"`
+}
+
+// planOperatorCost is an entry of the ranked list of expensive operators.
+type planOperatorCost struct {
+	NodeID        int      `json:"node_id"`
+	PhysicalOp    string   `json:"physical_op"`
+	Object        string   `json:"object,omitempty"`
+	CostPercent   float64  `json:"cost_percent"`
+	EstimatedRows float64  `json:"estimated_rows"`
+	ActualRows    *float64 `json:"actual_rows,omitempty"`
+}
+
+// planStatement is the analysis of one statement of the plan.
+type planStatement struct {
+	Text           string             `json:"statement,omitempty"`
+	EstimatedCost  float64            `json:"estimated_cost"`
+	EstimatedRows  float64            `json:"estimated_rows"`
+	Warnings       []string           `json:"warnings,omitempty"`
+	MissingIndexes []planMissingIndex `json:"missing_indexes,omitempty"`
+	TopOperators   []planOperatorCost `json:"top_operators"`
+	Tree           *planNode          `json:"tree,omitempty"`
+}
+
+// planAnalysis is what explain_query returns in estimated/actual mode.
+type planAnalysis struct {
+	Mode       string          `json:"mode"`
+	Statements []planStatement `json:"statements"`
+	XML        string          `json:"xml,omitempty"`
+}
+
+func attr(e xml.StartElement, name string) string {
+	for _, a := range e.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func attrFloat(e xml.StartElement, name string) float64 {
+	f, _ := strconv.ParseFloat(attr(e, name), 64)
+	return f
+}
+
+// planWarning renders one child element of a <Warnings> block.
+func planWarning(e xml.StartElement) string {
+	switch e.Name.Local {
+	case "SpillToTempDb":
+		return fmt.Sprintf("spill to tempdb (level %s)", attr(e, "SpillLevel"))
+	case "SortSpillDetails", "HashSpillDetails", "ExchangeSpillDetails":
+		return fmt.Sprintf("%s spill to tempdb (%s pages written)", strings.TrimSuffix(strings.TrimSuffix(e.Name.Local, "SpillDetails"), "Spill"), attr(e, "WritesToTempDb"))
+	case "NoJoinPredicate":
+		return "no join predicate (possible cartesian product)"
+	case "PlanAffectingConvert":
+		return fmt.Sprintf("implicit conversion affects %s: %s", strings.ToLower(attr(e, "ConvertIssue")), attr(e, "Expression"))
+	case "ColumnsWithNoStatistics":
+		return "columns without statistics"
+	case "MemoryGrantWarning":
+		return fmt.Sprintf("memory grant warning: %s", attr(e, "GrantWarningKind"))
+	case "UnmatchedIndexes":
+		return "filtered index not used because of parameterization (unmatched index)"
+	case "Wait":
+		return fmt.Sprintf("waited %s ms on %s", attr(e, "WaitTime"), attr(e, "WaitType"))
+	default:
+		return e.Name.Local
+	}
+}
+
+// parseWarnings reads a <Warnings> element (start already consumed).
+func parseWarnings(dec *xml.Decoder) ([]string, error) {
+	var out []string
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				out = append(out, planWarning(t))
+			}
+			depth++
+		case xml.EndElement:
+			if depth == 0 {
+				return out, nil
+			}
+			depth--
+		}
+	}
+}
+
+// parseRelOp reads one <RelOp> element (start already consumed) and every
+// operator nested in it. Child RelOps sit inside an operator-specific
+// element (<NestedLoops>, <Hash>, ...), so any RelOp found before this
+// one's end tag is a direct child.
+func parseRelOp(dec *xml.Decoder, start xml.StartElement) (*planNode, error) {
+	n := &planNode{
+		PhysicalOp:    attr(start, "PhysicalOp"),
+		LogicalOp:     attr(start, "LogicalOp"),
+		EstimatedRows: attrFloat(start, "EstimateRows"),
+		subtreeCost:   attrFloat(start, "EstimatedTotalSubtreeCost"),
+	}
+	n.NodeID, _ = strconv.Atoi(attr(start, "NodeId"))
+	if n.LogicalOp == n.PhysicalOp {
+		n.LogicalOp = ""
+	}
+
+	implicitConvert := false
+	var actualRows, actualExecs float64
+	hasRuntime := false
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "RelOp":
+				child, err := parseRelOp(dec, t)
+				if err != nil {
+					return nil, err
+				}
+				n.Children = append(n.Children, child)
+				continue
+			case "Warnings":
+				w, err := parseWarnings(dec)
+				if err != nil {
+					return nil, err
+				}
+				n.Warnings = append(n.Warnings, w...)
+				continue
+			case "RunTimeCountersPerThread":
+				hasRuntime = true
+				actualRows += attrFloat(t, "ActualRows")
+				actualExecs += attrFloat(t, "ActualExecutions")
+			case "Object":
+				if n.Object == "" {
+					n.Object = planObjectName(t)
+				}
+			}
+			if attr(t, "Lookup") == "1" || attr(t, "Lookup") == "true" {
+				n.lookup = true
+			}
+			if strings.Contains(attr(t, "ScalarString"), "CONVERT_IMPLICIT") {
+				implicitConvert = true
+			}
+		case xml.EndElement:
+			if t.Name.Local != "RelOp" {
+				continue
+			}
+			if hasRuntime {
+				n.ActualRows = &actualRows
+				n.ActualExecutions = &actualExecs
+			}
+			if implicitConvert {
+				n.Warnings = append(n.Warnings, "implicit conversion (CONVERT_IMPLICIT) in a predicate or expression")
+			}
+			if n.lookup || n.PhysicalOp == "Key Lookup" || n.PhysicalOp == "RID Lookup" {
+				n.Warnings = append(n.Warnings, "key lookup: consider a covering index (INCLUDE the looked-up columns)")
+			}
+			if w := misestimateWarning(n); w != "" {
+				n.Warnings = append(n.Warnings, w)
+			}
+			return n, nil
+		}
+	}
+}
+
+// planObjectName renders an <Object> element as db.schema.table (index).
+func planObjectName(e xml.StartElement) string {
+	var parts []string
+	for _, k := range []string{"Schema", "Table"} {
+		if v := attr(e, k); v != "" {
+			parts = append(parts, strings.Trim(v, "[]"))
+		}
+	}
+	name := strings.Join(parts, ".")
+	if idx := strings.Trim(attr(e, "Index"), "[]"); idx != "" {
+		name += " (" + idx + ")"
+	}
+	return name
+}
+
+// misestimateWarning flags large differences between estimated and actual
+// rows, a common cause of bad join and memory grant choices.
+func misestimateWarning(n *planNode) string {
+	if n.ActualRows == nil || n.ActualExecutions == nil {
+		return ""
+	}
+	execs := *n.ActualExecutions
+	if execs < 1 {
+		execs = 1
+	}
+	est := n.EstimatedRows * execs
+	act := *n.ActualRows
+	if abs(act-est) < planMisestimateMinRows {
+		return ""
+	}
+	if act >= est*misestimateFactor || act*misestimateFactor <= est {
+		return fmt.Sprintf("row estimate off: estimated %.0f, actual %.0f (check statistics)", est, act)
+	}
+	return ""
+}
+
+func abs(f float64) float64 {
+	if f < 0 {
+		return -f
+	}
+	return f
+}
+
+// parseMissingIndexes reads a <MissingIndexes> element (start consumed).
+func parseMissingIndexes(dec *xml.Decoder) ([]planMissingIndex, error) {
+	var out []planMissingIndex
+	var impact float64
+	var cur *planMissingIndex
+	usage := ""
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "MissingIndexGroup":
+				impact = attrFloat(t, "Impact")
+			case "MissingIndex":
+				cur = &planMissingIndex{
+					Table:  strings.Trim(attr(t, "Schema"), "[]") + "." + strings.Trim(attr(t, "Table"), "[]"),
+					Impact: impact,
+				}
+			case "ColumnGroup":
+				usage = attr(t, "Usage")
+			case "Column":
+				if cur == nil {
+					continue
+				}
+				col := strings.Trim(attr(t, "Name"), "[]")
+				switch usage {
+				case "EQUALITY":
+					cur.Equality = append(cur.Equality, col)
+				case "INEQUALITY":
+					cur.Inequality = append(cur.Inequality, col)
+				case "INCLUDE":
+					cur.Include = append(cur.Include, col)
+				}
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "MissingIndex":
+				if cur != nil {
+					cur.Suggestion = missingIndexDDL(*cur)
+					out = append(out, *cur)
+					cur = nil
+				}
+			case "MissingIndexes":
+				return out, nil
+			}
+		}
+	}
+}
+
+// missingIndexDDL renders a CREATE INDEX statement for a suggestion.
+func missingIndexDDL(m planMissingIndex) string {
+	keys := append(append([]string{}, m.Equality...), m.Inequality...)
+	schema, table, _ := strings.Cut(m.Table, ".")
+	quoted := make([]string, len(keys))
+	for i, k := range keys {
+		quoted[i] = quoteIdentifier(k)
+	}
+	ddl := fmt.Sprintf("CREATE NONCLUSTERED INDEX %s ON %s.%s (%s)",
+		quoteIdentifier("IX_"+table+"_"+strings.Join(keys, "_")),
+		quoteIdentifier(schema), quoteIdentifier(table), strings.Join(quoted, ", "))
+	if len(m.Include) > 0 {
+		inc := make([]string, len(m.Include))
+		for i, c := range m.Include {
+			inc[i] = quoteIdentifier(c)
+		}
+		ddl += " INCLUDE (" + strings.Join(inc, ", ") + ")"
+	}
+	return ddl
+}
+
+// parseShowplanXML parses a showplan document into per-statement analyses.
+func parseShowplanXML(doc string) ([]planStatement, error) {
+	dec := xml.NewDecoder(strings.NewReader(doc))
+	// The driver already decoded the nvarchar value to UTF-8; ignore an
+	// encoding="utf-16" declaration copied from the server.
+	dec.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) { return input, nil }
+	var stmts []planStatement
+	var cur *planStatement
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid showplan XML: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "StmtSimple", "StmtCond", "StmtCursor", "StmtReceive", "StmtUseDb":
+				stmts = append(stmts, planStatement{
+					Text:          strings.TrimSpace(attr(t, "StatementText")),
+					EstimatedCost: attrFloat(t, "StatementSubTreeCost"),
+					EstimatedRows: attrFloat(t, "StatementEstRows"),
+				})
+				cur = &stmts[len(stmts)-1]
+			case "Warnings":
+				w, err := parseWarnings(dec)
+				if err != nil {
+					return nil, err
+				}
+				if cur != nil {
+					cur.Warnings = append(cur.Warnings, w...)
+				}
+			case "MissingIndexes":
+				m, err := parseMissingIndexes(dec)
+				if err != nil {
+					return nil, err
+				}
+				if cur != nil {
+					cur.MissingIndexes = append(cur.MissingIndexes, m...)
+				}
+			case "RelOp":
+				root, err := parseRelOp(dec, t)
+				if err != nil {
+					return nil, err
+				}
+				if cur != nil && cur.Tree == nil {
+					cur.Tree = root
+				}
+			}
+		}
+	}
+
+	for i := range stmts {
+		st := &stmts[i]
+		if st.Tree == nil {
+			st.TopOperators = []planOperatorCost{}
+			continue
+		}
+		total := st.EstimatedCost
+		if total <= 0 {
+			total = st.Tree.subtreeCost
+		}
+		var all []*planNode
+		assignCostPercent(st.Tree, total, &all)
+		st.TopOperators = topOperators(all, planTopOperators)
+	}
+	return stmts, nil
+}
+
+// assignCostPercent computes each operator's own cost (its subtree cost
+// minus its children's) as a share of the statement cost.
+func assignCostPercent(n *planNode, total float64, all *[]*planNode) {
+	own := n.subtreeCost
+	for _, c := range n.Children {
+		own -= c.subtreeCost
+		assignCostPercent(c, total, all)
+	}
+	if own < 0 {
+		own = 0
+	}
+	if total > 0 {
+		n.CostPercent = float64(int(own/total*1000+0.5)) / 10
+	}
+	*all = append(*all, n)
+}
+
+func topOperators(nodes []*planNode, limit int) []planOperatorCost {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if nodes[i].CostPercent != nodes[j].CostPercent {
+			return nodes[i].CostPercent > nodes[j].CostPercent
+		}
+		return nodes[i].NodeID < nodes[j].NodeID
+	})
+	out := []planOperatorCost{}
+	for _, n := range nodes {
+		if len(out) == limit {
+			break
+		}
+		out = append(out, planOperatorCost{
+			NodeID:        n.NodeID,
+			PhysicalOp:    n.PhysicalOp,
+			Object:        n.Object,
+			CostPercent:   n.CostPercent,
+			EstimatedRows: n.EstimatedRows,
+			ActualRows:    n.ActualRows,
+		})
+	}
+	return out
+}
+
+// capturePlanXML runs query on a dedicated connection with SHOWPLAN_XML
+// (estimated: the query is compiled, not executed) or STATISTICS XML
+// (actual: the query runs and its rows are discarded) and returns the plan
+// documents.
+func capturePlanXML(ctx context.Context, db *sql.DB, query, mode string) ([]string, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Close()
+
+	setting := "SHOWPLAN_XML"
+	if mode == planModeActual {
+		setting = "STATISTICS XML"
+	}
+	if _, err := conn.ExecContext(ctx, "SET "+setting+" ON"); err != nil {
+		return nil, fmt.Errorf("enabling %s: %w", setting, err)
+	}
+	defer func() {
+		_, _ = conn.ExecContext(context.Background(), "SET "+setting+" OFF") // #nosec G104 - best-effort cleanup
+	}()
+
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var plans []string
+	for {
+		cols, err := rows.Columns()
+		if err != nil {
+			return nil, err
+		}
+		isPlan := mode == planModeEstimated || (len(cols) == 1 && cols[0] == statisticsXMLColumn)
+		for rows.Next() {
+			if !isPlan {
+				continue // actual mode: the query's own rows are not returned
+			}
+			var doc string
+			if err := rows.Scan(&doc); err != nil {
+				return nil, err
+			}
+			plans = append(plans, doc)
+		}
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+	return plans, rows.Err()
+}
+
+// handleExplainPlan implements explain_query's estimated and actual modes.
+// The query has already been through the policy pipeline (policyStrictRead
+// for actual plans, which really run it).
+func (s *MCPMSSQLServer) handleExplainPlan(id interface{}, target *queryTarget, query, mode string, includeXML bool) *MCPResponse {
+	errorResponse := func(msg string) *MCPResponse {
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result: CallToolResult{
+				Content: []ContentItem{{Type: "text", Text: msg}},
+				IsError: true,
+			},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	docs, err := capturePlanXML(ctx, target.db, query, mode)
+	if err != nil {
+		msg := "Error getting execution plan"
+		if s.devMode {
+			msg += ": " + err.Error()
+		}
+		return errorResponse(msg)
+	}
+	if len(docs) == 0 {
+		return errorResponse("Error: no plan returned (the query may be too simple or unsupported)")
+	}
+
+	analysis := planAnalysis{Mode: mode, Statements: []planStatement{}}
+	for _, doc := range docs {
+		stmts, err := parseShowplanXML(doc)
+		if err != nil {
+			return errorResponse(fmt.Sprintf("Error: %v", err))
+		}
+		analysis.Statements = append(analysis.Statements, stmts...)
+	}
+	if includeXML {
+		analysis.XML = strings.Join(docs, "\n")
+	}
+
+	out, err := json.MarshalIndent(analysis, "", "  ")
+	if err != nil {
+		return errorResponse(fmt.Sprintf("Error formatting plan: %v", err))
+	}
+	return &MCPResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Result: CallToolResult{
+			Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Execution plan (%s):\n%s", mode, string(out))}},
+		},
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// policyMode selects the extra restrictions a tool places on a statement on
+// top of the target's posture.
+type policyMode int
+
+const (
+	// policyPosture applies the target's read-only and whitelist rules only
+	// (query_database, metadata queries of explore/inspect, compare...).
+	policyPosture policyMode = iota
+	// policySelectOnly also requires a single SELECT statement whatever the
+	// posture (explain_query).
+	policySelectOnly
+	// policyStrictRead also holds the statement to the strict read-only
+	// rules on writable targets: it is executed by a tool that only
+	// promises to read (explain_query mode=actual).
+	policyStrictRead
+	// policyKill is a KILL of session (activity kill_session). It bypasses
+	// the posture's query rules: it needs an alias configured with
+	// _ALLOW_KILL=true and a confirm_operation for that session.
+	policyKill
+	// policyCommit is the commit of an explicit transaction (commit tool).
+	// It needs a confirm_operation naming the transaction.
+	policyCommit
+	// policyUndo applies an undo journal entry (undo_operation apply=true).
+	// It needs a writable dynamic alias and a confirm_operation naming the
+	// operation.
+	policyUndo
+	// policyImport is a bulk load of a file (import_data apply=true). It
+	// needs a whitelisted table of a writable dynamic alias and a
+	// confirm_operation naming the table and the row count.
+	policyImport
+)
+
+// policyRequest is one statement a tool wants to send to the server.
+type policyRequest struct {
+	target *queryTarget
+	query  string
+	mode   policyMode
+	// procedure is set for stored procedure calls; query is then the EXEC
+	// text built from the call.
+	procedure string
+	// session is the session to end for policyKill.
+	session int
+	// undoID is the undo journal operation to apply for policyUndo.
+	undoID string
+	// table and rows are the target table and row count for policyImport.
+	table string
+	rows  int
+}
+
+// errConfirmationRequired marks the errors that ask the client to call
+// confirm_operation; they are logged as "confirm" rather than "deny".
+var errConfirmationRequired = errors.New("CONFIRMATION REQUIRED")
+
+// enforcePolicy is the single policy pipeline every SQL-accepting tool goes
+// through before anything is sent to the server. Stages run in order and
+// the first refusal wins:
+//   - input: size limits;
+//   - kill: alias opt-in and confirmation for KILL (nothing else applies);
+//   - commit: confirmation of an explicit transaction's commit (likewise);
+//   - undo: confirmation of an undo journal operation (likewise);
+//   - import: whitelist, row limit and confirmation of a bulk import
+//     (likewise);
+//   - transaction: no transaction control inside an explicit transaction;
+//   - procedure: whitelist classification of stored procedure calls;
+//   - select-only / strict-read: the tool's own restrictions;
+//   - read-only: the posture's read-only rules;
+//   - dml-guard: the alias's REQUIRE_WHERE and MAX_AFFECTED_ROWS checks;
+//   - permissions: the table whitelist;
+//   - confirm: confirmation of modifications on writable dynamic aliases.
+//
+// Every decision is logged the same way (LogPolicyDecision), with the tool,
+// the alias and the statement's operation, never the statement text.
+func (s *MCPMSSQLServer) enforcePolicy(req policyRequest) error {
+	stage, err := s.evaluatePolicy(req)
+	s.logPolicyDecision(req, stage, err)
+	return err
+}
+
+func (s *MCPMSSQLServer) evaluatePolicy(req policyRequest) (string, error) {
+	target := req.target
+	if err := s.validateBasicInput(req.query); err != nil {
+		return "input", err
+	}
+
+	if req.mode == policyKill {
+		return "kill", s.killPolicy(target, req.session)
+	}
+	if req.mode == policyCommit {
+		return "commit", s.commitPolicy(target.tx)
+	}
+	if req.mode == policyUndo {
+		return "undo", s.undoPolicy(target, req.undoID)
+	}
+	if req.mode == policyImport {
+		return "import", s.importPolicy(target, req.table, req.rows)
+	}
+	if target.tx != nil {
+		if err := transactionPolicy(req.query); err != nil {
+			return "transaction", err
+		}
+	}
+
+	if req.procedure != "" {
+		done, err := s.procedurePolicy(target, req.procedure)
+		if err != nil || done {
+			return "procedure", err
+		}
+	}
+
+	switch req.mode {
+	case policyStrictRead:
+		if err := s.validateReadOnlyQueryFor(serverConfig{readOnly: true}, req.query); err != nil {
+			return "strict-read", err
+		}
+		if selectIntoPattern.MatchString(req.query) {
+			return "strict-read", fmt.Errorf("read-only mode: SELECT ... INTO creates a table")
+		}
+		fallthrough
+	case policySelectOnly:
+		if op := s.statementOperation(req.query); op != "SELECT" {
+			return "select-only", fmt.Errorf("%s only accepts SELECT queries, got: %s", target.toolName(), op)
+		}
+		if hasMultipleStatements(req.query) || len(splitStatements(req.query)) > 1 {
+			return "select-only", fmt.Errorf("%s only accepts a single SELECT statement", target.toolName())
+		}
+	}
+
+	if err := s.validateReadOnlyQueryFor(target.config, req.query); err != nil {
+		return "read-only", err
+	}
+	if err := s.dmlGuardPolicy(target, req.query); err != nil {
+		return "dml-guard", err
+	}
+	if err := s.validateTablePermissionsFor(target, req.query); err != nil {
+		return "permissions", err
+	}
+	// A procedure call was confirmed by its own stage; its EXEC text would
+	// otherwise ask again.
+	if req.procedure == "" {
+		if err := s.confirmationPolicy(target, req.query); err != nil {
+			return "confirm", err
+		}
+	}
+	return "", nil
+}
+
+// confirmationPolicy asks for a confirm_operation before a modification
+// runs on a writable dynamic alias, the high-risk "multiple databases"
+// setup. Classic connections and read-only targets (held to their table
+// whitelist) are not affected, and statements of an explicit transaction
+// are confirmed once, at commit. Every statement of the batch counts: only
+// a batch of plain SELECTs runs unconfirmed. The confirmation must name
+// every table the batch touches.
+func (s *MCPMSSQLServer) confirmationPolicy(target *queryTarget, query string) error {
+	if target.alias == "" || target.config.readOnly || target.tx != nil {
+		return nil
+	}
+	operation := batchModification(splitStatements(query))
+	if operation == "" {
+		return nil
+	}
+	tables := s.extractAllTablesFromQuery(query)
+	if s.isOperationConfirmed(target.alias, operation, tables) {
+		return nil
+	}
+	return s.requireConfirmationForModification(target.alias, operation, tables)
+}
+
+// batchModification names what a batch does besides reading: its
+// statements other than plain SELECTs, joined by '+' (e.g. "SET+UPDATE"),
+// or "" for a read-only batch. EXEC and dynamic SQL always count, and
+// statements not led by a known keyword are reported as OTHER.
+func batchModification(stmts []sqlStatement) string {
+	var ops []string
+	for _, st := range stmts {
+		op := st.keyword
+		if !statementKeywords[op] {
+			op = "OTHER"
+		}
+		if op == "SELECT" {
+			if !st.callsDynamicSQL() {
+				continue
+			}
+			op = "EXEC"
+		}
+		if !slices.Contains(ops, op) {
+			ops = append(ops, op)
+		}
+	}
+	return strings.Join(ops, "+")
+}
+
+// procedurePolicy applies the procedure whitelist classification:
+//   - read procedures run on any target (the call is a single RPC, so the
+//     read-only EXEC ban has nothing to protect against) and skip the
+//     remaining stages;
+//   - write procedures are refused on read-only targets;
+//   - on a writable dynamic alias, write and unclassified procedures need a
+//     confirm_operation first, like DML;
+//   - everything else continues through the usual stages.
+func (s *MCPMSSQLServer) procedurePolicy(target *queryTarget, procName string) (done bool, err error) {
+	_, kind, schema, name := matchProcWhitelist(target.config.whitelistProcs, procName)
+	switch {
+	case kind == procRead:
+		return true, nil
+	case kind == procWrite && target.config.readOnly:
+		return true, fmt.Errorf("read-only mode: procedure '%s' is classified as write", procName)
+	}
+	if target.alias != "" && !target.config.readOnly {
+		tables := []string{strings.ToLower(schema + "." + name)}
+		if !s.isOperationConfirmed(target.alias, "EXEC", tables) {
+			return true, s.requireConfirmationForModification(target.alias, "EXEC", tables)
+		}
+	}
+	return false, nil
+}
+
+// killPolicy allows KILL only on dynamic aliases configured for it, and
+// only once confirm_operation has confirmed that very session.
+func (s *MCPMSSQLServer) killPolicy(target *queryTarget, session int) error {
+	if target.alias == "" || !target.config.allowKill {
+		return fmt.Errorf("kill_session is not enabled for this connection (set MSSQL_DYNAMIC_<ALIAS>_ALLOW_KILL=true on the alias)")
+	}
+	sessions := []string{fmt.Sprintf("session %d", session)}
+	if !s.isOperationConfirmed(target.alias, "KILL", sessions) {
+		return s.requireConfirmationForModification(target.alias, "KILL", sessions)
+	}
+	return nil
+}
+
+// statementOperation names a statement for logs and messages without
+// echoing its text: the leading keyword of its first statement (the main
+// statement for a CTE) when it is a known statement keyword, OTHER
+// otherwise.
+func (s *MCPMSSQLServer) statementOperation(query string) string {
+	stmts := splitStatements(query)
+	if len(stmts) == 0 || !statementKeywords[stmts[0].keyword] {
+		return "OTHER"
+	}
+	return stmts[0].keyword
+}
+
+// logPolicyDecision records the outcome of enforcePolicy. Error messages
+// can quote the statement, so a refusal is logged by its stage only.
+// Statements the server issues on its own (the procedure tools refresher
+// polls the catalog every minute) are logged only when refused.
+func (s *MCPMSSQLServer) logPolicyDecision(req policyRequest, stage string, err error) {
+	if req.target.tool == "" && err == nil {
+		return
+	}
+	op := s.statementOperation(req.query)
+	if req.procedure != "" {
+		op = "EXEC " + req.procedure
+	}
+	decision := "allow"
+	switch {
+	case errors.Is(err, errConfirmationRequired):
+		decision = "confirm"
+	case err != nil:
+		decision = "deny"
+	}
+	s.secLogger.LogPolicyDecision(req.target.toolName(), req.target.alias, op, decision, stage)
+}
+
+// LogPolicyDecision logs one policy decision with the same fields whatever
+// the tool: decision is allow, deny or confirm, stage names the pipeline
+// stage that refused the statement.
+func (sl *SecurityLogger) LogPolicyDecision(tool, alias, operation, decision, stage string) {
+	attrs := []any{
+		slog.String("tool", tool),
+		slog.String("alias", alias),
+		slog.String("operation", operation),
+		slog.String("decision", decision),
+	}
+	if stage != "" {
+		attrs = append(attrs, slog.String("stage", stage))
+	}
+	sl.logger.Info("policy decision", attrs...)
+}
+
+// firstLine returns s up to its first newline.
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
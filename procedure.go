@@ -0,0 +1,642 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-sql/sqlexp"
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// procResultSet is one result set returned by a stored procedure.
+type procResultSet struct {
+	Index     int                      `json:"index"`
+	Columns   []string                 `json:"columns"`
+	Rows      []map[string]interface{} `json:"rows"`
+	RowCount  int                      `json:"row_count"`
+	Truncated bool                     `json:"truncated,omitempty"`
+}
+
+// procResult is the JSON document returned by execute_procedure.
+type procResult struct {
+	Procedure        string                 `json:"procedure"`
+	ReturnStatus     int32                  `json:"return_status"`
+	OutputParameters map[string]interface{} `json:"output_parameters,omitempty"`
+	ResultSets       []procResultSet        `json:"result_sets"`
+	RowsAffected     []int64                `json:"rows_affected,omitempty"`
+	Messages         []string               `json:"messages,omitempty"`
+	Errors           []string               `json:"errors,omitempty"`
+}
+
+// procOutputParam is an OUTPUT parameter declared for a procedure call.
+// dest is the pointer handed to the driver inside sql.Out.
+type procOutputParam struct {
+	name string
+	dest interface{}
+}
+
+// jsonObjectArg reads a tool argument given either as a JSON object or as a
+// string containing one (the historical form of execute_procedure's
+// 'parameters').
+func jsonObjectArg(v interface{}) (map[string]interface{}, error) {
+	switch t := v.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		return t, nil
+	case string:
+		if strings.TrimSpace(t) == "" {
+			return nil, nil
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(t), &m); err != nil {
+			return nil, err
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("expected a JSON object")
+	}
+}
+
+// outputDest returns a typed destination for an OUTPUT parameter of the
+// given SQL type. The sql.Null* wrappers keep the type information when the
+// initial value is NULL, which the driver needs to declare the parameter.
+// initial, when not nil, makes the parameter INPUT/OUTPUT.
+func outputDest(sqlType string, initial interface{}) (interface{}, error) {
+	base := strings.ToLower(strings.TrimSpace(sqlType))
+	if i := strings.Index(base, "("); i >= 0 {
+		base = strings.TrimSpace(base[:i])
+	}
+
+	// Initial values are converted like input arguments (coerceProcValue):
+	// out-of-range or fractional numbers are refused, never wrapped.
+	if n, ok := initial.(json.Number); ok {
+		initial = string(n)
+	}
+	coerce := func() (interface{}, error) {
+		v, err := coerceProcValue(procParam{BaseType: base}, initial)
+		if err != nil {
+			return nil, fmt.Errorf("initial value for %s: %v", base, err)
+		}
+		return v, nil
+	}
+
+	switch base {
+	case "bit":
+		d := &sql.NullBool{}
+		if initial != nil {
+			b, ok := initial.(bool)
+			if !ok {
+				return nil, fmt.Errorf("initial value for bit must be true or false")
+			}
+			*d = sql.NullBool{Bool: b, Valid: true}
+		}
+		return d, nil
+	case "tinyint":
+		d := &sql.NullByte{}
+		if initial != nil {
+			v, err := coerce()
+			if err != nil {
+				return nil, err
+			}
+			*d = sql.NullByte{Byte: v.(uint8), Valid: true}
+		}
+		return d, nil
+	case "smallint":
+		d := &sql.NullInt16{}
+		if initial != nil {
+			v, err := coerce()
+			if err != nil {
+				return nil, err
+			}
+			*d = sql.NullInt16{Int16: v.(int16), Valid: true}
+		}
+		return d, nil
+	case "int":
+		d := &sql.NullInt32{}
+		if initial != nil {
+			v, err := coerce()
+			if err != nil {
+				return nil, err
+			}
+			*d = sql.NullInt32{Int32: v.(int32), Valid: true}
+		}
+		return d, nil
+	case "bigint":
+		d := &sql.NullInt64{}
+		if initial != nil {
+			v, err := coerce()
+			if err != nil {
+				return nil, err
+			}
+			*d = sql.NullInt64{Int64: v.(int64), Valid: true}
+		}
+		return d, nil
+	case "float", "real":
+		d := &sql.NullFloat64{}
+		if initial != nil {
+			v, err := coerce()
+			if err != nil {
+				return nil, err
+			}
+			*d = sql.NullFloat64{Float64: v.(float64), Valid: true}
+		}
+		return d, nil
+	case "decimal", "numeric", "money", "smallmoney", "uniqueidentifier":
+		// Exact numerics and GUIDs travel as their validated text so that no
+		// precision is lost; SQL Server converts them on assignment.
+		d := &sql.NullString{}
+		if initial != nil {
+			v, err := coerce()
+			if err != nil {
+				return nil, err
+			}
+			*d = sql.NullString{String: fmt.Sprint(v), Valid: true}
+		}
+		return d, nil
+	case "date", "datetime", "datetime2", "smalldatetime", "datetimeoffset":
+		d := &sql.NullTime{}
+		if initial != nil {
+			s, ok := initial.(string)
+			if !ok {
+				return nil, fmt.Errorf("initial value for %s must be an RFC 3339 string", base)
+			}
+			ts, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				if ts, err = time.Parse("2006-01-02", s); err != nil {
+					return nil, fmt.Errorf("initial value for %s must be an RFC 3339 string", base)
+				}
+			}
+			*d = sql.NullTime{Time: ts, Valid: true}
+		}
+		return d, nil
+	case "varbinary", "binary":
+		d := &[]byte{}
+		if initial != nil {
+			s, ok := initial.(string)
+			if !ok {
+				return nil, fmt.Errorf("initial value for %s must be a string", base)
+			}
+			*d = []byte(s)
+		}
+		return d, nil
+	case "", "nvarchar", "varchar", "nchar", "char", "ntext", "text", "xml", "sysname", "time":
+		// Times travel as strings too, converted on assignment.
+		d := &sql.NullString{}
+		if initial != nil {
+			*d = sql.NullString{String: fmt.Sprint(initial), Valid: true}
+		}
+		return d, nil
+	default:
+		return nil, fmt.Errorf("unsupported OUTPUT parameter type '%s'", sqlType)
+	}
+}
+
+// outputValue converts an OUTPUT destination back to a JSON-friendly value.
+func outputValue(dest interface{}) interface{} {
+	switch d := dest.(type) {
+	case *[]byte:
+		if *d == nil {
+			return nil
+		}
+		return fmt.Sprintf("0x%X", *d)
+	case driver.Valuer:
+		v, err := d.Value()
+		if err != nil {
+			return nil
+		}
+		return v
+	}
+	return nil
+}
+
+// parseOutputParams builds the OUTPUT parameter list from the
+// 'output_parameters' argument (name -> SQL type). Names that also appear in
+// inputs become INPUT/OUTPUT parameters initialised with the input value.
+func parseOutputParams(spec map[string]interface{}, inputs map[string]interface{}) ([]procOutputParam, error) {
+	outs := make([]procOutputParam, 0, len(spec))
+	for _, name := range sortedKeys(spec) {
+		if !validParamNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid parameter name '%s' (only letters, digits and underscore are allowed, optionally prefixed with '@')", name)
+		}
+		sqlType, _ := spec[name].(string)
+		normalized := strings.TrimPrefix(name, "@")
+		var initial interface{}
+		for inName, v := range inputs {
+			if strings.EqualFold(strings.TrimPrefix(inName, "@"), normalized) {
+				initial = v
+			}
+		}
+		dest, err := outputDest(sqlType, initial)
+		if err != nil {
+			return nil, fmt.Errorf("OUTPUT parameter '%s': %w", name, err)
+		}
+		outs = append(outs, procOutputParam{name: normalized, dest: dest})
+	}
+	return outs, nil
+}
+
+// procValidationText renders the EXEC statement equivalent to a procedure
+// call. It is only used for policy validation (read-only, whitelist); the
+// call itself is sent as an RPC so that the return status and OUTPUT values
+// come back from the procedure rather than from a wrapping batch.
+func procValidationText(procName string, inputs map[string]interface{}, outs []procOutputParam) string {
+	var parts []string
+	i := 1
+	isOut := make(map[string]bool, len(outs))
+	for _, o := range outs {
+		isOut[strings.ToLower(o.name)] = true
+		parts = append(parts, fmt.Sprintf("@%s = @p%d OUTPUT", o.name, i))
+		i++
+	}
+	for _, name := range sortedKeys(inputs) {
+		normalized := strings.TrimPrefix(name, "@")
+		if isOut[strings.ToLower(normalized)] {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("@%s = @p%d", normalized, i))
+		i++
+	}
+	if len(parts) == 0 {
+		return "EXEC " + procName
+	}
+	return "EXEC " + procName + " " + strings.Join(parts, ", ")
+}
+
+// validateProcedureCall runs a procedure call through the policy pipeline,
+// which applies the procedure whitelist classification (see
+// procedurePolicy) before the usual stages.
+func (s *MCPMSSQLServer) validateProcedureCall(target *queryTarget, procName, execText string) error {
+	return s.enforcePolicy(policyRequest{target: target, query: execText, procedure: procName})
+}
+
+// executeProcedureOn calls a stored procedure on target and collects every
+// result set, the OUTPUT parameters, the return status and the
+// informational messages (PRINT, RAISERROR with severity <= 10). Errors
+// raised by the procedure are collected too instead of aborting the call,
+// so results produced before the error are not lost.
+func (s *MCPMSSQLServer) executeProcedureOn(ctx context.Context, target *queryTarget, procName string, inputs map[string]interface{}, outs []procOutputParam) (*procResult, error) {
+	if target.db == nil {
+		return nil, fmt.Errorf("database not connected")
+	}
+	if err := s.validateProcedureCall(target, procName, procValidationText(procName, inputs, outs)); err != nil {
+		return nil, err
+	}
+
+	isOut := make(map[string]bool, len(outs))
+	args := make([]interface{}, 0, len(inputs)+len(outs)+2)
+	for _, o := range outs {
+		isOut[strings.ToLower(o.name)] = true
+		args = append(args, sql.Named(o.name, sql.Out{Dest: o.dest}))
+	}
+	for _, name := range sortedKeys(inputs) {
+		normalized := strings.TrimPrefix(name, "@")
+		if isOut[strings.ToLower(normalized)] {
+			continue
+		}
+		args = append(args, sql.Named(normalized, inputs[name]))
+	}
+	var status mssql.ReturnStatus
+	msgs := &sqlexp.ReturnMessage{}
+	args = append(args, &status, msgs)
+
+	rows, err := target.db.QueryContext(ctx, procName, args...)
+	if err != nil {
+		if s.devMode {
+			return nil, fmt.Errorf("procedure call failed: %v", err)
+		}
+		return nil, fmt.Errorf("procedure call failed: check the procedure name, parameters and permissions")
+	}
+
+	result := &procResult{Procedure: procName, ResultSets: []procResultSet{}}
+	if err := collectProcMessages(ctx, rows, msgs, result); err != nil {
+		_ = rows.Close()
+		return nil, err
+	}
+	// OUTPUT values and the return status are only filled in once the
+	// response has been fully read.
+	if err := rows.Close(); err != nil {
+		result.Errors = append(result.Errors, err.Error())
+	}
+	result.ReturnStatus = int32(status)
+	if len(outs) > 0 {
+		result.OutputParameters = make(map[string]interface{}, len(outs))
+		for _, o := range outs {
+			result.OutputParameters[o.name] = outputValue(o.dest)
+		}
+	}
+	return result, nil
+}
+
+// collectProcMessages drives the driver's message loop until the response is
+// exhausted, filling result.
+func collectProcMessages(ctx context.Context, rows *sql.Rows, msgs *sqlexp.ReturnMessage, result *procResult) error {
+	for {
+		switch m := msgs.Message(ctx).(type) {
+		case sqlexp.MsgNotice:
+			result.Messages = append(result.Messages, m.Message.String())
+		case sqlexp.MsgError:
+			result.Errors = append(result.Errors, m.Error.Error())
+		case sqlexp.MsgRowsAffected:
+			result.RowsAffected = append(result.RowsAffected, m.Count)
+		case sqlexp.MsgNext:
+			set, err := readResultSet(rows, len(result.ResultSets)+1)
+			if err != nil {
+				return err
+			}
+			result.ResultSets = append(result.ResultSets, set)
+		case sqlexp.MsgNextResultSet:
+			if !rows.NextResultSet() {
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// readResultSet reads the current result set, keeping up to maxQueryRows
+// rows and draining the rest so the following messages are still received.
+func readResultSet(rows *sql.Rows, index int) (procResultSet, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return procResultSet{}, err
+	}
+	set := procResultSet{Index: index, Columns: columns, Rows: []map[string]interface{}{}}
+	for rows.Next() {
+		set.RowCount++
+		if len(set.Rows) >= maxQueryRows {
+			set.Truncated = true
+			continue
+		}
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return procResultSet{}, err
+		}
+		row := make(map[string]interface{}, len(columns))
+		for i, col := range columns {
+			if b, ok := values[i].([]byte); ok {
+				row[col] = string(b)
+			} else {
+				row[col] = values[i]
+			}
+		}
+		set.Rows = append(set.Rows, row)
+	}
+	return set, nil
+}
+
+// handleExecuteProcedure runs a whitelisted stored procedure. It backs both
+// execute_procedure and the per-procedure tools (see procedure_tools.go).
+func (s *MCPMSSQLServer) handleExecuteProcedure(id interface{}, args map[string]interface{}) *MCPResponse {
+	if s.getDB() == nil {
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result: CallToolResult{
+				Content: []ContentItem{
+					{
+						Type: "text",
+						Text: "Error: Database not connected. Call the get_database_info tool to see current configuration, diagnose the problem, and get specific troubleshooting steps.",
+					},
+				},
+				IsError: true,
+			},
+		}
+	}
+
+	procName, ok := args["procedure_name"].(string)
+	if !ok || procName == "" {
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result: CallToolResult{
+				Content: []ContentItem{
+					{
+						Type: "text",
+						Text: "Error: Missing or invalid 'procedure_name' parameter",
+					},
+				},
+				IsError: true,
+			},
+		}
+	}
+
+	// Check whitelist (cached; refreshed by reloadConfig). The active alias
+	// may have its own list (MSSQL_DYNAMIC_<ALIAS>_WHITELIST_PROCEDURES).
+	target := s.activeTarget()
+	target.tool = "execute_procedure"
+	whitelistEnv := target.config.whitelistProcs
+	if whitelistEnv == "" {
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result: CallToolResult{
+				Content: []ContentItem{
+					{
+						Type: "text",
+						Text: "Error: No stored procedures are whitelisted. Set MSSQL_WHITELIST_PROCEDURES (or MSSQL_DYNAMIC_<ALIAS>_WHITELIST_PROCEDURES) environment variable.",
+					},
+				},
+				IsError: true,
+			},
+		}
+	}
+
+	allowed, _, schema, name := matchProcWhitelist(whitelistEnv, procName)
+	if !allowed {
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result: CallToolResult{
+				Content: []ContentItem{
+					{
+						Type: "text",
+						Text: fmt.Sprintf("Error: Stored procedure '%s' is not in the whitelist. Allowed: %s", procName, whitelistEnv),
+					},
+				},
+				IsError: true,
+			},
+		}
+	}
+
+	// Validate procedure name contains only safe characters
+	if err := s.validateProcedureName(procName); err != nil {
+		s.secLogger.Printf("Rejected unsafe procedure name: %s", procName)
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result: CallToolResult{
+				Content: []ContentItem{
+					{
+						Type: "text",
+						Text: fmt.Sprintf("Error: %v", err),
+					},
+				},
+				IsError: true,
+			},
+		}
+	}
+	// Look up, validate and call the procedure the whitelist matched: an
+	// unqualified name means dbo there, but the login's default schema on
+	// the server.
+	procName = qualifiedTable(schema, name)
+
+	// Parse parameters if provided
+	procParams, err := jsonObjectArg(args["parameters"])
+	if err != nil {
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result: CallToolResult{
+				Content: []ContentItem{
+					{
+						Type: "text",
+						Text: fmt.Sprintf("Error: Invalid JSON in parameters: %v", err),
+					},
+				},
+				IsError: true,
+			},
+		}
+	}
+	for paramName := range procParams {
+		// Parameter NAMES end up in the EXEC text used for policy
+		// validation and in the RPC call, so an unvalidated name is an
+		// injection vector. Reject anything that is not a plain T-SQL
+		// identifier (optionally '@'-prefixed).
+		if !validParamNamePattern.MatchString(paramName) {
+			s.secLogger.Printf("Rejected unsafe stored-procedure parameter name: %q", paramName)
+			return &MCPResponse{
+				JSONRPC: "2.0",
+				ID:      id,
+				Result: CallToolResult{
+					Content: []ContentItem{
+						{Type: "text", Text: fmt.Sprintf("Error: invalid parameter name '%s' (only letters, digits and underscore are allowed, optionally prefixed with '@')", paramName)},
+					},
+					IsError: true,
+				},
+			}
+		}
+	}
+
+	outSpec, err := jsonObjectArg(args["output_parameters"])
+	if err != nil {
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result: CallToolResult{
+				Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Error: Invalid JSON in output_parameters: %v", err)}},
+				IsError: true,
+			},
+		}
+	}
+	// Reject malformed output_parameters before touching the server.
+	if _, err := parseOutputParams(outSpec, procParams); err != nil {
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result: CallToolResult{
+				Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Error: %v", err)}},
+				IsError: true,
+			},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	sig, err := s.loadProcSignature(ctx, target, procName)
+	if err != nil {
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result: CallToolResult{
+				Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Error reading signature of '%s': %v", procName, err)}},
+				IsError: true,
+			},
+		}
+	}
+	if describe, _ := args["describe"].(bool); describe {
+		out, _ := json.MarshalIndent(map[string]interface{}{
+			"signature":    sig,
+			"input_schema": sig.inputSchema(),
+		}, "", "  ")
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result: CallToolResult{
+				Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Signature of '%s':\n%s", procName, string(out))}},
+			},
+		}
+	}
+	typedParams, outParams, err := sig.bind(procParams, outSpec)
+	if err != nil {
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result: CallToolResult{
+				Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Error: %v", err)}},
+				IsError: true,
+			},
+		}
+	}
+
+	procResult, err := s.executeProcedureOn(ctx, target, procName, typedParams, outParams)
+	if err != nil {
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result: CallToolResult{
+				Content: []ContentItem{
+					{
+						Type: "text",
+						Text: fmt.Sprintf("Error executing procedure '%s': %v", procName, err),
+					},
+				},
+				IsError: true,
+			},
+		}
+	}
+
+	resultBytes, err := json.MarshalIndent(procResult, "", "  ")
+	if err != nil {
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result: CallToolResult{
+				Content: []ContentItem{
+					{
+						Type: "text",
+						Text: fmt.Sprintf("Error formatting results: %v", err),
+					},
+				},
+				IsError: true,
+			},
+		}
+	}
+
+	summary := fmt.Sprintf("Procedure '%s' executed successfully", procName)
+	if len(procResult.Errors) > 0 {
+		summary = fmt.Sprintf("Procedure '%s' raised %d error(s)", procName, len(procResult.Errors))
+	}
+	return &MCPResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Result: CallToolResult{
+			Content: []ContentItem{
+				{
+					Type: "text",
+					Text: fmt.Sprintf("%s (return status %d, %d result set(s)):\n%s", summary, procResult.ReturnStatus, len(procResult.ResultSets), string(resultBytes)),
+				},
+			},
+			IsError: len(procResult.Errors) > 0,
+		},
+	}
+}
@@ -0,0 +1,563 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/golang-sql/civil"
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// procParam is one parameter of a stored procedure as described by
+// sys.parameters.
+type procParam struct {
+	Name     string `json:"name"` // without '@'
+	Type     string `json:"type"` // DDL form, e.g. nvarchar(50)
+	BaseType string `json:"-"`    // system type name, e.g. nvarchar
+	IsOutput bool   `json:"is_output"`
+	// HasDefault is parsed from the procedure header: sys.parameters only
+	// records defaults for CLR procedures. When the definition is not
+	// visible it is assumed true and SQL Server reports missing values.
+	HasDefault bool `json:"has_default"`
+	IsTable    bool `json:"is_table_type,omitempty"`
+}
+
+// procSignature is the parameter list of a stored procedure.
+type procSignature struct {
+	Procedure string      `json:"procedure"`
+	Params    []procParam `json:"parameters"`
+}
+
+const (
+	procObjectQuery = `SELECT OBJECT_ID(@p1), OBJECT_DEFINITION(OBJECT_ID(@p1))`
+	procParamsQuery = `
+		SELECT p.name, TYPE_NAME(p.user_type_id), TYPE_NAME(p.system_type_id),
+			p.max_length, p.precision, p.scale, p.is_output, p.has_default_value, p.is_readonly
+		FROM sys.parameters p
+		WHERE p.object_id = OBJECT_ID(@p1) AND p.parameter_id > 0
+		ORDER BY p.parameter_id
+	`
+)
+
+// paramTypeName renders a sys.parameters type in DDL form. max_length is in
+// bytes, so Unicode lengths are halved.
+func paramTypeName(typeName, baseType string, maxLen, precision, scale int64) string {
+	if !strings.EqualFold(typeName, baseType) {
+		return typeName // alias or table type: keep the user-visible name
+	}
+	switch strings.ToLower(baseType) {
+	case "nchar", "nvarchar":
+		if maxLen > 0 {
+			maxLen /= 2
+		}
+	case "datetime2", "time", "datetimeoffset":
+		return fmt.Sprintf("%s(%d)", baseType, scale)
+	}
+	return formatColumnType(baseType,
+		sql.NullInt64{Int64: maxLen, Valid: true},
+		sql.NullInt64{Int64: precision, Valid: true},
+		sql.NullInt64{Int64: scale, Valid: true},
+		sql.NullInt64{})
+}
+
+// loadProcSignature reads a procedure's parameters from sys.parameters and
+// its defaults from the procedure header.
+func (s *MCPMSSQLServer) loadProcSignature(ctx context.Context, target *queryTarget, procName string) (*procSignature, error) {
+	rows, closeRows, err := s.openSecureRowsOn(ctx, target, procObjectQuery, procName)
+	if err != nil {
+		return nil, err
+	}
+	var objectID sql.NullInt64
+	var definition sql.NullString
+	if rows.Next() {
+		err = rows.Scan(&objectID, &definition)
+	}
+	closeRows()
+	if err != nil {
+		return nil, err
+	}
+	if !objectID.Valid {
+		return nil, fmt.Errorf("procedure '%s' not found (or no permission to see it)", procName)
+	}
+
+	var defaults map[string]bool
+	if definition.Valid {
+		defaults = procParamDefaults(definition.String)
+	}
+
+	sig := &procSignature{Procedure: procName, Params: []procParam{}}
+	rows, closeRows, err = s.openSecureRowsOn(ctx, target, procParamsQuery, procName)
+	if err != nil {
+		return nil, err
+	}
+	defer closeRows()
+	for rows.Next() {
+		var name, typeName, baseType string
+		var maxLen, precision, scale int64
+		var isOutput, clrDefault, isReadonly bool
+		if err := rows.Scan(&name, &typeName, &baseType, &maxLen, &precision, &scale, &isOutput, &clrDefault, &isReadonly); err != nil {
+			return nil, err
+		}
+		p := procParam{
+			Name:     strings.TrimPrefix(name, "@"),
+			Type:     paramTypeName(typeName, baseType, maxLen, precision, scale),
+			BaseType: strings.ToLower(baseType),
+			IsOutput: isOutput,
+			IsTable:  isReadonly,
+		}
+		p.HasDefault = clrDefault || defaults == nil || defaults[strings.ToLower(p.Name)]
+		sig.Params = append(sig.Params, p)
+	}
+	return sig, rows.Err()
+}
+
+// procParamDefaults parses the parameter list of a CREATE/ALTER PROCEDURE
+// statement and returns the (lower-case, '@'-less) names of the parameters
+// that declare a default value.
+func procParamDefaults(definition string) map[string]bool {
+	src := []rune(stripSQLComments(definition))
+	defaults := map[string]bool{}
+
+	start := -1
+	for i, r := range src {
+		if r == '@' {
+			start = i
+			break
+		}
+		if isKeywordAt(src, i, "AS") {
+			break // body starts before any parameter
+		}
+	}
+	if start < 0 {
+		return defaults
+	}
+
+	// A parenthesised list: "CREATE PROC p (@a int = 1, ...)".
+	base := 0
+	prev := start - 1
+	for prev >= 0 && unicode.IsSpace(src[prev]) {
+		prev--
+	}
+	if prev >= 0 && src[prev] == '(' {
+		base = 1
+	}
+	depth := base
+
+	var segment strings.Builder
+	hasEq := false
+	flush := func() {
+		fields := strings.Fields(segment.String())
+		if len(fields) > 0 && strings.HasPrefix(fields[0], "@") {
+			defaults[strings.ToLower(strings.TrimPrefix(fields[0], "@"))] = hasEq
+		}
+		segment.Reset()
+		hasEq = false
+	}
+
+	inString := false
+	for i := start; i < len(src); i++ {
+		r := src[i]
+		if inString {
+			segment.WriteRune(r)
+			if r == '\'' {
+				if i+1 < len(src) && src[i+1] == '\'' {
+					segment.WriteRune(src[i+1])
+					i++
+				} else {
+					inString = false
+				}
+			}
+			continue
+		}
+		switch {
+		case r == '\'':
+			inString = true
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+			if depth < base {
+				flush()
+				return defaults
+			}
+		case r == ',' && depth == base:
+			flush()
+			continue
+		case r == '=' && depth == base:
+			hasEq = true
+		case depth == 0 && isKeywordAt(src, i, "AS", "WITH", "FOR"):
+			// "@p AS int" is a valid declaration; AS only ends the list
+			// once the current parameter has a type.
+			if len(strings.Fields(segment.String())) >= 2 || !isKeywordAt(src, i, "AS") {
+				flush()
+				return defaults
+			}
+		}
+		segment.WriteRune(r)
+	}
+	flush()
+	return defaults
+}
+
+// isKeywordAt reports whether one of the keywords starts at src[i] as a
+// whole word.
+func isKeywordAt(src []rune, i int, keywords ...string) bool {
+	if i > 0 && (unicode.IsLetter(src[i-1]) || unicode.IsDigit(src[i-1]) || src[i-1] == '_' || src[i-1] == '@') {
+		return false
+	}
+	for _, kw := range keywords {
+		end := i + len(kw)
+		if end > len(src) || !strings.EqualFold(string(src[i:end]), kw) {
+			continue
+		}
+		if end == len(src) || !(unicode.IsLetter(src[end]) || unicode.IsDigit(src[end]) || src[end] == '_') {
+			return true
+		}
+	}
+	return false
+}
+
+// stripSQLComments removes -- and /* */ comments outside string literals.
+func stripSQLComments(sqlText string) string {
+	var sb strings.Builder
+	src := []rune(sqlText)
+	inString := false
+	for i := 0; i < len(src); i++ {
+		r := src[i]
+		switch {
+		case inString:
+			sb.WriteRune(r)
+			if r == '\'' {
+				inString = false
+			}
+		case r == '\'':
+			inString = true
+			sb.WriteRune(r)
+		case r == '-' && i+1 < len(src) && src[i+1] == '-':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			sb.WriteRune('\n')
+		case r == '/' && i+1 < len(src) && src[i+1] == '*':
+			i += 2
+			for i+1 < len(src) && !(src[i] == '*' && src[i+1] == '/') {
+				i++
+			}
+			i++
+			sb.WriteRune(' ')
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// param looks up a parameter by name (case-insensitive, '@' optional).
+func (sig *procSignature) param(name string) (procParam, bool) {
+	name = strings.TrimPrefix(name, "@")
+	for _, p := range sig.Params {
+		if strings.EqualFold(p.Name, name) {
+			return p, true
+		}
+	}
+	return procParam{}, false
+}
+
+// bind checks the call arguments against the signature and converts them to
+// typed values. Every OUTPUT parameter of the procedure is declared, typed
+// from metadata unless outSpec overrides it. All missing required
+// parameters are reported at once.
+func (sig *procSignature) bind(inputs map[string]interface{}, outSpec map[string]interface{}) (map[string]interface{}, []procOutputParam, error) {
+	for _, name := range sortedKeys(outSpec) {
+		p, ok := sig.param(name)
+		if !ok || !p.IsOutput {
+			return nil, nil, fmt.Errorf("'%s' is not an OUTPUT parameter of %s", name, sig.Procedure)
+		}
+	}
+
+	typed := make(map[string]interface{}, len(inputs))
+	provided := make(map[string]interface{}, len(inputs))
+	for _, name := range sortedKeys(inputs) {
+		p, ok := sig.param(name)
+		if !ok {
+			return nil, nil, fmt.Errorf("%s has no parameter '@%s' (parameters: %s)", sig.Procedure, strings.TrimPrefix(name, "@"), sig.paramList())
+		}
+		if p.IsTable {
+			return nil, nil, fmt.Errorf("parameter '@%s' is a table-valued parameter, which execute_procedure does not support", p.Name)
+		}
+		provided[strings.ToLower(p.Name)] = inputs[name]
+		if p.IsOutput {
+			continue // bound below as INPUT/OUTPUT
+		}
+		v, err := coerceProcValue(p, inputs[name])
+		if err != nil {
+			return nil, nil, fmt.Errorf("parameter @%s (%s): %w", p.Name, p.Type, err)
+		}
+		typed[p.Name] = v
+	}
+
+	var missing []string
+	var outs []procOutputParam
+	for _, p := range sig.Params {
+		if p.IsOutput {
+			sqlType := p.BaseType
+			if override, ok := outSpec[p.Name].(string); ok && override != "" {
+				sqlType = override
+			} else if override, ok := outSpec["@"+p.Name].(string); ok && override != "" {
+				sqlType = override
+			}
+			dest, err := outputDest(sqlType, provided[strings.ToLower(p.Name)])
+			if err != nil {
+				return nil, nil, fmt.Errorf("OUTPUT parameter '@%s': %w", p.Name, err)
+			}
+			outs = append(outs, procOutputParam{name: p.Name, dest: dest})
+			continue
+		}
+		if _, ok := provided[strings.ToLower(p.Name)]; !ok && !p.HasDefault {
+			missing = append(missing, fmt.Sprintf("@%s (%s)", p.Name, p.Type))
+		}
+	}
+	if len(missing) > 0 {
+		return nil, nil, fmt.Errorf("missing required parameter(s) for %s: %s", sig.Procedure, strings.Join(missing, ", "))
+	}
+	return typed, outs, nil
+}
+
+func (sig *procSignature) paramList() string {
+	if len(sig.Params) == 0 {
+		return "none"
+	}
+	names := make([]string, len(sig.Params))
+	for i, p := range sig.Params {
+		names[i] = "@" + p.Name + " " + p.Type
+	}
+	return strings.Join(names, ", ")
+}
+
+var guidPattern = regexp.MustCompile(`^\{?[0-9A-Fa-f]{8}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{12}\}?$`)
+
+// jsonNumber accepts a JSON number or a numeric string; NaN and infinities
+// are refused.
+func jsonNumber(v interface{}) (float64, error) {
+	switch t := v.(type) {
+	case float64:
+		return t, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return 0, fmt.Errorf("expected a number, got %q", t)
+		}
+		return f, nil
+	default:
+		return 0, fmt.Errorf("expected a number, got %T", v)
+	}
+}
+
+// maxExactJSONInteger is the largest safe integer of a JSON number (float64):
+// from 2^53 on, distinct integers round to the same number, so larger values
+// must be passed as strings.
+const maxExactJSONInteger = 1<<53 - 1
+
+// jsonInteger accepts a whole JSON number or an integer string. Strings are
+// parsed as int64 so no digits are lost; numbers from 2^53 on are refused,
+// since float64 may already have rounded them.
+func jsonInteger(v interface{}, min, max int64) (int64, error) {
+	var n int64
+	switch t := v.(type) {
+	case float64:
+		if t != math.Trunc(t) {
+			return 0, fmt.Errorf("expected an integer, got %v", t)
+		}
+		if math.Abs(t) > maxExactJSONInteger {
+			return 0, fmt.Errorf("integer %v is too large for a JSON number; pass it as a string", t)
+		}
+		n = int64(t)
+	case string:
+		var err error
+		if n, err = strconv.ParseInt(strings.TrimSpace(t), 10, 64); err != nil {
+			if errors.Is(err, strconv.ErrRange) {
+				return 0, fmt.Errorf("value %s out of range", strings.TrimSpace(t))
+			}
+			return 0, fmt.Errorf("expected an integer, got %q", t)
+		}
+	default:
+		return 0, fmt.Errorf("expected a number, got %T", v)
+	}
+	if n < min || n > max {
+		return 0, fmt.Errorf("value %d out of range", n)
+	}
+	return n, nil
+}
+
+// parseJSONTime accepts RFC 3339 timestamps and the common SQL forms
+// "2006-01-02", "2006-01-02 15:04:05[.fff]".
+func parseJSONTime(v interface{}) (time.Time, bool, error) {
+	s, ok := v.(string)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("expected a date/time string, got %T", v)
+	}
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, true, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05.999999999", "2006-01-02 15:04:05.999999999", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, false, nil
+		}
+	}
+	return time.Time{}, false, fmt.Errorf("expected a date/time such as 2026-01-31 or 2026-01-31T13:45:00Z, got %q", s)
+}
+
+// coerceProcValue converts a JSON argument to the Go type the driver binds
+// as the parameter's SQL type, avoiding implicit conversions on the server.
+func coerceProcValue(p procParam, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch p.BaseType {
+	case "bit":
+		switch t := v.(type) {
+		case bool:
+			return t, nil
+		case float64:
+			if t == 0 || t == 1 {
+				return t == 1, nil
+			}
+		case string:
+			if b, err := strconv.ParseBool(strings.TrimSpace(t)); err == nil {
+				return b, nil
+			}
+		}
+		return nil, fmt.Errorf("expected true/false, got %v", v)
+	case "tinyint":
+		n, err := jsonInteger(v, 0, math.MaxUint8)
+		return uint8(n), err
+	case "smallint":
+		n, err := jsonInteger(v, math.MinInt16, math.MaxInt16)
+		return int16(n), err
+	case "int":
+		n, err := jsonInteger(v, math.MinInt32, math.MaxInt32)
+		return int32(n), err
+	case "bigint":
+		return jsonInteger(v, math.MinInt64, math.MaxInt64)
+	case "float", "real":
+		return jsonNumber(v)
+	case "decimal", "numeric":
+		return jsonDecimal(v)
+	case "money", "smallmoney":
+		// Four decimal places; the digit limits follow the types' ranges.
+		d, err := jsonDecimal(v)
+		if err != nil {
+			return nil, err
+		}
+		limits := queryParamType{precision: 19, scale: 4}
+		if p.BaseType == "smallmoney" {
+			limits.precision = 10
+		}
+		if err := checkDecimalFits(d, limits); err != nil {
+			return nil, fmt.Errorf("%s does not fit %s", d, p.BaseType)
+		}
+		return d, nil
+	case "date":
+		t, _, err := parseJSONTime(v)
+		return civil.DateOf(t), err
+	case "datetime", "smalldatetime":
+		t, _, err := parseJSONTime(v)
+		return mssql.DateTime1(t), err
+	case "datetime2":
+		t, _, err := parseJSONTime(v)
+		return civil.DateTimeOf(t), err
+	case "datetimeoffset":
+		t, _, err := parseJSONTime(v)
+		return mssql.DateTimeOffset(t), err
+	case "time":
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a time string such as 13:45:00, got %T", v)
+		}
+		t, err := civil.ParseTime(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("expected a time such as 13:45:00, got %q", s)
+		}
+		return t, nil
+	case "uniqueidentifier":
+		s, ok := v.(string)
+		if !ok || !guidPattern.MatchString(strings.TrimSpace(s)) {
+			return nil, fmt.Errorf("expected a GUID, got %v", v)
+		}
+		var u mssql.UniqueIdentifier
+		if err := u.Scan(strings.Trim(strings.TrimSpace(s), "{}")); err != nil {
+			return nil, err
+		}
+		return u, nil
+	case "binary", "varbinary":
+		s, ok := v.(string)
+		if !ok || !strings.HasPrefix(strings.ToLower(s), "0x") {
+			return nil, fmt.Errorf("expected a hex string such as 0x0A1B, got %v", v)
+		}
+		b, err := hex.DecodeString(s[2:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex string: %v", err)
+		}
+		return b, nil
+	case "char", "varchar", "text":
+		// Non-Unicode parameters are bound as varchar so that the server
+		// does not convert an nvarchar value (and skip index seeks).
+		return mssql.VarChar(fmt.Sprint(v)), nil
+	default:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		return fmt.Sprint(v), nil
+	}
+}
+
+// jsonSchemaType maps a SQL base type to the JSON Schema type used in
+// generated tool input schemas.
+func jsonSchemaType(baseType string) string {
+	switch baseType {
+	case "bit":
+		return "boolean"
+	case "tinyint", "smallint", "int", "bigint":
+		return "integer"
+	case "float", "real", "decimal", "numeric", "money", "smallmoney":
+		return "number"
+	default:
+		return "string"
+	}
+}
+
+// inputSchema generates the tool input schema for calling the procedure:
+// one property per input parameter, required when it has no default.
+func (sig *procSignature) inputSchema() InputSchema {
+	schema := InputSchema{Type: "object", Properties: map[string]Property{}, Required: []string{}}
+	for _, p := range sig.Params {
+		if p.IsOutput || p.IsTable {
+			continue
+		}
+		desc := "SQL type " + p.Type
+		if p.HasDefault {
+			desc += " (optional, has a default)"
+		}
+		switch p.BaseType {
+		case "date", "datetime", "datetime2", "smalldatetime", "datetimeoffset":
+			desc += "; ISO 8601, e.g. 2026-01-31 or 2026-01-31T13:45:00Z"
+		case "binary", "varbinary":
+			desc += "; hex string such as 0x0A1B"
+		}
+		schema.Properties[p.Name] = Property{Type: jsonSchemaType(p.BaseType), Description: desc}
+		if !p.HasDefault {
+			schema.Required = append(schema.Required, p.Name)
+		}
+	}
+	return schema
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// procToolPrefix names the per-procedure tools so they never collide
+	// with the built-in ones.
+	procToolPrefix = "proc_"
+
+	defaultProcToolsInterval = 60 * time.Second
+	procToolsRefreshTimeout  = 30 * time.Second
+)
+
+// procToolCatalogQuery lists every procedure with what identifies a
+// version of its tool: the last modification and the MS_Description.
+// Changing the description with sp_updateextendedproperty does not touch
+// modify_date.
+const procToolCatalogQuery = `
+	SELECT SCHEMA_NAME(p.schema_id), p.name, CONVERT(varchar(33), p.modify_date, 126),
+		CAST(ep.value AS nvarchar(4000))
+	FROM sys.procedures p
+	LEFT JOIN sys.extended_properties ep
+		ON ep.class = 1 AND ep.major_id = p.object_id AND ep.minor_id = 0 AND ep.name = 'MS_Description'
+	ORDER BY 1, 2
+`
+
+// procToolEntry is one whitelisted procedure exposed as its own tool.
+type procToolEntry struct {
+	procedure string
+	stamp     string // alias + modify_date + description + classification
+	tool      Tool
+}
+
+var procToolNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// procToolName derives the tool name of a procedure, e.g. dbo.usp_GetOrder
+// becomes proc_dbo_usp_GetOrder.
+func procToolName(procName string) string {
+	return procToolPrefix + procToolNameUnsafe.ReplaceAllString(strings.TrimSpace(procName), "_")
+}
+
+// procedureTool builds the tool advertised for a procedure from its
+// signature and MS_Description.
+func procedureTool(procName, description string, sig *procSignature, kind procKind) Tool {
+	desc := strings.TrimSpace(description)
+	if desc == "" {
+		desc = fmt.Sprintf("Execute the stored procedure %s.", procName)
+	}
+	desc += " Returns result sets, OUTPUT parameter values and the RETURN status."
+	readOnly := kind == procRead
+	return Tool{
+		Name:        procToolName(procName),
+		Title:       "Procedure " + procName,
+		Description: desc,
+		InputSchema: sig.inputSchema(),
+		Annotations: &ToolAnnotations{
+			ReadOnlyHint:    boolPtr(readOnly),
+			DestructiveHint: boolPtr(!readOnly),
+			IdempotentHint:  boolPtr(false),
+			OpenWorldHint:   boolPtr(false),
+		},
+	}
+}
+
+// procedureTools returns the per-procedure tools for tools/list.
+func (s *MCPMSSQLServer) procedureTools() []Tool {
+	s.procToolsMu.RLock()
+	defer s.procToolsMu.RUnlock()
+	tools := make([]Tool, 0, len(s.procTools))
+	for _, e := range s.procTools {
+		tools = append(tools, e.tool)
+	}
+	return tools
+}
+
+// procedureForTool maps a per-procedure tool name back to its procedure.
+func (s *MCPMSSQLServer) procedureForTool(name string) (string, bool) {
+	if !strings.HasPrefix(name, procToolPrefix) {
+		return "", false
+	}
+	s.procToolsMu.RLock()
+	defer s.procToolsMu.RUnlock()
+	for _, e := range s.procTools {
+		if e.tool.Name == name {
+			return e.procedure, true
+		}
+	}
+	return "", false
+}
+
+// procCatalogEntry is one row of procToolCatalogQuery.
+type procCatalogEntry struct {
+	name        string // schema.name
+	stamp       string
+	description string
+}
+
+// listProcCatalog reads every procedure of the target with its stamp.
+func (s *MCPMSSQLServer) listProcCatalog(ctx context.Context, target *queryTarget) ([]procCatalogEntry, error) {
+	rows, closeRows, err := s.openSecureRowsOn(ctx, target, procToolCatalogQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer closeRows()
+	var procs []procCatalogEntry
+	for rows.Next() {
+		var schema, name, modified string
+		var desc sql.NullString
+		if err := rows.Scan(&schema, &name, &modified, &desc); err != nil {
+			return nil, err
+		}
+		procs = append(procs, procCatalogEntry{
+			name:        schema + "." + name,
+			stamp:       target.alias + "|" + modified + "|" + desc.String,
+			description: desc.String,
+		})
+	}
+	return procs, rows.Err()
+}
+
+// refreshProcedureTools rebuilds the per-procedure tools for the active
+// connection: one tool per procedure matched by the whitelist (wildcard
+// entries expand to every matching procedure). It sends
+// notifications/tools/list_changed when the advertised surface changed.
+// Procedures whose stamp is unchanged keep their tool without re-reading
+// sys.parameters, and a failed refresh keeps the previous tools so a
+// transient error does not make the list flap.
+func (s *MCPMSSQLServer) refreshProcedureTools(ctx context.Context) {
+	target := s.activeTarget()
+
+	s.procToolsMu.RLock()
+	previous := make(map[string]procToolEntry, len(s.procTools))
+	for _, e := range s.procTools {
+		previous[strings.ToLower(e.procedure)] = e
+	}
+	s.procToolsMu.RUnlock()
+
+	var entries []procToolEntry
+	if target.config.procedureTools && target.db != nil && target.config.whitelistProcs != "" {
+		procs, err := s.listProcCatalog(ctx, target)
+		if err != nil {
+			s.secLogger.Printf("Procedure tools: cannot list procedures: %v", err)
+			return
+		}
+		seen := make(map[string]string)
+		for _, proc := range procs {
+			allowed, kind, _, _ := matchProcWhitelist(target.config.whitelistProcs, proc.name)
+			if !allowed || s.validateProcedureName(proc.name) != nil {
+				continue
+			}
+			name := procToolName(proc.name)
+			if other, dup := seen[name]; dup {
+				s.secLogger.Printf("Procedure tools: '%s' and '%s' map to the same tool name %s; skipping '%s'", other, proc.name, name, proc.name)
+				continue
+			}
+			seen[name] = proc.name
+
+			stamp := proc.stamp + "|" + kind.String()
+			prev, hadPrev := previous[strings.ToLower(proc.name)]
+			if hadPrev && prev.stamp == stamp {
+				entries = append(entries, prev)
+				continue
+			}
+			sig, err := s.loadProcSignature(ctx, target, proc.name)
+			if err != nil {
+				s.secLogger.Printf("Procedure tools: cannot read signature of '%s': %v", proc.name, err)
+				if hadPrev {
+					entries = append(entries, prev)
+				}
+				continue
+			}
+			entries = append(entries, procToolEntry{
+				procedure: proc.name,
+				stamp:     stamp,
+				tool:      procedureTool(proc.name, proc.description, sig, kind),
+			})
+		}
+	}
+
+	before := toolSurfaceSignature(s.listTools())
+	s.procToolsMu.Lock()
+	s.procTools = entries
+	s.procToolsMu.Unlock()
+	if toolSurfaceSignature(s.listTools()) != before {
+		s.secLogger.Printf("Procedure tools updated: %d tool(s)", len(entries))
+		s.sendNotification("notifications/tools/list_changed", nil)
+	}
+}
+
+// kickProcedureTools asks the refresher to run now (e.g. after a connect or
+// a config reload) instead of waiting for the next interval. It is a no-op
+// when no refresher was set up (tests).
+func (s *MCPMSSQLServer) kickProcedureTools() {
+	if s.procToolsKick == nil {
+		return
+	}
+	select {
+	case s.procToolsKick <- struct{}{}:
+	default: // a refresh is already pending
+	}
+}
+
+// startProcedureTools launches the refresher that keeps the per-procedure
+// tools in sync with the database. It polls every
+// MSSQL_PROCEDURE_TOOLS_INTERVAL seconds (default 60) and stops when ctx is
+// cancelled. It always runs so that MSSQL_PROCEDURE_TOOLS can be switched on
+// or off by a config reload.
+func (s *MCPMSSQLServer) startProcedureTools(ctx context.Context, wg *sync.WaitGroup) {
+	if s.procToolsKick == nil {
+		s.procToolsKick = make(chan struct{}, 1)
+	}
+	interval := envSeconds("MSSQL_PROCEDURE_TOOLS_INTERVAL", defaultProcToolsInterval)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				s.secLogger.Printf("Recovered panic in procedure tools refresher: %v", r)
+			}
+		}()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-s.procToolsKick:
+			}
+			refreshCtx, cancel := context.WithTimeout(ctx, procToolsRefreshTimeout)
+			s.refreshProcedureTools(refreshCtx)
+			cancel()
+		}
+	}()
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// procKind classifies a whitelisted stored procedure.
+type procKind int
+
+const (
+	// procUnclassified entries keep the posture's usual EXEC rules and are
+	// treated as writes on writable dynamic aliases.
+	procUnclassified procKind = iota
+	// procRead procedures are declared not to modify data: they may run on
+	// read-only targets.
+	procRead
+	// procWrite procedures modify data: refused on read-only targets and
+	// subject to confirm_operation on writable dynamic aliases.
+	procWrite
+)
+
+func (k procKind) String() string {
+	switch k {
+	case procRead:
+		return "read"
+	case procWrite:
+		return "write"
+	default:
+		return "unclassified"
+	}
+}
+
+// procWhitelistEntry is one entry of a procedure whitelist:
+// [schema.]name[:read|:write], where schema and name may contain '*'.
+type procWhitelistEntry struct {
+	raw    string
+	schema *regexp.Regexp
+	name   *regexp.Regexp
+	kind   procKind
+}
+
+// procPatternPart validates one side of an entry: identifier characters
+// plus the '*' wildcard.
+var procPatternPart = regexp.MustCompile(`^[\p{L}\p{N}_$#@*]+$`)
+
+// wildcardRegexp compiles a case-insensitive '*' pattern.
+func wildcardRegexp(pattern string) *regexp.Regexp {
+	parts := strings.Split(pattern, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	return regexp.MustCompile(`(?i)^` + strings.Join(parts, ".*") + `$`)
+}
+
+// parseProcWhitelist parses a comma-separated procedure whitelist. Entries
+// without a schema refer to dbo.
+func parseProcWhitelist(whitelist string) ([]procWhitelistEntry, error) {
+	var entries []procWhitelistEntry
+	for _, raw := range strings.Split(whitelist, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		spec, kind := raw, procUnclassified
+		if i := strings.LastIndex(raw, ":"); i >= 0 {
+			switch strings.ToLower(strings.TrimSpace(raw[i+1:])) {
+			case "read":
+				kind = procRead
+			case "write":
+				kind = procWrite
+			default:
+				return nil, fmt.Errorf("invalid procedure whitelist entry '%s': the suffix must be ':read' or ':write'", raw)
+			}
+			spec = strings.TrimSpace(raw[:i])
+		}
+		schema, name := "dbo", spec
+		if parts := strings.Split(spec, "."); len(parts) == 2 {
+			schema, name = parts[0], parts[1]
+		} else if len(parts) > 2 {
+			return nil, fmt.Errorf("invalid procedure whitelist entry '%s': use 'name' or 'schema.name'", raw)
+		}
+		schema = strings.Trim(strings.TrimSpace(schema), "[]")
+		name = strings.Trim(strings.TrimSpace(name), "[]")
+		if !procPatternPart.MatchString(schema) || !procPatternPart.MatchString(name) {
+			return nil, fmt.Errorf("invalid procedure whitelist entry '%s'", raw)
+		}
+		entries = append(entries, procWhitelistEntry{
+			raw:    raw,
+			schema: wildcardRegexp(schema),
+			name:   wildcardRegexp(name),
+			kind:   kind,
+		})
+	}
+	return entries, nil
+}
+
+// matchProcWhitelist reports whether procName (optionally schema-qualified,
+// dbo by default) is allowed by the whitelist and how it is classified, with
+// the schema and name it matched. Callers must run that procedure
+// (qualifiedTable(schema, name)), not procName: the server resolves an
+// unqualified name through the login's default schema, not dbo. When several
+// entries match, the most restrictive classification wins (write over
+// unclassified over read). Invalid entries never match.
+func matchProcWhitelist(whitelist, procName string) (allowed bool, kind procKind, schema, name string) {
+	kind = procUnclassified
+	entries, err := parseProcWhitelist(whitelist)
+	if err != nil {
+		return false, kind, "", ""
+	}
+	schema, name, err = splitQualifiedName(procName, "dbo")
+	if err != nil {
+		return false, kind, "", ""
+	}
+	for _, e := range entries {
+		if !e.schema.MatchString(schema) || !e.name.MatchString(name) {
+			continue
+		}
+		if !allowed {
+			allowed, kind = true, e.kind
+			continue
+		}
+		if e.kind == procWrite || (e.kind == procUnclassified && kind == procRead) {
+			kind = e.kind
+		}
+	}
+	return allowed, kind, schema, name
+}
@@ -0,0 +1,607 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Tables with more than profileSampleThreshold rows are read through
+	// TABLESAMPLE, sized to read about profileSampleRows rows.
+	profileSampleThreshold = 1000000
+	profileSampleRows      = 100000
+	// profileApproxRows is the number of rows read above which distinct
+	// counts use APPROX_COUNT_DISTINCT in distinct=auto.
+	profileApproxRows = 1000000
+	// profileMaxColumns caps the columns of one profile.
+	profileMaxColumns = 64
+	// profileValueLimit caps the characters of a reported value.
+	profileValueLimit = 100
+	defaultProfileTop = 5
+	maxProfileTop     = 20
+	profileTimeout    = 60 * time.Second
+	// engineEditionManagedInstance is SERVERPROPERTY('EngineEdition') on
+	// Azure SQL Managed Instance; like Azure SQL Database it reports an old
+	// ProductMajorVersion but has the current engine.
+	engineEditionManagedInstance = 8
+)
+
+// unmaskExpr is 1 when the login sees the real values of masked columns:
+// it has UNMASK, or the server predates Dynamic Data Masking (SQL Server
+// 2016) and so masks nothing.
+const unmaskExpr = `CAST(CASE WHEN ISNULL(CAST(SERVERPROPERTY('ProductMajorVersion') AS int), 0) < 13 AND CAST(SERVERPROPERTY('EngineEdition') AS int) NOT IN (5, 8) THEN 1
+		ELSE ISNULL(HAS_PERMS_BY_NAME(DB_NAME(), 'DATABASE', 'UNMASK'), 0) END AS bit)`
+
+// profileObjectQuery resolves the table or view and reads what the profile
+// depends on: the row count from the partitions (tables only), the engine
+// version for APPROX_COUNT_DISTINCT and TRY_CONVERT, and whether the login
+// sees unmasked data.
+const profileObjectQuery = `SELECT o.object_id, o.type,
+	ISNULL((SELECT SUM(p.rows) FROM sys.partitions p WHERE p.object_id = o.object_id AND p.index_id < 2), -1),
+	ISNULL(CAST(SERVERPROPERTY('ProductMajorVersion') AS int), 0),
+	CAST(SERVERPROPERTY('EngineEdition') AS int),
+	` + unmaskExpr + `
+FROM sys.objects o
+WHERE o.object_id = OBJECT_ID(QUOTENAME(@p1) + '.' + QUOTENAME(@p2)) AND o.type IN ('U', 'V')`
+
+// profileColumnsQuery lists the columns with their base type and whether
+// Dynamic Data Masking applies to them.
+const profileColumnsQuery = `SELECT c.name, TYPE_NAME(c.system_type_id),
+	CAST(ISNULL(COLUMNPROPERTY(c.object_id, c.name, 'IsMasked'), 0) AS bit)
+FROM sys.columns c
+WHERE c.object_id = @p1
+ORDER BY c.column_id`
+
+// profileKind groups column types by the statistics that make sense for
+// them.
+type profileKind int
+
+const (
+	profileOther profileKind = iota // NULL counts only (xml, spatial, text...)
+	profileNumber
+	profileBit
+	profileTemporal
+	profileString
+	profileGUID
+	profileBinary
+)
+
+func profileKindOf(sqlType string) profileKind {
+	switch sqlType {
+	case "tinyint", "smallint", "int", "bigint", "decimal", "numeric", "money", "smallmoney", "float", "real":
+		return profileNumber
+	case "bit":
+		return profileBit
+	case "date", "time", "datetime", "datetime2", "smalldatetime", "datetimeoffset":
+		return profileTemporal
+	case "char", "varchar", "nchar", "nvarchar":
+		return profileString
+	case "uniqueidentifier":
+		return profileGUID
+	case "binary", "varbinary":
+		return profileBinary
+	}
+	return profileOther
+}
+
+// profilePatterns are the shapes looked for in string columns, as
+// conditions on the quoted column %[1]s. Dates are the ISO form the server
+// converts whatever the DATEFORMAT, or the dd/mm/yyyy and mm/dd/yyyy shapes.
+var profilePatterns = []struct{ name, cond string }{
+	{"email", `%[1]s LIKE '%%_@_%%._%%' AND %[1]s NOT LIKE '%% %%'`},
+	{"guid", `LEN(%[1]s) IN (36, 38) AND TRY_CONVERT(uniqueidentifier, %[1]s) IS NOT NULL`},
+	{"date", `(%[1]s LIKE '[12][0-9][0-9][0-9]-[01][0-9]-[0-3][0-9]%%' AND TRY_CONVERT(datetime2, %[1]s) IS NOT NULL)
+		OR %[1]s LIKE '[0-3][0-9][/.-][0-3][0-9][/.-][12][0-9][0-9][0-9]'`},
+	{"number", `%[1]s NOT LIKE '%%[^0-9.+-]%%' AND TRY_CONVERT(float, %[1]s) IS NOT NULL`},
+}
+
+// profileColumn is one column to profile.
+type profileColumn struct {
+	name    string
+	sqlType string
+	kind    profileKind
+	masked  bool // masked by Dynamic Data Masking for this login
+}
+
+func (c profileColumn) distinctable() bool {
+	return !c.masked && c.kind != profileOther && c.kind != profileBinary
+}
+
+func (c profileColumn) ordered() bool {
+	return !c.masked && (c.kind == profileNumber || c.kind == profileTemporal || c.kind == profileString)
+}
+
+func (c profileColumn) measured() bool {
+	return !c.masked && (c.kind == profileString || c.kind == profileBinary)
+}
+
+// profileOptions are the choices that shape the aggregate query.
+type profileOptions struct {
+	source   string // FROM clause: the quoted table, with TABLESAMPLE if sampled
+	approx   bool   // APPROX_COUNT_DISTINCT instead of COUNT(DISTINCT)
+	patterns bool   // TRY_CONVERT is available
+}
+
+// profileAggregateQuery builds the single query that computes every
+// aggregate of every column in one pass. Each column contributes, in order:
+// its non-NULL count, then its distinct count, min and max, length min, max
+// and average, and one count per pattern, as far as they apply.
+func profileAggregateQuery(cols []profileColumn, opt profileOptions) string {
+	exprs := []string{"COUNT_BIG(*)"}
+	for _, c := range cols {
+		q := quoteIdentifier(c.name)
+		exprs = append(exprs, fmt.Sprintf("COUNT_BIG(CASE WHEN %s IS NOT NULL THEN 1 END)", q))
+		if c.distinctable() {
+			if opt.approx {
+				exprs = append(exprs, fmt.Sprintf("APPROX_COUNT_DISTINCT(%s)", q))
+			} else {
+				exprs = append(exprs, fmt.Sprintf("COUNT_BIG(DISTINCT %s)", q))
+			}
+		}
+		if c.ordered() {
+			exprs = append(exprs, fmt.Sprintf("MIN(%s)", q), fmt.Sprintf("MAX(%s)", q))
+		}
+		if c.measured() {
+			length := "LEN"
+			if c.kind == profileBinary {
+				length = "DATALENGTH"
+			}
+			l := fmt.Sprintf("%s(%s)", length, q)
+			exprs = append(exprs, fmt.Sprintf("MIN(%s)", l), fmt.Sprintf("MAX(%s)", l), fmt.Sprintf("AVG(CAST(%s AS float))", l))
+		}
+		if c.kind == profileString && !c.masked && opt.patterns {
+			for _, p := range profilePatterns {
+				exprs = append(exprs, fmt.Sprintf("COUNT_BIG(CASE WHEN "+p.cond+" THEN 1 END)", q))
+			}
+		}
+	}
+	return "SELECT " + strings.Join(exprs, ",\n\t") + "\nFROM " + opt.source
+}
+
+// profileTopQuery lists the most frequent values of a column.
+func profileTopQuery(c profileColumn, source string, top int) string {
+	q := quoteIdentifier(c.name)
+	return fmt.Sprintf("SELECT TOP (%d) %s, COUNT_BIG(*) FROM %s WHERE %s IS NOT NULL GROUP BY %s ORDER BY COUNT_BIG(*) DESC", top, q, source, q, q)
+}
+
+// profileSamplePercent is the TABLESAMPLE percentage that reads about
+// profileSampleRows rows of a table of rows rows, or 0 to read it all.
+func profileSamplePercent(rows int64) float64 {
+	if rows <= profileSampleThreshold {
+		return 0
+	}
+	pct := float64(profileSampleRows) * 100 / float64(rows)
+	// Two significant digits are plenty and keep the SQL readable.
+	return math.Max(0.0001, roundSignificant(pct, 2))
+}
+
+func roundSignificant(v float64, digits int) float64 {
+	if v == 0 {
+		return 0
+	}
+	scale := math.Pow(10, float64(digits)-math.Ceil(math.Log10(math.Abs(v))))
+	return math.Round(v*scale) / scale
+}
+
+// profileLength is the length statistics of a string or binary column, in
+// characters (LEN, trailing spaces ignored) or bytes.
+type profileLength struct {
+	Min int64   `json:"min"`
+	Max int64   `json:"max"`
+	Avg float64 `json:"avg"`
+}
+
+// profileValue is one of the most frequent values of a column.
+type profileValue struct {
+	Value interface{} `json:"value"`
+	Count int64       `json:"count"`
+	Pct   float64     `json:"pct"`
+}
+
+// profileColumnReport is the profile of one column. Percentages are of the
+// rows read (null_pct) or of the non-NULL values read (top, patterns).
+type profileColumnReport struct {
+	Name     string             `json:"name"`
+	Type     string             `json:"type"`
+	Nulls    int64              `json:"nulls"`
+	NullPct  float64            `json:"null_pct"`
+	Distinct *int64             `json:"distinct,omitempty"`
+	Min      interface{}        `json:"min,omitempty"`
+	Max      interface{}        `json:"max,omitempty"`
+	Length   *profileLength     `json:"length,omitempty"`
+	Top      []profileValue     `json:"top,omitempty"`
+	Patterns map[string]float64 `json:"patterns,omitempty"`
+	Masked   bool               `json:"masked,omitempty"`
+	nonNull  int64
+}
+
+// profileReport is the reply of the profile tool.
+type profileReport struct {
+	Table            string                `json:"table"`
+	Rows             int64                 `json:"rows"`
+	RowsEstimated    bool                  `json:"rows_estimated,omitempty"`
+	SamplePercent    float64               `json:"sample_percent,omitempty"`
+	RowsRead         int64                 `json:"rows_read"`
+	DistinctCounting string                `json:"distinct_counting"`
+	Columns          []profileColumnReport `json:"columns"`
+	Notes            []string              `json:"notes,omitempty"`
+}
+
+func percentOf(part, whole int64) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return round1(float64(part) * 100 / float64(whole))
+}
+
+// profileDisplay converts a scanned value for the report, shortening long
+// text.
+func profileDisplay(c profileColumn, v interface{}) interface{} {
+	v = exportValue(exportColumn{Name: c.name, Type: c.sqlType}, v)
+	if s, ok := v.(string); ok {
+		return truncateRunes(s, profileValueLimit)
+	}
+	return v
+}
+
+// truncateRunes shortens s to limit characters, marking the cut.
+func truncateRunes(s string, limit int) string {
+	if r := []rune(s); len(r) > limit {
+		return string(r[:limit]) + "…"
+	}
+	return s
+}
+
+func profileInt(v interface{}) int64 {
+	switch t := v.(type) {
+	case int64:
+		return t
+	case int32:
+		return int64(t)
+	case []byte:
+		n, _ := strconv.ParseInt(string(t), 10, 64)
+		return n
+	}
+	return 0
+}
+
+func profileFloat(v interface{}) float64 {
+	switch t := v.(type) {
+	case float64:
+		return t
+	case int64:
+		return float64(t)
+	}
+	return 0
+}
+
+// readProfileAggregates fills the column reports from the values of the
+// aggregate query, in the order profileAggregateQuery lays them out, and
+// returns the number of rows read.
+func readProfileAggregates(cols []profileColumn, opt profileOptions, values []interface{}) (int64, []profileColumnReport) {
+	rowsRead := profileInt(values[0])
+	next := 1
+	take := func() interface{} {
+		v := values[next]
+		next++
+		return v
+	}
+	reports := make([]profileColumnReport, len(cols))
+	for i, c := range cols {
+		r := profileColumnReport{Name: c.name, Type: c.sqlType, Masked: c.masked}
+		r.nonNull = profileInt(take())
+		r.Nulls = rowsRead - r.nonNull
+		r.NullPct = percentOf(r.Nulls, rowsRead)
+		if c.distinctable() {
+			d := profileInt(take())
+			r.Distinct = &d
+		}
+		if c.ordered() {
+			min, max := take(), take()
+			if min != nil {
+				r.Min, r.Max = profileDisplay(c, min), profileDisplay(c, max)
+			}
+		}
+		if c.measured() {
+			min, max, avg := take(), take(), take()
+			if min != nil {
+				r.Length = &profileLength{Min: profileInt(min), Max: profileInt(max), Avg: round1(profileFloat(avg))}
+			}
+		}
+		if c.kind == profileString && !c.masked && opt.patterns {
+			for _, p := range profilePatterns {
+				if n := profileInt(take()); n > 0 {
+					if r.Patterns == nil {
+						r.Patterns = map[string]float64{}
+					}
+					r.Patterns[p.name] = percentOf(n, r.nonNull)
+				}
+			}
+		}
+		reports[i] = r
+	}
+	return rowsRead, reports
+}
+
+// selectProfileColumns picks the columns named in list (all when empty),
+// in table order.
+func selectProfileColumns(all []profileColumn, list string) ([]profileColumn, error) {
+	if strings.TrimSpace(list) == "" {
+		return all, nil
+	}
+	byName := make(map[string]profileColumn, len(all))
+	for _, c := range all {
+		byName[strings.ToLower(c.name)] = c
+	}
+	wanted := map[string]bool{}
+	var unknown []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.Trim(strings.TrimSpace(name), "[]"))
+		if name == "" {
+			continue
+		}
+		if _, ok := byName[name]; !ok {
+			unknown = append(unknown, name)
+		}
+		wanted[name] = true
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("no column %s in the table", strings.Join(unknown, ", "))
+	}
+	var cols []profileColumn
+	for _, c := range all {
+		if wanted[strings.ToLower(c.name)] {
+			cols = append(cols, c)
+		}
+	}
+	return cols, nil
+}
+
+// profileOn profiles columns of schema.table. Everything is read with the
+// target's login, so masked columns stay masked: for a login without UNMASK
+// they report NULL counts only.
+func (s *MCPMSSQLServer) profileOn(ctx context.Context, target *queryTarget, schema, table string, args map[string]interface{}) (*profileReport, error) {
+	var objectID, estimated int64
+	var objType string
+	var major, edition int
+	var unmask bool
+	found := false
+	err := s.scanQuery(ctx, target, profileObjectQuery, []interface{}{schema, table}, func(rows *sql.Rows) error {
+		found = true
+		return rows.Scan(&objectID, &objType, &estimated, &major, &edition, &unmask)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("table or view %s.%s not found", schema, table)
+	}
+	isTable := strings.TrimSpace(objType) == "U"
+	current := edition == engineEditionAzureSQLDatabase || edition == engineEditionManagedInstance
+
+	var all []profileColumn
+	err = s.scanQuery(ctx, target, profileColumnsQuery, []interface{}{objectID}, func(rows *sql.Rows) error {
+		var c profileColumn
+		var typeName sql.NullString
+		if err := rows.Scan(&c.name, &typeName, &c.masked); err != nil {
+			return err
+		}
+		c.sqlType = strings.ToLower(typeName.String)
+		c.kind = profileKindOf(c.sqlType)
+		c.masked = c.masked && !unmask
+		all = append(all, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	list, _ := args["columns"].(string)
+	cols, err := selectProfileColumns(all, list)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &profileReport{Table: schema + "." + table}
+	if len(cols) > profileMaxColumns {
+		report.Notes = append(report.Notes, fmt.Sprintf("only the first %d of %d columns were profiled; name the others in 'columns'", profileMaxColumns, len(cols)))
+		cols = cols[:profileMaxColumns]
+	}
+
+	top := defaultProfileTop
+	if n, ok := args["top"].(float64); ok {
+		top = int(math.Max(0, math.Min(n, maxProfileTop)))
+	}
+
+	opt := profileOptions{source: qualifiedTable(schema, table), patterns: current || major >= 11}
+	pct := profileSamplePercent(estimated)
+	if p, ok := args["sample_percent"].(float64); ok {
+		if p <= 0 || p > 100 {
+			return nil, fmt.Errorf("sample_percent must be greater than 0 and at most 100")
+		}
+		pct = p
+		if pct == 100 {
+			pct = 0
+		}
+	}
+	switch {
+	case pct > 0 && !isTable:
+		report.Notes = append(report.Notes, "views cannot be sampled (TABLESAMPLE); the whole view was read")
+	case pct > 0:
+		// REPEATABLE makes the top-value queries read the same sample.
+		opt.source += fmt.Sprintf(" TABLESAMPLE SYSTEM (%s PERCENT) REPEATABLE (1)", strconv.FormatFloat(pct, 'f', -1, 64))
+		report.SamplePercent = pct
+	}
+
+	distinct, _ := args["distinct"].(string)
+	approxAvailable := current || major >= 15
+	switch strings.ToLower(strings.TrimSpace(distinct)) {
+	case "", "auto":
+		expected := estimated
+		if report.SamplePercent > 0 {
+			expected = int64(float64(estimated) * report.SamplePercent / 100)
+		}
+		opt.approx = approxAvailable && expected > profileApproxRows
+	case "approx":
+		if !approxAvailable {
+			report.Notes = append(report.Notes, "APPROX_COUNT_DISTINCT needs SQL Server 2019 or later; distinct counts are exact")
+		}
+		opt.approx = approxAvailable
+	case "exact":
+	default:
+		return nil, fmt.Errorf("invalid distinct '%s' (use auto, exact or approx)", distinct)
+	}
+	report.DistinctCounting = "exact"
+	if opt.approx {
+		report.DistinctCounting = "approx"
+	}
+	if !opt.patterns {
+		report.Notes = append(report.Notes, "pattern detection needs TRY_CONVERT (SQL Server 2012 or later)")
+	}
+
+	var values []interface{}
+	err = s.scanQuery(ctx, target, profileAggregateQuery(cols, opt), nil, func(rows *sql.Rows) error {
+		n, err := rows.Columns()
+		if err != nil {
+			return err
+		}
+		values = make([]interface{}, len(n))
+		ptrs := make([]interface{}, len(n))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		return rows.Scan(ptrs...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	report.RowsRead, report.Columns = readProfileAggregates(cols, opt, values)
+	report.Rows = report.RowsRead
+	if report.SamplePercent > 0 {
+		report.Rows, report.RowsEstimated = estimated, true
+	}
+
+	for i, c := range cols {
+		r := &report.Columns[i]
+		// A column whose values are all different has no frequent values.
+		if top == 0 || !c.distinctable() || r.nonNull == 0 || (!opt.approx && *r.Distinct == r.nonNull) {
+			continue
+		}
+		err := s.scanQuery(ctx, target, profileTopQuery(c, opt.source, top), nil, func(rows *sql.Rows) error {
+			var v interface{}
+			var n int64
+			if err := rows.Scan(&v, &n); err != nil {
+				return err
+			}
+			r.Top = append(r.Top, profileValue{Value: profileDisplay(c, v), Count: n, Pct: percentOf(n, r.nonNull)})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range cols {
+		if c.masked {
+			report.Notes = append(report.Notes, "masked columns (Dynamic Data Masking) only report NULL counts: this login cannot see their values")
+			break
+		}
+	}
+	return report, nil
+}
+
+// handleProfile is the tools/call entry point of the profile tool.
+func (s *MCPMSSQLServer) handleProfile(id interface{}, args map[string]interface{}) *MCPResponse {
+	errorResponse := func(err error) *MCPResponse {
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result: CallToolResult{
+				Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Profile Error: %v", err)}},
+				IsError: true,
+			},
+		}
+	}
+	target, err := s.resolveTarget(args)
+	if err != nil {
+		return errorResponse(err)
+	}
+	target.tool = "profile"
+	tableArg, _ := args["table_name"].(string)
+	if strings.TrimSpace(tableArg) == "" {
+		return errorResponse(fmt.Errorf("missing or invalid 'table_name' parameter"))
+	}
+	defaultSchema := "dbo"
+	if sc, ok := args["schema"].(string); ok && strings.TrimSpace(sc) != "" {
+		defaultSchema = strings.TrimSpace(sc)
+	}
+	schema, table, err := splitQualifiedName(tableArg, defaultSchema)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), profileTimeout)
+	defer cancel()
+	report, err := s.profileOn(ctx, target, schema, table, args)
+	if err != nil {
+		return errorResponse(err)
+	}
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return errorResponse(err)
+	}
+	return &MCPResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Result:  CallToolResult{Content: []ContentItem{{Type: "text", Text: string(out)}}},
+	}
+}
+
+// profileTool describes the profile tool.
+func profileTool() Tool {
+	return Tool{
+		Name:        "profile",
+		Title:       "Profile Data",
+		Description: "Profile the data of a table or view in one call instead of many ad-hoc aggregates: row count, NULL %, distinct count (exact, or APPROX_COUNT_DISTINCT on large reads), min/max, text length stats, the most frequent values with their share, and patterns detected in text columns (emails, GUIDs, dates and numbers stored as text). Tables over 1M rows are sampled with TABLESAMPLE unless sample_percent says otherwise. Columns masked by Dynamic Data Masking only report NULL counts.",
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]Property{
+				"table_name": {
+					Type:        "string",
+					Description: "Table or view to profile (can include schema: 'dbo.Orders')",
+				},
+				"schema": {
+					Type:        "string",
+					Description: "Schema name (optional, defaults to 'dbo')",
+				},
+				"columns": {
+					Type:        "string",
+					Description: fmt.Sprintf("Columns to profile, comma-separated (default: all, up to %d)", profileMaxColumns),
+				},
+				"top": {
+					Type:        "integer",
+					Description: fmt.Sprintf("Most frequent values to list per column (default %d, max %d, 0 for none)", defaultProfileTop, maxProfileTop),
+				},
+				"distinct": {
+					Type:        "string",
+					Description: "'auto' (default: approximate above 1M rows read, on SQL Server 2019+), 'exact' or 'approx'",
+				},
+				"sample_percent": {
+					Type:        "number",
+					Description: "Percentage of the table to sample with TABLESAMPLE (default: about 100k rows for tables over 1M rows; 100 reads everything)",
+				},
+			},
+			Required: []string{"table_name"},
+		},
+		Annotations: &ToolAnnotations{
+			ReadOnlyHint:    boolPtr(true),
+			DestructiveHint: boolPtr(false),
+			IdempotentHint:  boolPtr(true),
+			OpenWorldHint:   boolPtr(false),
+		},
+	}
+}
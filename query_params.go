@@ -0,0 +1,262 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/shopspring/decimal"
+)
+
+// maxQueryParams caps the number of parameters of one query_database call
+// (SQL Server accepts at most 2100 per request).
+const maxQueryParams = 2100
+
+// queryParamTypePattern matches an explicit parameter type such as
+// nvarchar(50), varchar(max), decimal(18,2) or datetime2.
+var queryParamTypePattern = regexp.MustCompile(`^([A-Za-z0-9_]+)\s*(?:\(\s*(max|MAX|\d+)\s*(?:,\s*(\d+)\s*)?\))?$`)
+
+// queryParamNamePattern is what a named parameter may be called.
+var queryParamNamePattern = regexp.MustCompile(`^[\p{L}_][\p{L}\p{N}_]*$`)
+
+// queryParamTypes are the explicit types query_database accepts; values
+// are converted by coerceProcValue like stored procedure arguments.
+var queryParamTypes = map[string]bool{
+	"bit": true, "tinyint": true, "smallint": true, "int": true, "bigint": true,
+	"float": true, "real": true, "decimal": true, "numeric": true, "money": true, "smallmoney": true,
+	"date": true, "time": true, "datetime": true, "smalldatetime": true, "datetime2": true, "datetimeoffset": true,
+	"char": true, "varchar": true, "nchar": true, "nvarchar": true,
+	"uniqueidentifier": true, "binary": true, "varbinary": true,
+}
+
+// queryParamType is a parsed explicit type: length is the character or
+// byte length (-1 for max), precision and scale apply to decimal/numeric.
+type queryParamType struct {
+	base      string
+	length    int
+	precision int
+	scale     int
+}
+
+func parseQueryParamType(spec string) (queryParamType, error) {
+	m := queryParamTypePattern.FindStringSubmatch(strings.TrimSpace(spec))
+	if m == nil {
+		return queryParamType{}, fmt.Errorf("invalid type %q", spec)
+	}
+	t := queryParamType{base: strings.ToLower(m[1])}
+	if !queryParamTypes[t.base] {
+		return t, fmt.Errorf("unsupported type %q", spec)
+	}
+	switch t.base {
+	case "decimal", "numeric":
+		t.precision, t.scale = 18, 0
+		if m[2] != "" {
+			p, err := strconv.Atoi(m[2])
+			if err != nil || p < 1 || p > 38 {
+				return t, fmt.Errorf("invalid precision in %q", spec)
+			}
+			t.precision = p
+		}
+		if m[3] != "" {
+			t.scale, _ = strconv.Atoi(m[3])
+			if t.scale > t.precision {
+				return t, fmt.Errorf("scale larger than precision in %q", spec)
+			}
+		}
+	case "char", "varchar", "nchar", "nvarchar", "binary", "varbinary":
+		if m[3] != "" {
+			return t, fmt.Errorf("invalid type %q", spec)
+		}
+		switch {
+		case strings.EqualFold(m[2], "max"):
+			t.length = -1
+		case m[2] != "":
+			t.length, _ = strconv.Atoi(m[2])
+		}
+	default:
+		if m[2] != "" {
+			return t, fmt.Errorf("type %s takes no length", t.base)
+		}
+	}
+	return t, nil
+}
+
+// maxExactJSONDigits is the number of significant digits a JSON number
+// (float64) is guaranteed to keep; decimal values with more must be passed
+// as strings.
+const maxExactJSONDigits = 15
+
+// jsonDecimal accepts a decimal string or a JSON number as an exact
+// decimal. Strings keep every digit; numbers with more significant digits
+// than float64 keeps are refused, since they may already have been rounded.
+func jsonDecimal(v interface{}) (decimal.Decimal, error) {
+	switch t := v.(type) {
+	case float64:
+		if math.IsNaN(t) || math.IsInf(t, 0) {
+			return decimal.Decimal{}, fmt.Errorf("expected a number, got %v", t)
+		}
+		mantissa, _, _ := strings.Cut(strconv.FormatFloat(math.Abs(t), 'e', -1, 64), "e")
+		if len(strings.Replace(mantissa, ".", "", 1)) > maxExactJSONDigits {
+			return decimal.Decimal{}, fmt.Errorf("number %v has more digits than a JSON number keeps; pass it as a string", t)
+		}
+		return decimal.NewFromFloat(t), nil
+	case string:
+		d, err := decimal.NewFromString(strings.TrimSpace(t))
+		if err != nil {
+			return decimal.Decimal{}, fmt.Errorf("expected a decimal number, got %q", t)
+		}
+		return d, nil
+	default:
+		return decimal.Decimal{}, fmt.Errorf("expected a number, got %T", v)
+	}
+}
+
+// checkDecimalFits reports values with more integer or fractional digits
+// than decimal(precision, scale) holds.
+func checkDecimalFits(d decimal.Decimal, t queryParamType) error {
+	s := d.String()
+	intPart, frac, _ := strings.Cut(strings.TrimLeft(s, "-"), ".")
+	intPart = strings.TrimLeft(intPart, "0")
+	frac = strings.TrimRight(frac, "0")
+	if len(frac) > t.scale || len(intPart) > t.precision-t.scale {
+		return fmt.Errorf("%s does not fit decimal(%d,%d)", s, t.precision, t.scale)
+	}
+	return nil
+}
+
+// coerceQueryParam converts a JSON value to the Go value the driver binds
+// as the explicit type. Decimal values are bound as exact decimals checked
+// against the declared precision and scale: go-mssqldb has no decimal RPC
+// type and sends their exact text, which the server then converts without
+// rounding or overflow.
+func coerceQueryParam(t queryParamType, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch t.base {
+	case "decimal", "numeric":
+		d, err := jsonDecimal(v)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkDecimalFits(d, t); err != nil {
+			return nil, err
+		}
+		return d, nil
+	case "nchar", "nvarchar", "char", "varchar":
+		s, ok := v.(string)
+		if !ok {
+			s = fmt.Sprint(v)
+		}
+		if t.length > 0 && utf8.RuneCountInString(s) > t.length {
+			return nil, fmt.Errorf("value longer than %s(%d)", t.base, t.length)
+		}
+		if t.base == "char" || t.base == "varchar" {
+			return mssql.VarChar(s), nil
+		}
+		return s, nil
+	}
+	val, err := coerceProcValue(procParam{BaseType: t.base}, v)
+	if err != nil {
+		return nil, err
+	}
+	if t.base == "binary" || t.base == "varbinary" {
+		if t.length > 0 && len(val.([]byte)) > t.length {
+			return nil, fmt.Errorf("value longer than %s(%d)", t.base, t.length)
+		}
+	}
+	return val, nil
+}
+
+// inferQueryParam binds an untyped JSON value: strings as nvarchar, whole
+// numbers as bigint, other numbers as float, booleans as bit.
+func inferQueryParam(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case nil, string, bool:
+		return t, nil
+	case float64:
+		if n, err := jsonInteger(t, -maxExactJSONInteger, maxExactJSONInteger); err == nil {
+			return n, nil
+		}
+		return t, nil
+	default:
+		return nil, fmt.Errorf("expected a string, number, boolean or null, or {\"value\": ..., \"type\": ...}, got %T", v)
+	}
+}
+
+// queryParamValue binds one params entry: a plain JSON value, or an object
+// {"value": ..., "type": "decimal(18,2)"} with an explicit SQL type.
+func queryParamValue(v interface{}) (interface{}, error) {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return inferQueryParam(v)
+	}
+	for k := range obj {
+		if k != "value" && k != "type" {
+			return nil, fmt.Errorf("unexpected key %q (use \"value\" and \"type\")", k)
+		}
+	}
+	spec, _ := obj["type"].(string)
+	if strings.TrimSpace(spec) == "" {
+		return inferQueryParam(obj["value"])
+	}
+	t, err := parseQueryParamType(spec)
+	if err != nil {
+		return nil, err
+	}
+	return coerceQueryParam(t, obj["value"])
+}
+
+// parseQueryParams reads query_database's params argument. An array binds
+// @p1..@pN in order; an object binds named parameters (@name, with or
+// without the '@'). A string containing either JSON form is accepted too.
+func parseQueryParams(v interface{}) ([]interface{}, error) {
+	if s, ok := v.(string); ok {
+		if strings.TrimSpace(s) == "" {
+			return nil, nil
+		}
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			return nil, fmt.Errorf("params: %v", err)
+		}
+	}
+	var args []interface{}
+	switch t := v.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		if len(t) > maxQueryParams {
+			return nil, fmt.Errorf("params: at most %d parameters are allowed", maxQueryParams)
+		}
+		for i, item := range t {
+			val, err := queryParamValue(item)
+			if err != nil {
+				return nil, fmt.Errorf("params: @p%d: %v", i+1, err)
+			}
+			args = append(args, val)
+		}
+	case map[string]interface{}:
+		if len(t) > maxQueryParams {
+			return nil, fmt.Errorf("params: at most %d parameters are allowed", maxQueryParams)
+		}
+		for name, item := range t {
+			name = strings.TrimPrefix(strings.TrimSpace(name), "@")
+			if !queryParamNamePattern.MatchString(name) {
+				return nil, fmt.Errorf("params: invalid parameter name %q", name)
+			}
+			val, err := queryParamValue(item)
+			if err != nil {
+				return nil, fmt.Errorf("params: @%s: %v", name, err)
+			}
+			args = append(args, sql.Named(name, val))
+		}
+	default:
+		return nil, fmt.Errorf("params: expected an array (bound as @p1..@pN) or an object (bound by name)")
+	}
+	return args, nil
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultConfigWatchInterval is how often the .env file is polled for changes
+// when MSSQL_CONFIG_WATCH_INTERVAL is not set.
+const defaultConfigWatchInterval = 5 * time.Second
+
+// classicConnectionKeys are the environment variables that shape the classic
+// (non-dynamic) connection. When a reload changes any of them the classic pool
+// is closed and the connection supervisor, woken by the reload, reopens it with
+// the new values.
+var classicConnectionKeys = []string{
+	"MSSQL_SERVER", "MSSQL_DATABASE", "MSSQL_USER", "MSSQL_PASSWORD", "MSSQL_PORT",
+	"MSSQL_AUTH", "MSSQL_ENCRYPT", "MSSQL_CONNECTION_STRING",
+}
+
+// environMap returns the current process environment as a map.
+func environMap() map[string]string {
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if idx := strings.Index(kv, "="); idx > 0 {
+			env[kv[:idx]] = kv[idx+1:]
+		}
+	}
+	return env
+}
+
+// validateDynamicAliases checks that every alias can produce a usable
+// connection string. Startup (startupDynamicAliases) and reloadConfig both
+// reject the whole configuration when any alias fails, so a typo in .env never
+// half-applies and both paths accept the same configurations.
+func validateDynamicAliases(aliases map[string]DynamicAlias, devMode bool) error {
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		a := aliases[name]
+		if a.ConnectionString == "" && (a.Server == "" || a.Database == "") {
+			return fmt.Errorf("alias '%s' is missing SERVER or DATABASE configuration", name)
+		}
+		if _, err := buildAliasConnectionString(&a, devMode, name); err != nil {
+			return fmt.Errorf("alias '%s': %w", name, err)
+		}
+		if _, err := parseProcWhitelist(a.WhitelistProcs); err != nil {
+			return fmt.Errorf("alias '%s': %w", name, err)
+		}
+	}
+	return nil
+}
+
+// aliasConnectionChanged reports whether two alias definitions differ in any
+// field that affects the underlying connection (as opposed to policy-only
+// fields such as ReadOnly or WhitelistTables, which apply immediately).
+func aliasConnectionChanged(old, cur DynamicAlias) bool {
+	return old.Server != cur.Server ||
+		old.Database != cur.Database ||
+		old.User != cur.User ||
+		old.Password != cur.Password ||
+		old.Encrypt != cur.Encrypt ||
+		old.Port != cur.Port ||
+		old.ConnectionString != cur.ConnectionString
+}
+
+// toolSurfaceSignature summarises the advertised tool surface so a reload can
+// tell whether clients need notifications/tools/list_changed.
+func toolSurfaceSignature(tools []Tool) string {
+	var sb strings.Builder
+	for _, t := range tools {
+		sb.WriteString(t.Name)
+		sb.WriteByte('|')
+		sb.WriteString(t.Description)
+		if b, err := json.Marshal(t.InputSchema); err == nil {
+			sb.Write(b)
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// writeMessage serialises v as one JSON-RPC line on the server's output. All
+// stdout traffic (responses and notifications) goes through here so that
+// background notifications never interleave with a response mid-line.
+func (s *MCPMSSQLServer) writeMessage(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	if s.out == nil {
+		return nil
+	}
+	_, err = fmt.Fprintln(s.out, string(b))
+	return err
+}
+
+// sendNotification emits a server-initiated JSON-RPC notification.
+func (s *MCPMSSQLServer) sendNotification(method string, params interface{}) {
+	if err := s.writeMessage(MCPNotification{JSONRPC: "2.0", Method: method, Params: params}); err != nil {
+		s.secLogger.Printf("Failed to send notification %s: %v", method, err)
+	}
+}
+
+// reloadConfig re-reads the .env file, rebuilds the global configuration and
+// the dynamic aliases, validates them, and only then swaps them in. Policy
+// changes (read-only flags, whitelists) take effect for the next tool call.
+// Pools belonging to aliases that were removed or whose connection settings
+// changed are closed; if one of them was the active alias, the server returns
+// to the disconnected state. A rejected reload leaves the running
+// configuration and the process environment untouched.
+func (s *MCPMSSQLServer) reloadConfig(reason string) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	// Build the candidate environment: host-passed values always win, .env
+	// values fill the gaps (same precedence as loadDotEnvIfPresent).
+	env := environMap()
+	for key, val := range s.envFileValues {
+		if env[key] == val {
+			delete(env, key)
+		}
+	}
+	fileValues := map[string]string{}
+	if s.envFilePath != "" {
+		// #nosec G304 -- envFilePath is derived from os.Executable(), not user input
+		data, err := os.ReadFile(s.envFilePath)
+		if err != nil && !os.IsNotExist(err) {
+			s.secLogger.Printf("Config reload (%s) rejected: cannot read .env: %v", reason, err)
+			return fmt.Errorf("cannot read .env: %w", err)
+		}
+		if err == nil {
+			fileValues = parseDotEnv(data)
+		}
+	}
+	applied := make(map[string]string)
+	for key, val := range fileValues {
+		if env[key] == "" {
+			env[key] = val
+			applied[key] = val
+		}
+	}
+
+	getenv := func(key string) string { return env[key] }
+	cfg := buildServerConfig(getenv, s.isDynamic, s.secLogger)
+	aliases := make(map[string]DynamicAlias)
+	if s.isDynamic {
+		aliases = loadDynamicAliasesFromEnv(env, s.secLogger)
+	}
+	if err := validateDynamicAliases(aliases, s.devMode); err != nil {
+		s.secLogger.Printf("Config reload (%s) rejected, keeping previous configuration: %v", reason, err)
+		return err
+	}
+
+	classicChanged := false
+	for _, key := range classicConnectionKeys {
+		if os.Getenv(key) != env[key] {
+			classicChanged = true
+			break
+		}
+	}
+
+	// Candidate is valid: apply it to the process environment...
+	for key := range s.envFileValues {
+		if _, still := applied[key]; !still && os.Getenv(key) == s.envFileValues[key] {
+			_ = os.Unsetenv(key)
+		}
+	}
+	for key, val := range applied {
+		_ = os.Setenv(key, val)
+	}
+	s.envFileValues = applied
+
+	toolsBefore := toolSurfaceSignature(s.listTools())
+
+	// ...and to the server, atomically with respect to tool calls.
+	var closed []string
+	s.dynamicMu.Lock()
+	oldAliases := s.dynamicAliases
+	s.config = cfg
+	s.dynamicAliases = aliases
+	for name, oldAlias := range oldAliases {
+		newAlias, stillThere := aliases[name]
+		if stillThere && !aliasConnectionChanged(oldAlias, newAlias) {
+			continue
+		}
+		if conn, ok := s.connections[name]; ok && conn != nil {
+			s.rollbackTransactionOn("its alias was changed by a configuration reload", name)
+			_ = conn.Close()
+			delete(s.connections, name)
+			closed = append(closed, name)
+		}
+		if s.activeAlias == name {
+			s.activeAlias = ""
+			s.dbMu.Lock()
+			s.db = nil
+			s.dbMu.Unlock()
+		}
+	}
+	s.dynamicMu.Unlock()
+
+	// A pending confirmation was issued under the old policy; never let it
+	// authorise an operation under the new one.
+	s.confirmMu.Lock()
+	s.pendingConfirmation = nil
+	s.confirmMu.Unlock()
+	// Likewise an open transaction on an alias that is no longer writable.
+	if tx := s.currentTransaction(); tx != nil && s.getEffectiveConfigFor(tx.alias).readOnly {
+		s.rollbackTransactionOn("its alias is no longer writable after a configuration reload", tx.alias)
+	}
+
+	sort.Strings(closed)
+	s.secLogger.Printf("Configuration reloaded (%s): readOnly=%v, whitelistTables=%d, aliases=%d, closedConnections=%v",
+		reason, cfg.readOnly, len(cfg.whitelistTables), len(aliases), closed)
+	if classicChanged && !s.isDynamic {
+		// Drop the classic pool and wake the connection supervisor, which
+		// reopens it with the new settings right away.
+		s.dbMu.Lock()
+		old := s.db
+		s.db = nil
+		s.dbMu.Unlock()
+		if old != nil {
+			_ = old.Close()
+		}
+		s.recordConnState(classicTarget, connStateDown, nil)
+		s.secLogger.Printf("Classic connection settings changed in .env; pool closed and being reopened by the connection supervisor")
+		s.kickSupervisor()
+	}
+
+	if toolSurfaceSignature(s.listTools()) != toolsBefore {
+		s.sendNotification("notifications/tools/list_changed", nil)
+	}
+	// The procedure whitelist or MSSQL_PROCEDURE_TOOLS may have changed.
+	s.kickProcedureTools()
+	return nil
+}
+
+// configWatchInterval returns the .env polling interval, or 0 when polling is
+// disabled with MSSQL_CONFIG_WATCH=false.
+func configWatchInterval() time.Duration {
+	if v := strings.ToLower(strings.TrimSpace(os.Getenv("MSSQL_CONFIG_WATCH"))); v == "false" || v == "0" || v == "no" || v == "off" {
+		return 0
+	}
+	if v := os.Getenv("MSSQL_CONFIG_WATCH_INTERVAL"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+			return time.Duration(secs) * time.Second
+		}
+	}
+	return defaultConfigWatchInterval
+}
+
+// fileStamp identifies a version of a file for change detection.
+type fileStamp struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+func statFileStamp(path string) fileStamp {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{exists: true, size: fi.Size(), modTime: fi.ModTime()}
+}
+
+// startConfigReload launches the .env watcher (polling, no extra dependency)
+// and the SIGHUP handler. Both stop when ctx is cancelled.
+func (s *MCPMSSQLServer) startConfigReload(ctx context.Context, wg *sync.WaitGroup) {
+	if interval := configWatchInterval(); interval > 0 && s.envFilePath != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.watchConfigFile(ctx, interval)
+		}()
+	}
+
+	if sigs := reloadSignals(); len(sigs) > 0 {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, sigs...)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer signal.Stop(ch)
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case sig := <-ch:
+					_ = s.reloadConfig("signal " + sig.String())
+				}
+			}
+		}()
+	}
+}
+
+// watchConfigFile polls envFilePath and triggers reloadConfig on change.
+func (s *MCPMSSQLServer) watchConfigFile(ctx context.Context, interval time.Duration) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.secLogger.Printf("Recovered panic in config watcher: %v (hot reload disabled)", r)
+		}
+	}()
+
+	last := statFileStamp(s.envFilePath)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cur := statFileStamp(s.envFilePath)
+			if cur != last {
+				last = cur
+				_ = s.reloadConfig("file change")
+			}
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// reloadSignals returns the signals that trigger a configuration reload.
+func reloadSignals() []os.Signal {
+	return []os.Signal{syscall.SIGHUP}
+}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// reloadSignals returns nil on Windows, which has no SIGHUP; hot reload there
+// relies on the .env file watcher only.
+func reloadSignals() []os.Signal {
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// validSandboxFileName restricts file names accepted from tool arguments to
+// a single path element: no separators, no "..", no drive letters.
+var validSandboxFileName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+// sandboxDir returns the directory configured by envKey, or a directory
+// named defaultName next to the executable. Tools that read or write local
+// files are confined to such a directory.
+func sandboxDir(envKey, defaultName string) (string, error) {
+	if dir := strings.TrimSpace(os.Getenv(envKey)); dir != "" {
+		return filepath.Abs(dir)
+	}
+	exePath, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("cannot determine executable location; set %s", envKey)
+	}
+	return filepath.Join(filepath.Dir(exePath), defaultName), nil
+}
+
+// sandboxFile resolves a user-supplied file name inside dir, appending ext
+// when the name has no extension. Only plain file names are accepted.
+func sandboxFile(dir, name, ext string) (string, error) {
+	name = strings.TrimSpace(name)
+	if !validSandboxFileName.MatchString(name) || strings.Contains(name, "..") {
+		return "", fmt.Errorf("invalid file name '%s': use a plain name such as 'prod-2026-01.json' (no directories)", name)
+	}
+	if ext != "" && filepath.Ext(name) == "" {
+		name += ext
+	}
+	return filepath.Join(dir, name), nil
+}
@@ -0,0 +1,588 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	schemaSnapshotFormatVersion = 1
+	schemaDiffTimeout           = 2 * time.Minute
+	maxSchemaDiffChanges        = 500
+	maxSnapshotFileSize         = 64 << 20
+)
+
+// schemaSnapshot is the catalog of one database as compared by schema_diff
+// and saved to / loaded from the snapshot directory. Map keys are
+// "schema.object" so the JSON form is stable and diffable by hand.
+type schemaSnapshot struct {
+	FormatVersion int                     `json:"format_version"`
+	Source        string                  `json:"source"`
+	SchemaFilter  string                  `json:"schema_filter,omitempty"`
+	TakenAt       time.Time               `json:"taken_at"`
+	Tables        map[string]*tableSchema `json:"tables"`
+	Views         map[string]string       `json:"views"`
+	Procedures    map[string]string       `json:"procedures"`
+}
+
+type tableSchema struct {
+	Columns     map[string]columnSchema     `json:"columns"`
+	Indexes     map[string]indexSchema      `json:"indexes,omitempty"`
+	ForeignKeys map[string]foreignKeySchema `json:"foreign_keys,omitempty"`
+}
+
+type columnSchema struct {
+	DataType string `json:"data_type"`
+	Nullable bool   `json:"nullable"`
+	Default  string `json:"default,omitempty"`
+	Position int    `json:"position"`
+}
+
+type indexSchema struct {
+	Type       string `json:"type"`
+	Unique     bool   `json:"unique"`
+	PrimaryKey bool   `json:"primary_key"`
+	Columns    string `json:"columns"`
+	Included   string `json:"included,omitempty"`
+}
+
+type foreignKeySchema struct {
+	Columns           string `json:"columns"`
+	ReferencedTable   string `json:"referenced_table"`
+	ReferencedColumns string `json:"referenced_columns"`
+	OnDelete          string `json:"on_delete"`
+	OnUpdate          string `json:"on_update"`
+}
+
+// schemaChange is one difference reported by schema_diff, relative to the
+// left side: "added" exists only on the right, "removed" only on the left.
+type schemaChange struct {
+	ObjectType string   `json:"object_type"`
+	Name       string   `json:"name"`
+	Change     string   `json:"change"`
+	Details    []string `json:"details,omitempty"`
+}
+
+type schemaDiffResult struct {
+	Left         string         `json:"left"`
+	Right        string         `json:"right"`
+	SchemaFilter string         `json:"schema_filter,omitempty"`
+	Summary      map[string]int `json:"summary"`
+	Changes      []schemaChange `json:"changes"`
+	Truncated    bool           `json:"truncated,omitempty"`
+}
+
+// Catalog queries. They return one row per column (no STRING_AGG) so they
+// also run on SQL Server 2008/2012. @p1 is an optional schema filter (empty = all).
+const (
+	schemaColumnsQuery = `
+		SELECT c.TABLE_SCHEMA, c.TABLE_NAME, c.COLUMN_NAME, c.DATA_TYPE,
+			c.CHARACTER_MAXIMUM_LENGTH, c.NUMERIC_PRECISION, c.NUMERIC_SCALE, c.DATETIME_PRECISION,
+			c.IS_NULLABLE, c.COLUMN_DEFAULT, c.ORDINAL_POSITION
+		FROM INFORMATION_SCHEMA.COLUMNS c
+		INNER JOIN INFORMATION_SCHEMA.TABLES t ON t.TABLE_SCHEMA = c.TABLE_SCHEMA AND t.TABLE_NAME = c.TABLE_NAME
+		WHERE t.TABLE_TYPE = 'BASE TABLE' AND (@p1 = '' OR c.TABLE_SCHEMA = @p1)
+		ORDER BY c.TABLE_SCHEMA, c.TABLE_NAME, c.ORDINAL_POSITION
+	`
+	schemaIndexesQuery = `
+		SELECT s.name, t.name, i.name, i.type_desc, i.is_unique, i.is_primary_key,
+			c.name, ic.is_descending_key, ic.is_included_column
+		FROM sys.indexes i
+		INNER JOIN sys.tables t ON i.object_id = t.object_id
+		INNER JOIN sys.schemas s ON t.schema_id = s.schema_id
+		INNER JOIN sys.index_columns ic ON ic.object_id = i.object_id AND ic.index_id = i.index_id
+		INNER JOIN sys.columns c ON c.object_id = ic.object_id AND c.column_id = ic.column_id
+		WHERE i.type > 0 AND t.is_ms_shipped = 0 AND (@p1 = '' OR s.name = @p1)
+		ORDER BY s.name, t.name, i.name, ic.is_included_column, ic.key_ordinal, c.name
+	`
+	schemaForeignKeysQuery = `
+		SELECT OBJECT_SCHEMA_NAME(fk.parent_object_id), OBJECT_NAME(fk.parent_object_id), fk.name,
+			COL_NAME(fkc.parent_object_id, fkc.parent_column_id),
+			OBJECT_SCHEMA_NAME(fk.referenced_object_id), OBJECT_NAME(fk.referenced_object_id),
+			COL_NAME(fkc.referenced_object_id, fkc.referenced_column_id),
+			fk.delete_referential_action_desc, fk.update_referential_action_desc
+		FROM sys.foreign_keys fk
+		INNER JOIN sys.foreign_key_columns fkc ON fk.object_id = fkc.constraint_object_id
+		WHERE (@p1 = '' OR OBJECT_SCHEMA_NAME(fk.parent_object_id) = @p1)
+		ORDER BY 1, 2, fk.name, fkc.constraint_column_id
+	`
+	schemaModulesQuery = `
+		SELECT SCHEMA_NAME(o.schema_id), o.name, o.type, m.definition
+		FROM sys.objects o
+		INNER JOIN sys.sql_modules m ON m.object_id = o.object_id
+		WHERE o.type IN ('V', 'P') AND o.is_ms_shipped = 0 AND (@p1 = '' OR SCHEMA_NAME(o.schema_id) = @p1)
+	`
+)
+
+// formatColumnType renders an INFORMATION_SCHEMA column type the way it is
+// written in DDL, e.g. nvarchar(50), nvarchar(max), decimal(18,2).
+func formatColumnType(dataType string, maxLen, precision, scale, dtPrecision sql.NullInt64) string {
+	switch strings.ToLower(dataType) {
+	case "char", "varchar", "nchar", "nvarchar", "binary", "varbinary":
+		if maxLen.Valid {
+			if maxLen.Int64 == -1 {
+				return dataType + "(max)"
+			}
+			return fmt.Sprintf("%s(%d)", dataType, maxLen.Int64)
+		}
+	case "decimal", "numeric":
+		if precision.Valid {
+			return fmt.Sprintf("%s(%d,%d)", dataType, precision.Int64, scale.Int64)
+		}
+	case "datetime2", "time", "datetimeoffset":
+		if dtPrecision.Valid {
+			return fmt.Sprintf("%s(%d)", dataType, dtPrecision.Int64)
+		}
+	}
+	return dataType
+}
+
+// scanCatalog runs a catalog query on target and calls scan for every row.
+func (s *MCPMSSQLServer) scanCatalog(ctx context.Context, target *queryTarget, query, schemaFilter string, scan func(*sql.Rows) error) error {
+	rows, closeRows, err := s.openSecureRowsOn(ctx, target, query, schemaFilter)
+	if err != nil {
+		return err
+	}
+	defer closeRows()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// captureSchema reads the catalog of target into a snapshot.
+func (s *MCPMSSQLServer) captureSchema(ctx context.Context, target *queryTarget, schemaFilter string) (*schemaSnapshot, error) {
+	snap, err := s.captureTableSchema(ctx, target, schemaFilter)
+	if err != nil {
+		return nil, err
+	}
+	err = s.scanCatalog(ctx, target, schemaModulesQuery, schemaFilter, func(rows *sql.Rows) error {
+		var schema, name, typ string
+		var def sql.NullString
+		if err := rows.Scan(&schema, &name, &typ, &def); err != nil {
+			return err
+		}
+		body := def.String
+		if !def.Valid {
+			body = "(encrypted)"
+		}
+		if strings.TrimSpace(typ) == "V" {
+			snap.Views[schema+"."+name] = body
+		} else {
+			snap.Procedures[schema+"."+name] = body
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading view and procedure definitions: %w", err)
+	}
+	return snap, nil
+}
+
+// captureTableSchema reads the tables of the catalog (columns, indexes and
+// foreign keys) into a snapshot, leaving views and procedures empty.
+func (s *MCPMSSQLServer) captureTableSchema(ctx context.Context, target *queryTarget, schemaFilter string) (*schemaSnapshot, error) {
+	source := target.alias
+	if source == "" {
+		source = classicTarget
+	}
+	snap := &schemaSnapshot{
+		FormatVersion: schemaSnapshotFormatVersion,
+		Source:        source,
+		SchemaFilter:  schemaFilter,
+		TakenAt:       time.Now().UTC(),
+		Tables:        map[string]*tableSchema{},
+		Views:         map[string]string{},
+		Procedures:    map[string]string{},
+	}
+	table := func(schema, name string) *tableSchema {
+		key := schema + "." + name
+		t, ok := snap.Tables[key]
+		if !ok {
+			t = &tableSchema{Columns: map[string]columnSchema{}}
+			snap.Tables[key] = t
+		}
+		return t
+	}
+
+	err := s.scanCatalog(ctx, target, schemaColumnsQuery, schemaFilter, func(rows *sql.Rows) error {
+		var schema, tbl, col, dataType, nullable string
+		var maxLen, precision, scale, dtPrecision sql.NullInt64
+		var def sql.NullString
+		var pos int
+		if err := rows.Scan(&schema, &tbl, &col, &dataType, &maxLen, &precision, &scale, &dtPrecision, &nullable, &def, &pos); err != nil {
+			return err
+		}
+		table(schema, tbl).Columns[col] = columnSchema{
+			DataType: formatColumnType(dataType, maxLen, precision, scale, dtPrecision),
+			Nullable: strings.EqualFold(nullable, "YES"),
+			Default:  def.String,
+			Position: pos,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading columns: %w", err)
+	}
+
+	err = s.scanCatalog(ctx, target, schemaIndexesQuery, schemaFilter, func(rows *sql.Rows) error {
+		var schema, tbl, idx, typ, col string
+		var unique, pk, desc, included bool
+		if err := rows.Scan(&schema, &tbl, &idx, &typ, &unique, &pk, &col, &desc, &included); err != nil {
+			return err
+		}
+		t := table(schema, tbl)
+		if t.Indexes == nil {
+			t.Indexes = map[string]indexSchema{}
+		}
+		ix := t.Indexes[idx]
+		ix.Type, ix.Unique, ix.PrimaryKey = typ, unique, pk
+		switch {
+		case included:
+			ix.Included = appendList(ix.Included, col)
+		case desc:
+			ix.Columns = appendList(ix.Columns, col+" DESC")
+		default:
+			ix.Columns = appendList(ix.Columns, col)
+		}
+		t.Indexes[idx] = ix
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading indexes: %w", err)
+	}
+
+	err = s.scanCatalog(ctx, target, schemaForeignKeysQuery, schemaFilter, func(rows *sql.Rows) error {
+		var schema, tbl, name, col, refSchema, refTable, refCol, onDelete, onUpdate string
+		if err := rows.Scan(&schema, &tbl, &name, &col, &refSchema, &refTable, &refCol, &onDelete, &onUpdate); err != nil {
+			return err
+		}
+		t := table(schema, tbl)
+		if t.ForeignKeys == nil {
+			t.ForeignKeys = map[string]foreignKeySchema{}
+		}
+		fk := t.ForeignKeys[name]
+		fk.Columns = appendList(fk.Columns, col)
+		fk.ReferencedTable = refSchema + "." + refTable
+		fk.ReferencedColumns = appendList(fk.ReferencedColumns, refCol)
+		fk.OnDelete, fk.OnUpdate = onDelete, onUpdate
+		t.ForeignKeys[name] = fk
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading foreign keys: %w", err)
+	}
+	return snap, nil
+}
+
+func appendList(list, item string) string {
+	if list == "" {
+		return item
+	}
+	return list + ", " + item
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// diffKeyed reports added/removed entries of two maps and calls changed for
+// keys present on both sides.
+func diffKeyed[V any](objectType, prefix string, left, right map[string]V, out *[]schemaChange, changed func(name string, l, r V) []string) {
+	for _, k := range sortedKeys(left) {
+		if _, ok := right[k]; !ok {
+			*out = append(*out, schemaChange{ObjectType: objectType, Name: prefix + k, Change: "removed"})
+		}
+	}
+	for _, k := range sortedKeys(right) {
+		l, ok := left[k]
+		if !ok {
+			*out = append(*out, schemaChange{ObjectType: objectType, Name: prefix + k, Change: "added"})
+			continue
+		}
+		if details := changed(k, l, right[k]); len(details) > 0 {
+			*out = append(*out, schemaChange{ObjectType: objectType, Name: prefix + k, Change: "changed", Details: details})
+		}
+	}
+}
+
+func fieldChange(details []string, field, l, r string) []string {
+	if l == r {
+		return details
+	}
+	if l == "" {
+		l = "(none)"
+	}
+	if r == "" {
+		r = "(none)"
+	}
+	return append(details, fmt.Sprintf("%s: %s -> %s", field, l, r))
+}
+
+// normalizeDefinition removes differences that do not change a module:
+// line endings and trailing whitespace.
+func normalizeDefinition(def string) []string {
+	lines := strings.Split(strings.ReplaceAll(def, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	return lines
+}
+
+func truncateForDiff(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) > 120 {
+		return s[:117] + "..."
+	}
+	return s
+}
+
+// definitionChange returns a one-line description of the first differing
+// line of two module definitions, or nil when they are equivalent.
+func definitionChange(l, r string) []string {
+	left, right := normalizeDefinition(l), normalizeDefinition(r)
+	n := len(left)
+	if len(right) > n {
+		n = len(right)
+	}
+	for i := 0; i < n; i++ {
+		var a, b string
+		if i < len(left) {
+			a = left[i]
+		}
+		if i < len(right) {
+			b = right[i]
+		}
+		if a != b {
+			return []string{fmt.Sprintf("definition differs from line %d: %q -> %q (lines: %d -> %d)",
+				i+1, truncateForDiff(a), truncateForDiff(b), len(left), len(right))}
+		}
+	}
+	return nil
+}
+
+// diffSchemas compares two snapshots. Changes are ordered tables first
+// (with their columns, indexes and foreign keys), then views and procedures.
+func diffSchemas(left, right *schemaSnapshot) []schemaChange {
+	changes := []schemaChange{}
+	var tableChanges []schemaChange
+	diffKeyed("table", "", left.Tables, right.Tables, &tableChanges, func(name string, l, r *tableSchema) []string {
+		diffKeyed("column", name+".", l.Columns, r.Columns, &changes, func(_ string, lc, rc columnSchema) []string {
+			var d []string
+			d = fieldChange(d, "type", lc.DataType, rc.DataType)
+			d = fieldChange(d, "nullable", fmt.Sprint(lc.Nullable), fmt.Sprint(rc.Nullable))
+			d = fieldChange(d, "default", lc.Default, rc.Default)
+			return d
+		})
+		diffKeyed("index", name+".", l.Indexes, r.Indexes, &changes, func(_ string, li, ri indexSchema) []string {
+			var d []string
+			d = fieldChange(d, "type", li.Type, ri.Type)
+			d = fieldChange(d, "unique", fmt.Sprint(li.Unique), fmt.Sprint(ri.Unique))
+			d = fieldChange(d, "primary_key", fmt.Sprint(li.PrimaryKey), fmt.Sprint(ri.PrimaryKey))
+			d = fieldChange(d, "columns", li.Columns, ri.Columns)
+			d = fieldChange(d, "included", li.Included, ri.Included)
+			return d
+		})
+		diffKeyed("foreign_key", name+".", l.ForeignKeys, r.ForeignKeys, &changes, func(_ string, lf, rf foreignKeySchema) []string {
+			var d []string
+			d = fieldChange(d, "columns", lf.Columns, rf.Columns)
+			d = fieldChange(d, "references", lf.ReferencedTable+"("+lf.ReferencedColumns+")", rf.ReferencedTable+"("+rf.ReferencedColumns+")")
+			d = fieldChange(d, "on_delete", lf.OnDelete, rf.OnDelete)
+			d = fieldChange(d, "on_update", lf.OnUpdate, rf.OnUpdate)
+			return d
+		})
+		return nil // table-level entries only for added/removed tables
+	})
+	changes = append(tableChanges, changes...)
+	diffKeyed("view", "", left.Views, right.Views, &changes, func(_ string, l, r string) []string { return definitionChange(l, r) })
+	diffKeyed("procedure", "", left.Procedures, right.Procedures, &changes, func(_ string, l, r string) []string { return definitionChange(l, r) })
+	return changes
+}
+
+func loadSchemaSnapshot(path string) (*schemaSnapshot, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot not found: %s", path)
+	}
+	if fi.Size() > maxSnapshotFileSize {
+		return nil, fmt.Errorf("snapshot too large (%d bytes)", fi.Size())
+	}
+	// #nosec G304 -- path is confined to the snapshot directory by sandboxFile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snap schemaSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("invalid snapshot file: %w", err)
+	}
+	if snap.FormatVersion != schemaSnapshotFormatVersion {
+		return nil, fmt.Errorf("unsupported snapshot format version %d", snap.FormatVersion)
+	}
+	return &snap, nil
+}
+
+func saveSchemaSnapshot(path string, snap *schemaSnapshot) error {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// schemaDiff implements the schema_diff tool. The left side is always a live
+// connection (left_alias, or the active connection); the right side is
+// another alias or a saved snapshot. With save_snapshot the left catalog is
+// also written to the snapshot directory.
+func (s *MCPMSSQLServer) schemaDiff(ctx context.Context, args map[string]interface{}) (string, error) {
+	leftAlias, _ := args["left_alias"].(string)
+	rightAlias, _ := args["right_alias"].(string)
+	snapshotName, _ := args["snapshot"].(string)
+	saveName, _ := args["save_snapshot"].(string)
+	schemaFilter, _ := args["schema"].(string)
+	rightAlias, snapshotName, saveName, schemaFilter = strings.TrimSpace(rightAlias), strings.TrimSpace(snapshotName), strings.TrimSpace(saveName), strings.TrimSpace(schemaFilter)
+
+	if rightAlias != "" && snapshotName != "" {
+		return "", fmt.Errorf("provide either 'right_alias' or 'snapshot', not both")
+	}
+	if rightAlias == "" && snapshotName == "" && saveName == "" {
+		return "", fmt.Errorf("provide 'right_alias' or 'snapshot' to compare against, or 'save_snapshot' to save the current schema")
+	}
+	if schemaFilter != "" && !validIdentifierPattern.MatchString(schemaFilter) {
+		return "", fmt.Errorf("invalid schema name '%s'", schemaFilter)
+	}
+
+	var snapDir string
+	if snapshotName != "" || saveName != "" {
+		dir, err := sandboxDir("MSSQL_SNAPSHOT_DIR", "snapshots")
+		if err != nil {
+			return "", err
+		}
+		snapDir = dir
+	}
+
+	left, err := s.resolveTarget(map[string]interface{}{"alias": leftAlias})
+	if err != nil {
+		return "", fmt.Errorf("left side: %w", err)
+	}
+	left.tool = "schema_diff"
+	var right *schemaSnapshot
+	if snapshotName != "" {
+		path, err := sandboxFile(snapDir, snapshotName, ".json")
+		if err != nil {
+			return "", err
+		}
+		if right, err = loadSchemaSnapshot(path); err != nil {
+			return "", err
+		}
+		right.Source = "snapshot " + snapshotName + " (" + right.Source + ", " + right.TakenAt.Format(time.RFC3339) + ")"
+	}
+
+	leftSnap, err := s.captureSchema(ctx, left, schemaFilter)
+	if err != nil {
+		return "", fmt.Errorf("left side: %w", err)
+	}
+
+	var out strings.Builder
+	if saveName != "" {
+		path, err := sandboxFile(snapDir, saveName, ".json")
+		if err != nil {
+			return "", err
+		}
+		if err := saveSchemaSnapshot(path, leftSnap); err != nil {
+			return "", fmt.Errorf("saving snapshot: %w", err)
+		}
+		s.secLogger.Printf("schema_diff: saved snapshot of %s to %s", leftSnap.Source, path)
+		fmt.Fprintf(&out, "Saved schema snapshot of %s as '%s' (%d tables, %d views, %d procedures).\n",
+			leftSnap.Source, saveName, len(leftSnap.Tables), len(leftSnap.Views), len(leftSnap.Procedures))
+	}
+
+	if rightAlias != "" {
+		target, err := s.resolveTarget(map[string]interface{}{"alias": rightAlias})
+		if err != nil {
+			return "", fmt.Errorf("right side: %w", err)
+		}
+		target.tool = "schema_diff"
+		if right, err = s.captureSchema(ctx, target, schemaFilter); err != nil {
+			return "", fmt.Errorf("right side: %w", err)
+		}
+	}
+	if right == nil {
+		return out.String(), nil
+	}
+
+	changes := diffSchemas(leftSnap, right)
+	result := schemaDiffResult{
+		Left:         leftSnap.Source,
+		Right:        right.Source,
+		SchemaFilter: schemaFilter,
+		Summary:      map[string]int{"added": 0, "removed": 0, "changed": 0},
+	}
+	for _, c := range changes {
+		result.Summary[c.Change]++
+	}
+	if len(changes) > maxSchemaDiffChanges {
+		changes = changes[:maxSchemaDiffChanges]
+		result.Truncated = true
+	}
+	result.Changes = changes
+
+	resultBytes, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if len(result.Changes) == 0 {
+		fmt.Fprintf(&out, "No schema differences between %s and %s.\n", result.Left, result.Right)
+		return out.String(), nil
+	}
+	fmt.Fprintf(&out, "Schema differences (%s -> %s):\n%s", result.Left, result.Right, string(resultBytes))
+	return out.String(), nil
+}
+
+// handleSchemaDiff is the tools/call entry point of the schema_diff tool.
+func (s *MCPMSSQLServer) handleSchemaDiff(id interface{}, args map[string]interface{}) *MCPResponse {
+	ctx, cancel := context.WithTimeout(context.Background(), schemaDiffTimeout)
+	defer cancel()
+
+	text, err := s.schemaDiff(ctx, args)
+	if err != nil {
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result: CallToolResult{
+				Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Schema Diff Error: %v", err)}},
+				IsError: true,
+			},
+		}
+	}
+	return &MCPResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Result: CallToolResult{
+			Content: []ContentItem{{Type: "text", Text: text}},
+		},
+	}
+}
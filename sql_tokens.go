@@ -0,0 +1,209 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// sqlToken is one token of a T-SQL batch. Comments and whitespace are
+// skipped; string literals, bracketed and quoted identifiers are single
+// tokens, so keywords inside them are never matched.
+type sqlToken struct {
+	text  string // as written
+	upper string // upper-cased text, for keyword comparisons
+	start int    // byte offsets of the token in the batch
+	end   int
+	depth int // parenthesis nesting level the token is at
+}
+
+// isName reports whether the token can be part of an object name.
+func (t sqlToken) isName() bool {
+	if t.text == "" {
+		return false
+	}
+	c := t.text[0]
+	return c == '[' || c == '"' || c == '_' || c == '#' || c >= 0x80 ||
+		(c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+}
+
+func isSQLWordByte(c byte) bool {
+	return c == '_' || c == '@' || c == '#' || c == '$' || c >= 0x80 ||
+		(c >= '0' && c <= '9') || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+}
+
+// closeDelimited returns the offset just past the delimiter that closes the
+// literal or identifier opened at q[i], where a doubled delimiter escapes it.
+func closeDelimited(q string, i int, closing byte) int {
+	for j := i + 1; j < len(q); j++ {
+		if q[j] == closing {
+			if j+1 < len(q) && q[j+1] == closing {
+				j++
+				continue
+			}
+			return j + 1
+		}
+	}
+	return len(q)
+}
+
+// tokenizeSQL splits a batch into tokens.
+func tokenizeSQL(q string) []sqlToken {
+	var toks []sqlToken
+	depth := 0
+	for i := 0; i < len(q); {
+		c := q[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+			continue
+		case c == '-' && i+1 < len(q) && q[i+1] == '-':
+			if nl := strings.IndexByte(q[i:], '\n'); nl != -1 {
+				i += nl + 1
+			} else {
+				i = len(q)
+			}
+			continue
+		case c == '/' && i+1 < len(q) && q[i+1] == '*':
+			// Block comments nest in T-SQL.
+			nested := 0
+			for i < len(q) {
+				if strings.HasPrefix(q[i:], "/*") {
+					nested++
+					i += 2
+				} else if strings.HasPrefix(q[i:], "*/") {
+					nested--
+					i += 2
+					if nested == 0 {
+						break
+					}
+				} else {
+					i++
+				}
+			}
+			continue
+		case c == '\'':
+			i = closeDelimited(q, i, '\'')
+		case (c == 'N' || c == 'n') && i+1 < len(q) && q[i+1] == '\'':
+			i = closeDelimited(q, i+1, '\'')
+		case c == '[':
+			i = closeDelimited(q, i, ']')
+		case c == '"':
+			i = closeDelimited(q, i, '"')
+		case isSQLWordByte(c):
+			for i < len(q) && isSQLWordByte(q[i]) {
+				i++
+			}
+		case c == ')':
+			if depth > 0 {
+				depth--
+			}
+			i++
+		default:
+			i++
+		}
+		text := q[start:i]
+		toks = append(toks, sqlToken{text: text, upper: strings.ToUpper(text), start: start, end: i, depth: depth})
+		if c == '(' {
+			depth++
+		}
+	}
+	return toks
+}
+
+// statementKeywords are the reserved words that can only start a statement:
+// met at nesting level 0 where the current statement cannot continue with
+// them, they start the next one even without a semicolon.
+var statementKeywords = map[string]bool{
+	"SELECT": true, "INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true,
+	"SET": true, "DECLARE": true, "EXEC": true, "EXECUTE": true, "TRUNCATE": true,
+	"DROP": true, "CREATE": true, "ALTER": true, "BEGIN": true, "COMMIT": true,
+	"ROLLBACK": true, "SAVE": true, "GRANT": true, "REVOKE": true, "DENY": true,
+	"USE": true, "IF": true, "WHILE": true, "PRINT": true, "RAISERROR": true,
+	"RETURN": true, "KILL": true, "BACKUP": true, "RESTORE": true, "DBCC": true,
+	"BULK": true, "WAITFOR": true, "GOTO": true, "OPEN": true, "FETCH": true,
+	"CLOSE": true, "DEALLOCATE": true, "RECONFIGURE": true, "SHUTDOWN": true,
+	"CHECKPOINT": true,
+}
+
+// continuesStatement reports whether a statement keyword met at nesting
+// level 0 belongs to the current statement: the SELECT of INSERT ... SELECT
+// and of UNION/EXCEPT/INTERSECT branches, the SET of UPDATE, the WHEN
+// clauses of MERGE.
+func (st *sqlStatement) continuesStatement(keyword string) bool {
+	prev := st.tokens[len(st.tokens)-1].upper
+	switch {
+	case keyword == "SELECT" && (prev == "UNION" || prev == "ALL" || prev == "EXCEPT" || prev == "INTERSECT"):
+		return true
+	case st.keyword == "INSERT" && keyword == "SELECT":
+		return !st.hasTopLevel("SELECT", "VALUES")
+	case st.keyword == "UPDATE" && keyword == "SET":
+		return !st.hasTopLevel("SET")
+	case st.keyword == "MERGE":
+		return keyword == "INSERT" || keyword == "UPDATE" || keyword == "DELETE" || keyword == "SET"
+	}
+	return false
+}
+
+// hasTopLevel reports whether the statement already has one of the
+// keywords at nesting level 0, past its leading token.
+func (st *sqlStatement) hasTopLevel(keywords ...string) bool {
+	for _, t := range st.tokens[1:] {
+		if t.depth == 0 && slices.Contains(keywords, t.upper) {
+			return true
+		}
+	}
+	return false
+}
+
+// cteStatements are the statements a common table expression can lead to.
+var cteStatements = map[string]bool{"SELECT": true, "INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true}
+
+// sqlStatement is one statement of a batch.
+type sqlStatement struct {
+	// keyword is the statement's upper-cased leading token; for a statement
+	// introduced by WITH it is the statement the CTEs lead to.
+	keyword string
+	tokens  []sqlToken
+}
+
+// splitStatements splits a batch into statements, at semicolons and at the
+// statement keywords that start a new one. T-SQL does not require the
+// semicolon, so "SET NOCOUNT ON DELETE FROM t" is two statements.
+func splitStatements(q string) []sqlStatement {
+	var stmts []sqlStatement
+	var cur *sqlStatement
+	for _, t := range tokenizeSQL(q) {
+		if t.depth == 0 && t.text == ";" {
+			cur = nil
+			continue
+		}
+		if cur != nil && t.depth == 0 && statementKeywords[t.upper] {
+			switch {
+			case cur.keyword == "WITH" && cteStatements[t.upper]:
+				cur.keyword = t.upper
+			case cur.continuesStatement(t.upper):
+			default:
+				cur = nil
+			}
+		}
+		if cur == nil {
+			stmts = append(stmts, sqlStatement{keyword: t.upper})
+			cur = &stmts[len(stmts)-1]
+		}
+		cur.tokens = append(cur.tokens, t)
+	}
+	return stmts
+}
+
+// callsDynamicSQL reports whether the statement runs EXEC or sp_executesql,
+// whose text the policy cannot see.
+func (st sqlStatement) callsDynamicSQL() bool {
+	for _, t := range st.tokens {
+		switch t.upper {
+		case "EXEC", "EXECUTE", "SP_EXECUTESQL":
+			return true
+		}
+	}
+	return false
+}
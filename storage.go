@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// tableStorageQuery lists tables and views with their approximate row count
+// and sp_spaceused-style sizes from sys.dm_db_partition_stats, plus data
+// compression and partitioning of the heap or clustered index. %s is the
+// optional name filter, %s the ORDER BY.
+const tableStorageQuery = `SELECT
+	t.TABLE_SCHEMA AS schema_name,
+	t.TABLE_NAME AS table_name,
+	t.TABLE_TYPE AS table_type,
+	sz.row_count,
+	sz.reserved_kb,
+	sz.data_kb,
+	sz.used_kb - sz.data_kb AS index_kb,
+	sz.reserved_kb - sz.used_kb AS unused_kb,
+	(SELECT STRING_AGG(c.data_compression_desc, ', ')
+		FROM (SELECT DISTINCT p.data_compression_desc
+			FROM sys.partitions p
+			WHERE p.object_id = o.object_id AND p.index_id IN (0, 1)) c) AS compression,
+	sz.partition_count,
+	(SELECT sch.name
+		FROM sys.indexes i
+		JOIN sys.partition_schemes sch ON sch.data_space_id = i.data_space_id
+		WHERE i.object_id = o.object_id AND i.index_id IN (0, 1)) AS partition_scheme
+FROM INFORMATION_SCHEMA.TABLES t
+CROSS APPLY (SELECT OBJECT_ID(QUOTENAME(t.TABLE_SCHEMA) + '.' + QUOTENAME(t.TABLE_NAME)) AS object_id) o
+LEFT JOIN (
+	SELECT ps.object_id,
+		SUM(CASE WHEN ps.index_id IN (0, 1) THEN ps.row_count ELSE 0 END) AS row_count,
+		SUM(ps.reserved_page_count) * 8 AS reserved_kb,
+		SUM(CASE WHEN ps.index_id IN (0, 1)
+			THEN ps.in_row_data_page_count + ps.lob_used_page_count + ps.row_overflow_used_page_count
+			ELSE 0 END) * 8 AS data_kb,
+		SUM(ps.used_page_count) * 8 AS used_kb,
+		COUNT(CASE WHEN ps.index_id IN (0, 1) THEN 1 END) AS partition_count
+	FROM sys.dm_db_partition_stats ps
+	GROUP BY ps.object_id
+) sz ON sz.object_id = o.object_id
+WHERE t.TABLE_TYPE IN ('BASE TABLE', 'VIEW')%s
+ORDER BY %s`
+
+// tableListQuery is the explore type=tables listing without storage
+// figures, for logins that cannot read sys.dm_db_partition_stats.
+const tableListQuery = `SELECT
+	TABLE_SCHEMA as schema_name,
+	TABLE_NAME as table_name,
+	TABLE_TYPE as table_type
+FROM INFORMATION_SCHEMA.TABLES t
+WHERE TABLE_TYPE IN ('BASE TABLE', 'VIEW')%s
+ORDER BY TABLE_SCHEMA, TABLE_NAME`
+
+// tableSortOrders maps explore's sort_by values to ORDER BY clauses.
+var tableSortOrders = map[string]string{
+	"name": "t.TABLE_SCHEMA, t.TABLE_NAME",
+	"size": "sz.reserved_kb DESC, t.TABLE_SCHEMA, t.TABLE_NAME",
+	"rows": "sz.row_count DESC, t.TABLE_SCHEMA, t.TABLE_NAME",
+}
+
+// partitionBoundary renders a partition_range_values value as text; dates
+// use ISO 8601 rather than the locale-dependent default conversion.
+func partitionBoundary(alias string) string {
+	return fmt.Sprintf(`CASE WHEN SQL_VARIANT_PROPERTY(%[1]s.value, 'BaseType') IN ('date', 'datetime', 'datetime2', 'smalldatetime', 'datetimeoffset')
+		THEN CONVERT(nvarchar(100), CAST(%[1]s.value AS datetime2), 126)
+		ELSE CAST(%[1]s.value AS nvarchar(100)) END`, alias)
+}
+
+// partitionStorageQuery breaks a table's storage down per index and
+// partition. For partitioned indexes the range is lower_boundary to
+// upper_boundary; range_type RIGHT means the lower boundary is inclusive,
+// LEFT that the upper one is.
+var partitionStorageQuery = `SELECT
+	i.name AS index_name,
+	i.type_desc AS index_type,
+	p.partition_number,
+	ps.row_count,
+	ps.reserved_page_count * 8 AS reserved_kb,
+	(ps.in_row_data_page_count + ps.lob_used_page_count + ps.row_overflow_used_page_count) * 8 AS data_kb,
+	ps.used_page_count * 8 AS used_kb,
+	p.data_compression_desc AS compression,
+	fg.name AS filegroup,
+	sch.name AS partition_scheme,
+	pf.name AS partition_function,
+	CASE WHEN pf.function_id IS NULL THEN NULL WHEN pf.boundary_value_on_right = 1 THEN 'RIGHT' ELSE 'LEFT' END AS range_type,
+	` + partitionBoundary("lo") + ` AS lower_boundary,
+	` + partitionBoundary("hi") + ` AS upper_boundary
+FROM sys.partitions p
+JOIN sys.indexes i ON i.object_id = p.object_id AND i.index_id = p.index_id
+JOIN sys.dm_db_partition_stats ps ON ps.partition_id = p.partition_id
+LEFT JOIN sys.partition_schemes sch ON sch.data_space_id = i.data_space_id
+LEFT JOIN sys.partition_functions pf ON pf.function_id = sch.function_id
+LEFT JOIN sys.partition_range_values lo ON lo.function_id = pf.function_id AND lo.boundary_id = p.partition_number - 1
+LEFT JOIN sys.partition_range_values hi ON hi.function_id = pf.function_id AND hi.boundary_id = p.partition_number
+LEFT JOIN sys.destination_data_spaces dds ON dds.partition_scheme_id = sch.data_space_id AND dds.destination_id = p.partition_number
+LEFT JOIN sys.filegroups fg ON fg.data_space_id = COALESCE(dds.data_space_id, i.data_space_id)
+WHERE p.object_id = OBJECT_ID(QUOTENAME(@p1) + '.' + QUOTENAME(@p2))
+ORDER BY p.index_id, p.partition_number`
+
+// exploreTables implements explore type=tables. Row counts and sizes come
+// from sys.dm_db_partition_stats (VIEW DATABASE STATE); without it the
+// plain listing is returned with a note saying why.
+func (s *MCPMSSQLServer) exploreTables(ctx context.Context, target *queryTarget, filter, sortBy string) ([]map[string]interface{}, string, error) {
+	sortBy = strings.ToLower(strings.TrimSpace(sortBy))
+	if sortBy == "" {
+		sortBy = "name"
+	}
+	order, ok := tableSortOrders[sortBy]
+	if !ok {
+		return nil, "", fmt.Errorf("invalid sort_by '%s' (use name, size or rows)", sortBy)
+	}
+	where := ""
+	var args []interface{}
+	if filter != "" {
+		where = "\n\tAND t.TABLE_NAME LIKE @p1"
+		args = append(args, "%"+filter+"%")
+	}
+
+	results, err := s.executeSecureQueryOn(ctx, target, fmt.Sprintf(tableStorageQuery, where, order), args...)
+	if err == nil {
+		return results, "row counts and sizes are approximate, from sys.dm_db_partition_stats", nil
+	}
+	note := "row counts and sizes unavailable: reading sys.dm_db_partition_stats needs VIEW DATABASE STATE"
+	if s.devMode {
+		note = fmt.Sprintf("row counts and sizes unavailable: %v", err)
+	}
+	results, err = s.executeSecureQueryOn(ctx, target, fmt.Sprintf(tableListQuery, where), args...)
+	return results, note, err
+}
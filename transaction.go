@@ -0,0 +1,534 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultTransactionIdle is how long an explicit transaction may stay
+	// unused before it is rolled back (MSSQL_TRANSACTION_IDLE_TIMEOUT).
+	defaultTransactionIdle = 120 * time.Second
+	transactionOpTimeout   = 15 * time.Second
+)
+
+// transactionControl returns the transaction control statement of a batch
+// that would end or nest the pinned transaction behind the server's back
+// (and around the commit confirmation): COMMIT, ROLLBACK, SAVE TRAN,
+// BEGIN [DISTRIBUTED] TRAN or SET IMPLICIT_TRANSACTIONS. Literals and
+// comments are skipped by the tokenizer. It returns "" when there is none.
+func transactionControl(query string) string {
+	toks := tokenizeSQL(query)
+	next := func(i int) string {
+		if i+1 < len(toks) {
+			return toks[i+1].upper
+		}
+		return ""
+	}
+	isTran := func(word string) bool { return word == "TRAN" || word == "TRANSACTION" }
+	for i, t := range toks {
+		switch t.upper {
+		case "COMMIT", "ROLLBACK", "IMPLICIT_TRANSACTIONS":
+			return t.upper
+		case "SAVE":
+			if isTran(next(i)) {
+				return "SAVE " + next(i)
+			}
+		case "BEGIN":
+			if isTran(next(i)) {
+				return "BEGIN " + next(i)
+			}
+			if next(i) == "DISTRIBUTED" && isTran(next(i+1)) {
+				return "BEGIN DISTRIBUTED " + next(i+1)
+			}
+		}
+	}
+	return ""
+}
+
+// transactionIsolationLevels are the isolation levels begin_transaction
+// accepts.
+var transactionIsolationLevels = map[string]sql.IsolationLevel{
+	"read_committed":  sql.LevelReadCommitted,
+	"repeatable_read": sql.LevelRepeatableRead,
+	"serializable":    sql.LevelSerializable,
+	"snapshot":        sql.LevelSnapshot,
+}
+
+// txStatement is one statement run inside an explicit transaction, kept for
+// the summary shown before commit.
+type txStatement struct {
+	Operation string `json:"operation"`
+	Query     string `json:"query"`
+	Params    int    `json:"params,omitempty"`
+	Rows      int    `json:"rows_returned"`
+	Error     string `json:"error,omitempty"`
+}
+
+// pinnedTx is an explicit transaction opened by begin_transaction. It owns
+// a connection taken out of the alias pool until commit or rollback.
+// mu serializes the statements run in it with its end.
+type pinnedTx struct {
+	mu         sync.Mutex
+	id         int
+	alias      string
+	isolation  string
+	conn       *sql.Conn
+	sqlTx      *sql.Tx
+	cancel     context.CancelFunc
+	started    time.Time
+	lastUsed   time.Time
+	idle       time.Duration
+	timer      *time.Timer
+	statements []txStatement
+	done       bool
+	// undo holds the undo journal entries of the statements run in the
+	// transaction; they are written to journal when it commits.
+	undo    []*undoEntry
+	journal *undoJournal
+}
+
+// summary lists every statement run in the transaction.
+func (tx *pinnedTx) summary() string {
+	if len(tx.statements) == 0 {
+		return "(no statements)"
+	}
+	var sb strings.Builder
+	for i, st := range tx.statements {
+		fmt.Fprintf(&sb, "%d. %s", i+1, st.Query)
+		if st.Params > 0 {
+			fmt.Fprintf(&sb, " [%d params]", st.Params)
+		}
+		if st.Error != "" {
+			sb.WriteString(" -> failed")
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// confirmationKey is what confirm_operation must name to allow the commit.
+func (tx *pinnedTx) confirmationKey() string {
+	return fmt.Sprintf("transaction %d", tx.id)
+}
+
+// currentTransaction returns the open explicit transaction, if any.
+func (s *MCPMSSQLServer) currentTransaction() *pinnedTx {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+	return s.tx
+}
+
+// transactionFor returns the open transaction when target is its alias:
+// query_database calls on that alias run inside it.
+func (s *MCPMSSQLServer) transactionFor(target *queryTarget) *pinnedTx {
+	tx := s.currentTransaction()
+	if tx == nil || target.alias == "" || tx.alias != target.alias {
+		return nil
+	}
+	return tx
+}
+
+// endTransaction commits or rolls back tx and returns its connection to the
+// pool. The caller holds tx.mu.
+func (s *MCPMSSQLServer) endTransaction(tx *pinnedTx, commit bool, reason string) error {
+	if tx.done {
+		return fmt.Errorf("transaction %d already ended", tx.id)
+	}
+	tx.done = true
+	tx.timer.Stop()
+	var err error
+	if commit {
+		err = s.saveTransactionUndo(tx)
+		if err == nil {
+			err = tx.sqlTx.Commit()
+		} else {
+			_ = tx.sqlTx.Rollback()
+		}
+		if err != nil {
+			for _, e := range tx.undo {
+				tx.journal.discard(e)
+			}
+		}
+	} else {
+		err = tx.sqlTx.Rollback()
+	}
+	tx.cancel()
+	_ = tx.conn.Close()
+
+	s.txMu.Lock()
+	if s.tx == tx {
+		s.tx = nil
+	}
+	if !commit && reason != "" {
+		s.txEndNote = fmt.Sprintf("Transaction %d on alias '%s' was rolled back: %s.", tx.id, tx.alias, reason)
+	}
+	s.txMu.Unlock()
+
+	outcome := "rolled back"
+	if commit {
+		outcome = "committed"
+	}
+	if reason != "" {
+		outcome += " (" + reason + ")"
+	}
+	if err != nil {
+		s.secLogger.Printf("Transaction %d on alias '%s' failed to end: %s: %v", tx.id, tx.alias, outcome, err)
+	} else {
+		s.secLogger.Printf("Transaction %d on alias '%s' %s after %d statement(s)", tx.id, tx.alias, outcome, len(tx.statements))
+	}
+	return err
+}
+
+// saveTransactionUndo writes the undo journal entries of tx before it
+// commits.
+func (s *MCPMSSQLServer) saveTransactionUndo(tx *pinnedTx) error {
+	if len(tx.undo) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), transactionOpTimeout)
+	defer cancel()
+	for _, e := range tx.undo {
+		if err := tx.journal.save(ctx, tx.sqlTx, e); err != nil {
+			return fmt.Errorf("the undo journal could not be written: %v", err)
+		}
+	}
+	return nil
+}
+
+// expireTransaction rolls tx back once it has been idle for its timeout.
+// A statement that ran while the timer fired re-arms it instead.
+func (s *MCPMSSQLServer) expireTransaction(tx *pinnedTx) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.done {
+		return
+	}
+	if left := tx.idle - time.Since(tx.lastUsed); left > 0 {
+		tx.timer.Reset(left)
+		return
+	}
+	_ = s.endTransaction(tx, false, fmt.Sprintf("idle for more than %s", tx.idle))
+}
+
+// rollbackTransactionOn rolls back the open transaction when it runs on
+// one of aliases (all aliases when none is given): its pool is about to be
+// closed, or the server is shutting down.
+func (s *MCPMSSQLServer) rollbackTransactionOn(reason string, aliases ...string) {
+	tx := s.currentTransaction()
+	if tx == nil {
+		return
+	}
+	if len(aliases) > 0 {
+		found := false
+		for _, a := range aliases {
+			found = found || a == tx.alias
+		}
+		if !found {
+			return
+		}
+	}
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if !tx.done {
+		_ = s.endTransaction(tx, false, reason)
+	}
+}
+
+// beginTransaction pins a connection of the target's pool and opens a
+// transaction on it.
+func (s *MCPMSSQLServer) beginTransaction(target *queryTarget, isolation string) (*pinnedTx, error) {
+	if target.alias == "" {
+		return nil, fmt.Errorf("explicit transactions are only available on writable dynamic aliases")
+	}
+	if target.config.readOnly {
+		return nil, fmt.Errorf("alias '%s' is read-only: explicit transactions are only available on writable aliases", target.alias)
+	}
+	isolation = strings.ToLower(strings.TrimSpace(isolation))
+	if isolation == "" {
+		isolation = "read_committed"
+	}
+	level, ok := transactionIsolationLevels[isolation]
+	if !ok {
+		return nil, fmt.Errorf("invalid isolation_level '%s' (use read_committed, repeatable_read, serializable or snapshot)", isolation)
+	}
+
+	if err := s.checkNoTransaction(); err != nil {
+		return nil, err
+	}
+	// Reserving the connection can wait for the pool: it is done without
+	// holding txMu, and the check is repeated once it is held.
+	ctx, cancel := context.WithTimeout(context.Background(), transactionOpTimeout)
+	defer cancel()
+	conn, err := target.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not reserve a connection: %v", err)
+	}
+
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+	if s.tx != nil {
+		_ = conn.Close()
+		return nil, s.transactionOpenError()
+	}
+	// The transaction lives until commit or rollback, not for this call.
+	txCtx, txCancel := context.WithCancel(context.Background())
+	sqlTx, err := conn.BeginTx(txCtx, &sql.TxOptions{Isolation: level})
+	if err != nil {
+		txCancel()
+		_ = conn.Close()
+		return nil, fmt.Errorf("could not begin the transaction: %v", err)
+	}
+
+	s.txSeq++
+	now := time.Now()
+	tx := &pinnedTx{
+		id:        s.txSeq,
+		alias:     target.alias,
+		isolation: isolation,
+		conn:      conn,
+		sqlTx:     sqlTx,
+		cancel:    txCancel,
+		started:   now,
+		lastUsed:  now,
+		idle:      envSeconds("MSSQL_TRANSACTION_IDLE_TIMEOUT", defaultTransactionIdle),
+	}
+	tx.timer = time.AfterFunc(tx.idle, func() { s.expireTransaction(tx) })
+	s.tx = tx
+	s.txEndNote = ""
+	s.secLogger.Printf("Transaction %d begun on alias '%s' (%s)", tx.id, tx.alias, isolation)
+	return tx, nil
+}
+
+// checkNoTransaction refuses to begin a transaction while one is open.
+func (s *MCPMSSQLServer) checkNoTransaction() error {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+	if s.tx != nil {
+		return s.transactionOpenError()
+	}
+	return nil
+}
+
+// transactionOpenError is the refusal of a second transaction; txMu must be
+// held.
+func (s *MCPMSSQLServer) transactionOpenError() error {
+	return fmt.Errorf("transaction %d is already open on alias '%s': commit or roll it back first", s.tx.id, s.tx.alias)
+}
+
+// record adds a statement that passed the policy and is about to be sent
+// to the server. The caller holds tx.mu.
+func (tx *pinnedTx) record(operation, query string, params int) {
+	tx.statements = append(tx.statements, txStatement{Operation: operation, Query: truncateText(query), Params: params})
+}
+
+// runInTransaction runs one query_database statement inside tx. Statements
+// refused by the policy never reach the server and are not recorded.
+func (s *MCPMSSQLServer) runInTransaction(ctx context.Context, tx *pinnedTx, target *queryTarget, query string, args []interface{}) ([]map[string]interface{}, error) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.done {
+		return nil, fmt.Errorf("transaction %d has already ended", tx.id)
+	}
+	target.tx = tx
+	recorded := len(tx.statements)
+	results, err := s.executeCapturedQueryOn(ctx, target, query, args...)
+	tx.lastUsed = time.Now()
+	if len(tx.statements) > recorded {
+		st := &tx.statements[len(tx.statements)-1]
+		st.Rows = len(results)
+		if err != nil {
+			st.Error = firstLine(err.Error())
+		}
+	}
+	return results, err
+}
+
+// transactionPolicy refuses transaction control statements inside a pinned
+// transaction: they would commit without confirmation or desynchronize it.
+// EXEC, dynamic SQL and statements that do not start with a statement
+// keyword (a bare procedure call) are refused too, since the procedure or
+// batch they run could hold a COMMIT the policy cannot see.
+func transactionPolicy(query string) error {
+	if stmt := transactionControl(query); stmt != "" {
+		return fmt.Errorf("%s is not allowed inside an explicit transaction: use the commit or rollback tools", stmt)
+	}
+	for _, st := range splitStatements(query) {
+		if st.callsDynamicSQL() || (st.keyword != "WITH" && !statementKeywords[st.keyword]) {
+			return fmt.Errorf("EXEC, dynamic SQL and procedure calls are not allowed inside an explicit transaction: they could commit or roll back behind the commit confirmation")
+		}
+	}
+	return nil
+}
+
+// commitPolicy requires a confirm_operation naming the transaction before
+// it is committed; the confirmation request lists every statement.
+func (s *MCPMSSQLServer) commitPolicy(tx *pinnedTx) error {
+	keys := []string{tx.confirmationKey()}
+	if s.isOperationConfirmed(tx.alias, "COMMIT", keys) {
+		return nil
+	}
+	err := s.requireConfirmationForModification(tx.alias, "COMMIT", keys)
+	return fmt.Errorf("%w\n\nStatements in transaction %d on alias '%s':\n%s", err, tx.id, tx.alias, tx.summary())
+}
+
+// transactionTools are the begin_transaction, commit and rollback tools,
+// listed in dynamic mode when at least one alias is writable.
+func transactionTools() []Tool {
+	annotations := func(readOnly, destructive bool) *ToolAnnotations {
+		return &ToolAnnotations{
+			ReadOnlyHint:    boolPtr(readOnly),
+			DestructiveHint: boolPtr(destructive),
+			IdempotentHint:  boolPtr(false),
+			OpenWorldHint:   boolPtr(false),
+		}
+	}
+	return []Tool{
+		{
+			Name:        "begin_transaction",
+			Title:       "Begin Transaction",
+			Description: "Open an explicit transaction on a writable dynamic alias, so that a multi-step change (insert header, insert lines, update totals) is applied all at once or not at all. Until commit or rollback, query_database calls on that alias run inside the transaction and nothing is visible to others; per-statement confirmation is replaced by one confirmation at commit. The transaction is rolled back automatically after MSSQL_TRANSACTION_IDLE_TIMEOUT seconds without statements (default 120). Only one transaction can be open at a time.",
+			InputSchema: InputSchema{
+				Type: "object",
+				Properties: map[string]Property{
+					"alias": {
+						Type:        "string",
+						Description: "Writable dynamic alias to open the transaction on (optional, defaults to the active connection)",
+					},
+					"isolation_level": {
+						Type:        "string",
+						Description: "'read_committed' (default), 'repeatable_read', 'serializable' or 'snapshot'",
+					},
+				},
+				Required: []string{},
+			},
+			Annotations: annotations(false, false),
+		},
+		{
+			Name:        "commit",
+			Title:       "Commit Transaction",
+			Description: "Commit the open explicit transaction. The first call returns the list of statements executed in it and asks for confirm_operation (e.g. 'COMMIT transaction 3'); call commit again once confirmed.",
+			InputSchema: InputSchema{
+				Type:       "object",
+				Properties: map[string]Property{},
+				Required:   []string{},
+			},
+			Annotations: annotations(false, true),
+		},
+		{
+			Name:        "rollback",
+			Title:       "Rollback Transaction",
+			Description: "Roll back the open explicit transaction, undoing every statement executed in it.",
+			InputSchema: InputSchema{
+				Type:       "object",
+				Properties: map[string]Property{},
+				Required:   []string{},
+			},
+			Annotations: annotations(false, false),
+		},
+	}
+}
+
+// writableAliasAnywhere reports whether any dynamic alias is writable,
+// which is when the transaction tools are listed.
+func (s *MCPMSSQLServer) writableAliasAnywhere() bool {
+	s.dynamicMu.RLock()
+	defer s.dynamicMu.RUnlock()
+	for name := range s.dynamicAliases {
+		if !s.effectiveConfigLocked(name).readOnly {
+			return true
+		}
+	}
+	return false
+}
+
+// handleTransactionTool implements begin_transaction, commit and rollback.
+func (s *MCPMSSQLServer) handleTransactionTool(id interface{}, name string, args map[string]interface{}) *MCPResponse {
+	errorResponse := func(msg string) *MCPResponse {
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result: CallToolResult{
+				Content: []ContentItem{{Type: "text", Text: msg}},
+				IsError: true,
+			},
+		}
+	}
+	textResponse := func(text string) *MCPResponse {
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result:  CallToolResult{Content: []ContentItem{{Type: "text", Text: text}}},
+		}
+	}
+	if !s.isDynamic {
+		return errorResponse(fmt.Sprintf("Error: %s is only available in dynamic multi-connection mode, on writable aliases.", name))
+	}
+
+	if name == "begin_transaction" {
+		target, err := s.resolveTarget(args)
+		if err != nil {
+			return errorResponse(fmt.Sprintf("Error: %v", err))
+		}
+		isolation, _ := args["isolation_level"].(string)
+		tx, err := s.beginTransaction(target, isolation)
+		if err != nil {
+			return errorResponse(fmt.Sprintf("Error: %v", err))
+		}
+		return textResponse(fmt.Sprintf("Transaction %d begun on alias '%s' (%s). query_database calls on this alias now run inside it. Finish with commit or rollback; it is rolled back automatically after %s without statements.", tx.id, tx.alias, tx.isolation, tx.idle))
+	}
+
+	tx := s.currentTransaction()
+	if tx == nil {
+		s.txMu.Lock()
+		note := s.txEndNote
+		s.txMu.Unlock()
+		if note != "" {
+			return errorResponse("Error: no transaction is open. " + note)
+		}
+		return errorResponse("Error: no transaction is open. Use begin_transaction first.")
+	}
+
+	// The alias posture is read before locking the transaction: pools are
+	// closed (and their transaction rolled back) under dynamicMu.
+	config := s.getEffectiveConfigFor(tx.alias)
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.done {
+		return errorResponse("Error: the transaction has already ended.")
+	}
+
+	if name == "rollback" {
+		if err := s.endTransaction(tx, false, ""); err != nil {
+			return errorResponse(fmt.Sprintf("Error: rollback failed: %v", err))
+		}
+		return textResponse(fmt.Sprintf("Transaction %d on alias '%s' rolled back (%d statement(s) undone).", tx.id, tx.alias, len(tx.statements)))
+	}
+
+	// commit
+	target := &queryTarget{alias: tx.alias, config: config, tool: "commit", tx: tx}
+	if err := s.enforcePolicy(policyRequest{target: target, query: "COMMIT", mode: policyCommit}); err != nil {
+		return errorResponse(fmt.Sprintf("Error: %v", err))
+	}
+	if err := s.endTransaction(tx, true, ""); err != nil {
+		if s.devMode {
+			return errorResponse(fmt.Sprintf("Error: commit failed, the transaction was rolled back: %v", err))
+		}
+		return errorResponse("Error: commit failed, the transaction was rolled back")
+	}
+	statements, _ := json.MarshalIndent(tx.statements, "", "  ")
+	text := fmt.Sprintf("Transaction %d on alias '%s' committed. Statements:\n%s", tx.id, tx.alias, string(statements))
+	if len(tx.undo) > 0 {
+		var ids []string
+		for _, e := range tx.undo {
+			ids = append(ids, e.ID)
+		}
+		text += "\nUndo journal operations (undo_operation): " + strings.Join(ids, ", ")
+	}
+	return textResponse(text)
+}
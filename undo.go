@@ -0,0 +1,1030 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+const (
+	undoEntryFormatVersion = 1
+	// defaultUndoMaxRows caps the before-images kept for one statement
+	// (MSSQL_UNDO_MAX_ROWS); a statement affecting more rows is rolled back.
+	defaultUndoMaxRows   = 10000
+	maxUndoEntryFileSize = 256 << 20
+)
+
+// undoOperationIDPattern is the shape of the ids newUndoOperationID makes;
+// they double as journal file names.
+var undoOperationIDPattern = regexp.MustCompile(`^\d{8}-\d{6}-[0-9a-f]{6}$`)
+
+// undoTableQuery resolves the target of a journaled statement and counts
+// its enabled triggers (OUTPUT without INTO is refused on such tables).
+const undoTableQuery = `SELECT o.object_id, SCHEMA_NAME(o.schema_id) AS schema_name, o.name, o.type,
+	(SELECT COUNT(*) FROM sys.triggers tr WHERE tr.parent_id = o.object_id AND tr.is_disabled = 0) AS triggers
+FROM sys.objects o
+WHERE o.object_id = OBJECT_ID(@p1)`
+
+// undoColumnsQuery lists a table's columns with their position in the
+// primary key (0 when not part of it).
+const undoColumnsQuery = `SELECT c.name, TYPE_NAME(c.system_type_id) AS type_name, c.is_identity, c.is_computed,
+	ISNULL(ic.key_ordinal, 0) AS key_ordinal
+FROM sys.columns c
+LEFT JOIN sys.indexes i ON i.object_id = c.object_id AND i.is_primary_key = 1
+LEFT JOIN sys.index_columns ic ON ic.object_id = i.object_id AND ic.index_id = i.index_id AND ic.column_id = c.column_id
+WHERE c.object_id = @p1
+ORDER BY c.column_id`
+
+// undoColumn is a column whose before-image is journaled. Computed and
+// rowversion columns are left out: they cannot be written back.
+type undoColumn struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Identity bool   `json:"identity,omitempty"`
+	Key      int    `json:"key,omitempty"` // position in the primary key, 0 when not part of it
+}
+
+// undoEntry is one journaled UPDATE or DELETE. Before (and, for UPDATE,
+// After) hold one row per affected row, aligned with Columns.
+type undoEntry struct {
+	FormatVersion int             `json:"format_version"`
+	ID            string          `json:"operation_id"`
+	Alias         string          `json:"alias"`
+	Table         string          `json:"table"`
+	Operation     string          `json:"operation"`
+	Statement     string          `json:"statement"`
+	CreatedAt     time.Time       `json:"created_at"`
+	Transaction   int             `json:"transaction,omitempty"`
+	Columns       []undoColumn    `json:"columns"`
+	Before        [][]interface{} `json:"before"`
+	After         [][]interface{} `json:"after,omitempty"`
+	UndoneAt      *time.Time      `json:"undone_at,omitempty"`
+}
+
+// keyColumns returns the indexes in Columns of the primary key columns, in
+// key order.
+func (e *undoEntry) keyColumns() []int {
+	var keys []int
+	for pos := 1; ; pos++ {
+		found := false
+		for i, c := range e.Columns {
+			if c.Key == pos {
+				keys = append(keys, i)
+				found = true
+			}
+		}
+		if !found {
+			return keys
+		}
+	}
+}
+
+func newUndoOperationID(now time.Time) string {
+	b := make([]byte, 3)
+	_, _ = rand.Read(b)
+	return now.UTC().Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
+
+// undoCapture is where an UPDATE or DELETE gets its OUTPUT clause.
+type undoCapture struct {
+	operation string // UPDATE or DELETE
+	table     string // target object as written in the statement
+	insertAt  int    // byte offset where the OUTPUT clause goes
+}
+
+// planUndoCapture finds the target table of a single UPDATE or DELETE and
+// the position of its OUTPUT clause: after the target (DELETE) or the SET
+// list (UPDATE), before any FROM, WHERE or OPTION. The error says why the
+// statement cannot be journaled.
+func planUndoCapture(query string) (*undoCapture, error) {
+	if hasMultipleStatements(query) {
+		return nil, fmt.Errorf("only single statements are journaled")
+	}
+	toks := tokenizeSQL(query)
+	if n := len(toks); n > 0 && toks[n-1].text == ";" {
+		toks = toks[:n-1]
+	}
+	if len(toks) == 0 {
+		return nil, fmt.Errorf("empty statement")
+	}
+	c := &undoCapture{operation: toks[0].upper, insertAt: toks[len(toks)-1].end}
+	if c.operation != "UPDATE" && c.operation != "DELETE" {
+		return nil, fmt.Errorf("only statements starting with UPDATE or DELETE are journaled (no CTEs)")
+	}
+	for _, t := range toks {
+		if t.depth == 0 && t.upper == "OUTPUT" {
+			return nil, fmt.Errorf("the statement already has an OUTPUT clause")
+		}
+	}
+
+	i := 1
+	if i < len(toks) && toks[i].upper == "TOP" {
+		i++
+		if i < len(toks) && toks[i].text == "(" {
+			for depth := toks[i].depth; i < len(toks); i++ {
+				if toks[i].text == ")" && toks[i].depth == depth {
+					i++
+					break
+				}
+			}
+		}
+		if i < len(toks) && toks[i].upper == "PERCENT" {
+			i++
+		}
+	}
+	if c.operation == "DELETE" && i < len(toks) && toks[i].upper == "FROM" {
+		i++
+	}
+	nameStart := i
+	for i < len(toks) && toks[i].isName() {
+		i++
+		if i < len(toks) && toks[i].text == "." {
+			i++
+			continue
+		}
+		break
+	}
+	if i == nameStart || toks[i-1].text == "." {
+		return nil, fmt.Errorf("could not find the target table")
+	}
+	c.table = query[toks[nameStart].start:toks[i-1].end]
+
+	if c.operation == "UPDATE" {
+		for i < len(toks) && !(toks[i].depth == 0 && toks[i].upper == "SET") {
+			i++
+		}
+		if i == len(toks) {
+			return nil, fmt.Errorf("could not find the SET clause")
+		}
+	}
+	for ; i < len(toks); i++ {
+		if toks[i].depth == 0 && (toks[i].upper == "FROM" || toks[i].upper == "WHERE" || toks[i].upper == "OPTION") {
+			c.insertAt = toks[i].start
+			break
+		}
+	}
+	return c, nil
+}
+
+// rewrite inserts the OUTPUT clause capturing cols: deleted.* values, then
+// for UPDATE the inserted.* ones, used to detect later changes.
+func (c *undoCapture) rewrite(query string, cols []undoColumn) string {
+	var out []string
+	prefixes := []string{"deleted."}
+	if c.operation == "UPDATE" {
+		prefixes = append(prefixes, "inserted.")
+	}
+	for _, p := range prefixes {
+		for _, col := range cols {
+			out = append(out, p+quoteIdentifier(col.Name))
+		}
+	}
+	return query[:c.insertAt] + " OUTPUT " + strings.Join(out, ", ") + " " + query[c.insertAt:]
+}
+
+// undoTable is the resolved target of a journaled statement.
+type undoTable struct {
+	qualified string // [schema].[name]
+	columns   []undoColumn
+	hasKey    bool
+}
+
+// resolveUndoTable reads the catalog for c's target and reports why its
+// rows cannot be journaled, if they cannot.
+func (s *MCPMSSQLServer) resolveUndoTable(ctx context.Context, target *queryTarget, c *undoCapture) (*undoTable, error) {
+	// Catalog reads never run inside the caller's explicit transaction.
+	meta := *target
+	meta.tx = nil
+
+	var objectID int64
+	var schema, name, objType string
+	var triggers int
+	found := false
+	err := s.scanQuery(ctx, &meta, undoTableQuery, []interface{}{c.table}, func(rows *sql.Rows) error {
+		found = true
+		return rows.Scan(&objectID, &schema, &name, &objType, &triggers)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not read the catalog: %v", err)
+	}
+	switch {
+	case !found:
+		return nil, fmt.Errorf("%s is not a table of this database (name the table itself, not an alias)", c.table)
+	case strings.TrimSpace(objType) != "U":
+		return nil, fmt.Errorf("%s is not a user table", c.table)
+	case triggers > 0:
+		return nil, fmt.Errorf("%s has enabled triggers, which rule out an OUTPUT clause", c.table)
+	}
+
+	t := &undoTable{qualified: qualifiedTable(schema, name)}
+	err = s.scanQuery(ctx, &meta, undoColumnsQuery, []interface{}{objectID}, func(rows *sql.Rows) error {
+		var col undoColumn
+		var computed bool
+		if err := rows.Scan(&col.Name, &col.Type, &col.Identity, &computed, &col.Key); err != nil {
+			return err
+		}
+		if computed || col.Type == "timestamp" {
+			return nil
+		}
+		if !queryParamTypes[col.Type] {
+			return fmt.Errorf("column %s has type %s, which the journal does not support", col.Name, col.Type)
+		}
+		t.hasKey = t.hasKey || col.Key > 0
+		t.columns = append(t.columns, col)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if c.operation == "UPDATE" && !t.hasKey {
+		return nil, fmt.Errorf("%s has no primary key to find the updated rows again", t.qualified)
+	}
+	return t, nil
+}
+
+// encodeUndoValue turns a scanned value into its journal form: JSON values
+// that decodeUndoValue converts back to the column type without loss.
+func encodeUndoValue(col undoColumn, v interface{}) interface{} {
+	switch t := v.(type) {
+	case []byte:
+		switch col.Type {
+		case "uniqueidentifier":
+			var u mssql.UniqueIdentifier
+			if u.Scan(t) == nil {
+				return u.String()
+			}
+		case "binary", "varbinary":
+			return "0x" + strings.ToUpper(hex.EncodeToString(t))
+		}
+		return string(t)
+	case time.Time:
+		switch col.Type {
+		case "date":
+			return t.Format("2006-01-02")
+		case "time":
+			return t.Format("15:04:05.999999999")
+		case "datetimeoffset":
+			return t.Format(time.RFC3339Nano)
+		}
+		return t.Format("2006-01-02T15:04:05.999999999")
+	case int64:
+		if col.Type == "bigint" {
+			return strconv.FormatInt(t, 10) // beyond float64 precision
+		}
+		return t
+	case float32:
+		return float64(t)
+	}
+	return v
+}
+
+// decodeUndoValue converts a journaled value to the Go value the driver
+// binds as the column type.
+func decodeUndoValue(col undoColumn, v interface{}) (interface{}, error) {
+	switch col.Type {
+	case "bigint":
+		if s, ok := v.(string); ok {
+			return strconv.ParseInt(s, 10, 64)
+		}
+	case "decimal", "numeric":
+		return coerceProcValue(procParam{BaseType: col.Type}, v)
+	}
+	return coerceQueryParam(queryParamType{base: col.Type}, v)
+}
+
+// sameUndoValue compares journal values after a JSON round trip, so that
+// values read back from a journal file compare equal to freshly scanned ones.
+func sameUndoValue(a, b interface{}) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ja) == string(jb)
+}
+
+// undoJournal stores undo entries in files of a local directory
+// (MSSQL_UNDO_JOURNAL_DIR) or, when MSSQL_UNDO_JOURNAL_TABLE is set, in
+// that table of the alias database.
+type undoJournal struct {
+	dir   string
+	table string // quoted [schema].[name]
+}
+
+func undoJournalFromEnv() (*undoJournal, error) {
+	if name := strings.TrimSpace(os.Getenv("MSSQL_UNDO_JOURNAL_TABLE")); name != "" {
+		schema, table, err := splitQualifiedName(name, "dbo")
+		if err != nil {
+			return nil, fmt.Errorf("MSSQL_UNDO_JOURNAL_TABLE: %v", err)
+		}
+		return &undoJournal{table: qualifiedTable(schema, table)}, nil
+	}
+	dir, err := sandboxDir("MSSQL_UNDO_JOURNAL_DIR", "undo-journal")
+	if err != nil {
+		return nil, err
+	}
+	return &undoJournal{dir: dir}, nil
+}
+
+// undoMaxRows is MSSQL_UNDO_MAX_ROWS, or defaultUndoMaxRows.
+func undoMaxRows() int {
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("MSSQL_UNDO_MAX_ROWS"))); err == nil && n > 0 {
+		return n
+	}
+	return defaultUndoMaxRows
+}
+
+func (j *undoJournal) path(id string) (string, error) {
+	if !undoOperationIDPattern.MatchString(id) {
+		return "", fmt.Errorf("invalid operation id '%s'", id)
+	}
+	return sandboxFile(j.dir, id, ".json")
+}
+
+// save writes e. A table journal writes inside tx, so the entry commits or
+// rolls back with the statement; a file is written before the commit and
+// removed by discard if the commit fails.
+func (j *undoJournal) save(ctx context.Context, tx *sql.Tx, e *undoEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if j.table != "" {
+		_, err := tx.ExecContext(ctx, "INSERT INTO "+j.table+" (operation_id, created_at, alias, table_name, operation, entry) VALUES (@p1, @p2, @p3, @p4, @p5, @p6)",
+			e.ID, e.CreatedAt, e.Alias, e.Table, e.Operation, string(data))
+		return err
+	}
+	path, err := j.path(e.ID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+func (j *undoJournal) discard(e *undoEntry) {
+	if j.table == "" {
+		if path, err := j.path(e.ID); err == nil {
+			_ = os.Remove(path)
+		}
+	}
+}
+
+// load reads entry id; db is the alias database for a table journal, and
+// alias the alias it belongs to ("" for the file journal).
+func (j *undoJournal) load(ctx context.Context, db *sql.DB, alias, id string) (*undoEntry, error) {
+	var data []byte
+	if j.table != "" {
+		if !undoOperationIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("invalid operation id '%s'", id)
+		}
+		var entry string
+		err := db.QueryRowContext(ctx, "SELECT entry FROM "+j.table+" WHERE operation_id = @p1", id).Scan(&entry)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("operation '%s' not found in the undo journal", id)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read the undo journal table: %v", err)
+		}
+		data = []byte(entry)
+	} else {
+		path, err := j.path(id)
+		if err != nil {
+			return nil, err
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("operation '%s' not found in the undo journal", id)
+		}
+		if fi.Size() > maxUndoEntryFileSize {
+			return nil, fmt.Errorf("undo journal entry too large (%d bytes)", fi.Size())
+		}
+		// #nosec G304 -- path is confined to the journal directory by sandboxFile
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	var e undoEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("invalid undo journal entry: %w", err)
+	}
+	if e.FormatVersion != undoEntryFormatVersion {
+		return nil, fmt.Errorf("unsupported undo journal format version %d", e.FormatVersion)
+	}
+	if err := e.requote(alias); err != nil {
+		return nil, fmt.Errorf("invalid undo journal entry: %v", err)
+	}
+	return &e, nil
+}
+
+// requote rebuilds the table and column names of a loaded entry before they
+// are pasted into the undo statements: a table journal is a row any writer
+// of the alias database can edit. alias, when set, is the alias whose
+// journal the entry was read from; an entry naming another one is refused.
+func (e *undoEntry) requote(alias string) error {
+	if alias != "" && !strings.EqualFold(e.Alias, alias) {
+		return fmt.Errorf("recorded for alias '%s' in the journal of alias '%s'", e.Alias, alias)
+	}
+	schema, name, err := splitQualifiedName(e.Table, "")
+	if err != nil {
+		return err
+	}
+	e.Table = qualifiedTable(schema, name)
+	seen := make(map[string]bool, len(e.Columns))
+	for _, c := range e.Columns {
+		// Column names are quoted by quoteIdentifier wherever they are used;
+		// only names SQL Server could not have produced are refused.
+		if c.Name == "" || utf8.RuneCountInString(c.Name) > 128 || strings.ContainsRune(c.Name, 0) || seen[strings.ToLower(c.Name)] {
+			return fmt.Errorf("invalid column name '%s'", c.Name)
+		}
+		seen[strings.ToLower(c.Name)] = true
+	}
+	return nil
+}
+
+// markUndone records that e was undone: inside tx for a table journal, by
+// rewriting the file (after the commit) otherwise.
+func (j *undoJournal) markUndone(ctx context.Context, tx *sql.Tx, e *undoEntry, at time.Time) error {
+	e.UndoneAt = &at
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if j.table != "" {
+		_, err := tx.ExecContext(ctx, "UPDATE "+j.table+" SET undone_at = @p2, entry = @p3 WHERE operation_id = @p1", e.ID, at, string(data))
+		return err
+	}
+	path, err := j.path(e.ID)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// undoJournalApplies reports whether UPDATE and DELETE statements on target
+// are journaled: writable dynamic aliases, with MSSQL_UNDO_JOURNAL=true.
+func (s *MCPMSSQLServer) undoJournalApplies(target *queryTarget, query string) bool {
+	if target.alias == "" || target.config.readOnly || !target.config.undoJournal {
+		return false
+	}
+	op := s.extractOperation(query)
+	return op == "UPDATE" || op == "DELETE"
+}
+
+// executeCapturedQueryOn is executeSecureQueryOn for query_database: UPDATE
+// and DELETE statements on writable dynamic aliases are journaled so that
+// undo_operation can revert them. A statement that cannot be journaled
+// still runs, with an "_undo" row saying why it cannot be undone.
+func (s *MCPMSSQLServer) executeCapturedQueryOn(ctx context.Context, target *queryTarget, query string, args ...interface{}) ([]map[string]interface{}, error) {
+	if !s.undoJournalApplies(target, query) {
+		return s.executeDMLOn(ctx, target, query, args)
+	}
+	capture, table, journal, err := s.prepareUndoCapture(ctx, target, query)
+	if err != nil {
+		results, qerr := s.executeDMLOn(ctx, target, query, args)
+		if qerr != nil {
+			return nil, qerr
+		}
+		return append(results, map[string]interface{}{
+			"_undo": "not journaled, undo_operation cannot revert this statement: " + err.Error(),
+		}), nil
+	}
+	return s.executeJournaledOn(ctx, target, query, args, capture, table, journal)
+}
+
+func (s *MCPMSSQLServer) prepareUndoCapture(ctx context.Context, target *queryTarget, query string) (*undoCapture, *undoTable, *undoJournal, error) {
+	capture, err := planUndoCapture(query)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	journal, err := undoJournalFromEnv()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	table, err := s.resolveUndoTable(ctx, target, capture)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return capture, table, journal, nil
+}
+
+// executeJournaledOn runs the statement with its OUTPUT clause in a
+// transaction (a savepoint of the explicit transaction, if any), keeps the
+// before-images and writes them to the journal before the commit. Inside an
+// explicit transaction the entry is written when that transaction commits.
+func (s *MCPMSSQLServer) executeJournaledOn(ctx context.Context, target *queryTarget, query string, args []interface{}, capture *undoCapture, table *undoTable, journal *undoJournal) ([]map[string]interface{}, error) {
+	if err := s.enforcePolicy(policyRequest{target: target, query: query}); err != nil {
+		return nil, err
+	}
+
+	pinned := target.tx
+	if pinned != nil {
+		pinned.record(capture.operation, query, len(args))
+	}
+	scope, err := s.openDMLScope(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &undoEntry{
+		FormatVersion: undoEntryFormatVersion,
+		ID:            newUndoOperationID(time.Now()),
+		Alias:         target.alias,
+		Table:         table.qualified,
+		Operation:     capture.operation,
+		Statement:     truncateText(query),
+		CreatedAt:     time.Now().UTC(),
+		Columns:       table.columns,
+	}
+	if err := s.captureRows(ctx, scope.tx, capture.rewrite(query, table.columns), args, entry, target); err != nil {
+		scope.abort()
+		return nil, err
+	}
+
+	affected := len(entry.Before)
+	result := map[string]interface{}{"rows_affected": affected}
+	switch {
+	case affected == 0:
+		if err := scope.commit(); err != nil {
+			return nil, s.queryFailed(err)
+		}
+		return []map[string]interface{}{result}, nil
+	case pinned != nil:
+		entry.Transaction = pinned.id
+		pinned.undo = append(pinned.undo, entry)
+		pinned.journal = journal
+		result["undo_operation_id"] = entry.ID
+		result["_undo"] = fmt.Sprintf("journaled when transaction %d commits", pinned.id)
+		return []map[string]interface{}{result}, nil
+	}
+
+	if err := journal.save(ctx, scope.tx, entry); err != nil {
+		scope.abort()
+		return nil, fmt.Errorf("the undo journal could not be written, the statement was rolled back: %v", err)
+	}
+	if err := scope.commit(); err != nil {
+		journal.discard(entry)
+		return nil, s.queryFailed(err)
+	}
+	s.secLogger.Printf("Undo journal: operation %s captured %d row(s) of %s on alias '%s'", entry.ID, affected, entry.Table, entry.Alias)
+	result["undo_operation_id"] = entry.ID
+	return []map[string]interface{}{result}, nil
+}
+
+// captureRows runs the rewritten statement and stores the OUTPUT rows in
+// entry, failing when there are more than undoMaxRows or more than the
+// alias's max_affected_rows. Past max_affected_rows the rows are still
+// counted, so that the error can say how many would have been touched.
+func (s *MCPMSSQLServer) captureRows(ctx context.Context, tx *sql.Tx, query string, args []interface{}, entry *undoEntry, target *queryTarget) error {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return s.queryFailed(err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	n := len(entry.Columns)
+	limit := undoMaxRows()
+	maxAffected := int64(target.config.maxAffectedRows)
+	var seen int64
+	for rows.Next() {
+		seen++
+		if len(entry.Before) >= limit || (maxAffected > 0 && seen > maxAffected) {
+			if maxAffected > 0 {
+				continue
+			}
+			break
+		}
+		values := make([]interface{}, n*2)
+		ptrs := make([]interface{}, len(values))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if entry.Operation == "DELETE" {
+			ptrs = ptrs[:n]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		before := make([]interface{}, n)
+		for i, col := range entry.Columns {
+			before[i] = encodeUndoValue(col, values[i])
+		}
+		entry.Before = append(entry.Before, before)
+		if entry.Operation == "UPDATE" {
+			after := make([]interface{}, n)
+			for i, col := range entry.Columns {
+				after[i] = encodeUndoValue(col, values[n+i])
+			}
+			entry.After = append(entry.After, after)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return s.queryFailed(err)
+	}
+	if maxAffected > 0 && seen > maxAffected {
+		s.secLogger.Printf("DML on alias '%s' rolled back: %d rows affected, limit %d", target.alias, seen, maxAffected)
+		return tooManyRows(target, seen)
+	}
+	if seen > int64(len(entry.Before)) {
+		return fmt.Errorf("the statement affects more than %d rows, more than the undo journal keeps (MSSQL_UNDO_MAX_ROWS); it was rolled back. Work in smaller batches", limit)
+	}
+	return nil
+}
+
+// queryFailed is the error reported for a statement the server rejected,
+// detailed in developer mode only.
+func (s *MCPMSSQLServer) queryFailed(err error) error {
+	if s.devMode {
+		s.secLogger.Printf("Failed to execute query: %v", err)
+		return fmt.Errorf("query execution failed: %v", err)
+	}
+	s.secLogger.Printf("Failed to execute query: execution error")
+	return fmt.Errorf("query execution failed: the query syntax is valid but execution was rejected by the server. Check permissions and data constraints")
+}
+
+// undoStep reverts one journaled row. check finds the row as the journaled
+// statement left it; apply writes the before-image back.
+type undoStep struct {
+	key       string
+	check     string
+	checkArgs []interface{}
+	expect    []interface{} // UPDATE: the after-image the row must still have
+	apply     string
+	args      []interface{}
+}
+
+// buildUndoSteps generates the compensating statements: an INSERT of the
+// before-image for each deleted row, an UPDATE back to it for each updated
+// row.
+func buildUndoSteps(e *undoEntry) ([]undoStep, error) {
+	keys := e.keyColumns()
+	if e.Operation == "UPDATE" && len(keys) == 0 {
+		return nil, fmt.Errorf("the entry has no primary key columns")
+	}
+	var names, placeholders []string
+	for i, c := range e.Columns {
+		names = append(names, quoteIdentifier(c.Name))
+		placeholders = append(placeholders, "@p"+strconv.Itoa(i+1))
+	}
+
+	steps := make([]undoStep, 0, len(e.Before))
+	for r, before := range e.Before {
+		if len(before) != len(e.Columns) {
+			return nil, fmt.Errorf("row %d of the entry does not match its columns", r+1)
+		}
+		image := before
+		if e.Operation == "UPDATE" {
+			if r >= len(e.After) || len(e.After[r]) != len(e.Columns) {
+				return nil, fmt.Errorf("row %d of the entry has no after-image", r+1)
+			}
+			image = e.After[r]
+		}
+		var step undoStep
+		var where, keyText []string
+		for n, k := range keys {
+			col := e.Columns[k]
+			v, err := decodeUndoValue(col, image[k])
+			if err != nil {
+				return nil, fmt.Errorf("row %d, column %s: %v", r+1, col.Name, err)
+			}
+			where = append(where, fmt.Sprintf("%s = @p%d", quoteIdentifier(col.Name), n+1))
+			keyText = append(keyText, fmt.Sprintf("%s=%v", col.Name, image[k]))
+			step.checkArgs = append(step.checkArgs, v)
+		}
+		step.key = fmt.Sprintf("row %d", r+1)
+		if len(keyText) > 0 {
+			step.key = "(" + strings.Join(keyText, ", ") + ")"
+		}
+
+		values := make([]interface{}, len(e.Columns))
+		for i, col := range e.Columns {
+			v, err := decodeUndoValue(col, before[i])
+			if err != nil {
+				return nil, fmt.Errorf("row %d, column %s: %v", r+1, col.Name, err)
+			}
+			values[i] = v
+		}
+
+		switch e.Operation {
+		case "DELETE":
+			if len(keys) > 0 {
+				step.check = "SELECT 1 FROM " + e.Table + " WITH (UPDLOCK, HOLDLOCK) WHERE " + strings.Join(where, " AND ")
+			}
+			step.apply = "INSERT INTO " + e.Table + " (" + strings.Join(names, ", ") + ") VALUES (" + strings.Join(placeholders, ", ") + ")"
+			step.args = values
+		case "UPDATE":
+			step.check = "SELECT " + strings.Join(names, ", ") + " FROM " + e.Table + " WITH (UPDLOCK, HOLDLOCK) WHERE " + strings.Join(where, " AND ")
+			step.expect = image
+			var set []string
+			for i, c := range e.Columns {
+				if c.Identity {
+					continue
+				}
+				step.args = append(step.args, values[i])
+				set = append(set, fmt.Sprintf("%s = @p%d", quoteIdentifier(c.Name), len(step.args)))
+			}
+			var keyWhere []string
+			for n, k := range keys {
+				step.args = append(step.args, step.checkArgs[n])
+				keyWhere = append(keyWhere, fmt.Sprintf("%s = @p%d", quoteIdentifier(e.Columns[k].Name), len(step.args)))
+			}
+			step.apply = "UPDATE " + e.Table + " SET " + strings.Join(set, ", ") + " WHERE " + strings.Join(keyWhere, " AND ")
+		default:
+			return nil, fmt.Errorf("unsupported operation %s", e.Operation)
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// undoQueryer is what conflict checks run on: the pool for a preview, the
+// undo transaction when applying.
+type undoQueryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// undoConflicts reports the rows that changed since the journaled
+// statement: a deleted row that exists again, an updated row that is gone
+// or no longer has the values the statement gave it.
+func undoConflicts(ctx context.Context, q undoQueryer, e *undoEntry, steps []undoStep) ([]string, error) {
+	var conflicts []string
+	for _, step := range steps {
+		if step.check == "" {
+			continue
+		}
+		rows, err := q.QueryContext(ctx, step.check, step.checkArgs...)
+		if err != nil {
+			return nil, err
+		}
+		found := rows.Next()
+		var changed []string
+		if found && step.expect != nil {
+			values := make([]interface{}, len(e.Columns))
+			ptrs := make([]interface{}, len(values))
+			for i := range values {
+				ptrs[i] = &values[i]
+			}
+			if err := rows.Scan(ptrs...); err != nil {
+				_ = rows.Close()
+				return nil, err
+			}
+			for i, col := range e.Columns {
+				if !sameUndoValue(encodeUndoValue(col, values[i]), step.expect[i]) {
+					changed = append(changed, col.Name)
+				}
+			}
+		}
+		_ = rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		switch {
+		case e.Operation == "DELETE" && found:
+			conflicts = append(conflicts, step.key+": a row with this key exists again")
+		case e.Operation == "UPDATE" && !found:
+			conflicts = append(conflicts, step.key+": the row no longer exists")
+		case len(changed) > 0:
+			conflicts = append(conflicts, step.key+": changed since ("+strings.Join(changed, ", ")+")")
+		}
+	}
+	return conflicts, nil
+}
+
+// undoPolicy allows undo_operation to apply an entry on a writable dynamic
+// alias once confirm_operation has confirmed that very operation.
+func (s *MCPMSSQLServer) undoPolicy(target *queryTarget, operationID string) error {
+	if target.alias == "" || target.config.readOnly {
+		return fmt.Errorf("undo_operation can only write to a writable dynamic alias")
+	}
+	keys := []string{"operation " + operationID}
+	if s.isOperationConfirmed(target.alias, "UNDO", keys) {
+		return nil
+	}
+	return s.requireConfirmationForModification(target.alias, "UNDO", keys)
+}
+
+// applyUndo re-checks the conflicts under locks and applies the steps in
+// one transaction. It returns the conflicts when there are any, and then
+// changes nothing.
+func (s *MCPMSSQLServer) applyUndo(ctx context.Context, target *queryTarget, journal *undoJournal, e *undoEntry, steps []undoStep) ([]string, error) {
+	tx, err := target.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, s.queryFailed(err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	conflicts, err := undoConflicts(ctx, tx, e, steps)
+	if err != nil {
+		return nil, s.queryFailed(err)
+	}
+	if len(conflicts) > 0 {
+		return conflicts, nil
+	}
+
+	identityInsert := false
+	if e.Operation == "DELETE" {
+		for _, c := range e.Columns {
+			identityInsert = identityInsert || c.Identity
+		}
+	}
+	if identityInsert {
+		if _, err := tx.ExecContext(ctx, "SET IDENTITY_INSERT "+e.Table+" ON"); err != nil {
+			return nil, s.queryFailed(err)
+		}
+	}
+	for _, step := range steps {
+		if _, err := tx.ExecContext(ctx, step.apply, step.args...); err != nil {
+			return nil, s.queryFailed(err)
+		}
+	}
+	if identityInsert {
+		if _, err := tx.ExecContext(ctx, "SET IDENTITY_INSERT "+e.Table+" OFF"); err != nil {
+			return nil, s.queryFailed(err)
+		}
+	}
+
+	now := time.Now().UTC()
+	if journal.table != "" {
+		if err := journal.markUndone(ctx, tx, e, now); err != nil {
+			return nil, fmt.Errorf("could not update the undo journal, nothing was changed: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, s.queryFailed(err)
+	}
+	if journal.table == "" {
+		if err := journal.markUndone(ctx, nil, e, now); err != nil {
+			s.secLogger.Printf("Undo journal: operation %s undone but its file could not be updated: %v", e.ID, err)
+		}
+	}
+	s.secLogger.Printf("Undo journal: operation %s undone (%d row(s) of %s on alias '%s')", e.ID, len(steps), e.Table, e.Alias)
+	return nil, nil
+}
+
+// describeUndo summarizes an entry and its compensating statements.
+func describeUndo(e *undoEntry, steps []undoStep) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Operation %s: %s on %s (alias '%s') at %s, %d row(s).\nStatement: %s\n",
+		e.ID, e.Operation, e.Table, e.Alias, e.CreatedAt.Format(time.RFC3339), len(e.Before), e.Statement)
+	if len(steps) > 0 {
+		fmt.Fprintf(&sb, "Compensating statement (run once per row, in one transaction):\n  %s\n", steps[0].apply)
+	}
+	if e.Operation == "DELETE" && len(e.keyColumns()) == 0 {
+		sb.WriteString("The table has no primary key: rows are re-inserted without checking whether they exist again.\n")
+	}
+	return sb.String()
+}
+
+// handleUndoOperation implements the undo_operation tool: a preview of the
+// compensating statements and of the conflicts by default, and with
+// apply=true their execution once confirmed.
+func (s *MCPMSSQLServer) handleUndoOperation(id interface{}, args map[string]interface{}) *MCPResponse {
+	errorResponse := func(msg string) *MCPResponse {
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result: CallToolResult{
+				Content: []ContentItem{{Type: "text", Text: msg}},
+				IsError: true,
+			},
+		}
+	}
+	if !s.isDynamic {
+		return errorResponse("Error: undo_operation is only available in dynamic multi-connection mode, on writable aliases.")
+	}
+	opID, _ := args["operation_id"].(string)
+	opID = strings.TrimSpace(opID)
+	if opID == "" {
+		return errorResponse("Error: 'operation_id' is required (returned by query_database as undo_operation_id)")
+	}
+	apply, _ := args["apply"].(bool)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	journal, err := undoJournalFromEnv()
+	if err != nil {
+		return errorResponse(fmt.Sprintf("Error: %v", err))
+	}
+	var entry *undoEntry
+	if journal.table != "" {
+		// The journal lives in the alias database.
+		target, err := s.resolveTarget(args)
+		if err != nil {
+			return errorResponse(fmt.Sprintf("Error: %v", err))
+		}
+		entry, err = journal.load(ctx, target.db, target.alias, opID)
+		if err != nil {
+			return errorResponse(fmt.Sprintf("Error: %v", err))
+		}
+	} else if entry, err = journal.load(ctx, nil, "", opID); err != nil {
+		return errorResponse(fmt.Sprintf("Error: %v", err))
+	}
+	if a, _ := args["alias"].(string); strings.TrimSpace(a) != "" && !strings.EqualFold(strings.TrimSpace(a), entry.Alias) {
+		return errorResponse(fmt.Sprintf("Error: operation %s was run on alias '%s', not '%s'", opID, entry.Alias, strings.TrimSpace(a)))
+	}
+	if entry.UndoneAt != nil {
+		return errorResponse(fmt.Sprintf("Error: operation %s was already undone at %s", opID, entry.UndoneAt.Format(time.RFC3339)))
+	}
+
+	target, err := s.resolveTarget(map[string]interface{}{"alias": entry.Alias})
+	if err != nil {
+		return errorResponse(fmt.Sprintf("Error: %v", err))
+	}
+	target.tool = "undo_operation"
+	if tx := s.transactionFor(target); tx != nil {
+		return errorResponse(fmt.Sprintf("Error: transaction %d is open on alias '%s': commit or roll it back first", tx.id, tx.alias))
+	}
+
+	steps, err := buildUndoSteps(entry)
+	if err != nil {
+		return errorResponse(fmt.Sprintf("Error: operation %s cannot be undone: %v", opID, err))
+	}
+	preview := describeUndo(entry, steps)
+
+	if !apply {
+		conflicts, err := undoConflicts(ctx, target.db, entry, steps)
+		if err != nil {
+			return errorResponse(fmt.Sprintf("Error: %v", s.queryFailed(err)))
+		}
+		text := preview
+		if len(conflicts) > 0 {
+			text += fmt.Sprintf("\n%d conflict(s): these rows changed since the operation, so it cannot be undone as is:\n- %s", len(conflicts), strings.Join(limitStrings(conflicts, 20), "\n- "))
+		} else {
+			text += "\nNo conflicts found. Call undo_operation again with apply=true to revert it (confirmation required)."
+		}
+		return &MCPResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Result:  CallToolResult{Content: []ContentItem{{Type: "text", Text: text}}},
+		}
+	}
+
+	if err := s.enforcePolicy(policyRequest{target: target, query: "UNDO", mode: policyUndo, undoID: opID}); err != nil {
+		return errorResponse(fmt.Sprintf("Error: %v\n\n%s", err, preview))
+	}
+	conflicts, err := s.applyUndo(ctx, target, journal, entry, steps)
+	if err != nil {
+		return errorResponse(fmt.Sprintf("Error: %v", err))
+	}
+	if len(conflicts) > 0 {
+		return errorResponse(fmt.Sprintf("Error: operation %s was not undone, %d row(s) changed since:\n- %s", opID, len(conflicts), strings.Join(limitStrings(conflicts, 20), "\n- ")))
+	}
+	return &MCPResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Result:  CallToolResult{Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Operation %s undone: %d row(s) of %s restored.", opID, len(steps), entry.Table)}}},
+	}
+}
+
+// limitStrings keeps the first n items, noting how many were left out.
+func limitStrings(items []string, n int) []string {
+	if len(items) <= n {
+		return items
+	}
+	return append(items[:n:n], fmt.Sprintf("... and %d more", len(items)-n))
+}
+
+// undoTool is the undo_operation tool definition.
+func undoTool() Tool {
+	return Tool{
+		Name:        "undo_operation",
+		Title:       "Undo Operation",
+		Description: "Revert an UPDATE or DELETE run by query_database on a writable dynamic alias, using the before-images kept in the undo journal (query_database returns its undo_operation_id). Without apply it shows the compensating INSERT/UPDATE statements and the rows that changed since (conflicts). With apply=true and after confirm_operation (e.g. 'UNDO operation <id>') it applies them in one transaction; any conflict cancels the whole undo.",
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]Property{
+				"operation_id": {
+					Type:        "string",
+					Description: "undo_operation_id returned by query_database",
+				},
+				"apply": {
+					Type:        "boolean",
+					Description: "Apply the undo (default false: preview only)",
+				},
+				"alias": {
+					Type:        "string",
+					Description: "Alias whose database holds the journal table (only with MSSQL_UNDO_JOURNAL_TABLE; defaults to the active connection)",
+				},
+			},
+			Required: []string{"operation_id"},
+		},
+		Annotations: &ToolAnnotations{
+			ReadOnlyHint:    boolPtr(false),
+			DestructiveHint: boolPtr(true),
+			IdempotentHint:  boolPtr(false),
+			OpenWorldHint:   boolPtr(false),
+		},
+	}
+}